@@ -0,0 +1,182 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/security"
+)
+
+// HeaderAPIKey is the header of the requests authenticated by an API key.
+const HeaderAPIKey = "X-API-KEY"
+
+// APIKeyPrefix starts the API keys, followed by the key ID and the secret.
+const APIKeyPrefix = "coco_"
+
+// Types of the API keys.
+const (
+	// APIKeyTypePersonal is a key of a user, managed by the user
+	APIKeyTypePersonal = "personal"
+	// APIKeyTypeService is a key of an automation, managed by the users with
+	// the `coco/api_key/manage` permission
+	APIKeyTypeService = "service"
+)
+
+// Keys of the session of a request authenticated by an API key.
+const (
+	// UserSessionInfoKeyAPIKey is the ID of the API key
+	UserSessionInfoKeyAPIKey param.ParaKey = "api_key"
+	// UserSessionInfoKeyAPIKeyDatasources is the datasources the API key is
+	// restricted to, it is not set when the key is not restricted
+	UserSessionInfoKeyAPIKeyDatasources param.ParaKey = "api_key_datasources"
+	// UserSessionInfoKeyAPIKeyAssistants is the assistants the API key is
+	// restricted to, it is not set when the key is not restricted
+	UserSessionInfoKeyAPIKeyAssistants param.ParaKey = "api_key_assistants"
+)
+
+// Scopes of the API keys.
+const (
+	APIKeyScopeSearch          = "search"
+	APIKeyScopeChat            = "chat"
+	APIKeyScopeDatasourceRead  = "datasource:read"
+	APIKeyScopeDatasourceWrite = "datasource:write"
+)
+
+// APIKeyScopes are the permissions granted by the scopes of the API keys, a
+// key is never granted more than the permissions of the user it runs as.
+var APIKeyScopes = map[string][]security.PermissionKey{
+	APIKeyScopeSearch: {
+		security.GetSimplePermission("coco", "search", string(security.Search)),
+		security.GetSimplePermission("coco", "document", string(security.Search)),
+		security.GetSimplePermission("coco", "document", string(security.Read)),
+		security.GetSimplePermission("coco", "datasource", string(security.Search)),
+	},
+	APIKeyScopeChat: {
+		security.GetSimplePermission("coco", "assistant", string(security.Read)),
+		security.GetSimplePermission("coco", "assistant", string(security.Search)),
+		security.GetSimplePermission("coco", "assistant", "ask"),
+		security.GetSimplePermission("coco", "session", string(security.Create)),
+		security.GetSimplePermission("coco", "session", string(security.Read)),
+		security.GetSimplePermission("coco", "session", string(security.Update)),
+		security.GetSimplePermission("coco", "session", string(security.Search)),
+		security.GetSimplePermission("coco", "session", "view_single_session_history"),
+		security.GetSimplePermission("coco", "session", "cancel_session"),
+		security.GetSimplePermission("coco", "attachment", string(security.Create)),
+		security.GetSimplePermission("coco", "attachment", string(security.Read)),
+	},
+	APIKeyScopeDatasourceRead: {
+		security.GetSimplePermission("coco", "datasource", string(security.Read)),
+		security.GetSimplePermission("coco", "datasource", string(security.Search)),
+		security.GetSimplePermission("coco", "document", string(security.Read)),
+		security.GetSimplePermission("coco", "document", string(security.Search)),
+	},
+	APIKeyScopeDatasourceWrite: {
+		security.GetSimplePermission("coco", "datasource", string(security.Create)),
+		security.GetSimplePermission("coco", "datasource", string(security.Read)),
+		security.GetSimplePermission("coco", "datasource", string(security.Update)),
+		security.GetSimplePermission("coco", "datasource", string(security.Delete)),
+		security.GetSimplePermission("coco", "datasource", string(security.Search)),
+		security.GetSimplePermission("coco", "document", string(security.Create)),
+		security.GetSimplePermission("coco", "document", string(security.Read)),
+		security.GetSimplePermission("coco", "document", string(security.Update)),
+		security.GetSimplePermission("coco", "document", string(security.Delete)),
+		security.GetSimplePermission("coco", "document", string(security.Search)),
+	},
+}
+
+// APIKey is a key for the programmatic access, only the hash of its secret is
+// stored.
+type APIKey struct {
+	orm.ORMObjectBase
+	Name string `json:"name" elastic_mapping:"name:{type:keyword}"`
+	Type string `json:"type" elastic_mapping:"type:{type:keyword}"` // personal or service
+	// RunAs is the user the requests of the key run as, the owner of a
+	// personal key
+	RunAs string `json:"run_as" elastic_mapping:"run_as:{type:keyword}"`
+	// Hash is the SHA-256 hash of the secret of the key, never returned
+	Hash string `json:"hash,omitempty" elastic_mapping:"hash:{enabled:false}"`
+	// Hint is the last characters of the secret, to tell the keys apart
+	Hint   string   `json:"hint,omitempty" elastic_mapping:"hint:{type:keyword}"`
+	Scopes []string `json:"scopes" elastic_mapping:"scopes:{type:keyword}"`
+	// Datasources and Assistants restrict the key to some datasources and
+	// assistants, all those of the user are allowed when empty
+	Datasources []string `json:"datasources,omitempty" elastic_mapping:"datasources:{type:keyword}"`
+	Assistants  []string `json:"assistants,omitempty" elastic_mapping:"assistants:{type:keyword}"`
+	// ExpireInDays is the lifetime of the key, from its creation or rotation
+	ExpireInDays int        `json:"expire_in_days" elastic_mapping:"expire_in_days:{type:integer}"`
+	ExpireAt     time.Time  `json:"expire_at" elastic_mapping:"expire_at:{type:date}"`
+	RotatedAt    *time.Time `json:"rotated_at,omitempty" elastic_mapping:"rotated_at:{type:date}"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" elastic_mapping:"last_used_at:{type:date}"`
+	LastUsedIP   string     `json:"last_used_ip,omitempty" elastic_mapping:"last_used_ip:{type:keyword}"`
+	Revoked      bool       `json:"revoked" elastic_mapping:"revoked:{type:boolean}"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty" elastic_mapping:"revoked_at:{type:date}"`
+}
+
+// Active tells if the key may be used at a time.
+func (k *APIKey) Active(now time.Time) bool {
+	return !k.Revoked && now.Before(k.ExpireAt)
+}
+
+// APIKeyConfig is the configuration of the API keys, under `coco.api_key`.
+type APIKeyConfig struct {
+	// DefaultExpireDays is the lifetime of a key created without one
+	DefaultExpireDays int `config:"default_expire_days"`
+	// MaxExpireDays is the longest lifetime of a key
+	MaxExpireDays int `config:"max_expire_days"`
+}
+
+func (cfg *APIKeyConfig) GetDefaultExpireDays() int {
+	if cfg == nil || cfg.DefaultExpireDays <= 0 {
+		return 90
+	}
+	return cfg.DefaultExpireDays
+}
+
+func (cfg *APIKeyConfig) GetMaxExpireDays() int {
+	if cfg == nil || cfg.MaxExpireDays <= 0 {
+		return 365
+	}
+	return cfg.MaxExpireDays
+}
+
+// APIKeyDatasources returns the datasources the API key of a request is
+// restricted to, it is false when the request is not restricted.
+func APIKeyDatasources(ctx context.Context) ([]string, bool) {
+	return apiKeyRestriction(ctx, UserSessionInfoKeyAPIKeyDatasources)
+}
+
+// APIKeyAllowsDatasource tells if the API key of a request, if any, may
+// access a datasource.
+func APIKeyAllowsDatasource(ctx context.Context, datasourceID string) bool {
+	ids, restricted := APIKeyDatasources(ctx)
+	return !restricted || slices.Contains(ids, datasourceID)
+}
+
+// APIKeyAssistants returns the assistants the API key of a request is
+// restricted to, it is false when the request is not restricted.
+func APIKeyAssistants(ctx context.Context) ([]string, bool) {
+	return apiKeyRestriction(ctx, UserSessionInfoKeyAPIKeyAssistants)
+}
+
+// APIKeyAllowsAssistant tells if the API key of a request, if any, may use an
+// assistant.
+func APIKeyAllowsAssistant(ctx context.Context, assistantID string) bool {
+	ids, restricted := APIKeyAssistants(ctx)
+	return !restricted || slices.Contains(ids, assistantID)
+}
+
+func apiKeyRestriction(ctx context.Context, key param.ParaKey) ([]string, bool) {
+	session, err := security.GetUserFromContext(ctx)
+	if err != nil || session == nil || !session.Has(key) {
+		return nil, false
+	}
+	ids, _ := session.GetStringArray(key)
+	return ids, true
+}
@@ -6,6 +6,8 @@ package core
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"slices"
 	"time"
 
@@ -39,11 +41,13 @@ type Assistant struct {
 	Enabled      bool         `json:"enabled" elastic_mapping:"enabled:{type:boolean}"`
 	Builtin      bool         `json:"builtin" elastic_mapping:"builtin:{type:boolean}"` // Whether the model provider is builtin
 	UploadConfig UploadConfig `json:"upload,omitempty" elastic_mapping:"upload:{type:object,enabled:false}"`
+	Revision     int          `json:"revision,omitempty" elastic_mapping:"revision:{type:integer}"` // current revision of the configuration, see AssistantRevision
 
 	// This field contains assistant-specific configuration settings
 	//
 	// After loading, Config is decoded into the corresponding typed field:
-	// DeepThinkConfig or DeepResearchConfig (both tagged json:"-").
+	// DeepThinkConfig, DeepResearchConfig or ExternalWorkflowConfig (all tagged
+	// json:"-").
 	Config interface{} `json:"config,omitempty" elastic_mapping:"config:{enabled:false}"`
 	// used by  simple/deep_think
 	AnsweringModel ModelConfig `json:"answering_model" elastic_mapping:"answering_model:{type:object,enabled:false}"`
@@ -58,11 +62,13 @@ type Assistant struct {
 	// used by simple/deep_think (passed as system prompt to GenerateFinalResponse)
 	RolePrompt string `json:"role_prompt" elastic_mapping:"role_prompt:{enabled:false}"`
 
-	// DeepThinkConfig and DeepResearchConfig are populated at load time by
-	// decoding Config into the appropriate type (based on Type). They are not
-	// persisted; json:"-" keeps them out of serialization.
-	DeepThinkConfig    *DeepThinkConfig    `json:"-"`
-	DeepResearchConfig *DeepResearchConfig `json:"-"`
+	// DeepThinkConfig, DeepResearchConfig and ExternalWorkflowConfig are
+	// populated at load time by decoding Config into the appropriate type
+	// (based on Type). They are not persisted; json:"-" keeps them out of
+	// serialization.
+	DeepThinkConfig        *DeepThinkConfig        `json:"-"`
+	DeepResearchConfig     *DeepResearchConfig     `json:"-"`
+	ExternalWorkflowConfig *ExternalWorkflowConfig `json:"-"`
 }
 
 type DeepThinkConfig struct {
@@ -84,12 +90,31 @@ type DeepResearchInternalSearchConfig struct {
 }
 
 // DeepResearchExternalSearchConfig controls external web search behaviour.
+// Engine is "duckduckgo", "wikipedia", "tavily", or one of WebSearchEngines.
+// Default: "duckduckgo".
 type DeepResearchExternalSearchConfig struct {
-	// Optional. External search engine: "duckduckgo", "wikipedia", or "tavily".
-	// Default: "duckduckgo".
-	Engine string `json:"engine"`
-	// Required when Engine is "tavily"; otherwise optional. Default: "".
-	APIKey string `json:"api_key,omitempty"`
+	WebSearchConfig
+}
+
+// DeepResearchPageFetchConfig controls the fetching of the pages of the top
+// external search results, whose main content replaces their snippets.
+type DeepResearchPageFetchConfig struct {
+	// Optional. Fetch the pages of the top external results. Default: true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Optional. Number of pages fetched per search. Default: 3.
+	MaxPages int `json:"max_pages"`
+	// Optional. Number of characters of main content kept per page.
+	// Default: 8000.
+	MaxContentLength int `json:"max_content_length"`
+	// Optional. Timeout to fetch a page as a Go duration string. Default: "15s".
+	Timeout string `json:"timeout,omitempty"`
+	// Optional. Apache Tika server extracting the text of PDF pages, e.g.
+	// "http://127.0.0.1:9998". Default: "", PDF pages are skipped.
+	TikaEndpoint string `json:"tika_endpoint,omitempty"`
+}
+
+func (cfg *DeepResearchPageFetchConfig) IsEnabled() bool {
+	return cfg.Enabled == nil || *cfg.Enabled
 }
 
 type DeepResearchConfig struct {
@@ -125,15 +150,23 @@ type DeepResearchConfig struct {
 	// If provided, it must be positive.
 	MaxResults int `json:"max_results"`
 	// Optional. Total research deadline as a Go duration string, e.g. "30m" or
-	// "1h". Default: "" (no timeout).
+	// "1h", the wait for the approval of the plan excluded. Default: "" (no
+	// timeout).
 	Timeout string `json:"timeout"`
 	// Optional. Effort level for the research pipeline: "basic", "comprehensive",
 	// or "exhaustive". Default: "basic".
 	ResearchDepth string `json:"research_depth"`
+	// Optional. Pause after planning until the user approves, edits or rejects
+	// the plan. Default: false.
+	PlanApproval bool `json:"plan_approval"`
+	// Optional. How long to wait for the approval of the plan as a Go duration
+	// string, the research is stopped after it. Default: "30m".
+	PlanApprovalTimeout string `json:"plan_approval_timeout,omitempty"`
 
 	// Output
 
-	// Optional. Rendered output format: "markdown" or "html". Default: "markdown".
+	// Optional. Rendered output format: "markdown", "html", "pdf", "docx" or
+	// "pptx". Default: "markdown".
 	ReportFormat string `json:"report_format"`
 	// Optional. Report language as a BCP 47 tag, e.g. "en-US" or "zh-CN".
 	// Default: "" (inherits system locale).
@@ -146,6 +179,89 @@ type DeepResearchConfig struct {
 	InternalSearch DeepResearchInternalSearchConfig `json:"internal_search"`
 	// Optional. External web search settings. Default: duckduckgo.
 	ExternalSearch DeepResearchExternalSearchConfig `json:"external_search"`
+	// Optional. Fetching of the pages of the top external results. Default:
+	// enabled, 3 pages per search.
+	PageFetch DeepResearchPageFetchConfig `json:"page_fetch"`
+	// Optional. Embedding model scoring the relevance of the sources to the
+	// research steps and the chapters. Default: empty, which falls back to the
+	// default embedding model in settings, or to keyword matching without one.
+	EmbeddingModel ModelConfig `json:"embedding_model"`
+}
+
+// ExternalWorkflowAuthConfig controls how requests to an external workflow
+// endpoint are authenticated.
+type ExternalWorkflowAuthConfig struct {
+	// Optional. Authentication scheme: "", "bearer", "basic" or "header".
+	// Default: "" (no authentication).
+	Type string `json:"type"`
+	// Required when Type is "bearer" or "header". The secret value to send.
+	Token string `json:"token,omitempty"`
+	// Required when Type is "header". Name of the header carrying Token, e.g.
+	// "X-API-Key".
+	HeaderName string `json:"header_name,omitempty"`
+	// Required when Type is "basic".
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// ExternalWorkflowRequestMapping names the request body fields that carry the
+// conversation. Each value is a dot-separated path, e.g. "inputs.question";
+// an explicit "-" omits the field from the request.
+type ExternalWorkflowRequestMapping struct {
+	// Optional. Field for the current user message. Default: "query".
+	Query string `json:"query"`
+	// Optional. Field for the previous messages of the session. Default: "history".
+	History string `json:"history"`
+	// Optional. Field for the attachments of the current message. Default: "attachments".
+	Attachments string `json:"attachments"`
+	// Optional. Field for the ID of the requesting user. Default: "user".
+	User string `json:"user"`
+	// Optional. Field for the chat session ID. Default: "session_id".
+	SessionID string `json:"session_id"`
+}
+
+// ExternalWorkflowResponseMapping describes where the answer text lives in the
+// payload(s) returned by the external workflow.
+type ExternalWorkflowResponseMapping struct {
+	// Optional. Dot-separated path of the answer text in each event (or in the
+	// whole body for "json" mode). Default: "answer".
+	Answer string `json:"answer"`
+	// Optional. Dot-separated path of the event type in each streamed event.
+	// Default: "" (events are not typed).
+	Event string `json:"event,omitempty"`
+	// Optional. Event types whose answer text is forwarded to the user. Default:
+	// empty, which forwards every event that carries an answer.
+	MessageEvents []string `json:"message_events,omitempty"`
+	// Optional. Event types that terminate the stream. Default: empty.
+	DoneEvents []string `json:"done_events,omitempty"`
+	// Optional. Event types that indicate a failure, the answer path is then
+	// reported as the error message. Default: empty.
+	ErrorEvents []string `json:"error_events,omitempty"`
+}
+
+// ExternalWorkflowConfig configures an assistant that delegates answering to
+// an external workflow engine such as Dify, n8n or a custom HTTP service.
+type ExternalWorkflowConfig struct {
+	// Required. Full URL of the workflow endpoint.
+	Endpoint string `json:"endpoint"`
+	// Optional. HTTP method used to call the endpoint. Default: "POST".
+	Method string `json:"method"`
+	// Optional. Additional static headers sent with every request.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Optional. Authentication settings. Default: no authentication.
+	Auth ExternalWorkflowAuthConfig `json:"auth"`
+	// Optional. Total request deadline as a Go duration string. Default: "5m".
+	Timeout string `json:"timeout"`
+	// Optional. How the endpoint replies: "sse" (text/event-stream), "chunked"
+	// (newline-delimited JSON or plain text) or "json" (a single JSON body).
+	// Default: "sse".
+	ResponseMode string `json:"response_mode"`
+	// Optional. Static fields merged into the request body before the mapped
+	// conversation fields, e.g. {"response_mode": "streaming", "inputs": {}}.
+	ExtraBody map[string]interface{} `json:"extra_body,omitempty"`
+
+	RequestMapping  ExternalWorkflowRequestMapping  `json:"request_mapping"`
+	ResponseMapping ExternalWorkflowResponseMapping `json:"response_mapping"`
 }
 
 type UploadConfig struct {
@@ -188,6 +304,9 @@ type BuiltinToolsConfig struct {
 	Wikipedia  bool `json:"wikipedia"`
 	Duckduckgo bool `json:"duckduckgo"`
 	Scraper    bool `json:"scraper"`
+	// Optional. Lets the assistant search the web with a web search
+	// provider. Default: nil, disabled.
+	WebSearch *WebSearchConfig `json:"web_search,omitempty"`
 }
 
 // ModelConfig is a runtime reference to a model, specifying which model to use
@@ -212,6 +331,10 @@ type ModelConfig struct {
 type PromptConfig struct {
 	PromptTemplate string   `json:"template"`
 	InputVars      []string `json:"input_vars"`
+	// TemplateID references a shared PromptTemplate, which takes precedence
+	// over the inline template and variables
+	TemplateID string           `json:"template_id,omitempty"`
+	Variables  []PromptVariable `json:"variables,omitempty"`
 }
 
 type ModelSettings struct {
@@ -237,10 +360,38 @@ type ChatSettings struct {
 	InputPreprocessTemplate string `json:"input_preprocess_tpl"`
 	PlaceHolder             string `json:"placeholder"`
 	HistoryMessage          struct {
-		Number               int  `json:"number"`
-		CompressionThreshold int  `json:"compression_threshold"`
-		Summary              bool `json:"summary"`
+		Number int `json:"number"`
+		// CompressionThreshold is the size, in characters, above which the
+		// older turns of the history are folded into the session summary
+		CompressionThreshold int `json:"compression_threshold"`
+		// Summary enables the rolling session summary
+		Summary bool `json:"summary"`
+		// SummaryModel summarizes the history, the intent analysis model is
+		// used if not specified
+		SummaryModel *ModelConfig `json:"summary_model,omitempty"`
 	} `json:"history_message"`
+	ContextBudget ContextBudget `json:"context_budget"`
+	// AutoTitle names the session after the first exchange, unless it was
+	// renamed by the user
+	AutoTitle struct {
+		Enabled bool `json:"enabled"`
+		// Model generates the title, the intent analysis model is used if
+		// not specified
+		Model *ModelConfig `json:"model,omitempty"`
+	} `json:"auto_title"`
+}
+
+// ContextBudget splits the tokens of the answering prompt between its
+// sections. Each section is guaranteed its share of the budget, the tokens
+// left by smaller sections go to the others by priority: attachments, tools
+// output, references, then history. Zero values use the defaults.
+type ContextBudget struct {
+	// ContextLength overrides the context window, in tokens, of the answering model
+	ContextLength int     `json:"context_length,omitempty"`
+	History       float64 `json:"history,omitempty"`
+	References    float64 `json:"references,omitempty"`
+	Attachments   float64 `json:"attachments,omitempty"`
+	ToolsOutput   float64 `json:"tools_output,omitempty"`
 }
 
 func (cfg *DatasourceConfig) SetIDs(ids []string) {
@@ -283,13 +434,18 @@ func (cfg *DeepResearchConfig) Validate() error {
 	}
 	// Validate external search engine if one is configured
 	if cfg.ExternalSearch.Engine != "" {
-		validEngines := []string{"duckduckgo", "wikipedia", "tavily"}
+		validEngines := append([]string{"duckduckgo", "wikipedia", "tavily"}, WebSearchEngines...)
 		if !slices.Contains(validEngines, cfg.ExternalSearch.Engine) {
 			return fmt.Errorf("external_search.engine must be one of: %v", validEngines)
 		}
 		if cfg.ExternalSearch.Engine == "tavily" && cfg.ExternalSearch.APIKey == "" {
 			return fmt.Errorf("external_search.api_key is required when engine is \"tavily\"")
 		}
+		if slices.Contains(WebSearchEngines, cfg.ExternalSearch.Engine) {
+			if err := cfg.ExternalSearch.Validate(); err != nil {
+				return fmt.Errorf("external_search: %w", err)
+			}
+		}
 	}
 
 	// Validate research depth
@@ -299,11 +455,17 @@ func (cfg *DeepResearchConfig) Validate() error {
 	}
 
 	// Validate report format
-	validFormats := []string{"markdown", "html", "pdf"}
+	validFormats := []string{"markdown", "html", "pdf", "docx", "pptx"}
 	if !slices.Contains(validFormats, cfg.ReportFormat) {
 		return fmt.Errorf("report_format must be one of: %v", validFormats)
 	}
 
+	if cfg.PlanApprovalTimeout != "" {
+		if _, err := time.ParseDuration(cfg.PlanApprovalTimeout); err != nil {
+			return fmt.Errorf("plan_approval_timeout is invalid: %s", err)
+		}
+	}
+
 	// Validate Timeout
 	if cfg.Timeout != "" {
 		if _, err := time.ParseDuration(cfg.Timeout); err != nil {
@@ -311,6 +473,20 @@ func (cfg *DeepResearchConfig) Validate() error {
 		}
 	}
 
+	if cfg.PageFetch.MaxPages < 0 || cfg.PageFetch.MaxContentLength < 0 {
+		return fmt.Errorf("page_fetch.max_pages and page_fetch.max_content_length must not be negative")
+	}
+	if cfg.PageFetch.Timeout != "" {
+		if _, err := time.ParseDuration(cfg.PageFetch.Timeout); err != nil {
+			return fmt.Errorf("page_fetch.timeout is invalid: %s", err)
+		}
+	}
+	if cfg.PageFetch.TikaEndpoint != "" {
+		if u, err := url.Parse(cfg.PageFetch.TikaEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("page_fetch.tika_endpoint must be a valid http(s) URL")
+		}
+	}
+
 	return nil
 }
 
@@ -325,7 +501,7 @@ func MergeDeepResearchConfig(userConfig *DeepResearchConfig) *DeepResearchConfig
 		MaxResults:                 5,
 		ResearchDepth:              "basic",
 		ReportFormat:               "markdown",
-		ExternalSearch:             DeepResearchExternalSearchConfig{Engine: "duckduckgo"},
+		ExternalSearch:             DeepResearchExternalSearchConfig{WebSearchConfig{Engine: "duckduckgo"}},
 	}
 	if userConfig == nil {
 		return &d
@@ -351,5 +527,93 @@ func MergeDeepResearchConfig(userConfig *DeepResearchConfig) *DeepResearchConfig
 	if userConfig.ExternalSearch.Engine == "" {
 		userConfig.ExternalSearch.Engine = d.ExternalSearch.Engine
 	}
+	if userConfig.PageFetch.MaxPages == 0 {
+		userConfig.PageFetch.MaxPages = 3
+	}
+	if userConfig.PageFetch.MaxContentLength == 0 {
+		userConfig.PageFetch.MaxContentLength = 8000
+	}
+	return userConfig
+}
+
+// Validate validates the external workflow configuration
+func (cfg *ExternalWorkflowConfig) Validate() error {
+	if cfg.Endpoint == "" {
+		return fmt.Errorf("endpoint is required")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint must be a valid http(s) URL")
+	}
+
+	validMethods := []string{http.MethodPost, http.MethodPut}
+	if !slices.Contains(validMethods, cfg.Method) {
+		return fmt.Errorf("method must be one of: %v", validMethods)
+	}
+
+	validModes := []string{"sse", "chunked", "json"}
+	if !slices.Contains(validModes, cfg.ResponseMode) {
+		return fmt.Errorf("response_mode must be one of: %v", validModes)
+	}
+
+	switch cfg.Auth.Type {
+	case "":
+	case "bearer":
+		if cfg.Auth.Token == "" {
+			return fmt.Errorf("auth.token is required when auth.type is \"bearer\"")
+		}
+	case "header":
+		if cfg.Auth.HeaderName == "" || cfg.Auth.Token == "" {
+			return fmt.Errorf("auth.header_name and auth.token are required when auth.type is \"header\"")
+		}
+	case "basic":
+		if cfg.Auth.Username == "" {
+			return fmt.Errorf("auth.username is required when auth.type is \"basic\"")
+		}
+	default:
+		return fmt.Errorf("auth.type must be one of: %v", []string{"bearer", "basic", "header"})
+	}
+
+	if _, err := time.ParseDuration(cfg.Timeout); err != nil {
+		return fmt.Errorf("timeout is invalid: %s", err)
+	}
+
+	return nil
+}
+
+// MergeExternalWorkflowConfig fills zero-valued fields in userConfig with
+// hardcoded defaults.
+func MergeExternalWorkflowConfig(userConfig *ExternalWorkflowConfig) *ExternalWorkflowConfig {
+	if userConfig == nil {
+		userConfig = &ExternalWorkflowConfig{}
+	}
+	if userConfig.Method == "" {
+		userConfig.Method = http.MethodPost
+	}
+	if userConfig.Timeout == "" {
+		userConfig.Timeout = "5m"
+	}
+	if userConfig.ResponseMode == "" {
+		userConfig.ResponseMode = "sse"
+	}
+	m := &userConfig.RequestMapping
+	if m.Query == "" {
+		m.Query = "query"
+	}
+	if m.History == "" {
+		m.History = "history"
+	}
+	if m.Attachments == "" {
+		m.Attachments = "attachments"
+	}
+	if m.User == "" {
+		m.User = "user"
+	}
+	if m.SessionID == "" {
+		m.SessionID = "session_id"
+	}
+	if userConfig.ResponseMapping.Answer == "" {
+		userConfig.ResponseMapping.Answer = "answer"
+	}
 	return userConfig
 }
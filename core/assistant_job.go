@@ -0,0 +1,170 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"infini.sh/framework/core/orm"
+)
+
+const (
+	AssistantJobOutputSession    = "session"
+	AssistantJobOutputAttachment = "attachment"
+)
+
+const (
+	AssistantJobRunRunning  = "running"
+	AssistantJobRunComplete = "complete"
+	AssistantJobRunFailed   = "failed"
+)
+
+// AssistantJobUpdatedSinceLastRun limits the documents of a job to the ones
+// updated since its last successful run.
+const AssistantJobUpdatedSinceLastRun = "last_run"
+
+// AssistantJob runs a prompt through an assistant on a schedule, e.g. a
+// weekly digest of the documents changed in a datasource, and delivers the
+// answer.
+type AssistantJob struct {
+	CombinedFullText
+	Name        string      `json:"name" elastic_mapping:"name:{type:keyword,copy_to:combined_fulltext}"`
+	Description string      `json:"description,omitempty" elastic_mapping:"description:{type:text,copy_to:combined_fulltext}"`
+	Enabled     bool        `json:"enabled" elastic_mapping:"enabled:{type:boolean}"`
+	AssistantID string      `json:"assistant_id" elastic_mapping:"assistant_id:{type:keyword}"`
+	Prompt      string      `json:"prompt" elastic_mapping:"prompt:{type:text}"`
+	Schedule    JobSchedule `json:"schedule" elastic_mapping:"schedule:{type:object,enabled:false}"`
+
+	// Datasources given, the documents of the datasources updated within the
+	// window are given to the assistant as references
+	Datasources []string `json:"datasources,omitempty" elastic_mapping:"datasources:{type:keyword}"`
+	// UpdatedWithin is a duration, e.g. "168h", or "last_run"
+	UpdatedWithin string `json:"updated_within,omitempty" elastic_mapping:"updated_within:{type:keyword}"`
+	MaxDocuments  int    `json:"max_documents,omitempty" elastic_mapping:"max_documents:{type:integer}"`
+
+	Output   string      `json:"output,omitempty" elastic_mapping:"output:{type:keyword}"` // session by default, or attachment
+	Delivery JobDelivery `json:"delivery,omitempty" elastic_mapping:"delivery:{type:object,enabled:false}"`
+
+	LastRun *time.Time `json:"last_run,omitempty" elastic_mapping:"last_run:{type:date}"`
+	// LastSuccess is the start of the last successful run
+	LastSuccess *time.Time `json:"last_success,omitempty" elastic_mapping:"last_success:{type:date}"`
+	NextRun     *time.Time `json:"next_run,omitempty" elastic_mapping:"next_run:{type:date}"`
+}
+
+// JobSchedule runs a job either every interval, or at a time of day on some
+// days of the week.
+type JobSchedule struct {
+	Interval string   `json:"interval,omitempty"` // e.g. "6h"
+	At       string   `json:"at,omitempty"`       // e.g. "09:00"
+	Weekdays []string `json:"weekdays,omitempty"` // e.g. ["monday"], every day if empty
+	Timezone string   `json:"timezone,omitempty"` // e.g. "Asia/Shanghai", the server's by default
+}
+
+// JobDelivery sends the answer of a job to a webhook and/or by email.
+type JobDelivery struct {
+	Webhook *WebhookDelivery `json:"webhook,omitempty"`
+	Email   *EmailDelivery   `json:"email,omitempty"`
+}
+
+// WebhookDelivery posts the run of a job as JSON.
+type WebhookDelivery struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// EmailDelivery mails the answer of a job, through the SMTP server of the
+// configuration.
+type EmailDelivery struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject,omitempty"` // the name of the job by default
+}
+
+// Validate checks the job can run, and sets the default values.
+func (j *AssistantJob) Validate() error {
+	if j.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if j.AssistantID == "" {
+		return fmt.Errorf("assistant_id is required")
+	}
+	if j.Prompt == "" {
+		return fmt.Errorf("prompt is required")
+	}
+	if j.Schedule.Interval == "" && j.Schedule.At == "" {
+		return fmt.Errorf("schedule requires an interval or a time of day")
+	}
+	if j.UpdatedWithin != "" && j.UpdatedWithin != AssistantJobUpdatedSinceLastRun {
+		if _, err := time.ParseDuration(j.UpdatedWithin); err != nil {
+			return fmt.Errorf("invalid updated_within [%v]: %w", j.UpdatedWithin, err)
+		}
+	}
+	switch j.Output {
+	case "":
+		j.Output = AssistantJobOutputSession
+	case AssistantJobOutputSession, AssistantJobOutputAttachment:
+	default:
+		return fmt.Errorf("invalid output [%v]", j.Output)
+	}
+	if w := j.Delivery.Webhook; w != nil {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url [%v]", w.URL)
+		}
+	}
+	if e := j.Delivery.Email; e != nil {
+		if len(e.To) == 0 {
+			return fmt.Errorf("email delivery requires recipients")
+		}
+		for _, to := range e.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("invalid email recipient [%v]: %w", to, err)
+			}
+		}
+		if strings.ContainsAny(e.Subject, "\r\n") {
+			return fmt.Errorf("email subject must be a single line")
+		}
+	}
+	return nil
+}
+
+// AssistantJobRun is a run of an assistant job.
+type AssistantJobRun struct {
+	orm.ORMObjectBase
+	JobID        string         `json:"job_id" elastic_mapping:"job_id:{type:keyword}"`
+	JobName      string         `json:"job_name" elastic_mapping:"job_name:{type:keyword}"`
+	AssistantID  string         `json:"assistant_id" elastic_mapping:"assistant_id:{type:keyword}"`
+	Trigger      string         `json:"trigger" elastic_mapping:"trigger:{type:keyword}"` // schedule or manual
+	Status       string         `json:"status" elastic_mapping:"status:{type:keyword}"`
+	Error        string         `json:"error,omitempty" elastic_mapping:"error:{type:text}"`
+	Finished     *time.Time     `json:"finished,omitempty" elastic_mapping:"finished:{type:date}"`
+	Documents    int            `json:"documents" elastic_mapping:"documents:{type:integer}"` // number of documents given to the assistant
+	SessionID    string         `json:"session_id,omitempty" elastic_mapping:"session_id:{type:keyword}"`
+	AttachmentID string         `json:"attachment_id,omitempty" elastic_mapping:"attachment_id:{type:keyword}"`
+	Answer       string         `json:"answer,omitempty" elastic_mapping:"answer:{enabled:false}"`
+	Deliveries   []JobDelivered `json:"deliveries,omitempty" elastic_mapping:"deliveries:{enabled:false}"`
+}
+
+// JobDelivered is the outcome of a delivery of a run.
+type JobDelivered struct {
+	Type   string `json:"type"` // webhook or email
+	Target string `json:"target"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SMTPConfig is the mail server the email deliveries are sent through.
+type SMTPConfig struct {
+	Host     string `config:"host" json:"host"`
+	Port     int    `config:"port" json:"port"`
+	Username string `config:"username" json:"username,omitempty"`
+	Password string `config:"password" json:"-"`
+	From     string `config:"from" json:"from"`
+	// TLS connects with implicit TLS, e.g. on port 465, otherwise STARTTLS is
+	// used when the server supports it
+	TLS bool `config:"tls" json:"tls"`
+}
@@ -0,0 +1,41 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"fmt"
+
+	"infini.sh/framework/core/orm"
+)
+
+// AssistantRevision is an immutable snapshot of the configuration of an
+// assistant, saved on every update of the assistant.
+type AssistantRevision struct {
+	orm.ORMObjectBase
+	AssistantID string                 `json:"assistant_id" elastic_mapping:"assistant_id:{type:keyword}"`
+	Revision    int                    `json:"revision" elastic_mapping:"revision:{type:integer}"`
+	Author      string                 `json:"author,omitempty" elastic_mapping:"author:{type:keyword}"` // ID of the user who made the change
+	Comment     string                 `json:"comment,omitempty" elastic_mapping:"comment:{type:text}"`
+	RollbackOf  int                    `json:"rollback_of,omitempty" elastic_mapping:"rollback_of:{type:integer}"` // the revision restored, if a rollback
+	Changes     []AssistantFieldChange `json:"changes,omitempty" elastic_mapping:"changes:{enabled:false}"`
+	Snapshot    Assistant              `json:"snapshot" elastic_mapping:"snapshot:{enabled:false}"`
+	// Prompts are the prompts of the snapshot referencing a shared prompt
+	// template, as resolved when saved, by the dotted JSON path of the model
+	Prompts map[string]PromptConfig `json:"prompts,omitempty" elastic_mapping:"prompts:{enabled:false}"`
+}
+
+// AssistantFieldChange is a field changed from the previous revision, the
+// field is the dotted JSON path of the value.
+type AssistantFieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// AssistantRevisionID is the document ID of a revision of an assistant, the
+// revisions are addressed by assistant and number.
+func AssistantRevisionID(assistantID string, revision int) string {
+	return fmt.Sprintf("%s_r%d", assistantID, revision)
+}
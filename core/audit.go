@@ -0,0 +1,66 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import "infini.sh/framework/core/orm"
+
+// Actions of the audit logs.
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	AuditActionRead   = "read"
+)
+
+// Resource types of the audit logs.
+const (
+	AuditResourceAssistant     = "assistant"
+	AuditResourceDatasource    = "datasource"
+	AuditResourceConnector     = "connector"
+	AuditResourceModelProvider = "model_provider"
+	AuditResourceIntegration   = "integration"
+	AuditResourceSettings      = "settings"
+	AuditResourceDocument      = "document"
+	AuditResourceAPIKey        = "api_key"
+)
+
+// AuditLog is the record of an administrative or a data access action of a
+// user.
+type AuditLog struct {
+	orm.ORMObjectBase
+	ActorID    string `json:"actor_id" elastic_mapping:"actor_id:{type:keyword}"`
+	ActorLogin string `json:"actor_login,omitempty" elastic_mapping:"actor_login:{type:keyword}"`
+	// IntegrationID is the integration the request was sent through, if any
+	IntegrationID string `json:"integration_id,omitempty" elastic_mapping:"integration_id:{type:keyword}"`
+	// APIKeyID is the API key the request was authenticated by, if any
+	APIKeyID     string `json:"api_key_id,omitempty" elastic_mapping:"api_key_id:{type:keyword}"`
+	ClientIP     string `json:"client_ip,omitempty" elastic_mapping:"client_ip:{type:keyword}"`
+	Action       string `json:"action" elastic_mapping:"action:{type:keyword}"`
+	ResourceType string `json:"resource_type" elastic_mapping:"resource_type:{type:keyword}"`
+	ResourceID   string `json:"resource_id,omitempty" elastic_mapping:"resource_id:{type:keyword}"`
+	// Changes are the fields changed by the action, with the secrets redacted
+	Changes []AuditChange `json:"changes,omitempty" elastic_mapping:"changes:{type:object,enabled:false}"`
+}
+
+// AuditChange is a top level field of a resource changed by an action.
+type AuditChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// AuditConfig is the policy of the audit logs.
+type AuditConfig struct {
+	Disabled bool `config:"disabled"`
+	// RetentionDays is how long the audit logs are kept, 90 days by default
+	RetentionDays int `config:"retention_days"`
+}
+
+func (c *AuditConfig) GetRetentionDays() int {
+	if c == nil || c.RetentionDays <= 0 {
+		return 90
+	}
+	return c.RetentionDays
+}
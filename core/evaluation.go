@@ -0,0 +1,105 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"infini.sh/framework/core/orm"
+)
+
+const (
+	EvaluationRunRunning  = "running"
+	EvaluationRunComplete = "complete"
+	EvaluationRunFailed   = "failed"
+)
+
+// EvaluationSet is a golden set of questions an assistant is evaluated on.
+type EvaluationSet struct {
+	orm.ORMObjectBase
+	Name        string               `json:"name" elastic_mapping:"name:{type:keyword,copy_to:combined_fulltext}"`
+	Description string               `json:"description,omitempty" elastic_mapping:"description:{type:text,copy_to:combined_fulltext}"`
+	Questions   []EvaluationQuestion `json:"questions" elastic_mapping:"questions:{enabled:false}"`
+}
+
+// EvaluationQuestion is a question with the answer and the source documents
+// expected from the assistant, either can be omitted.
+type EvaluationQuestion struct {
+	ID                string   `json:"id"`
+	Question          string   `json:"question"`
+	ExpectedAnswer    string   `json:"expected_answer,omitempty"`
+	ExpectedDocuments []string `json:"expected_documents,omitempty"` // IDs of the documents
+}
+
+// Validate checks the evaluation set has questions, and gives the questions
+// without an ID their position as ID.
+func (s *EvaluationSet) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(s.Questions) == 0 {
+		return fmt.Errorf("at least one question is required")
+	}
+	ids := map[string]bool{}
+	for i := range s.Questions {
+		q := &s.Questions[i]
+		if q.Question == "" {
+			return fmt.Errorf("question #%d is empty", i+1)
+		}
+		if q.ID == "" {
+			q.ID = fmt.Sprintf("%d", i+1)
+		}
+		if ids[q.ID] {
+			return fmt.Errorf("duplicated question id [%v]", q.ID)
+		}
+		ids[q.ID] = true
+	}
+	return nil
+}
+
+// EvaluationRun is the result of an evaluation set run against an assistant.
+// The assistant is identified with its update time, so runs of different
+// versions of the assistant can be compared.
+type EvaluationRun struct {
+	orm.ORMObjectBase
+	SetID            string       `json:"set_id" elastic_mapping:"set_id:{type:keyword}"`
+	SetName          string       `json:"set_name,omitempty" elastic_mapping:"set_name:{type:keyword}"`
+	AssistantID      string       `json:"assistant_id" elastic_mapping:"assistant_id:{type:keyword}"`
+	AssistantUpdated *time.Time   `json:"assistant_updated,omitempty" elastic_mapping:"assistant_updated:{type:date}"`
+	AnsweringModel   string       `json:"answering_model,omitempty" elastic_mapping:"answering_model:{type:keyword}"`
+	JudgeModel       *ModelConfig `json:"judge_model,omitempty" elastic_mapping:"judge_model:{enabled:false}"`
+	Status           string       `json:"status" elastic_mapping:"status:{type:keyword}"` // running, complete or failed
+	Error            string       `json:"error,omitempty" elastic_mapping:"error:{type:text}"`
+	Finished         *time.Time   `json:"finished,omitempty" elastic_mapping:"finished:{type:date}"`
+
+	Score   EvaluationScore    `json:"score" elastic_mapping:"score:{type:object}"`
+	Results []EvaluationResult `json:"results,omitempty" elastic_mapping:"results:{enabled:false}"`
+}
+
+// EvaluationScore aggregates the results of a run, the averages only cover
+// the questions the metric applies to.
+type EvaluationScore struct {
+	Questions        int     `json:"questions" elastic_mapping:"questions:{type:integer}"`
+	Errors           int     `json:"errors" elastic_mapping:"errors:{type:integer}"`
+	RetrievalRecall  float64 `json:"retrieval_recall" elastic_mapping:"retrieval_recall:{type:float}"`
+	AnswerSimilarity float64 `json:"answer_similarity" elastic_mapping:"answer_similarity:{type:float}"`
+	AvgLatencyMs     int64   `json:"avg_latency_ms" elastic_mapping:"avg_latency_ms:{type:long}"`
+	P95LatencyMs     int64   `json:"p95_latency_ms" elastic_mapping:"p95_latency_ms:{type:long}"`
+}
+
+// EvaluationResult is the answer of the assistant to a question and its
+// scores. A nil score means the question has no expectation for it.
+type EvaluationResult struct {
+	QuestionID       string   `json:"question_id"`
+	Question         string   `json:"question"`
+	Answer           string   `json:"answer"`
+	Sources          []string `json:"sources,omitempty"` // IDs of the documents the answer is based on
+	RetrievalRecall  *float64 `json:"retrieval_recall,omitempty"`
+	AnswerSimilarity *float64 `json:"answer_similarity,omitempty"`
+	Judgement        string   `json:"judgement,omitempty"` // explanation of the judge model
+	LatencyMs        int64    `json:"latency_ms"`
+	Error            string   `json:"error,omitempty"`
+}
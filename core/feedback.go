@@ -0,0 +1,67 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"fmt"
+	"slices"
+
+	"infini.sh/framework/core/orm"
+)
+
+const (
+	FeedbackVoteUp   = "up"
+	FeedbackVoteDown = "down"
+)
+
+// Reason categories of a feedback, mostly given with down votes.
+const (
+	FeedbackReasonInaccurate = "inaccurate"
+	FeedbackReasonIncomplete = "incomplete"
+	FeedbackReasonIrrelevant = "irrelevant"
+	FeedbackReasonOutdated   = "outdated"
+	FeedbackReasonBadSources = "bad_sources"
+	FeedbackReasonHarmful    = "harmful"
+	FeedbackReasonHelpful    = "helpful"
+	FeedbackReasonOther      = "other"
+)
+
+var feedbackReasons = []string{
+	FeedbackReasonInaccurate,
+	FeedbackReasonIncomplete,
+	FeedbackReasonIrrelevant,
+	FeedbackReasonOutdated,
+	FeedbackReasonBadSources,
+	FeedbackReasonHarmful,
+	FeedbackReasonHelpful,
+	FeedbackReasonOther,
+}
+
+// MessageFeedback is the vote of a user on an assistant answer. A user has
+// at most one feedback per message, its ID is derived from both.
+type MessageFeedback struct {
+	orm.ORMObjectBase
+	SessionID   string `json:"session_id" elastic_mapping:"session_id:{type:keyword}"`
+	MessageID   string `json:"message_id" elastic_mapping:"message_id:{type:keyword}"`
+	AssistantID string `json:"assistant_id" elastic_mapping:"assistant_id:{type:keyword}"`
+	UserID      string `json:"user_id" elastic_mapping:"user_id:{type:keyword}"`
+	Vote        string `json:"vote" elastic_mapping:"vote:{type:keyword}"` // up or down
+	Reason      string `json:"reason,omitempty" elastic_mapping:"reason:{type:keyword}"`
+	Comment     string `json:"comment,omitempty" elastic_mapping:"comment:{type:text}"`
+}
+
+// Validate checks the vote and the reason category of the feedback.
+func (f *MessageFeedback) Validate() error {
+	if f.Vote != FeedbackVoteUp && f.Vote != FeedbackVoteDown {
+		return fmt.Errorf("invalid vote [%v], expected %v or %v", f.Vote, FeedbackVoteUp, FeedbackVoteDown)
+	}
+	if f.Reason != "" && !slices.Contains(feedbackReasons, f.Reason) {
+		return fmt.Errorf("invalid reason [%v], expected one of %v", f.Reason, feedbackReasons)
+	}
+	if len(f.Comment) > 4096 {
+		return fmt.Errorf("comment is too long")
+	}
+	return nil
+}
@@ -66,4 +66,7 @@ type AIChatModuleConfig struct {
 	Placeholder       string              `json:"placeholder,omitempty" elastic_mapping:"placeholder:{type:keyword}"`                           // Placeholder text for search input
 	Assistants        []string            `json:"assistants,omitempty" elastic_mapping:"assistants:{type:keyword}"`                             // Assistant ID
 	StartPageSettings ChatStartPageConfig `json:"start_page_config,omitempty" elastic_mapping:"start_page_config:{type:object, enabled:false}"` // Start page settings
+	// AssistantRevisions pins assistants to a revision of their configuration,
+	// by assistant ID, the others follow their latest configuration
+	AssistantRevisions map[string]int `json:"assistant_revisions,omitempty" elastic_mapping:"assistant_revisions:{type:object,enabled:false}"`
 }
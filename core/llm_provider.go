@@ -22,13 +22,16 @@ type Model struct {
 	// SupportReasoning reports whether this model is capable of reasoning mode.
 	// Only meaningful for language models (Type == LLMTypeLanguage).
 	SupportReasoning bool `json:"support_reasoning,omitempty"`
+
+	// ContextLength is the context window of the model in tokens, 0 if unknown.
+	ContextLength int `json:"context_length,omitempty"`
 }
 
 type ModelProvider struct {
 	CombinedFullText
 
 	Name        string  `json:"name" elastic_mapping:"name:{type:keyword,copy_to:combined_fulltext,fields:{text: {type: text}, pinyin: {type: text, analyzer: pinyin_analyzer}}}"`
-	APIKey      string  `json:"api_key" elastic_mapping:"api_key:{type:keyword}"`                                // API key of the model provider
+	APIKey      string  `json:"api_key" elastic_mapping:"api_key:{enabled:false}"`                               // API key of the model provider
 	APIType     string  `json:"api_type" elastic_mapping:"api_type:{type:keyword}"`                              // API type of the model provider, possible values: openai,gemini, anthropic
 	Icon        string  `json:"icon" elastic_mapping:"icon:{enabled:false}"`                                     // Icon of the model provider
 	Models      []Model `json:"models" elastic_mapping:"models:{type:object,enabled:false}"`                     // Models provided by the model provider
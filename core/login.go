@@ -0,0 +1,191 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"slices"
+	"time"
+
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+)
+
+// LoginAttemptsBucket is the KV bucket of the failed logins of the accounts and
+// the client IPs.
+const LoginAttemptsBucket = "login_attempts"
+
+// LoginChallengeBucket is the KV bucket of the pending second steps of the
+// logins with a second factor.
+const LoginChallengeBucket = "login_challenges"
+
+// Reasons of a failed login.
+const (
+	LoginFailedBadCredentials = "bad_credentials"
+	LoginFailedLocked         = "locked"
+	LoginFailedThrottled      = "throttled"
+	LoginFailedChallenge      = "challenge_failed"
+	LoginFailedSecondFactor   = "second_factor_failed"
+)
+
+// LoginProtectionConfig limits the failed logins of an account and of a client
+// IP. The zero values fall back to the defaults.
+type LoginProtectionConfig struct {
+	Disabled bool `config:"disabled"`
+	// MaxAccountFailures is the number of failed logins of an account from a
+	// client IP before it is locked out from this IP, 5 by default
+	MaxAccountFailures int `config:"max_account_failures"`
+	// MaxIPFailures is the number of failed logins from a client IP before it
+	// is locked out, 20 by default
+	MaxIPFailures int `config:"max_ip_failures"`
+	// BackoffAfter is the number of failed logins allowed before the next ones
+	// are delayed, 3 by default
+	BackoffAfter int `config:"backoff_after"`
+	// Backoff is the first delay, doubled on each failed login up to MaxBackoff,
+	// 1s and 1m by default
+	Backoff    string `config:"backoff"`
+	MaxBackoff string `config:"max_backoff"`
+	// Lockout is how long an account from a client IP, or a client IP, is
+	// locked out, 15m by default
+	Lockout string `config:"lockout"`
+	// FailureWindow is how long a failed login is remembered, 1h by default
+	FailureWindow string `config:"failure_window"`
+	// ChallengeAfter is the number of failed logins after which the registered
+	// login challenge, e.g. a CAPTCHA, must be passed, 3 by default
+	ChallengeAfter int `config:"challenge_after"`
+	// TrustProxyHeaders takes the client IP from the X-Forwarded-For and
+	// X-Real-IP headers, enable it only behind a trusted reverse proxy
+	TrustProxyHeaders bool `config:"trust_proxy_headers"`
+	// TrustedProxies are the IPs or CIDRs of the reverse proxies, the client
+	// IP is the last hop of X-Forwarded-For not among them. Without them, the
+	// peer of a request is taken as the only proxy
+	TrustedProxies []string `config:"trusted_proxies"`
+}
+
+func (c *LoginProtectionConfig) GetMaxAccountFailures() int {
+	if c == nil || c.MaxAccountFailures <= 0 {
+		return 5
+	}
+	return c.MaxAccountFailures
+}
+
+func (c *LoginProtectionConfig) GetMaxIPFailures() int {
+	if c == nil || c.MaxIPFailures <= 0 {
+		return 20
+	}
+	return c.MaxIPFailures
+}
+
+func (c *LoginProtectionConfig) GetBackoffAfter() int {
+	if c == nil || c.BackoffAfter <= 0 {
+		return 3
+	}
+	return c.BackoffAfter
+}
+
+func (c *LoginProtectionConfig) GetChallengeAfter() int {
+	if c == nil || c.ChallengeAfter <= 0 {
+		return 3
+	}
+	return c.ChallengeAfter
+}
+
+func (c *LoginProtectionConfig) GetBackoff() time.Duration {
+	if c == nil {
+		return time.Second
+	}
+	return parseDurationOrDefault(c.Backoff, time.Second)
+}
+
+func (c *LoginProtectionConfig) GetMaxBackoff() time.Duration {
+	if c == nil {
+		return time.Minute
+	}
+	return parseDurationOrDefault(c.MaxBackoff, time.Minute)
+}
+
+func (c *LoginProtectionConfig) GetLockout() time.Duration {
+	if c == nil {
+		return 15 * time.Minute
+	}
+	return parseDurationOrDefault(c.Lockout, 15*time.Minute)
+}
+
+func (c *LoginProtectionConfig) GetFailureWindow() time.Duration {
+	if c == nil {
+		return time.Hour
+	}
+	return parseDurationOrDefault(c.FailureWindow, time.Hour)
+}
+
+func parseDurationOrDefault(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// LoginRecord is the audit record of a login attempt.
+type LoginRecord struct {
+	orm.ORMObjectBase
+	// Login is the email of the account, or the default user with the
+	// password-only login
+	Login     string `json:"login" elastic_mapping:"login:{type:keyword}"`
+	UserID    string `json:"user_id,omitempty" elastic_mapping:"user_id:{type:keyword}"`
+	Provider  string `json:"provider,omitempty" elastic_mapping:"provider:{type:keyword}"`
+	ClientIP  string `json:"client_ip" elastic_mapping:"client_ip:{type:keyword}"`
+	UserAgent string `json:"user_agent,omitempty" elastic_mapping:"user_agent:{type:keyword,ignore_above:512}"`
+	Success   bool   `json:"success" elastic_mapping:"success:{type:boolean}"`
+	// Reason is why the login failed, e.g. bad_credentials or locked
+	Reason string `json:"reason,omitempty" elastic_mapping:"reason:{type:keyword}"`
+}
+
+// TwoFactorConfig is the policy of the TOTP second factor.
+type TwoFactorConfig struct {
+	// RequiredForAdmin requires a second factor for the administrators
+	RequiredForAdmin bool `config:"required_for_admin"`
+	// RequiredRoles are the other roles of the users who must login with a
+	// second factor, they enroll on their next login if not yet
+	RequiredRoles []string `config:"required_roles"`
+	// Issuer is shown by the authenticator apps, "Coco AI" by default
+	Issuer string `config:"issuer"`
+}
+
+func (c *TwoFactorConfig) GetIssuer() string {
+	if c == nil || c.Issuer == "" {
+		return "Coco AI"
+	}
+	return c.Issuer
+}
+
+// Required tells if the users of any of the roles must login with a second
+// factor.
+func (c *TwoFactorConfig) Required(roles []string) bool {
+	if c == nil {
+		return false
+	}
+	if c.RequiredForAdmin && slices.Contains(roles, security.RoleAdmin) {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(c.RequiredRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserTwoFactor is the TOTP second factor of a user, its ID is the user ID.
+type UserTwoFactor struct {
+	orm.ORMObjectBase
+	// Secret is the TOTP secret, encrypted
+	Secret string `json:"secret" elastic_mapping:"secret:{enabled:false}"`
+	// Enabled is set once the enrollment is confirmed with a code
+	Enabled bool `json:"enabled" elastic_mapping:"enabled:{type:boolean}"`
+	// LastUsedStep is the time step of the last accepted code, so that a code
+	// is only accepted once
+	LastUsedStep int64 `json:"last_used_step,omitempty" elastic_mapping:"last_used_step:{type:long}"`
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes,omitempty" elastic_mapping:"recovery_codes:{enabled:false}"`
+}
@@ -30,6 +30,11 @@ type ChatMessage struct {
 
 	ReplyMessageID string `config:"reply_to_message" json:"reply_to_message,omitempty" elastic_mapping:"reply_to_message:{type:keyword}"`
 
+	// ParentID is the message this one follows in the conversation tree, the
+	// previous reply for a question and the question for a reply. Regenerated
+	// replies and edited questions are siblings sharing the same parent.
+	ParentID string `json:"parent_id,omitempty" elastic_mapping:"parent_id:{type:keyword}"`
+
 	// Details holds the ordered list of processing steps performed during an assistant reply.
 	// Each entry has a Type that determines the schema of its Payload. Known types:
 	//
@@ -47,6 +52,11 @@ type ChatMessage struct {
 	//
 	//   Type="deep_read"     (Order=40)  — Payload: nil; Description contains "Analyzing: <title>\n" per doc
 	//
+	//   Type="context_budget" (Order=45) — Payload: token usage of the prompt context sections
+	//     {"context_length":0, "budget":0, "sections":[{"name":"history|references|attachments|tools_output",
+	//      "tokens":0, "original_tokens":0, "truncated":bool}]}
+	//     Only set when a section was truncated to fit the context window.
+	//
 	//   Type="think"         (Order=50)  — Payload: nil; Description contains the LLM reasoning trace text
 	//
 	//   Type="deep_research" (Order=10)  — Payload: array of ChunkRecord from deep research v2
@@ -63,6 +73,11 @@ type ChatMessage struct {
 	DownVote    int    `json:"down_vote"`
 	AssistantID string `json:"assistant_id"`
 
+	// Citations lists the sources behind the numbered markers, e.g. "[1]", of
+	// an assistant answer. Only markers matching a document given to the model
+	// are kept.
+	Citations []Citation `json:"citations,omitempty" elastic_mapping:"citations:{enabled:false}"`
+
 	// Payload carries top-level structured data for the entire message.
 	// Currently only set by deep_research_v2 when a research report is generated:
 	//   {"title":"", "url":"", "created":"", "attachment":"<attachment_id>", "format":"md|html"}
@@ -81,6 +96,19 @@ type ProcessingDetails struct {
 	Payload     interface{} `json:"payload"`     // Structured data; concrete type depends on Type (see ChatMessage.Details doc)
 }
 
+// Citation links a numbered citation marker in an assistant answer to the
+// document it refers to.
+type Citation struct {
+	Number     int    `json:"number"`
+	DocumentID string `json:"document_id"`
+	Title      string `json:"title,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Source     string `json:"source,omitempty"` // name of the datasource
+	// PageRange is the range of pages of the document chunks that best match
+	// the cited sentences, only set for chunked documents.
+	PageRange *ChunkRange `json:"page_range,omitempty"`
+}
+
 // ToolCallItem represents a single tool invocation within a tool-call chunk.
 type ToolCallItem struct {
 	Name      string `json:"name"`
@@ -105,6 +133,12 @@ type MessageChunk struct {
 	// only populated for chunks with ChunkType="tools". Coexists with
 	// MessageChunk for backward compatibility with older clients.
 	ToolCallMessageChunk string `json:"tool_call_message_chunk,omitempty"`
+
+	// Offset is the position of this chunk in the whole reply stream, starting
+	// from 1. Unlike ChunkSequence, which restarts for every chunk type, it is
+	// strictly increasing and lets a client re-attach to a reply after the chunk
+	// it received last.
+	Offset int `json:"offset,omitempty"`
 }
 
 func NewMessageChunk(sessionId, messageId, messageType, replyToMessage, chunkType, messageChunk string, chunkSequence int) *MessageChunk {
@@ -0,0 +1,83 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"fmt"
+
+	"infini.sh/framework/core/orm"
+)
+
+// Steps of the chat pipeline a prompt template is written for, each supplies
+// its own variables to the template.
+const (
+	PromptUsageAnswering      = "answering"
+	PromptUsageIntentAnalysis = "intent_analysis"
+	PromptUsagePickingDoc     = "picking_doc"
+)
+
+const (
+	PromptVariableString  = "string"
+	PromptVariableNumber  = "number"
+	PromptVariableBoolean = "boolean"
+	PromptVariableList    = "list"
+	PromptVariableObject  = "object"
+)
+
+// PromptTemplate is a named prompt template, shared across assistants.
+type PromptTemplate struct {
+	CombinedFullText
+	Name        string           `json:"name" elastic_mapping:"name:{type:keyword,copy_to:combined_fulltext}"`
+	Description string           `json:"description,omitempty" elastic_mapping:"description:{type:text,copy_to:combined_fulltext}"`
+	Usage       string           `json:"usage" elastic_mapping:"usage:{type:keyword}"`
+	Template    string           `json:"template" elastic_mapping:"template:{enabled:false}"`
+	Variables   []PromptVariable `json:"variables,omitempty" elastic_mapping:"variables:{enabled:false}"`
+}
+
+// PromptVariable declares a variable of a prompt template, the default is
+// used when the pipeline does not supply the variable.
+type PromptVariable struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type,omitempty"` // string by default
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+}
+
+// Validate checks the type of the variable, and of its default value.
+func (v *PromptVariable) Validate() error {
+	if v.Name == "" {
+		return fmt.Errorf("variable name is required")
+	}
+	if v.Type == "" {
+		v.Type = PromptVariableString
+	}
+	if v.Default == nil {
+		return nil
+	}
+
+	ok := false
+	switch v.Type {
+	case PromptVariableString:
+		_, ok = v.Default.(string)
+	case PromptVariableNumber:
+		_, ok = v.Default.(float64)
+		if !ok {
+			_, ok = v.Default.(int)
+		}
+	case PromptVariableBoolean:
+		_, ok = v.Default.(bool)
+	case PromptVariableList:
+		_, ok = v.Default.([]interface{})
+	case PromptVariableObject:
+		_, ok = v.Default.(map[string]interface{})
+	default:
+		return fmt.Errorf("invalid type [%v] of variable [%v]", v.Type, v.Name)
+	}
+	if !ok {
+		return fmt.Errorf("default value of variable [%v] is not a %v", v.Name, v.Type)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"time"
+
+	"infini.sh/framework/core/orm"
+)
+
+const (
+	ReplyStreamStatusRunning  = "running"
+	ReplyStreamStatusFinished = "finished"
+)
+
+// ReplyStream tracks an assistant reply while it is being generated, so that
+// clients connected to any node can re-attach to it or cancel it. The ID is
+// the ID of the reply message.
+type ReplyStream struct {
+	orm.ORMObjectBase
+	SessionID        string `json:"session_id" elastic_mapping:"session_id:{type:keyword}"`
+	RequestMessageID string `json:"request_message_id" elastic_mapping:"request_message_id:{type:keyword}"`
+	// NodeID identifies the node running the reply task.
+	NodeID string `json:"node_id" elastic_mapping:"node_id:{type:keyword}"`
+	Status string `json:"status" elastic_mapping:"status:{type:keyword}"`
+	// CancelRequested is set by a node that received a cancel request for a
+	// task it does not own; the owning node picks it up and stops the task.
+	CancelRequested bool `json:"cancel_requested" elastic_mapping:"cancel_requested:{type:boolean}"`
+	// LastOffset is the offset of the last chunk persisted in ReplyStreamChunks.
+	LastOffset int `json:"last_offset" elastic_mapping:"last_offset:{type:integer}"`
+	// HeartbeatAt is renewed by the owning node while the reply runs, a
+	// running stream whose heartbeat is older than its lease was abandoned,
+	// e.g. by a crashed node.
+	HeartbeatAt time.Time `json:"heartbeat_at" elastic_mapping:"heartbeat_at:{type:date}"`
+}
+
+// ReplyStreamChunks is a persisted batch of consecutive chunks of a reply
+// stream, used to replay the stream from nodes other than the owning one.
+type ReplyStreamChunks struct {
+	orm.ORMObjectBase
+	ReplyMessageID string          `json:"reply_message_id" elastic_mapping:"reply_message_id:{type:keyword}"`
+	FromOffset     int             `json:"from_offset" elastic_mapping:"from_offset:{type:integer}"`
+	ToOffset       int             `json:"to_offset" elastic_mapping:"to_offset:{type:integer}"`
+	Chunks         []*MessageChunk `json:"chunks" elastic_mapping:"chunks:{enabled:false}"`
+}
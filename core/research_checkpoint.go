@@ -0,0 +1,39 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"encoding/json"
+
+	"infini.sh/framework/core/orm"
+)
+
+const (
+	ResearchCheckpointRunning   = "running"
+	ResearchCheckpointStopped   = "stopped"
+	ResearchCheckpointCompleted = "completed"
+)
+
+// ResearchCheckpoint is the state of a deep research saved after each step,
+// so an interrupted research can be resumed instead of started over. The ID
+// is the ID of the reply message.
+type ResearchCheckpoint struct {
+	orm.ORMObjectBase
+	SessionID        string `json:"session_id" elastic_mapping:"session_id:{type:keyword}"`
+	RequestMessageID string `json:"request_message_id" elastic_mapping:"request_message_id:{type:keyword}"`
+	// ResumedFrom is the ID of the checkpoint the research was resumed from
+	ResumedFrom string `json:"resumed_from,omitempty" elastic_mapping:"resumed_from:{type:keyword}"`
+	Status      string `json:"status" elastic_mapping:"status:{type:keyword}"`
+	Error       string `json:"error,omitempty" elastic_mapping:"error:{type:text}"`
+	// Node is the last node of the research graph completed, and
+	// CompletedSteps the number of research steps completed
+	Node           string `json:"node" elastic_mapping:"node:{type:keyword}"`
+	CompletedSteps int    `json:"completed_steps" elastic_mapping:"completed_steps:{type:integer}"`
+	TotalSteps     int    `json:"total_steps" elastic_mapping:"total_steps:{type:integer}"`
+	// State is the state of the research graph, Chunks the chunks streamed so
+	// far, replayed to the client on resume
+	State  json.RawMessage `json:"state,omitempty" elastic_mapping:"state:{enabled:false}"`
+	Chunks json.RawMessage `json:"chunks,omitempty" elastic_mapping:"chunks:{enabled:false}"`
+}
@@ -0,0 +1,34 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"time"
+
+	"infini.sh/framework/core/orm"
+)
+
+const (
+	PlanApprovalPending  = "pending"
+	PlanApprovalApproved = "approved"
+	PlanApprovalRejected = "rejected"
+	PlanApprovalExpired  = "expired"
+)
+
+// ResearchPlanApproval is the plan of a deep research waiting for the user to
+// approve it, persisted so it can be decided after a page reload or from any
+// node. The ID is the ID of the reply message.
+type ResearchPlanApproval struct {
+	orm.ORMObjectBase
+	SessionID        string     `json:"session_id" elastic_mapping:"session_id:{type:keyword}"`
+	RequestMessageID string     `json:"request_message_id" elastic_mapping:"request_message_id:{type:keyword}"`
+	Query            string     `json:"query" elastic_mapping:"query:{type:text}"`
+	Plan             []string   `json:"plan" elastic_mapping:"plan:{enabled:false}"`
+	MaxSteps         int        `json:"max_steps" elastic_mapping:"max_steps:{type:integer}"`
+	Status           string     `json:"status" elastic_mapping:"status:{type:keyword}"`
+	Expires          *time.Time `json:"expires" elastic_mapping:"expires:{type:date}"`
+	// Comment is the reason given by the user with the decision
+	Comment string `json:"comment,omitempty" elastic_mapping:"comment:{type:text}"`
+}
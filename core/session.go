@@ -4,7 +4,11 @@
 
 package core
 
-import "infini.sh/framework/core/orm"
+import (
+	"time"
+
+	"infini.sh/framework/core/orm"
+)
 
 type Session struct {
 	orm.ORMObjectBase
@@ -13,6 +17,16 @@ type Session struct {
 	Summary              string `config:"summary" json:"summary,omitempty" elastic_mapping:"summary:{type:text}"`
 	ManuallyRenamedTitle bool   `config:"manually_renamed_title" json:"manually_renamed_title,omitempty" elastic_mapping:"manually_renamed_title:{type:boolean}"`
 
+	// HistorySummary is the rolling summary of the older turns of a long
+	// conversation, used in place of them in the prompts. Summary is the short
+	// description written with the title.
+	HistorySummary string `config:"history_summary" json:"history_summary,omitempty" elastic_mapping:"history_summary:{type:text}"`
+	// SummarizedUntil is the creation time of the last message folded into HistorySummary
+	SummarizedUntil *time.Time `config:"summarized_until" json:"summarized_until,omitempty" elastic_mapping:"summarized_until:{type:date}"`
+
+	// ActiveMessageID is the last message of the active branch of the conversation
+	ActiveMessageID string `config:"active_message_id" json:"active_message_id,omitempty" elastic_mapping:"active_message_id:{type:keyword}"`
+
 	Visible bool `json:"visible" elastic_mapping:"visible:{type:boolean}"` // Whether the connector is enabled or not
 
 	Context *SessionContext `config:"context" json:"context,omitempty" elastic_mapping:"context:{type:object}"`
@@ -10,6 +10,16 @@ type Config struct {
 	SearchSettings     *SearchSettings     `config:"search_settings" json:"search_settings,omitempty"`
 	DefaultModel       *DefaultModel       `config:"default_model" json:"default_model,omitempty"`
 	DocumentProcessing *DocumentProcessing `config:"document_processing" json:"document_processing,omitempty"`
+	// SMTP sends the emails, e.g. the digests of the assistant jobs
+	SMTP *SMTPConfig `config:"smtp" json:"-"`
+	// LoginProtection throttles and locks out the failed logins
+	LoginProtection *LoginProtectionConfig `config:"login_protection" json:"-"`
+	// TwoFactor is the policy of the TOTP second factor of the logins
+	TwoFactor *TwoFactorConfig `config:"two_factor" json:"-"`
+	// Audit is the policy of the audit logs
+	Audit *AuditConfig `config:"audit" json:"-"`
+	// APIKey is the policy of the API keys
+	APIKey *APIKeyConfig `config:"api_key" json:"-"`
 }
 
 type AppSettings struct {
@@ -4,6 +4,7 @@
 package core
 
 import (
+	"context"
 	"net/http"
 
 	"infini.sh/framework/core/errors"
@@ -39,6 +40,17 @@ func InternalGetIntegration(id string) (*Integration, error) {
 	return &obj, nil
 }
 
+// SessionIntegrationID returns the integration the session of a request was
+// authenticated by, "" for the sessions of real logins and API keys.
+func SessionIntegrationID(ctx context.Context) string {
+	session, err := security.GetUserFromContext(ctx)
+	if err != nil || session == nil {
+		return ""
+	}
+	integrationID, _ := session.GetString(UserSessionInfoKeyIntegration)
+	return integrationID
+}
+
 func ValidateLoginByIntegrationHeader(w http.ResponseWriter, r *http.Request) (claims *security.UserClaims, err error) {
 	integrationID := r.Header.Get(HeaderIntegrationID)
 
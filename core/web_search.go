@@ -0,0 +1,65 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package core
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Web search engines served through a web search provider.
+const (
+	WebSearchEngineSearXNG = "searxng"
+	WebSearchEngineBing    = "bing"
+	WebSearchEngineBrave   = "brave"
+	WebSearchEngineGoogle  = "google"
+)
+
+var WebSearchEngines = []string{WebSearchEngineSearXNG, WebSearchEngineBing, WebSearchEngineBrave, WebSearchEngineGoogle}
+
+// WebSearchConfig selects a web search engine and holds its credentials.
+type WebSearchConfig struct {
+	// Required. One of WebSearchEngines, or for deep research also
+	// "duckduckgo", "wikipedia" or "tavily".
+	Engine string `json:"engine"`
+	// Required for "bing", "brave", "google" and "tavily". Default: "".
+	APIKey string `json:"api_key,omitempty"`
+	// Required for "searxng", the base URL of the instance, e.g.
+	// "http://searxng:8080". Overrides the API endpoint of the other engines.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Required for "google", the ID (cx) of the Programmable Search Engine.
+	SearchEngineID string `json:"search_engine_id,omitempty"`
+	// Optional. Number of results returned by the builtin tool. Default: 5.
+	MaxResults int `json:"max_results,omitempty"`
+}
+
+// Validate checks the settings required by a web search provider.
+func (cfg *WebSearchConfig) Validate() error {
+	switch cfg.Engine {
+	case WebSearchEngineSearXNG:
+		if cfg.Endpoint == "" {
+			return fmt.Errorf("endpoint is required when engine is %q", cfg.Engine)
+		}
+	case WebSearchEngineBing, WebSearchEngineBrave:
+		if cfg.APIKey == "" {
+			return fmt.Errorf("api_key is required when engine is %q", cfg.Engine)
+		}
+	case WebSearchEngineGoogle:
+		if cfg.APIKey == "" || cfg.SearchEngineID == "" {
+			return fmt.Errorf("api_key and search_engine_id are required when engine is %q", cfg.Engine)
+		}
+	default:
+		return fmt.Errorf("engine must be one of: %v", WebSearchEngines)
+	}
+	if cfg.Endpoint != "" {
+		if u, err := url.Parse(cfg.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid endpoint: %v", cfg.Endpoint)
+		}
+	}
+	if cfg.MaxResults < 0 {
+		return fmt.Errorf("max_results must not be negative")
+	}
+	return nil
+}
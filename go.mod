@@ -31,6 +31,8 @@ require (
 	github.com/minio/minio-go/v7 v7.0.90
 	github.com/mmcdole/gofeed v1.3.0
 	github.com/neo4j/neo4j-go-driver/v5 v5.28.3
+	github.com/pkoukk/tiktoken-go v0.1.6
+	github.com/pkoukk/tiktoken-go-loader v0.0.2
 	github.com/sijms/go-ora/v2 v2.9.0
 	github.com/smallnest/langgraphgo v0.5.1-0.20251208060332-cdde22ee1d77
 	github.com/stretchr/testify v1.11.1
@@ -185,7 +187,6 @@ require (
 	github.com/pelletier/go-toml/v2 v2.2.3 // indirect
 	github.com/pierrec/lz4/v4 v4.1.22 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/pkoukk/tiktoken-go v0.1.6 // indirect
 	github.com/power-devops/perfstat v0.0.0-20240221224432-82ca36839d55 // indirect
 	github.com/r3labs/diff/v2 v2.15.1 // indirect
 	github.com/rogpeppe/go-internal v1.13.1 // indirect
@@ -28,15 +28,31 @@ func (h *APIHandler) createAssistant(w http.ResponseWriter, req *http.Request, p
 		return
 	}
 
+	if err = service.ValidateAssistantPrompts(req.Context(), obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = service.ValidateAssistantTools(obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = common.EncryptAssistantSecrets(obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ctx := orm.NewContextWithParent(req.Context())
 	ctx.Refresh = orm.WaitForRefresh
 
 	obj.Builtin = false
+	obj.Revision = 1
 	err = orm.Create(ctx, obj)
 	if err != nil {
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.saveFirstRevision(req, obj)
+	common.RecordAudit(req, core.AuditActionCreate, core.AuditResourceAssistant, obj.ID, nil, obj)
 
 	service.ClearAssistantsCache()
 
@@ -58,7 +74,10 @@ func (h *APIHandler) getAssistant(w http.ResponseWriter, req *http.Request, ps h
 		return
 	}
 
-	h.WriteGetOKJSON(w, id, obj)
+	// the assistant is cached, the secrets are redacted from a copy
+	redacted := *obj
+	common.RedactAssistantSecrets(&redacted)
+	h.WriteGetOKJSON(w, id, &redacted)
 }
 
 func (h *APIHandler) updateAssistant(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -84,6 +103,15 @@ func (h *APIHandler) updateAssistant(w http.ResponseWriter, req *http.Request, p
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	common.RestoreAssistantSecrets(&newObj, &obj)
+	if err = service.ValidateAssistantPrompts(req.Context(), &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = service.ValidateAssistantTools(&newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	//protect
 	newObj.ID = id
@@ -93,13 +121,10 @@ func (h *APIHandler) updateAssistant(w http.ResponseWriter, req *http.Request, p
 	newObj.Builtin = obj.Builtin
 	newObj.Created = obj.Created
 
-	ctx.Refresh = orm.WaitForRefresh
-	ctx.Set(orm.SharingEnabled, true)
-	ctx.Set(orm.SharingResourceType, "assistant")
-
-	err = orm.Update(ctx, &newObj)
+	comment := h.GetParameterOrDefault(req, "comment", "")
+	err = h.updateAssistantWithRevision(req, &obj, &newObj, comment, 0)
 	if err != nil {
-		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		h.writeUpdateError(w, err)
 		return
 	}
 
@@ -138,6 +163,11 @@ func (h *APIHandler) deleteAssistant(w http.ResponseWriter, req *http.Request, p
 		return
 	}
 
+	if err := service.DeleteAssistantRevisions(req.Context(), obj.ID); err != nil {
+		_ = log.Errorf("failed to delete the revisions of assistant [%v]: %v", obj.ID, err)
+	}
+	common.RecordAudit(req, core.AuditActionDelete, core.AuditResourceAssistant, obj.ID, &obj, nil)
+
 	h.WriteDeletedOKJSON(w, obj.ID)
 }
 
@@ -150,6 +180,9 @@ func (h *APIHandler) searchAssistant(w http.ResponseWriter, req *http.Request, p
 		return
 	}
 	builder.EnableBodyBytes()
+	if ids, restricted := core.APIKeyAssistants(req.Context()); restricted {
+		builder.Filter(orm.TermsQuery("id", ids))
+	}
 	if len(builder.Sorts()) == 0 {
 		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
 	}
@@ -159,7 +192,7 @@ func (h *APIHandler) searchAssistant(w http.ResponseWriter, req *http.Request, p
 	docs := []core.Assistant{}
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "assistant")
-	err, res := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, nil)
+	err, res := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, redactAssistantSource)
 	if err != nil {
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 
@@ -172,6 +205,18 @@ func (h *APIHandler) searchAssistant(w http.ResponseWriter, req *http.Request, p
 	}
 }
 
+// redactAssistantSource redacts the secrets of an assistant hit, e.g. the API
+// key of its web search tool.
+func redactAssistantSource(source map[string]interface{}, targetRef interface{}) error {
+	if tools, ok := source["tools"]; ok {
+		source["tools"] = common.RedactConfigSecrets(tools)
+	}
+	if config, ok := source["config"]; ok {
+		source["config"] = common.RedactConfigSecrets(config)
+	}
+	return nil
+}
+
 func (h *APIHandler) cloneAssistant(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	id := ps.MustGetParameter("id")
 
@@ -195,6 +240,11 @@ func (h *APIHandler) cloneAssistant(w http.ResponseWriter, req *http.Request, ps
 	obj.Created = &now
 	obj.Updated = &now
 	obj.Builtin = false
+	obj.Revision = 1
+	if err = common.EncryptAssistantSecrets(&obj); err != nil {
+		h.Error(w, err)
+		return
+	}
 
 	ctx.Refresh = orm.WaitForRefresh
 
@@ -203,5 +253,7 @@ func (h *APIHandler) cloneAssistant(w http.ResponseWriter, req *http.Request, ps
 		h.Error(w, err)
 		return
 	}
+	h.saveFirstRevision(req, &obj)
+	common.RecordAudit(req, core.AuditActionCreate, core.AuditResourceAssistant, obj.ID, nil, &obj)
 	h.WriteCreatedOKJSON(w, obj.ID)
 }
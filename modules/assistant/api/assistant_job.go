@@ -0,0 +1,286 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/service"
+	"infini.sh/coco/modules/document"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// validateAssistantJob checks a job and that its assistant and datasources
+// are accessible to the user, and schedules its next run.
+func validateAssistantJob(req *http.Request, job *core.AssistantJob) error {
+	if err := job.Validate(); err != nil {
+		return err
+	}
+	next, err := service.NextJobRun(job.Schedule, time.Now())
+	if err != nil {
+		return err
+	}
+	job.NextRun = &next
+
+	_, exists, err := service.GetAssistant(req, job.AssistantID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("assistant [%v] not found", job.AssistantID)
+	}
+
+	if len(job.Datasources) == 0 {
+		return nil
+	}
+	reqUser := security.MustGetUserFromRequest(req)
+	teamsID, _ := reqUser.GetStringArray(orm.TeamsIDKey)
+	access, err := document.ResolveDocumentAccess(req.Context(), reqUser.MustGetUserID(), teamsID, job.Datasources, "")
+	if err != nil {
+		return err
+	}
+	for _, id := range job.Datasources {
+		if !access.CanAccessDatasource(id) {
+			return fmt.Errorf("datasource [%v] not found", id)
+		}
+	}
+	return nil
+}
+
+func (h APIHandler) createAssistantJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	obj := &core.AssistantJob{}
+	if err := h.DecodeJSON(req, obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	obj.LastRun = nil
+	obj.LastSuccess = nil
+	if err := validateAssistantJob(req, obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Create(ctx, obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteCreatedOKJSON(w, obj.ID)
+}
+
+func (h APIHandler) getAssistantJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.AssistantJob{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	h.WriteGetOKJSON(w, id, obj)
+}
+
+func (h APIHandler) updateAssistantJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.AssistantJob{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	newObj := core.AssistantJob{}
+	if err := h.DecodeJSON(req, &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateAssistantJob(req, &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	//protect
+	newObj.ID = id
+	newObj.Created = obj.Created
+	newObj.LastRun = obj.LastRun
+	newObj.LastSuccess = obj.LastSuccess
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Update(ctx, &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteUpdatedOKJSON(w, id)
+}
+
+func (h APIHandler) deleteAssistantJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.AssistantJob{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Delete(ctx, &obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the run history goes with the job
+	runs := orm.NewQuery()
+	runs.Filter(orm.TermQuery("job_id", id))
+	ctx1 := orm.NewContextWithParent(req.Context())
+	ctx1.DirectAccess()
+	orm.WithModel(ctx1, &core.AssistantJobRun{})
+	if _, err := orm.DeleteByQuery(ctx1, runs); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteDeletedOKJSON(w, id)
+}
+
+func (h APIHandler) searchAssistantJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	builder, err := orm.NewQueryBuilderFromRequest(req, "name", "combined_fulltext")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if assistantID := h.GetParameterOrDefault(req, "assistant_id", ""); assistantID != "" {
+		builder.Filter(orm.TermQuery("assistant_id", assistantID))
+	}
+	builder.EnableBodyBytes()
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	orm.WithModel(ctx, &core.AssistantJob{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.Write(w, res.Payload.([]byte)); err != nil {
+		h.Error(w, err)
+	}
+}
+
+// runAssistantJob runs a job now in the background, whether it is enabled
+// or not, the run can be followed with its ID.
+func (h APIHandler) runAssistantJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.AssistantJob{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	run, err := service.StartAssistantJobRun(req.Context(), &obj)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    run.ID,
+		"result": "created",
+		"status": run.Status,
+	}, http.StatusOK)
+}
+
+// searchAssistantJobRuns lists the runs of a job, newest first, without the
+// answers.
+func (h APIHandler) searchAssistantJobRuns(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	builder, err := orm.NewQueryBuilderFromRequest(req)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.Filter(orm.TermQuery("job_id", id))
+	if status := h.GetParameterOrDefault(req, "status", ""); status != "" {
+		builder.Filter(orm.TermQuery("status", status))
+	}
+	builder.Exclude("answer")
+	builder.EnableBodyBytes()
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	orm.WithModel(ctx, &core.AssistantJobRun{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.Write(w, res.Payload.([]byte)); err != nil {
+		h.Error(w, err)
+	}
+}
+
+func (h APIHandler) getAssistantJobRun(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.AssistantJobRun{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	h.WriteGetOKJSON(w, id, obj)
+}
+
+func (h APIHandler) deleteAssistantJobRun(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.AssistantJobRun{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Delete(ctx, &obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteDeletedOKJSON(w, id)
+}
@@ -0,0 +1,222 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/service"
+	"infini.sh/coco/modules/common"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// saveFirstRevision stores the configuration of a created assistant as its
+// first revision. The assistant is created already, a failure is only logged.
+func (h *APIHandler) saveFirstRevision(req *http.Request, obj *core.Assistant) {
+	userID := security.MustGetUserFromRequest(req).MustGetUserID()
+	if err := service.SaveAssistantRevision(req.Context(), userID, nil, obj, "", 0); err != nil {
+		_ = log.Errorf("failed to save the first revision of assistant [%v]: %v", obj.ID, err)
+	}
+}
+
+// updateAssistantWithRevision replaces the configuration prev of an assistant
+// with next, storing next as a new revision, and audits the change. The
+// assistant is only replaced if it is still at the revision of prev, so the
+// concurrent updates of an assistant can not overwrite each other nor their
+// revisions, the later ones fail with common.ErrConcurrentUpdate.
+func (h *APIHandler) updateAssistantWithRevision(req *http.Request, prev, next *core.Assistant, comment string, rollbackOf int) error {
+	userID := security.MustGetUserFromRequest(req).MustGetUserID()
+	revision, err := service.NextAssistantRevision(req.Context(), userID, prev)
+	if err != nil {
+		return err
+	}
+	next.Revision = revision
+	now := time.Now()
+	next.Updated = &now
+	if err = common.EncryptAssistantSecrets(next); err != nil {
+		return err
+	}
+
+	var expected interface{}
+	if prev.Revision > 0 {
+		expected = prev.Revision
+	}
+	if err = common.UpdateIfUnchanged(next.ID, next, "revision", expected, true); err != nil {
+		return err
+	}
+	common.GeneralObjectCache.Delete(core.AssistantCachePrimary, next.ID)
+	if err = service.SaveAssistantRevision(req.Context(), userID, prev, next, comment, rollbackOf); err != nil {
+		return err
+	}
+	common.RecordAudit(req, core.AuditActionUpdate, core.AuditResourceAssistant, next.ID, prev, next)
+	return nil
+}
+
+// writeUpdateError writes the error of an update, a concurrent update of the
+// object is a conflict.
+func (h *APIHandler) writeUpdateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, common.ErrConcurrentUpdate) {
+		h.WriteError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	h.WriteError(w, err.Error(), http.StatusInternalServerError)
+}
+
+// getAccessibleAssistant loads the stored configuration of an assistant the
+// user owns or is shared with.
+func getAccessibleAssistant(req *http.Request, id string) (*core.Assistant, bool, error) {
+	obj := &core.Assistant{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.Set(orm.SharingEnabled, true)
+	ctx.Set(orm.SharingResourceType, "assistant")
+
+	exists, err := orm.GetV2(ctx, obj)
+	return obj, exists, err
+}
+
+// searchAssistantRevisions lists the revisions of an assistant, newest first,
+// with who changed what. The snapshots are omitted unless `snapshot=true`.
+func (h *APIHandler) searchAssistantRevisions(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	_, exists, err := getAccessibleAssistant(req, id)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	builder, err := orm.NewQueryBuilderFromRequest(req, "comment")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.Filter(orm.TermQuery("assistant_id", id))
+	if !h.GetBoolOrDefault(req, "snapshot", false) {
+		builder.Exclude("snapshot")
+	}
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "revision", SortType: orm.DESC})
+	}
+
+	// the revisions are shared along with the assistant
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.DirectReadAccess()
+	orm.WithModel(ctx, &core.AssistantRevision{})
+	docs := []core.AssistantRevision{}
+	err, res := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, func(source map[string]interface{}, targetRef interface{}) error {
+		if snapshot, ok := source["snapshot"].(map[string]interface{}); ok {
+			return redactAssistantSource(snapshot, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.Write(w, res.Raw); err != nil {
+		h.Error(w, err)
+	}
+}
+
+func (h *APIHandler) getAssistantRevision(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+	revision, err := strconv.Atoi(ps.MustGetParameter("revision"))
+	if err != nil {
+		h.WriteError(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	_, exists, err := getAccessibleAssistant(req, id)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	rev, exists, err := service.GetAssistantRevision(req.Context(), id, revision)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.WriteOpRecordNotFoundJSON(w, core.AssistantRevisionID(id, revision))
+		return
+	}
+
+	common.RedactAssistantSecrets(&rev.Snapshot)
+	h.WriteGetOKJSON(w, rev.ID, rev)
+}
+
+type rollbackAssistantRequest struct {
+	Revision int    `json:"revision"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// rollbackAssistant restores the configuration of a revision, as a new
+// revision, the history is never rewritten.
+func (h *APIHandler) rollbackAssistant(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	var request rollbackAssistantRequest
+	if err := h.DecodeJSON(req, &request); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Revision <= 0 {
+		h.WriteError(w, "revision is required", http.StatusBadRequest)
+		return
+	}
+
+	//clear cache
+	common.GeneralObjectCache.Delete(core.AssistantCachePrimary, id)
+
+	obj, exists, err := getAccessibleAssistant(req, id)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	rev, exists, err := service.GetAssistantRevision(req.Context(), id, request.Revision)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.WriteOpRecordNotFoundJSON(w, core.AssistantRevisionID(id, request.Revision))
+		return
+	}
+
+	newObj := rev.Snapshot
+
+	//protect
+	newObj.ID = id
+	if obj.Builtin {
+		newObj.Name = obj.Name
+	}
+	newObj.Builtin = obj.Builtin
+	newObj.Created = obj.Created
+
+	err = h.updateAssistantWithRevision(req, obj, &newObj, request.Comment, request.Revision)
+	if err != nil {
+		h.writeUpdateError(w, err)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":      id,
+		"result":   "updated",
+		"revision": newObj.Revision,
+	}, http.StatusOK)
+}
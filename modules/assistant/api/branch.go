@@ -0,0 +1,228 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"net/http"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/service"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// getOwnMessage loads a message of a session owned by the user of the request.
+func getOwnMessage(req *http.Request, sessionID, messageID string) (*core.ChatMessage, bool) {
+	msg := core.ChatMessage{}
+	msg.ID = messageID
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &msg)
+	if err != nil || !exists || msg.SessionID != sessionID {
+		return nil, false
+	}
+	return &msg, true
+}
+
+// regenerateMessage generates a new reply to a question, a sibling of the
+// previous replies. The message can be the question or one of its replies,
+// the reply can be given by another assistant with assistant_id.
+func (h APIHandler) regenerateMessage(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+	messageID := ps.MustGetParameter("message_id")
+
+	msg, ok := getOwnMessage(req, sessionID, messageID)
+	if !ok {
+		h.WriteOpRecordNotFoundJSON(w, messageID)
+		return
+	}
+	reqMsg := msg
+	if msg.MessageType == core.MessageTypeAssistant {
+		if reqMsg, ok = getOwnMessage(req, sessionID, msg.ReplyMessageID); !ok {
+			h.WriteOpRecordNotFoundJSON(w, msg.ReplyMessageID)
+			return
+		}
+	}
+	if reqMsg.MessageType != core.MessageTypeUser {
+		h.WriteError(w, "only replies to user messages can be regenerated", http.StatusBadRequest)
+		return
+	}
+
+	// one reply to a question at a time, whichever node generates it
+	inflight, err := service.IsMessageBeingReplied(req.Context(), sessionID, reqMsg.ID)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if inflight {
+		h.WriteError(w, "the message is being replied", http.StatusConflict)
+		return
+	}
+
+	id := h.GetParameterOrDefault(req, "assistant_id", msg.AssistantID)
+	assistant, exists, err := service.GetAssistant(req, id)
+	if err != nil {
+		h.WriteError(w, "failed to get assistant", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	response := []util.MapStr{{
+		"_id":     reqMsg.ID,
+		"result":  "regenerating",
+		"_source": reqMsg,
+	}}
+	h.streamAssistantReply(w, req, assistant, sessionID, reqMsg, response)
+}
+
+// editAndResendMessage saves an edited version of a question as a sibling of
+// it, in a new branch of the conversation, and streams the reply to it.
+func (h APIHandler) editAndResendMessage(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+	messageID := ps.MustGetParameter("message_id")
+
+	var request core.MessageRequest
+	if err := h.DecodeJSON(req, &request); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.IsEmpty() {
+		h.WriteError(w, "message is required", http.StatusBadRequest)
+		return
+	}
+
+	msg, ok := getOwnMessage(req, sessionID, messageID)
+	if !ok {
+		h.WriteOpRecordNotFoundJSON(w, messageID)
+		return
+	}
+	if msg.MessageType != core.MessageTypeUser {
+		h.WriteError(w, "only user messages can be edited", http.StatusBadRequest)
+		return
+	}
+
+	id := h.GetParameterOrDefault(req, "assistant_id", msg.AssistantID)
+	assistant, exists, err := service.GetAssistant(req, id)
+	if err != nil {
+		h.WriteError(w, "failed to get assistant", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	// the parent of messages saved before branching is implied by their order
+	parentID := msg.ParentID
+	if parentID == "" {
+		tree, err := service.LoadMessageTree(req.Context(), sessionID, msg.ID)
+		if err != nil {
+			h.WriteError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		parentID = tree.Parent(msg.ID)
+	}
+
+	ormCtx := orm.NewContextWithParent(req.Context())
+	ormCtx.Refresh = orm.WaitForRefresh
+	reqMsg, err := service.SaveRequestMessage(ormCtx, sessionID, id, parentID, &request)
+	if err != nil {
+		h.Error(w, err)
+		return
+	}
+
+	response := []util.MapStr{{
+		"_id":     reqMsg.ID,
+		"result":  "created",
+		"_source": reqMsg,
+	}}
+	h.streamAssistantReply(w, req, assistant, sessionID, reqMsg, response)
+}
+
+// branchMessage is a message of the active branch with its alternatives.
+type branchMessage struct {
+	core.ChatMessage
+	Siblings []string `json:"siblings,omitempty"`
+}
+
+// getSessionBranch returns the active branch of a session, each message with
+// the IDs of its alternatives, e.g. the regenerated replies of a question.
+func (h APIHandler) getSessionBranch(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+
+	session, exists, err := getSharedSession(req.Context(), sessionID)
+	if err != nil || !exists {
+		h.WriteOpRecordNotFoundJSON(w, sessionID)
+		return
+	}
+	h.writeSessionBranch(w, req, sessionID, session.ActiveMessageID)
+}
+
+// corsPreflight answers the preflight requests of the routes whose methods
+// have different handlers, the CORS headers are set by the CORS feature.
+func (h APIHandler) corsPreflight(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// switchSessionBranch makes the branch of a message the active branch of a
+// session, the branch continues to the latest reply following the message.
+func (h APIHandler) switchSessionBranch(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+
+	var request struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := h.DecodeJSON(req, &request); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := getOwnMessage(req, sessionID, request.MessageID); !ok {
+		h.WriteOpRecordNotFoundJSON(w, request.MessageID)
+		return
+	}
+
+	tree, err := service.LoadMessageTree(req.Context(), sessionID, request.MessageID)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	leafID := tree.Leaf(request.MessageID)
+	if err := service.SetActiveMessage(req.Context(), sessionID, leafID); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeSessionBranch(w, req, sessionID, leafID)
+}
+
+func (h APIHandler) writeSessionBranch(w http.ResponseWriter, req *http.Request, sessionID, leafID string) {
+	tree, err := service.LoadMessageTree(req.Context(), sessionID, leafID)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	branch := tree.Branch(leafID)
+
+	hits := make([]branchMessage, 0, len(branch))
+	for _, msg := range branch {
+		hit := branchMessage{ChatMessage: msg}
+		if siblings := tree.Siblings(msg.ID); len(siblings) > 1 {
+			hit.Siblings = siblings
+		}
+		hits = append(hits, hit)
+	}
+	activeMessageID := ""
+	if len(branch) > 0 {
+		activeMessageID = branch[len(branch)-1].ID
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":               sessionID,
+		"active_message_id": activeMessageID,
+		"messages":          hits,
+	}, http.StatusOK)
+}
@@ -0,0 +1,151 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"infini.sh/coco/core"
+	common2 "infini.sh/coco/modules/assistant/common"
+	deep_research2 "infini.sh/coco/modules/assistant/deep_research_v2"
+	"infini.sh/coco/modules/assistant/service"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// getResearchPlanApproval returns the plan of a deep research reply waiting
+// for approval, or its decision, so it can be shown again after a reload.
+func (h APIHandler) getResearchPlanApproval(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+	messageID := ps.MustGetParameter("message_id")
+
+	if !h.sessionAccessible(req, sessionID) {
+		h.WriteOpRecordNotFoundJSON(w, sessionID)
+		return
+	}
+
+	record, err := deep_research2.GetPlanApproval(req.Context(), sessionID, messageID)
+	if err != nil {
+		if errors.Is(err, deep_research2.ErrPlanApprovalNotFound) {
+			h.WriteOpRecordNotFoundJSON(w, messageID)
+			return
+		}
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteGetOKJSON(w, messageID, record)
+}
+
+// decideResearchPlan approves, edits or rejects the plan of a paused deep
+// research reply, the research resumes or stops accordingly.
+func (h APIHandler) decideResearchPlan(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+	messageID := ps.MustGetParameter("message_id")
+
+	var decision deep_research2.PlanDecision
+	if err := h.DecodeJSON(req, &decision); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !h.sessionAccessible(req, sessionID) {
+		h.WriteOpRecordNotFoundJSON(w, sessionID)
+		return
+	}
+
+	record, err := deep_research2.DecidePlanApproval(req.Context(), sessionID, messageID, decision)
+	if err != nil {
+		if errors.Is(err, deep_research2.ErrPlanApprovalNotFound) {
+			h.WriteOpRecordNotFoundJSON(w, messageID)
+			return
+		}
+		if errors.Is(err, deep_research2.ErrPlanAlreadyDecided) {
+			h.WriteError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.WriteUpdatedOKJSON(w, record.ID)
+}
+
+// resumeResearch resumes an interrupted deep research reply from its last
+// checkpoint, in a new reply to the same question.
+func (h APIHandler) resumeResearch(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+	messageID := ps.MustGetParameter("message_id")
+
+	msg, ok := getOwnMessage(req, sessionID, messageID)
+	if !ok || msg.MessageType != core.MessageTypeAssistant {
+		h.WriteOpRecordNotFoundJSON(w, messageID)
+		return
+	}
+	reqMsg, ok := getOwnMessage(req, sessionID, msg.ReplyMessageID)
+	if !ok {
+		h.WriteOpRecordNotFoundJSON(w, msg.ReplyMessageID)
+		return
+	}
+
+	checkpoint, err := deep_research2.GetCheckpoint(req.Context(), sessionID, messageID)
+	if err != nil {
+		if errors.Is(err, deep_research2.ErrCheckpointNotFound) {
+			h.WriteOpRecordNotFoundJSON(w, messageID)
+			return
+		}
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := deep_research2.CheckResumable(checkpoint); err != nil {
+		h.WriteError(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// one reply to a question at a time
+	if _, inflight := service.InflightMessages.Load(service.GetReplyMessageTaskID(sessionID, reqMsg.ID)); inflight {
+		h.WriteError(w, "the message is being replied", http.StatusConflict)
+		return
+	}
+
+	assistant, exists, err := service.GetAssistant(req, msg.AssistantID)
+	if err != nil {
+		h.WriteError(w, "failed to get assistant", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.WriteOpRecordNotFoundJSON(w, msg.AssistantID)
+		return
+	}
+	if assistant.Type != core.AssistantTypeDeepResearch {
+		h.WriteError(w, "only deep research replies can be resumed", http.StatusBadRequest)
+		return
+	}
+
+	params, err := common2.NewRagContext(req, assistant, sessionID)
+	if err != nil {
+		h.Error(w, err)
+		return
+	}
+	params.ResumeResearch = checkpoint.ID
+
+	response := []util.MapStr{{
+		"_id":     reqMsg.ID,
+		"result":  "resuming",
+		"_source": reqMsg,
+	}}
+	h.streamAssistantReplyWithParams(w, req, assistant, sessionID, reqMsg, response, params)
+}
+
+// sessionAccessible checks the session can be read by the user.
+func (h APIHandler) sessionAccessible(req *http.Request, sessionID string) bool {
+	session := core.Session{}
+	session.ID = sessionID
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &session)
+	return exists && err == nil
+}
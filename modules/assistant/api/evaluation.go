@@ -0,0 +1,272 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"net/http"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/service"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+func (h APIHandler) createEvaluationSet(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	obj := &core.EvaluationSet{}
+	if err := h.DecodeJSON(req, obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := obj.Validate(); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Create(ctx, obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    obj.ID,
+		"result": "created",
+	}, http.StatusOK)
+}
+
+func (h APIHandler) getEvaluationSet(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.EvaluationSet{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"found":   true,
+		"_id":     id,
+		"_source": obj,
+	}, http.StatusOK)
+}
+
+func (h APIHandler) updateEvaluationSet(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.EvaluationSet{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	newObj := core.EvaluationSet{}
+	if err := h.DecodeJSON(req, &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := newObj.Validate(); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	//protect
+	newObj.ID = id
+	newObj.Created = obj.Created
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Update(ctx, &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    id,
+		"result": "updated",
+	}, http.StatusOK)
+}
+
+func (h APIHandler) deleteEvaluationSet(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.EvaluationSet{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Delete(ctx, &obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    id,
+		"result": "deleted",
+	}, http.StatusOK)
+}
+
+func (h APIHandler) searchEvaluationSet(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	builder, err := orm.NewQueryBuilderFromRequest(req, "name", "combined_fulltext")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.EnableBodyBytes()
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	orm.WithModel(ctx, &core.EvaluationSet{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.Write(w, res.Payload.([]byte)); err != nil {
+		h.Error(w, err)
+	}
+}
+
+// evaluateRequest is the body of the evaluation API, the judge model scoring
+// the expected answers defaults to the default answering model.
+type evaluateRequest struct {
+	SetID      string            `json:"set_id"`
+	JudgeModel *core.ModelConfig `json:"judge_model,omitempty"`
+}
+
+// evaluateAssistant runs an evaluation set against an assistant in the
+// background, the run can be followed with its ID.
+func (h APIHandler) evaluateAssistant(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	var request evaluateRequest
+	if err := h.DecodeJSON(req, &request); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	assistant, exists, err := service.GetAssistant(req, id)
+	if err != nil {
+		h.WriteError(w, "failed to get assistant", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	set := core.EvaluationSet{}
+	set.ID = request.SetID
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err = orm.GetV2(ctx, &set)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, request.SetID)
+		return
+	}
+
+	userID := security.MustGetUserFromRequest(req).MustGetUserID()
+	run, err := service.StartEvaluationRun(req.Context(), userID, assistant, &set, request.JudgeModel)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    run.ID,
+		"result": "created",
+		"status": run.Status,
+	}, http.StatusOK)
+}
+
+func (h APIHandler) getEvaluationRun(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.EvaluationRun{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"found":   true,
+		"_id":     id,
+		"_source": obj,
+	}, http.StatusOK)
+}
+
+func (h APIHandler) deleteEvaluationRun(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.EvaluationRun{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Delete(ctx, &obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":    id,
+		"result": "deleted",
+	}, http.StatusOK)
+}
+
+// searchEvaluationRun lists the runs, newest first, optionally of an assistant
+// and an evaluation set, to compare the scores of the assistant over time.
+// The results of the questions are omitted.
+func (h APIHandler) searchEvaluationRun(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	builder, err := orm.NewQueryBuilderFromRequest(req, "set_name")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if assistantID := h.GetParameterOrDefault(req, "assistant_id", ""); assistantID != "" {
+		builder.Filter(orm.TermQuery("assistant_id", assistantID))
+	}
+	if setID := h.GetParameterOrDefault(req, "set_id", ""); setID != "" {
+		builder.Filter(orm.TermQuery("set_id", setID))
+	}
+	builder.Exclude("results")
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	orm.WithModel(ctx, &core.EvaluationRun{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.Write(w, res.Payload.([]byte)); err != nil {
+		h.Error(w, err)
+	}
+}
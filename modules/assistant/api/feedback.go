@@ -0,0 +1,232 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/service"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// feedbackRequest is the body of the feedback API, an empty vote removes the
+// feedback of the user.
+type feedbackRequest struct {
+	Vote    string `json:"vote"`
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+// maxFeedbackReportWindow bounds the down votes a report is built from.
+const maxFeedbackReportWindow = 1000
+
+func (h APIHandler) sendMessageFeedback(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+	messageID := ps.MustGetParameter("message_id")
+
+	var request feedbackRequest
+	if err := h.DecodeJSON(req, &request); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// only the owner of the session can see, and rate, the message
+	msg := core.ChatMessage{}
+	msg.ID = messageID
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &msg)
+	if err != nil || !exists || msg.SessionID != sessionID {
+		h.WriteOpRecordNotFoundJSON(w, messageID)
+		return
+	}
+	if msg.MessageType != core.MessageTypeAssistant {
+		h.WriteError(w, "only assistant messages can be rated", http.StatusBadRequest)
+		return
+	}
+
+	userID := security.MustGetUserFromRequest(req).MustGetUserID()
+	if request.Vote == "" {
+		if _, err := service.DeleteMessageFeedback(req.Context(), &msg, userID); err != nil {
+			h.WriteError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.WriteJSON(w, util.MapStr{
+			"_id":       service.FeedbackID(messageID, userID),
+			"result":    "deleted",
+			"up_vote":   msg.UpVote,
+			"down_vote": msg.DownVote,
+		}, http.StatusOK)
+		return
+	}
+
+	feedback := core.MessageFeedback{
+		UserID:  userID,
+		Vote:    request.Vote,
+		Reason:  request.Reason,
+		Comment: request.Comment,
+	}
+	if err := feedback.Validate(); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := service.SaveMessageFeedback(req.Context(), &msg, &feedback); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":       feedback.ID,
+		"result":    "updated",
+		"up_vote":   msg.UpVote,
+		"down_vote": msg.DownVote,
+	}, http.StatusOK)
+}
+
+// downVotedAnswer is a down-voted answer in the feedback report, with the
+// question and the sources it was based on.
+type downVotedAnswer struct {
+	MessageID   string                 `json:"message_id"`
+	SessionID   string                 `json:"session_id"`
+	AssistantID string                 `json:"assistant_id"`
+	Question    string                 `json:"question"`
+	Answer      string                 `json:"answer"`
+	UpVote      int                    `json:"up_vote"`
+	DownVote    int                    `json:"down_vote"`
+	Sources     []interface{}          `json:"sources"`
+	Citations   []core.Citation        `json:"citations,omitempty"`
+	Feedback    []core.MessageFeedback `json:"feedback"`
+}
+
+// getFeedbackReport lists the down-voted answers, newest first, optionally of
+// a single assistant, with the number of down votes per assistant and reason.
+// It is built from the latest maxFeedbackReportWindow down votes, truncated
+// tells whether there were more.
+func (h APIHandler) getFeedbackReport(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	assistantID := h.GetParameterOrDefault(req, "assistant_id", "")
+	from := max(h.GetIntOrDefault(req, "from", 0), 0)
+	size := h.GetIntOrDefault(req, "size", 20)
+	if size <= 0 {
+		size = 20
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.DirectReadAccess()
+	orm.WithModel(ctx, &core.MessageFeedback{})
+
+	builder := orm.NewQuery()
+	builder.Must(orm.TermQuery("vote", core.FeedbackVoteDown))
+	if assistantID != "" {
+		builder.Must(orm.TermQuery("assistant_id", assistantID))
+	}
+	builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	builder.Size(maxFeedbackReportWindow)
+
+	feedback := []core.MessageFeedback{}
+	err, res := elastic.SearchV2WithResultItemMapper(ctx, &feedback, builder, nil)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the report only covers the most recent down votes
+	truncated := res != nil && res.Total > int64(len(feedback))
+
+	// group by message, the most recently down-voted first
+	byAssistant := map[string]int{}
+	byReason := map[string]int{}
+	messageIDs := []string{}
+	feedbackByMessage := map[string][]core.MessageFeedback{}
+	for _, f := range feedback {
+		byAssistant[f.AssistantID]++
+		reason := f.Reason
+		if reason == "" {
+			reason = core.FeedbackReasonOther
+		}
+		byReason[reason]++
+		if _, ok := feedbackByMessage[f.MessageID]; !ok {
+			messageIDs = append(messageIDs, f.MessageID)
+		}
+		feedbackByMessage[f.MessageID] = append(feedbackByMessage[f.MessageID], f)
+	}
+
+	total := len(messageIDs)
+	from = min(from, total)
+	messageIDs = messageIDs[from:min(from+size, total)]
+
+	answers, err := getMessagesByID(req.Context(), messageIDs)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	questionIDs := []string{}
+	for _, msg := range answers {
+		if msg.ReplyMessageID != "" {
+			questionIDs = append(questionIDs, msg.ReplyMessageID)
+		}
+	}
+	questions, err := getMessagesByID(req.Context(), questionIDs)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]downVotedAnswer, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		msg, ok := answers[id]
+		if !ok {
+			continue
+		}
+		items = append(items, downVotedAnswer{
+			MessageID:   msg.ID,
+			SessionID:   msg.SessionID,
+			AssistantID: msg.AssistantID,
+			Question:    questions[msg.ReplyMessageID].Message,
+			Answer:      msg.Message,
+			UpVote:      msg.UpVote,
+			DownVote:    msg.DownVote,
+			Sources:     collectSources(&msg),
+			Citations:   msg.Citations,
+			Feedback:    feedbackByMessage[id],
+		})
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"total":        total,
+		"by_assistant": byAssistant,
+		"by_reason":    byReason,
+		"truncated":    truncated,
+		"hits":         items,
+	}, http.StatusOK)
+}
+
+// getMessagesByID loads chat messages of any user, keyed by ID.
+func getMessagesByID(parent context.Context, ids []string) (map[string]core.ChatMessage, error) {
+	out := make(map[string]core.ChatMessage, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	ctx := orm.NewContextWithParent(parent)
+	ctx.DirectReadAccess()
+	orm.WithModel(ctx, &core.ChatMessage{})
+
+	builder := orm.NewQuery()
+	builder.Filter(orm.TermsQuery("id", ids))
+	builder.Size(len(ids))
+
+	docs := []core.ChatMessage{}
+	if err, _ := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, nil); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		out[doc.ID] = doc
+	}
+	return out, nil
+}
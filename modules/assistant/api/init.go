@@ -17,11 +17,17 @@ type APIHandler struct {
 const Category = "coco"
 const Session = "session"
 const Assistant = "assistant"
+const Evaluation = "evaluation"
+const PromptTemplate = "prompt_template"
+const AssistantJob = "assistant_job"
 
 const ViewHistoryAction = "view_all_session_history"
 const ViewSingleSessionHistoryAction = "view_single_session_history"
 const manageChatSessionAction = "view_single_session_history"
 const cancelChatSessionAction = "cancel_session"
+const FeedbackAction = "feedback"
+const ViewFeedbackAction = "view_feedback"
+const EvaluateAction = "evaluate"
 
 func init() {
 	createPermission := security.GetSimplePermission(Category, Session, string(security.Create))
@@ -32,6 +38,7 @@ func init() {
 	manageChatSessionPermission := security.GetSimplePermission(Category, Session, manageChatSessionAction)
 	viewHistoryPermission := security.GetSimplePermission(Category, Session, ViewHistoryAction)
 	viewSessionHistoryPermission := security.GetSimplePermission(Category, Session, ViewSingleSessionHistoryAction)
+	feedbackPermission := security.GetSimplePermission(Category, Session, FeedbackAction)
 
 	createAssistantPermission := security.GetSimplePermission(Category, Assistant, string(security.Create))
 	updateAssistantPermission := security.GetSimplePermission(Category, Assistant, string(security.Update))
@@ -39,17 +46,45 @@ func init() {
 	deleteAssistantPermission := security.GetSimplePermission(Category, Assistant, string(security.Delete))
 	searchAssistantPermission := security.GetSimplePermission(Category, Assistant, string(security.Search))
 	askAssistantPermission := security.GetSimplePermission(Category, Assistant, string("ask"))
+	viewFeedbackPermission := security.GetSimplePermission(Category, Assistant, ViewFeedbackAction)
+	evaluateAssistantPermission := security.GetSimplePermission(Category, Assistant, EvaluateAction)
+
+	createEvaluationPermission := security.GetSimplePermission(Category, Evaluation, string(security.Create))
+	updateEvaluationPermission := security.GetSimplePermission(Category, Evaluation, string(security.Update))
+	readEvaluationPermission := security.GetSimplePermission(Category, Evaluation, string(security.Read))
+	deleteEvaluationPermission := security.GetSimplePermission(Category, Evaluation, string(security.Delete))
+	searchEvaluationPermission := security.GetSimplePermission(Category, Evaluation, string(security.Search))
+
+	createPromptTemplatePermission := security.GetSimplePermission(Category, PromptTemplate, string(security.Create))
+	updatePromptTemplatePermission := security.GetSimplePermission(Category, PromptTemplate, string(security.Update))
+	readPromptTemplatePermission := security.GetSimplePermission(Category, PromptTemplate, string(security.Read))
+	deletePromptTemplatePermission := security.GetSimplePermission(Category, PromptTemplate, string(security.Delete))
+	searchPromptTemplatePermission := security.GetSimplePermission(Category, PromptTemplate, string(security.Search))
+
+	createAssistantJobPermission := security.GetSimplePermission(Category, AssistantJob, string(security.Create))
+	updateAssistantJobPermission := security.GetSimplePermission(Category, AssistantJob, string(security.Update))
+	readAssistantJobPermission := security.GetSimplePermission(Category, AssistantJob, string(security.Read))
+	deleteAssistantJobPermission := security.GetSimplePermission(Category, AssistantJob, string(security.Delete))
+	searchAssistantJobPermission := security.GetSimplePermission(Category, AssistantJob, string(security.Search))
+	runAssistantJobPermission := security.GetSimplePermission(Category, AssistantJob, "run")
 
 	security.GetOrInitPermissionKeys(createPermission, updatePermission, readPermission, askAssistantPermission, deletePermission, searchPermission, viewHistoryPermission, manageChatSessionPermission, cancelChatSessionAction)
 	security.GetOrInitPermissionKeys(createAssistantPermission, updateAssistantPermission, readAssistantPermission, askAssistantPermission, deleteAssistantPermission, searchAssistantPermission)
+	security.GetOrInitPermissionKeys(feedbackPermission, viewFeedbackPermission)
+	security.GetOrInitPermissionKeys(evaluateAssistantPermission, createEvaluationPermission, updateEvaluationPermission, readEvaluationPermission, deleteEvaluationPermission, searchEvaluationPermission)
+	security.GetOrInitPermissionKeys(createPromptTemplatePermission, updatePromptTemplatePermission, readPromptTemplatePermission, deletePromptTemplatePermission, searchPromptTemplatePermission)
+	security.GetOrInitPermissionKeys(createAssistantJobPermission, updateAssistantJobPermission, readAssistantJobPermission, deleteAssistantJobPermission, searchAssistantJobPermission, runAssistantJobPermission)
 
-	security.RegisterPermissionsToRole(core.WidgetRole, createPermission, searchPermission, viewSessionHistoryPermission, readAssistantPermission, searchAssistantPermission, askAssistantPermission, cancelChatSessionAction)
+	security.RegisterPermissionsToRole(core.WidgetRole, createPermission, searchPermission, viewSessionHistoryPermission, readAssistantPermission, searchAssistantPermission, askAssistantPermission, cancelChatSessionAction, feedbackPermission)
 
 	handler := APIHandler{}
 
 	api.HandleUIMethod(api.GET, "/chat/_history", handler.getChatSessions, api.RequirePermission(viewHistoryPermission), api.Feature(core.FeatureCORS))
 	api.HandleUIMethod(api.OPTIONS, "/chat/_history", handler.getChatSessions, api.RequirePermission(viewHistoryPermission), api.Feature(core.FeatureCORS))
 
+	api.HandleUIMethod(api.GET, "/chat/_search", handler.searchChatSessions, api.RequirePermission(searchPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, "/chat/_search", handler.searchChatSessions, api.RequirePermission(searchPermission), api.Feature(core.FeatureCORS))
+
 	api.HandleUIMethod(api.POST, "/chat/_create", handler.createChatSession, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS), api.Feature(core.FeatureFingerprintThrottle))
 	api.HandleUIMethod(api.OPTIONS, "/chat/_create", handler.createChatSession, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS))
 
@@ -63,6 +98,29 @@ func init() {
 	api.HandleUIMethod(api.POST, "/chat/:session_id/_cancel", handler.cancelReplyMessage, api.RequirePermission(manageChatSessionPermission), api.Feature(core.FeatureCORS))
 	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/_cancel", handler.cancelReplyMessage, api.RequirePermission(manageChatSessionPermission), api.Feature(core.FeatureCORS))
 
+	api.HandleUIMethod(api.GET, "/chat/:session_id/message/:message_id/_stream", handler.resumeReplyStream, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/message/:message_id/_stream", handler.resumeReplyStream, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.POST, "/chat/:session_id/message/:message_id/_feedback", handler.sendMessageFeedback, api.RequirePermission(feedbackPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/message/:message_id/_feedback", handler.sendMessageFeedback, api.RequirePermission(feedbackPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.POST, "/chat/:session_id/message/:message_id/_regenerate", handler.regenerateMessage, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS), api.Feature(core.FeatureFingerprintThrottle))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/message/:message_id/_regenerate", handler.regenerateMessage, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.POST, "/chat/:session_id/message/:message_id/_edit", handler.editAndResendMessage, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS), api.Feature(core.FeatureFingerprintThrottle))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/message/:message_id/_edit", handler.editAndResendMessage, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.GET, "/chat/:session_id/message/:message_id/_plan_approval", handler.getResearchPlanApproval, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.POST, "/chat/:session_id/message/:message_id/_plan_approval", handler.decideResearchPlan, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/message/:message_id/_plan_approval", handler.getResearchPlanApproval, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.POST, "/chat/:session_id/message/:message_id/_resume_research", handler.resumeResearch, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS), api.Feature(core.FeatureFingerprintThrottle))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/message/:message_id/_resume_research", handler.resumeResearch, api.RequirePermission(createPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.GET, "/chat/:session_id/_branch", handler.getSessionBranch, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.POST, "/chat/:session_id/_branch", handler.switchSessionBranch, api.RequirePermission(manageChatSessionPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/_branch", handler.corsPreflight, api.Feature(core.FeatureCORS))
+
 	api.HandleUIMethod(api.POST, "/chat/:session_id/_open", handler.openChatSession, api.RequirePermission(manageChatSessionPermission), api.Feature(core.FeatureCORS))
 	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/_open", handler.openChatSession, api.RequirePermission(manageChatSessionPermission), api.Feature(core.FeatureCORS))
 
@@ -72,16 +130,64 @@ func init() {
 	api.HandleUIMethod(api.GET, "/chat/:session_id/_history", handler.getChatHistoryBySession, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
 	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/_history", handler.getChatHistoryBySession, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
 
+	api.HandleUIMethod(api.GET, "/chat/:session_id/_export", handler.exportSession, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/_export", handler.exportSession, api.RequirePermission(viewSessionHistoryPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.GET, "/chat/:session_id/_share_link", handler.getSessionShareLink, api.RequirePermission(manageChatSessionPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, "/chat/:session_id/_share_link", handler.getSessionShareLink, api.RequirePermission(manageChatSessionPermission), api.Feature(core.FeatureCORS))
+
 	api.HandleUIMethod(api.POST, "/assistant/", handler.createAssistant, api.RequirePermission(createAssistantPermission))
 	api.HandleUIMethod(api.GET, "/assistant/:id", handler.getAssistant, api.RequirePermission(readAssistantPermission))
 
 	api.HandleUIMethod(api.POST, "/assistant/:id/_ask", handler.askAssistant, api.RequirePermission(askAssistantPermission), api.Feature(core.FeatureCORS), api.Feature(core.FeatureFingerprintThrottle))
 	api.HandleUIMethod(api.OPTIONS, "/assistant/:id/_ask", handler.askAssistant, api.RequirePermission(askAssistantPermission), api.Feature(core.FeatureCORS))
 
+	// OpenAI-compatible API, the model is the assistant ID
+	api.HandleUIMethod(api.POST, "/v1/chat/completions", handler.chatCompletions, api.RequirePermission(askAssistantPermission), api.Feature(core.FeatureCORS), api.Feature(core.FeatureFingerprintThrottle))
+	api.HandleUIMethod(api.OPTIONS, "/v1/chat/completions", handler.chatCompletions, api.RequirePermission(askAssistantPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.GET, "/v1/models", handler.listModels, api.RequirePermission(searchAssistantPermission), api.Feature(core.FeatureCORS))
+
 	api.HandleUIMethod(api.PUT, "/assistant/:id", handler.updateAssistant, api.RequirePermission(updateAssistantPermission))
 	api.HandleUIMethod(api.DELETE, "/assistant/:id", handler.deleteAssistant, api.RequirePermission(deleteAssistantPermission))
 	api.HandleUIMethod(api.GET, "/assistant/_search", handler.searchAssistant, api.RequirePermission(searchAssistantPermission), api.Feature(core.FeatureCORS))
 	api.HandleUIMethod(api.OPTIONS, "/assistant/_search", handler.searchAssistant, api.RequirePermission(searchAssistantPermission), api.Feature(core.FeatureCORS))
 	api.HandleUIMethod(api.POST, "/assistant/_search", handler.searchAssistant, api.RequirePermission(searchAssistantPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.GET, "/assistant/_feedback_report", handler.getFeedbackReport, api.RequirePermission(viewFeedbackPermission))
 	api.HandleUIMethod(api.POST, "/assistant/:id/_clone", handler.cloneAssistant, api.RequirePermission(createAssistantPermission))
+	api.HandleUIMethod(api.GET, "/assistant/:id/_revisions", handler.searchAssistantRevisions, api.RequirePermission(readAssistantPermission))
+	api.HandleUIMethod(api.GET, "/assistant/:id/_revisions/:revision", handler.getAssistantRevision, api.RequirePermission(readAssistantPermission))
+	api.HandleUIMethod(api.POST, "/assistant/:id/_rollback", handler.rollbackAssistant, api.RequirePermission(updateAssistantPermission))
+	api.HandleUIMethod(api.POST, "/assistant/:id/_evaluate", handler.evaluateAssistant, api.RequirePermission(evaluateAssistantPermission))
+
+	api.HandleUIMethod(api.POST, "/evaluation_set/", handler.createEvaluationSet, api.RequirePermission(createEvaluationPermission))
+	api.HandleUIMethod(api.GET, "/evaluation_set/:id", handler.getEvaluationSet, api.RequirePermission(readEvaluationPermission))
+	api.HandleUIMethod(api.PUT, "/evaluation_set/:id", handler.updateEvaluationSet, api.RequirePermission(updateEvaluationPermission))
+	api.HandleUIMethod(api.DELETE, "/evaluation_set/:id", handler.deleteEvaluationSet, api.RequirePermission(deleteEvaluationPermission))
+	api.HandleUIMethod(api.GET, "/evaluation_set/_search", handler.searchEvaluationSet, api.RequirePermission(searchEvaluationPermission))
+	api.HandleUIMethod(api.POST, "/evaluation_set/_search", handler.searchEvaluationSet, api.RequirePermission(searchEvaluationPermission))
+
+	api.HandleUIMethod(api.GET, "/evaluation_run/:id", handler.getEvaluationRun, api.RequirePermission(readEvaluationPermission))
+	api.HandleUIMethod(api.DELETE, "/evaluation_run/:id", handler.deleteEvaluationRun, api.RequirePermission(deleteEvaluationPermission))
+	api.HandleUIMethod(api.GET, "/evaluation_run/_search", handler.searchEvaluationRun, api.RequirePermission(searchEvaluationPermission))
+	api.HandleUIMethod(api.POST, "/evaluation_run/_search", handler.searchEvaluationRun, api.RequirePermission(searchEvaluationPermission))
+
+	api.HandleUIMethod(api.POST, "/prompt_template/", handler.createPromptTemplate, api.RequirePermission(createPromptTemplatePermission))
+	api.HandleUIMethod(api.GET, "/prompt_template/:id", handler.getPromptTemplate, api.RequirePermission(readPromptTemplatePermission))
+	api.HandleUIMethod(api.PUT, "/prompt_template/:id", handler.updatePromptTemplate, api.RequirePermission(updatePromptTemplatePermission))
+	api.HandleUIMethod(api.DELETE, "/prompt_template/:id", handler.deletePromptTemplate, api.RequirePermission(deletePromptTemplatePermission))
+	api.HandleUIMethod(api.GET, "/prompt_template/_search", handler.searchPromptTemplate, api.RequirePermission(searchPromptTemplatePermission))
+	api.HandleUIMethod(api.POST, "/prompt_template/_search", handler.searchPromptTemplate, api.RequirePermission(searchPromptTemplatePermission))
+	api.HandleUIMethod(api.POST, "/prompt_template/_preview", handler.previewPromptTemplate, api.RequirePermission(readPromptTemplatePermission))
+	api.HandleUIMethod(api.POST, "/prompt_template/:id/_preview", handler.previewPromptTemplate, api.RequirePermission(readPromptTemplatePermission))
+
+	api.HandleUIMethod(api.POST, "/assistant_job/", handler.createAssistantJob, api.RequirePermission(createAssistantJobPermission))
+	api.HandleUIMethod(api.GET, "/assistant_job/:id", handler.getAssistantJob, api.RequirePermission(readAssistantJobPermission))
+	api.HandleUIMethod(api.PUT, "/assistant_job/:id", handler.updateAssistantJob, api.RequirePermission(updateAssistantJobPermission))
+	api.HandleUIMethod(api.DELETE, "/assistant_job/:id", handler.deleteAssistantJob, api.RequirePermission(deleteAssistantJobPermission))
+	api.HandleUIMethod(api.GET, "/assistant_job/_search", handler.searchAssistantJob, api.RequirePermission(searchAssistantJobPermission))
+	api.HandleUIMethod(api.POST, "/assistant_job/_search", handler.searchAssistantJob, api.RequirePermission(searchAssistantJobPermission))
+	api.HandleUIMethod(api.POST, "/assistant_job/:id/_run", handler.runAssistantJob, api.RequirePermission(runAssistantJobPermission))
+	api.HandleUIMethod(api.GET, "/assistant_job/:id/_runs", handler.searchAssistantJobRuns, api.RequirePermission(readAssistantJobPermission))
+	api.HandleUIMethod(api.GET, "/assistant_job_run/:id", handler.getAssistantJobRun, api.RequirePermission(readAssistantJobPermission))
+	api.HandleUIMethod(api.DELETE, "/assistant_job_run/:id", handler.deleteAssistantJobRun, api.RequirePermission(deleteAssistantJobPermission))
 }
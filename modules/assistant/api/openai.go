@@ -0,0 +1,477 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	common2 "infini.sh/coco/modules/assistant/common"
+	"infini.sh/coco/modules/assistant/service"
+	"infini.sh/coco/modules/common"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// OpenAI-compatible API, see https://platform.openai.com/docs/api-reference/chat
+//
+// The `model` field is the ID of the assistant to talk to. The documents
+// retrieved while answering are returned in the `coco.sources` extension
+// field, on the last chunk when streaming.
+
+type openAIChatMessage struct {
+	Role string `json:"role"`
+	// Content is either a string or an array of content parts, only the text
+	// parts are used.
+	Content interface{} `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model    string              `json:"model"`
+	Messages []openAIChatMessage `json:"messages"`
+	Stream   bool                `json:"stream"`
+	User     string              `json:"user,omitempty"`
+}
+
+type openAIDelta struct {
+	Role             string `json:"role,omitempty"`
+	Content          string `json:"content,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int                `json:"index"`
+	Message      *openAIChatMessage `json:"message,omitempty"`
+	Delta        *openAIDelta       `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type openAICocoExtension struct {
+	SessionID string        `json:"session_id"`
+	MessageID string        `json:"message_id"`
+	Sources   []interface{} `json:"sources"`
+}
+
+type openAIChatResponse struct {
+	ID      string               `json:"id"`
+	Object  string               `json:"object"`
+	Created int64                `json:"created"`
+	Model   string               `json:"model"`
+	Choices []openAIChoice       `json:"choices"`
+	Coco    *openAICocoExtension `json:"coco,omitempty"`
+}
+
+// getText flattens the message content into plain text.
+func (m openAIChatMessage) getText() string {
+	switch v := m.Content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := []string{}
+		for _, item := range v {
+			part, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if t, _ := part["type"].(string); t == "text" {
+				if text, ok := part["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+func openAIError(errType, msg string) util.MapStr {
+	return util.MapStr{
+		"error": util.MapStr{
+			"message": msg,
+			"type":    errType,
+			"code":    nil,
+		},
+	}
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, errType, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(openAIError(errType, msg))
+}
+
+// finishReason maps the reply_end reason to the OpenAI finish_reason. It is
+// empty for the replies that failed or timed out, which are reported as
+// errors instead, see replyEndError.
+func finishReason(reason string) string {
+	switch reason {
+	case common.ReplyEndReasonCompleted, common.ReplyEndReasonUserCancelled:
+		// OpenAI has no finish_reason for a cancelled reply, which ends where
+		// the user stopped it
+		return "stop"
+	default:
+		return ""
+	}
+}
+
+// replyEndError returns the HTTP status, the OpenAI error type and the
+// message of a reply that ended without a finish_reason.
+func replyEndError(reason, errMsg string) (int, string, string) {
+	if reason == common.ReplyEndReasonTimeout {
+		return http.StatusGatewayTimeout, "timeout", "the reply timed out"
+	}
+	if errMsg == "" {
+		errMsg = "failed to generate the reply"
+	}
+	return http.StatusInternalServerError, "api_error", errMsg
+}
+
+// parseReplyEnd returns the reason and the error of a reply_end chunk.
+func parseReplyEnd(messageChunk string) (string, string) {
+	payload := util.MapStr{}
+	_ = util.FromJSONBytes([]byte(messageChunk), &payload)
+	reason, _ := payload["reason"].(string)
+	errMsg, _ := payload["error"].(string)
+	return reason, errMsg
+}
+
+// collectSources returns the documents referenced by the reply, preferring
+// the ones picked by the model over all fetched candidates.
+func collectSources(replyMsg *core.ChatMessage) []interface{} {
+	var fetched, picked []interface{}
+	for _, d := range replyMsg.Details {
+		items, ok := d.Payload.([]interface{})
+		if !ok {
+			buf, err := json.Marshal(d.Payload)
+			if err != nil || json.Unmarshal(buf, &items) != nil {
+				continue
+			}
+		}
+		switch d.Type {
+		case common.FetchSource:
+			fetched = append(fetched, items...)
+		case common.PickSource:
+			picked = append(picked, items...)
+		}
+	}
+	if len(picked) > 0 {
+		return picked
+	}
+	if fetched == nil {
+		return []interface{}{}
+	}
+	return fetched
+}
+
+// openAIStreamSender converts the assistant chunks into OpenAI
+// chat.completion.chunk server-sent events.
+type openAIStreamSender struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	ctx      context.Context
+	id       string
+	model    string
+	created  int64
+	replyMsg *core.ChatMessage
+}
+
+func (s *openAIStreamSender) SendChunkMessage(messageType, chunkType, messageChunk string, chunkSequence int) error {
+	msg := core.NewMessageChunk(s.replyMsg.SessionID, s.replyMsg.ID, messageType, s.replyMsg.ReplyMessageID, chunkType, messageChunk, chunkSequence)
+	return s.SendMessage(msg)
+}
+
+func (s *openAIStreamSender) SendMessage(msg *core.MessageChunk) error {
+	if msg == nil {
+		return nil
+	}
+
+	if msg.ChunkType != common.ReplyEnd {
+		select {
+		case <-s.ctx.Done():
+			return fmt.Errorf("client disconnected")
+		default:
+		}
+	}
+
+	switch msg.ChunkType {
+	case common.ReplyStart:
+		return s.write(s.newChunk(&openAIDelta{Role: core.MessageTypeAssistant}, nil))
+	case common.Response:
+		if msg.MessageChunk == "" {
+			return nil
+		}
+		return s.write(s.newChunk(&openAIDelta{Content: msg.MessageChunk}, nil))
+	case common.Think:
+		if msg.MessageChunk == "" {
+			return nil
+		}
+		return s.write(s.newChunk(&openAIDelta{ReasoningContent: msg.MessageChunk}, nil))
+	case common.ReplyEnd:
+		reason, errMsg := parseReplyEnd(msg.MessageChunk)
+		fr := finishReason(reason)
+		if fr == "" {
+			_, errType, message := replyEndError(reason, errMsg)
+			if err := s.writeRaw(util.MustToJSON(openAIError(errType, message))); err != nil {
+				return err
+			}
+			return s.writeRaw("[DONE]")
+		}
+
+		chunk := s.newChunk(&openAIDelta{}, &fr)
+		chunk.Coco = &openAICocoExtension{
+			SessionID: s.replyMsg.SessionID,
+			MessageID: s.replyMsg.ID,
+			Sources:   collectSources(s.replyMsg),
+		}
+		if err := s.write(chunk); err != nil {
+			return err
+		}
+		return s.writeRaw("[DONE]")
+	}
+	return nil
+}
+
+func (s *openAIStreamSender) newChunk(delta *openAIDelta, finishReason *string) *openAIChatResponse {
+	return &openAIChatResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: s.created,
+		Model:   s.model,
+		Choices: []openAIChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+	}
+}
+
+func (s *openAIStreamSender) write(chunk *openAIChatResponse) error {
+	return s.writeRaw(util.MustToJSON(chunk))
+}
+
+func (s *openAIStreamSender) writeRaw(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// openAIReplyReceiver collects a reply for the non-streaming requests,
+// keeping how it ended.
+type openAIReplyReceiver struct {
+	common2.MemoryMessageSender
+	reason, errMsg string
+}
+
+func (r *openAIReplyReceiver) SendChunkMessage(messageType, chunkType, messageChunk string, chunkSequence int) error {
+	return r.SendMessage(core.NewMessageChunk("", "", messageType, "", chunkType, messageChunk, chunkSequence))
+}
+
+func (r *openAIReplyReceiver) SendMessage(msg *core.MessageChunk) error {
+	if msg != nil && msg.ChunkType == common.ReplyEnd {
+		r.reason, r.errMsg = parseReplyEnd(msg.MessageChunk)
+	}
+	return r.MemoryMessageSender.SendMessage(msg)
+}
+
+func (h *APIHandler) chatCompletions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var request openAIChatRequest
+	if err := h.DecodeJSON(r, &request); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	// the last user message is the question, everything before is history
+	lastUser := -1
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		if request.Messages[i].Role == core.MessageTypeUser {
+			lastUser = i
+			break
+		}
+	}
+	if lastUser < 0 || strings.TrimSpace(request.Messages[lastUser].getText()) == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "messages must contain a non-empty user message")
+		return
+	}
+
+	assistantID := request.Model
+	if assistantID == "" {
+		assistantID = common2.DefaultAssistantID
+	}
+	assistant, exists, err := service.GetAssistant(r, assistantID)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", "failed to get assistant")
+		return
+	}
+	if !exists || !assistant.Enabled {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("the model `%v` does not exist", assistantID))
+		return
+	}
+
+	userInfo := security.MustGetUserFromRequest(r)
+
+	msgRequest := core.MessageRequest{Message: request.Messages[lastUser].getText()}
+	session, err, reqMsg, _ := service.CreateAndSaveNewChatMessage(r, assistant.ID, &msgRequest, false)
+	if err != nil || reqMsg == nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("failed to create chat message: %v", err))
+		return
+	}
+
+	params, err := common2.NewRagContext(r, assistant, session.ID)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+	// OpenAI clients can't toggle search and tools, use whatever the
+	// assistant is configured with
+	params.SearchDB = assistant.Datasource.Enabled
+	if params.Datasource == "" {
+		params.Datasource = strings.Join(assistant.Datasource.GetIDs(), ",")
+	}
+	params.MCP = assistant.MCPConfig.Enabled || assistant.ToolsConfig.Enabled
+	if assistant.MCPConfig.Enabled && len(params.MCPServers) == 0 {
+		params.MCPServers = assistant.MCPConfig.GetIDs()
+	}
+
+	params.InputValues = map[string]any{}
+	if history := request.Messages[:lastUser]; len(history) > 0 {
+		messages := make([]core.ChatMessage, 0, len(history))
+		for _, m := range history {
+			msgType := m.Role
+			switch msgType {
+			case core.MessageTypeUser, core.MessageTypeAssistant, core.MessageTypeSystem:
+			case "developer":
+				msgType = core.MessageTypeSystem
+			default:
+				continue
+			}
+			messages = append(messages, core.ChatMessage{MessageType: msgType, Message: m.getText()})
+		}
+		params.ChatHistory, params.InputValues["history"] = service.FormatChatHistory(r.Context(), messages)
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	ctx, cancel := common.WithPausableTimeout(ctx, resolveTimeout(assistant))
+	defer cancel()
+
+	replyMsg := service.CreateAssistantReplyMessage(session.ID, reqMsg.AssistantID, reqMsg.ID)
+	replyMsgTaskID := service.GetReplyMessageTaskID(session.ID, reqMsg.ID)
+	service.InflightMessages.Store(replyMsgTaskID, common2.MessageTask{
+		SessionID:  session.ID,
+		CancelFunc: cancel,
+	})
+
+	completionID := "chatcmpl-" + replyMsg.ID
+	created := time.Now().Unix()
+
+	if request.Stream {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeOpenAIError(w, http.StatusInternalServerError, "api_error", "streaming is not supported")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		sender := &openAIStreamSender{
+			w:        w,
+			flusher:  flusher,
+			ctx:      ctx,
+			id:       completionID,
+			model:    assistant.ID,
+			created:  created,
+			replyMsg: replyMsg,
+		}
+		_ = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, sender)
+		return
+	}
+
+	receiver := &openAIReplyReceiver{}
+	err = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, receiver)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		_ = log.Errorf("failed to process chat completion: %v", err)
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	reason := receiver.reason
+	if reason == "" {
+		reason = common.ReplyEndReasonCompleted
+		if errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			reason = common.ReplyEndReasonTimeout
+		}
+	}
+	fr := finishReason(reason)
+	if fr == "" {
+		status, errType, message := replyEndError(reason, receiver.errMsg)
+		writeOpenAIError(w, status, errType, message)
+		return
+	}
+	h.WriteJSON(w, openAIChatResponse{
+		ID:      completionID,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   assistant.ID,
+		Choices: []openAIChoice{{
+			Index:        0,
+			Message:      &openAIChatMessage{Role: core.MessageTypeAssistant, Content: receiver.FinalResponse()},
+			FinishReason: &fr,
+		}},
+		Coco: &openAICocoExtension{
+			SessionID: session.ID,
+			MessageID: replyMsg.ID,
+			Sources:   collectSources(replyMsg),
+		},
+	}, http.StatusOK)
+}
+
+// listModels lists the assistants visible to the user as OpenAI models.
+func (h *APIHandler) listModels(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	builder := orm.NewQuery()
+	builder.Size(1000)
+	builder.Filter(orm.TermQuery("enabled", true))
+	builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+
+	ctx := orm.NewContextWithParent(r.Context())
+	orm.WithModel(ctx, &core.Assistant{})
+	ctx.Set(orm.SharingEnabled, true)
+	ctx.Set(orm.SharingResourceType, "assistant")
+
+	docs := []core.Assistant{}
+	err, _ := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, nil)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	models := make([]util.MapStr, 0, len(docs))
+	for _, v := range docs {
+		var created int64
+		if v.Created != nil {
+			created = v.Created.Unix()
+		}
+		models = append(models, util.MapStr{
+			"id":       v.ID,
+			"object":   "model",
+			"created":  created,
+			"owned_by": "coco",
+			"name":     v.Name,
+		})
+	}
+	h.WriteJSON(w, util.MapStr{"object": "list", "data": models}, http.StatusOK)
+}
@@ -0,0 +1,146 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+)
+
+func TestOpenAIChatMessageGetText(t *testing.T) {
+	var msgs []openAIChatMessage
+	raw := `[
+		{"role":"user","content":"plain"},
+		{"role":"user","content":[{"type":"text","text":"first"},{"type":"image_url","image_url":{"url":"x"}},{"type":"text","text":"second"}]},
+		{"role":"assistant","content":null}
+	]`
+	if err := json.Unmarshal([]byte(raw), &msgs); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	expected := []string{"plain", "first\nsecond", ""}
+	for i, want := range expected {
+		if got := msgs[i].getText(); got != want {
+			t.Fatalf("message %d: expected %q, got %q", i, want, got)
+		}
+	}
+}
+
+func TestCollectSourcesPrefersPickedDocuments(t *testing.T) {
+	reply := &core.ChatMessage{Details: []core.ProcessingDetails{
+		{Type: common.FetchSource, Payload: []interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}}},
+		{Type: common.PickSource, Payload: []map[string]string{{"id": "b"}}},
+	}}
+
+	sources := collectSources(reply)
+	if len(sources) != 1 {
+		t.Fatalf("expected 1 picked source, got %d", len(sources))
+	}
+	if id := sources[0].(map[string]interface{})["id"]; id != "b" {
+		t.Fatalf("expected picked source b, got %v", id)
+	}
+
+	reply.Details = reply.Details[:1]
+	if sources = collectSources(reply); len(sources) != 2 {
+		t.Fatalf("expected 2 fetched sources, got %d", len(sources))
+	}
+
+	if sources = collectSources(&core.ChatMessage{}); sources == nil || len(sources) != 0 {
+		t.Fatalf("expected empty non-nil sources, got %v", sources)
+	}
+}
+
+func TestFinishReason(t *testing.T) {
+	cases := map[string]string{
+		common.ReplyEndReasonCompleted:     "stop",
+		common.ReplyEndReasonUserCancelled: "stop",
+		common.ReplyEndReasonTimeout:       "",
+		common.ReplyEndReasonError:         "",
+		"":                                 "",
+	}
+	for reason, want := range cases {
+		if got := finishReason(reason); got != want {
+			t.Fatalf("finishReason(%q): expected %q, got %q", reason, want, got)
+		}
+	}
+}
+
+func TestReplyEndError(t *testing.T) {
+	tests := []struct {
+		reason, errMsg string
+		wantStatus     int
+		wantType       string
+		wantMessage    string
+	}{
+		{common.ReplyEndReasonTimeout, "", http.StatusGatewayTimeout, "timeout", "the reply timed out"},
+		{common.ReplyEndReasonError, "model unavailable", http.StatusInternalServerError, "api_error", "model unavailable"},
+		{common.ReplyEndReasonError, "", http.StatusInternalServerError, "api_error", "failed to generate the reply"},
+	}
+	for _, tt := range tests {
+		status, errType, message := replyEndError(tt.reason, tt.errMsg)
+		if status != tt.wantStatus || errType != tt.wantType || message != tt.wantMessage {
+			t.Fatalf("replyEndError(%q, %q): got %v %q %q", tt.reason, tt.errMsg, status, errType, message)
+		}
+	}
+}
+
+func TestOpenAIStreamSenderEndsWithFinishReasonOrError(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "completed",
+			payload: `{"reason":"completed"}`,
+			want:    []string{`"finish_reason":"stop"`, "data: [DONE]"},
+			notWant: []string{`"error"`},
+		},
+		{
+			name:    "error",
+			payload: `{"reason":"error","error":"model unavailable"}`,
+			want:    []string{`"error":{`, `"message":"model unavailable"`, "data: [DONE]"},
+			notWant: []string{"finish_reason"},
+		},
+		{
+			name:    "timeout",
+			payload: `{"reason":"timeout","type":"assistant_generation"}`,
+			want:    []string{`"type":"timeout"`, "data: [DONE]"},
+			notWant: []string{"finish_reason", `"length"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			replyMsg := &core.ChatMessage{SessionID: "session"}
+			replyMsg.ID = "reply"
+			sender := &openAIStreamSender{w: rec, flusher: rec, ctx: context.Background(), replyMsg: replyMsg}
+
+			if err := sender.SendChunkMessage(core.MessageTypeSystem, common.ReplyEnd, tt.payload, 0); err != nil {
+				t.Fatal(err)
+			}
+			body := strings.ReplaceAll(rec.Body.String(), " ", "")
+			for _, s := range tt.want {
+				if !strings.Contains(body, strings.ReplaceAll(s, " ", "")) {
+					t.Fatalf("expected %q in %q", s, body)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(body, s) {
+					t.Fatalf("unexpected %q in %q", s, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/langchain"
+	"infini.sh/coco/modules/assistant/service"
+	"infini.sh/coco/modules/common"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+func (h APIHandler) createPromptTemplate(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	obj := &core.PromptTemplate{}
+	if err := h.DecodeJSON(req, obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if obj.Name == "" {
+		h.WriteError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if err := langchain.ValidatePromptTemplate(obj.Usage, obj.Template, obj.Variables); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Create(ctx, obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.WriteCreatedOKJSON(w, obj.ID)
+}
+
+func (h APIHandler) getPromptTemplate(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.PromptTemplate{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	h.WriteGetOKJSON(w, id, obj)
+}
+
+func (h APIHandler) updatePromptTemplate(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.PromptTemplate{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	newObj := core.PromptTemplate{}
+	if err := h.DecodeJSON(req, &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if newObj.Name == "" {
+		h.WriteError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if err := langchain.ValidatePromptTemplate(newObj.Usage, newObj.Template, newObj.Variables); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the assistants check the usage of the templates they reference
+	if newObj.Usage != obj.Usage && !h.refuseReferencedPromptTemplate(w, id, newObj.Usage) {
+		return
+	}
+
+	//protect
+	newObj.ID = id
+	newObj.Created = obj.Created
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Update(ctx, &newObj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the assistants are cached with the templates they use
+	common.GeneralObjectCache.DeleteAll(core.AssistantCachePrimary)
+
+	h.WriteUpdatedOKJSON(w, id)
+}
+
+func (h APIHandler) deletePromptTemplate(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("id")
+
+	obj := core.PromptTemplate{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	if !h.refuseReferencedPromptTemplate(w, id, "") {
+		return
+	}
+
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Delete(ctx, &obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	common.GeneralObjectCache.DeleteAll(core.AssistantCachePrimary)
+
+	h.WriteDeletedOKJSON(w, id)
+}
+
+// refuseReferencedPromptTemplate writes a conflict with the IDs of the
+// assistants referencing the template for prompts of another usage than
+// usage, or for any prompt if usage is empty. It returns whether the change
+// can go on.
+func (h APIHandler) refuseReferencedPromptTemplate(w http.ResponseWriter, id, usage string) bool {
+	assistants, err := service.AssistantsUsingPromptTemplate(id, usage)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if len(assistants) == 0 {
+		return true
+	}
+	h.WriteJSON(w, util.MapStr{
+		"error":      fmt.Sprintf("prompt template [%v] is used by %d assistants", id, len(assistants)),
+		"assistants": assistants,
+	}, http.StatusConflict)
+	return false
+}
+
+func (h APIHandler) searchPromptTemplate(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	builder, err := orm.NewQueryBuilderFromRequest(req, "name", "combined_fulltext")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if usage := h.GetParameterOrDefault(req, "usage", ""); usage != "" {
+		builder.Filter(orm.TermQuery("usage", usage))
+	}
+	builder.EnableBodyBytes()
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	orm.WithModel(ctx, &core.PromptTemplate{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.Write(w, res.Payload.([]byte)); err != nil {
+		h.Error(w, err)
+	}
+}
+
+// previewPromptRequest is the body of the preview APIs, the template is
+// omitted to preview a saved template.
+type previewPromptRequest struct {
+	Usage     string                `json:"usage,omitempty"`
+	Template  string                `json:"template,omitempty"`
+	Variables []core.PromptVariable `json:"variables,omitempty"`
+	Inputs    map[string]any        `json:"inputs,omitempty"`
+}
+
+// previewPromptTemplate validates and renders a template with sample inputs,
+// the template of the request, or the saved one if an ID is given.
+func (h APIHandler) previewPromptTemplate(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var request previewPromptRequest
+	if err := h.DecodeJSON(req, &request); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if id := ps.ByName("id"); id != "" {
+		obj := core.PromptTemplate{}
+		obj.ID = id
+		ctx := orm.NewContextWithParent(req.Context())
+		exists, err := orm.GetV2(ctx, &obj)
+		if !exists || err != nil {
+			h.WriteOpRecordNotFoundJSON(w, id)
+			return
+		}
+		request.Usage = obj.Usage
+		request.Template = obj.Template
+		request.Variables = obj.Variables
+	}
+
+	if err := langchain.ValidatePromptTemplate(request.Usage, request.Template, request.Variables); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prompt, missing, err := langchain.RenderPromptTemplate(request.Template, request.Variables, request.Inputs)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"prompt":   prompt,
+		"missing":  missing,
+		"supplied": langchain.PipelineVariables[request.Usage],
+	}, http.StatusOK)
+}
@@ -12,10 +12,11 @@ import (
 	"strings"
 	"time"
 
-	"github.com/smallnest/langgraphgo/log"
+	log "github.com/cihub/seelog"
 	_ "github.com/tmc/langchaingo/llms/ollama"
 	"infini.sh/coco/core"
 	common2 "infini.sh/coco/modules/assistant/common"
+	deep_research2 "infini.sh/coco/modules/assistant/deep_research_v2"
 	"infini.sh/coco/modules/assistant/service"
 	"infini.sh/coco/modules/common"
 	httprouter "infini.sh/framework/core/api/router"
@@ -25,7 +26,8 @@ import (
 	"infini.sh/framework/core/util"
 )
 
-// helper function to choose the request timeout for an assistant.
+// helper function to choose the request timeout for an assistant. The time a
+// deep research waits for the approval of its plan does not count.
 func resolveTimeout(assistant *core.Assistant) time.Duration {
 	const defaultTimeout = 10 * time.Minute
 	const deepResearchDefault = 30 * time.Minute
@@ -112,11 +114,8 @@ func decodeSearchResultFetchSource(raw json.RawMessage) (interface{}, error) {
 func (h APIHandler) getSession(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	id := ps.MustGetParameter("session_id")
 
-	obj := core.Session{}
-	obj.ID = id
-	ctx := orm.NewContextWithParent(req.Context())
-
-	exists, err := orm.GetV2(ctx, &obj)
+	// the session is readable by its owner and the users it is shared with
+	obj, exists, err := getSharedSession(req.Context(), id)
 	if !exists || err != nil {
 		h.WriteJSON(w, util.MapStr{
 			"_id":    id,
@@ -166,6 +165,11 @@ func (h APIHandler) deleteSession(w http.ResponseWriter, req *http.Request, ps h
 		return
 	}
 
+	if err := deep_research2.DeleteSessionResearchState(req.Context(), id); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ctx.Refresh = orm.WaitForRefresh
 	err = orm.Delete(ctx, &obj)
 	if err != nil {
@@ -322,7 +326,7 @@ func (h APIHandler) createChatSession(w http.ResponseWriter, r *http.Request, ps
 
 	// Create a context with cancel to handle the message asynchronously
 	ctx := context.WithoutCancel(r.Context())
-	ctx, cancel := context.WithTimeout(ctx, resolveTimeout(assistant))
+	ctx, cancel := common.WithPausableTimeout(ctx, resolveTimeout(assistant))
 
 	replyMsg := service.CreateAssistantReplyMessage(params.SessionID, reqMsg.AssistantID, reqMsg.ID)
 
@@ -341,7 +345,9 @@ func (h APIHandler) createChatSession(w http.ResponseWriter, r *http.Request, ps
 		CancelFunc: cancel,
 	})
 
-	_ = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, streamSender)
+	// Buffer the reply so the client can re-attach to it after a disconnect.
+	replySender := service.NewReplyStreamSender(ctx, reqMsg, replyMsg, streamSender)
+	_ = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, replySender)
 }
 
 // askAssistant handles _ask requests.
@@ -416,7 +422,7 @@ func (h *APIHandler) askAssistant(w http.ResponseWriter, r *http.Request, ps htt
 	}
 
 	ctx := context.WithoutCancel(r.Context())
-	ctx, cancel := context.WithTimeout(ctx, resolveTimeout(assistant))
+	ctx, cancel := common.WithPausableTimeout(ctx, resolveTimeout(assistant))
 
 	sessionID := reqMsg.SessionID
 	replyMsgTaskID := service.GetReplyMessageTaskID(sessionID, reqMsg.ID)
@@ -437,7 +443,9 @@ func (h *APIHandler) askAssistant(w http.ResponseWriter, r *http.Request, ps htt
 		Ctx:      ctx, // Use the timeout context for consistency
 	}
 
-	_ = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, streamSender)
+	// Buffer the reply so the client can re-attach to it after a disconnect.
+	replySender := service.NewReplyStreamSender(ctx, reqMsg, replyMsg, streamSender)
+	_ = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, replySender)
 
 }
 
@@ -489,10 +497,37 @@ func (h APIHandler) getChatHistoryBySession(w http.ResponseWriter, req *http.Req
 		builder.Size(20)
 	}
 
+	sessionID := ps.MustGetParameter("session_id")
+	session, exists, err := getSharedSession(req.Context(), sessionID)
+	if err != nil || !exists {
+		h.WriteOpRecordNotFoundJSON(w, sessionID)
+		return
+	}
+
 	builder.SortBy(orm.Sort{Field: "created", SortType: orm.ASC})
-	builder.Must(orm.TermQuery("session_id", ps.MustGetParameter("session_id")))
+	builder.Must(orm.TermQuery("session_id", sessionID))
 
+	// only the active branch of a regenerated or edited conversation, unless
+	// all the messages are asked with branch=all
+	if h.GetParameterOrDefault(req, "branch", "active") != "all" {
+		tree, err := service.LoadMessageTree(req.Context(), sessionID, session.ActiveMessageID)
+		if err != nil {
+			h.WriteError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tree.Branched() {
+			branch := tree.Branch(session.ActiveMessageID)
+			ids := make([]string, 0, len(branch))
+			for _, msg := range branch {
+				ids = append(ids, msg.ID)
+			}
+			builder.Filter(orm.TermsQuery("id", ids))
+		}
+	}
+
+	// the messages belong to the session owner, the session may be shared
 	ctx := orm.NewContextWithParent(req.Context())
+	ctx.DirectReadAccess()
 	orm.WithModel(ctx, &core.ChatMessage{})
 
 	res, err := orm.SearchV2(ctx, builder)
@@ -580,13 +615,68 @@ func (h APIHandler) cancelReplyMessage(w http.ResponseWriter, req *http.Request,
 	messageID := h.GetParameterOrDefault(req, "message_id", "")
 	log.Info("cancel reply to message: ", messageID, ", session: ", sessionID)
 	taskID := service.GetReplyMessageTaskID(sessionID, messageID)
-	service.StopMessageReplyTask(taskID)
+	if !service.StopMessageReplyTask(taskID) {
+		// the reply may be running on another node
+		if err := service.RequestCancelReplyStream(req.Context(), sessionID, messageID); err != nil {
+			_ = log.Warnf("failed to request cancellation of reply to message [%v]: %v", messageID, err)
+		}
+	}
 	h.WriteAckOKJSON(w)
 }
 
+// resumeReplyStream re-attaches a client to an assistant reply, sending every
+// chunk after the `offset` query parameter and then following the reply
+// until it ends.
+func (h APIHandler) resumeReplyStream(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sessionID := ps.MustGetParameter("session_id")
+	messageID := ps.MustGetParameter("message_id")
+	offset := h.GetIntOrDefault(req, "offset", 0)
+
+	session := core.Session{}
+	session.ID = sessionID
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &session)
+	if !exists || err != nil {
+		h.WriteOpRecordNotFoundJSON(w, sessionID)
+		return
+	}
+
+	record, err := service.GetReplyStream(req.Context(), messageID)
+	if err != nil || record.SessionID != sessionID {
+		// finished replies are only kept for a while, use the history API then
+		h.WriteOpRecordNotFoundJSON(w, messageID)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.Error(w, errors.New("http.Flusher not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+
+	reqMsg := &core.ChatMessage{SessionID: sessionID}
+	reqMsg.ID = record.RequestMessageID
+	replyMsg := &core.ChatMessage{SessionID: sessionID}
+	replyMsg.ID = messageID
+
+	streamSender := &common2.HTTPStreamSender{
+		ReqMsg:   reqMsg,
+		ReplyMsg: replyMsg,
+		Enc:      json.NewEncoder(w),
+		Flusher:  flusher,
+		Ctx:      req.Context(),
+	}
+	if err := service.ResumeReplyStream(req.Context(), messageID, offset, streamSender); err != nil {
+		log.Debugf("resumed reply stream [%v] ended: %v", messageID, err)
+	}
+}
+
 func (h APIHandler) sendChatMessageV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	sessionID := ps.MustGetParameter("session_id")
-	userInfo := security.MustGetUserFromRequest(r)
 
 	ormCtx := orm.NewContextWithParent(r.Context())
 	ormCtx.Refresh = orm.WaitForRefresh
@@ -613,42 +703,67 @@ func (h APIHandler) sendChatMessageV2(w http.ResponseWriter, r *http.Request, ps
 		return
 	}
 
-	reqMsg, err := service.SaveRequestMessage(ormCtx, sessionID, id, &request)
+	// the question continues the active branch of the conversation
+	parentID, err := service.GetActiveMessageID(r.Context(), sessionID)
 	if err != nil {
 		h.Error(w, err)
 		return
 	}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		h.Error(w, errors.New("http.Flusher not supported"))
+	reqMsg, err := service.SaveRequestMessage(ormCtx, sessionID, id, parentID, &request)
+	if err != nil {
+		h.Error(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Transfer-Encoding", "chunked")
-	w.WriteHeader(http.StatusOK)
-
 	response := []util.MapStr{util.MapStr{
 		"_id":     reqMsg.ID,
 		"result":  "created",
 		"_source": reqMsg,
 	}}
+	h.streamAssistantReply(w, r, assistant, sessionID, reqMsg, response)
+}
 
-	enc := json.NewEncoder(w)
-	_ = enc.Encode(response)
-	flusher.Flush()
-
+// streamAssistantReply writes response as the first line of the chunked
+// response, then streams the reply of assistant to reqMsg.
+func (h APIHandler) streamAssistantReply(w http.ResponseWriter, r *http.Request, assistant *core.Assistant, sessionID string, reqMsg *core.ChatMessage, response interface{}) {
 	params, err := common2.NewRagContext(r, assistant, sessionID)
 	if err != nil {
 		h.Error(w, err)
 		return
 	}
+	h.streamAssistantReplyWithParams(w, r, assistant, sessionID, reqMsg, response, params)
+}
+
+// streamAssistantReplyWithParams streams the reply to a message, generated
+// with the given context.
+func (h APIHandler) streamAssistantReplyWithParams(w http.ResponseWriter, r *http.Request, assistant *core.Assistant, sessionID string, reqMsg *core.ChatMessage, response interface{}, params *common2.RAGContext) {
+	userInfo := security.MustGetUserFromRequest(r)
+
+	if err := service.SetActiveMessage(r.Context(), sessionID, reqMsg.ID); err != nil {
+		h.Error(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.Error(w, errors.New("http.Flusher not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	_ = enc.Encode(response)
+	flusher.Flush()
+
 	// Create a context with cancel to handle the message asynchronously
 	ctx := context.WithoutCancel(r.Context())
-	ctx, cancel := context.WithTimeout(ctx, resolveTimeout(assistant))
+	ctx, cancel := common.WithPausableTimeout(ctx, resolveTimeout(assistant))
 
-	replyMsg := service.CreateAssistantReplyMessage(params.SessionID, reqMsg.AssistantID, reqMsg.ID)
+	replyMsg := service.CreateAssistantReplyMessage(params.SessionID, assistant.ID, reqMsg.ID)
 
 	streamSender := &common2.HTTPStreamSender{
 		ReqMsg:   reqMsg,
@@ -662,8 +777,9 @@ func (h APIHandler) sendChatMessageV2(w http.ResponseWriter, r *http.Request, ps
 		SessionID:  sessionID,
 		CancelFunc: cancel,
 	})
-	_ = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, streamSender)
-
+	// Buffer the reply so the client can re-attach to it after a disconnect.
+	replySender := service.NewReplyStreamSender(ctx, reqMsg, replyMsg, streamSender)
+	_ = service.ProcessMessageAsync(ctx, userInfo.MustGetUserID(), reqMsg, replyMsg, params, replySender)
 }
 
 func (h APIHandler) closeChatSession(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -0,0 +1,368 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/service"
+	"infini.sh/coco/modules/common"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	// sessionResourceType is the resource type sessions are shared with, a
+	// session is shared read-only by granting the view permission through the
+	// resource sharing API, e.g. /resources/session/:id/share
+	sessionResourceType = "session"
+
+	// maxExportMessages bounds the messages of an exported session
+	maxExportMessages = 1000
+
+	exportFormatMarkdown = "markdown"
+	exportFormatHTML     = "html"
+	exportFormatJSON     = "json"
+)
+
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+const exportHTMLStyle = `<style>
+body { font-family: system-ui, -apple-system, "Microsoft YaHei", sans-serif; max-width: 860px; margin: 2em auto; padding: 0 1em; line-height: 1.6; color: #24292f; }
+pre { background: #f6f8fa; padding: 12px; overflow: auto; }
+code { font-family: ui-monospace, Menlo, Consolas, monospace; }
+h2 { border-bottom: 1px solid #d0d7de; padding-bottom: .3em; }
+blockquote { color: #57606a; border-left: 4px solid #d0d7de; margin: 0; padding: 0 1em; }
+</style>`
+
+// sessionExport is a session with its messages, as exported to JSON.
+type sessionExport struct {
+	Session  core.Session       `json:"session"`
+	Messages []core.ChatMessage `json:"messages"`
+}
+
+// exportSession exports a session with its messages to Markdown, HTML or
+// JSON. The session can be exported by its owner and the users it is shared
+// with.
+func (h APIHandler) exportSession(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("session_id")
+	format := strings.ToLower(h.GetParameterOrDefault(req, "format", exportFormatMarkdown))
+	if format == "md" {
+		format = exportFormatMarkdown
+	}
+	if format != exportFormatMarkdown && format != exportFormatHTML && format != exportFormatJSON {
+		h.WriteError(w, fmt.Sprintf("invalid format [%v], expected one of markdown, html, json", format), http.StatusBadRequest)
+		return
+	}
+
+	session, exists, err := getSharedSession(req.Context(), id)
+	if err != nil || !exists {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	messages, err := getSessionMessages(req.Context(), id)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the conversation as the user sees it, without the other attempts
+	messages = service.NewMessageTree(messages).Branch(session.ActiveMessageID)
+	attachmentNames, err := getAttachmentNames(req.Context(), messages)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var (
+		body        []byte
+		contentType string
+		fileExt     string
+	)
+	switch format {
+	case exportFormatJSON:
+		body = util.MustToJSONBytes(sessionExport{Session: session, Messages: messages})
+		contentType, fileExt = "application/json; charset=utf-8", "json"
+	case exportFormatHTML:
+		body, err = renderSessionHTML(&session, messages, attachmentNames)
+		if err != nil {
+			h.WriteError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		contentType, fileExt = "text/html; charset=utf-8", "html"
+	default:
+		body = []byte(renderSessionMarkdown(&session, messages, attachmentNames))
+		contentType, fileExt = "text/markdown; charset=utf-8", "md"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chat-%v.%v\"", id, fileExt))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		h.Error(w, err)
+	}
+}
+
+// getSessionShareLink returns the read-only link of a session. Only the owner
+// can get the link, and only the users the session is shared with through the
+// resource sharing API can open it.
+func (h APIHandler) getSessionShareLink(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.MustGetParameter("session_id")
+
+	session := core.Session{}
+	session.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &session)
+	if err != nil || !exists {
+		h.WriteOpRecordNotFoundJSON(w, id)
+		return
+	}
+
+	format := h.GetParameterOrDefault(req, "format", exportFormatHTML)
+	link := fmt.Sprintf("/chat/%v/_export?format=%v", id, format)
+	if endpoint := common.AppConfig().ServerInfo.Endpoint; endpoint != "" {
+		link = strings.TrimRight(endpoint, "/") + link
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":           id,
+		"link":          link,
+		"resource_type": sessionResourceType,
+	}, http.StatusOK)
+}
+
+// getSharedSession loads a session owned by, or shared with, the user of the
+// request.
+func getSharedSession(parent context.Context, id string) (core.Session, bool, error) {
+	session := core.Session{}
+	session.ID = id
+
+	ctx := orm.NewContextWithParent(parent)
+	ctx.Set(orm.SharingEnabled, true)
+	ctx.Set(orm.SharingResourceType, sessionResourceType)
+	exists, err := orm.GetV2(ctx, &session)
+	return session, exists, err
+}
+
+// getSessionMessages loads the messages of a session in their order, with the
+// attachment links of the reports expanded. The caller must have checked the
+// access to the session, as the messages belong to the session owner.
+func getSessionMessages(parent context.Context, sessionID string) ([]core.ChatMessage, error) {
+	builder := orm.NewQuery()
+	builder.Must(orm.TermQuery("session_id", sessionID))
+	builder.SortBy(orm.Sort{Field: "created", SortType: orm.ASC})
+	builder.Size(maxExportMessages)
+
+	ctx := orm.NewContextWithParent(parent)
+	ctx.DirectReadAccess()
+	orm.WithModel(ctx, &core.ChatMessage{})
+
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	refined, err := refineAttachmentURLs(res.Payload.([]byte))
+	if err != nil {
+		return nil, err
+	}
+
+	response := struct {
+		Hits struct {
+			Hits []struct {
+				Source core.ChatMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}{}
+	if err := util.FromJSONBytes(refined, &response); err != nil {
+		return nil, err
+	}
+	messages := make([]core.ChatMessage, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		messages = append(messages, hit.Source)
+	}
+	return messages, nil
+}
+
+// getAttachmentNames loads the names of the attachments of the messages,
+// keyed by attachment ID.
+func getAttachmentNames(parent context.Context, messages []core.ChatMessage) (map[string]string, error) {
+	ids := []string{}
+	for _, msg := range messages {
+		ids = append(ids, msg.Attachments...)
+	}
+	names := make(map[string]string, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+
+	builder := orm.NewQuery()
+	builder.Filter(orm.TermsQuery("id", ids))
+	builder.Size(len(ids))
+
+	ctx := orm.NewContextWithParent(parent)
+	ctx.DirectReadAccess()
+	orm.WithModel(ctx, &core.Attachment{})
+
+	docs := []core.Attachment{}
+	if err, _ := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, nil); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		names[doc.ID] = doc.Name
+	}
+	return names, nil
+}
+
+var markdownLinkTextEscaper = strings.NewReplacer("\\", "\\\\", "[", "\\[", "]", "\\]")
+
+// attachmentLinks renders the attachments as Markdown links to their
+// download API, named after the attachments when known.
+func attachmentLinks(ids []string, names map[string]string) string {
+	links := make([]string, 0, len(ids))
+	for _, id := range ids {
+		name := names[id]
+		if name == "" {
+			name = id
+		}
+		links = append(links, fmt.Sprintf("[%v](/attachment/%v)", markdownLinkTextEscaper.Replace(name), url.PathEscape(id)))
+	}
+	return strings.Join(links, ", ")
+}
+
+// renderSessionMarkdown renders a session as a Markdown document, the answers
+// followed by the sources, the tool calls and the citations they are based on.
+func renderSessionMarkdown(session *core.Session, messages []core.ChatMessage, attachmentNames map[string]string) string {
+	sb := strings.Builder{}
+	title := session.Title
+	if title == "" {
+		title = "Chat " + session.ID
+	}
+	sb.WriteString("# " + title + "\n\n")
+	if session.Created != nil {
+		sb.WriteString("_Created: " + session.Created.Format(exportTimeLayout) + "_\n\n")
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		role := "User"
+		if msg.MessageType == core.MessageTypeAssistant {
+			role = "Assistant"
+		} else if msg.MessageType == core.MessageTypeSystem {
+			role = "System"
+		}
+		sb.WriteString("## " + role)
+		if msg.Created != nil {
+			sb.WriteString(" · " + msg.Created.Format(exportTimeLayout))
+		}
+		sb.WriteString("\n\n")
+
+		if msg.Message != "" {
+			sb.WriteString(strings.TrimSpace(msg.Message) + "\n\n")
+		}
+		if len(msg.Attachments) > 0 {
+			sb.WriteString("**Attachments:** " + attachmentLinks(msg.Attachments, attachmentNames) + "\n\n")
+		}
+		if report, ok := msg.Payload.(map[string]interface{}); ok {
+			if url, _ := report["url"].(string); url != "" {
+				name, _ := report["title"].(string)
+				if name == "" {
+					name = "Report"
+				}
+				sb.WriteString(fmt.Sprintf("**Report:** [%v](%v)\n\n", name, url))
+			}
+		}
+		writeMessageDetails(&sb, msg)
+	}
+	return sb.String()
+}
+
+// writeMessageDetails writes the tool calls, sources and citations of an
+// assistant answer.
+func writeMessageDetails(sb *strings.Builder, msg *core.ChatMessage) {
+	if msg.MessageType != core.MessageTypeAssistant {
+		return
+	}
+
+	for _, d := range msg.Details {
+		if d.Type == common.Tools && d.Description != "" {
+			sb.WriteString("### Tool calls\n\n" + strings.TrimSpace(d.Description) + "\n\n")
+		}
+	}
+
+	sources := collectSources(msg)
+	if len(sources) > 0 {
+		sb.WriteString("### Sources\n\n")
+		for _, item := range sources {
+			source, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			title, _ := source["title"].(string)
+			url, _ := source["url"].(string)
+			if title == "" {
+				title, _ = source["id"].(string)
+			}
+			if url != "" {
+				sb.WriteString(fmt.Sprintf("- [%v](%v)", title, url))
+			} else {
+				sb.WriteString("- " + title)
+			}
+			if explain, _ := source["explain"].(string); explain != "" {
+				sb.WriteString(": " + explain)
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(msg.Citations) > 0 {
+		sb.WriteString("### Citations\n\n")
+		for _, c := range msg.Citations {
+			title := c.Title
+			if title == "" {
+				title = c.DocumentID
+			}
+			if c.URL != "" {
+				sb.WriteString(fmt.Sprintf("[%d] [%v](%v)", c.Number, title, c.URL))
+			} else {
+				sb.WriteString(fmt.Sprintf("[%d] %v", c.Number, title))
+			}
+			if c.PageRange != nil {
+				sb.WriteString(fmt.Sprintf(", pages %d-%d", c.PageRange.Start, c.PageRange.End))
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+	}
+}
+
+// renderSessionHTML renders the Markdown export as a standalone HTML page,
+// raw HTML in the messages is not rendered.
+func renderSessionHTML(session *core.Session, messages []core.ChatMessage, attachmentNames map[string]string) ([]byte, error) {
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	var buf bytes.Buffer
+	buf.WriteString(`<!DOCTYPE html><html><head><meta charset="UTF-8"><title>`)
+	buf.WriteString(html.EscapeString(session.Title))
+	buf.WriteString(`</title>`)
+	buf.WriteString(exportHTMLStyle)
+	buf.WriteString(`</head><body>`)
+	if err := md.Convert([]byte(renderSessionMarkdown(session, messages, attachmentNames)), &buf); err != nil {
+		return nil, fmt.Errorf("failed to convert markdown: %w", err)
+	}
+	buf.WriteString(`</body></html>`)
+	return buf.Bytes(), nil
+}
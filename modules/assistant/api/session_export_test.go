@@ -0,0 +1,82 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+)
+
+func TestRenderSessionMarkdown(t *testing.T) {
+	session := core.Session{Title: "Connector setup"}
+	session.ID = "s1"
+
+	answer := core.ChatMessage{
+		MessageType: core.MessageTypeAssistant,
+		Message:     "Use the Google Drive connector [1].",
+		Details: []core.ProcessingDetails{
+			{Order: 15, Type: common.Tools, Description: "**search**\n```json\n{}\n```"},
+			{Order: 20, Type: common.FetchSource, Payload: []interface{}{
+				map[string]interface{}{"id": "d1", "title": "Fetched", "url": "https://a"},
+			}},
+			{Order: 30, Type: common.PickSource, Payload: []interface{}{
+				map[string]interface{}{"id": "d2", "title": "Picked", "explain": "most relevant"},
+			}},
+		},
+		Citations: []core.Citation{{Number: 1, DocumentID: "d2", Title: "Picked", URL: "https://b", PageRange: &core.ChunkRange{Start: 2, End: 3}}},
+		Payload:   map[string]interface{}{"title": "Report", "url": "https://coco/attachment/a1"},
+	}
+	messages := []core.ChatMessage{
+		{MessageType: core.MessageTypeUser, Message: "How do I add a connector?", Attachments: []string{"a0", "a9"}},
+		answer,
+	}
+
+	got := renderSessionMarkdown(&session, messages, map[string]string{"a0": "setup [draft].pdf"})
+	for _, want := range []string{
+		"# Connector setup\n",
+		"## User\n\nHow do I add a connector?\n\n**Attachments:** [setup \\[draft\\].pdf](/attachment/a0), [a9](/attachment/a9)\n",
+		"## Assistant\n\nUse the Google Drive connector [1].\n",
+		"**Report:** [Report](https://coco/attachment/a1)\n",
+		"### Tool calls\n\n**search**\n",
+		"### Sources\n\n- Picked: most relevant\n",
+		"### Citations\n\n[1] [Picked](https://b), pages 2-3\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in export:\n%v", want, got)
+		}
+	}
+	if strings.Contains(got, "Fetched") {
+		t.Fatalf("expected the picked sources only:\n%v", got)
+	}
+}
+
+func TestRenderSessionHTMLEscapesRawHTML(t *testing.T) {
+	session := core.Session{Title: "<b>title</b>"}
+	messages := []core.ChatMessage{{MessageType: core.MessageTypeUser, Message: "hi <script>alert(1)</script>"}}
+
+	got, err := renderSessionHTML(&session, messages, nil)
+	if err != nil {
+		t.Fatalf("renderSessionHTML returned error: %v", err)
+	}
+	if strings.Contains(string(got), "<script>") || strings.Contains(string(got), "<b>title</b>") {
+		t.Fatalf("expected raw HTML to be omitted:\n%s", got)
+	}
+}
+
+func TestRenderSessionHTMLAttachmentLinks(t *testing.T) {
+	session := core.Session{Title: "Attachments"}
+	messages := []core.ChatMessage{{MessageType: core.MessageTypeUser, Message: "see the file", Attachments: []string{"a0"}}}
+
+	got, err := renderSessionHTML(&session, messages, map[string]string{"a0": "notes.txt"})
+	if err != nil {
+		t.Fatalf("renderSessionHTML returned error: %v", err)
+	}
+	if !strings.Contains(string(got), `<a href="/attachment/a0">notes.txt</a>`) {
+		t.Fatalf("expected a link to the attachment:\n%s", got)
+	}
+}
@@ -0,0 +1,248 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"html"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"infini.sh/coco/core"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	// maxMessageHits bounds the messages searched for the sessions they belong to
+	maxMessageHits = 200
+	// maxMessagesPerSession is the number of matching messages returned per session
+	maxMessagesPerSession = 3
+	highlightFragmentSize = 120
+	maxHighlightFragments = 3
+	highlightPreTag       = "<em>"
+	highlightPostTag      = "</em>"
+)
+
+// sessionSearchHit is a session matching the search by its title or summary,
+// or by the messages exchanged in it.
+type sessionSearchHit struct {
+	Session   core.Session        `json:"session"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+	Messages  []messageSearchHit  `json:"messages,omitempty"`
+}
+
+type messageSearchHit struct {
+	ID        string     `json:"id"`
+	Type      string     `json:"type"`
+	Created   *time.Time `json:"created,omitempty"`
+	Highlight []string   `json:"highlight"`
+}
+
+// searchChatSessions searches the sessions of the user by title, summary and
+// the messages exchanged in them, the matches are highlighted with <em> tags
+// in HTML escaped fragments.
+func (h APIHandler) searchChatSessions(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	query := strings.TrimSpace(h.GetParameterOrDefault(req, "query", ""))
+	if query == "" {
+		h.WriteError(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	from := max(h.GetIntOrDefault(req, "from", 0), 0)
+	size := h.GetIntOrDefault(req, "size", 20)
+	if size <= 0 {
+		size = 20
+	}
+
+	// messages matching by their body
+	builder, err := orm.NewQueryBuilderFromRequest(req, "message")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.Query(query)
+	builder.From(0)
+	builder.Size(maxMessageHits)
+
+	ctx := orm.NewContextWithParent(req.Context())
+	orm.WithModel(ctx, &core.ChatMessage{})
+	messages := []core.ChatMessage{}
+	if err, _ = elastic.SearchV2WithResultItemMapper(ctx, &messages, builder, nil); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	messageSessions := []string{}
+	seen := map[string]bool{}
+	for _, msg := range messages {
+		if !seen[msg.SessionID] {
+			seen[msg.SessionID] = true
+			messageSessions = append(messageSessions, msg.SessionID)
+		}
+	}
+
+	// sessions matching by title or summary, or by their messages, in one
+	// query so that the total and the pages agree
+	builder, err = orm.NewQueryBuilderFromRequest(req, "title", "title.pinyin", "summary")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.SkipFuzziness()
+	shouldClauses, err := orm.BuildFuzzinessQueryClauses(query, h.GetIntOrDefault(req, "fuzziness", 3), []string{"title", "title.pinyin", "summary"})
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(messageSessions) > 0 {
+		shouldClauses = append(shouldClauses, orm.TermsQuery("id", messageSessions))
+	}
+	builder.Must(orm.BoolQuery(orm.Should, shouldClauses...).Parameter("minimum_should_match", 1))
+	builder.Filter(orm.MustNotQuery(orm.TermQuery("visible", false)))
+	builder.From(from)
+	builder.Size(size)
+
+	ctx = orm.NewContextWithParent(req.Context())
+	orm.WithModel(ctx, &core.Session{})
+	sessions := []core.Session{}
+	err, res := elastic.SearchV2WithResultItemMapper(ctx, &sessions, builder, nil)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var total int64
+	if res != nil {
+		total = res.Total
+	}
+
+	h.WriteJSON(w, util.MapStr{
+		"total": total,
+		"hits":  mergeSessionSearchHits(query, sessions, messages),
+	}, http.StatusOK)
+}
+
+// mergeSessionSearchHits lists sessions in their order, each with the
+// highlighted fields and the matching messages of the session.
+func mergeSessionSearchHits(query string, sessions []core.Session, messages []core.ChatMessage) []sessionSearchHit {
+	terms := highlightTerms(query)
+
+	hits := make([]sessionSearchHit, 0, len(sessions))
+	index := make(map[string]int, len(sessions))
+	for _, s := range sessions {
+		hit := sessionSearchHit{Session: s, Highlight: map[string][]string{}}
+		if fragments := highlightText(s.Title, terms); len(fragments) > 0 {
+			hit.Highlight["title"] = fragments
+		}
+		if fragments := highlightText(s.Summary, terms); len(fragments) > 0 {
+			hit.Highlight["summary"] = fragments
+		}
+		index[s.ID] = len(hits)
+		hits = append(hits, hit)
+	}
+
+	for _, msg := range messages {
+		i, ok := index[msg.SessionID]
+		if !ok || len(hits[i].Messages) >= maxMessagesPerSession {
+			continue
+		}
+		fragments := highlightText(msg.Message, terms)
+		if len(fragments) == 0 {
+			// matched by the analyzer only, e.g. a stemmed word
+			fragments = []string{html.EscapeString(util.SubStringWithSuffix(msg.Message, highlightFragmentSize, "..."))}
+		}
+		hits[i].Messages = append(hits[i].Messages, messageSearchHit{
+			ID:        msg.ID,
+			Type:      msg.MessageType,
+			Created:   msg.Created,
+			Highlight: fragments,
+		})
+	}
+	return hits
+}
+
+// highlightTerms splits the query into lower-cased terms.
+func highlightTerms(query string) [][]rune {
+	terms := [][]rune{}
+	for _, field := range strings.Fields(query) {
+		terms = append(terms, lowerRunes(field))
+	}
+	return terms
+}
+
+func lowerRunes(text string) []rune {
+	runes := []rune(text)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// highlightText returns the fragments of text around the terms, the terms
+// wrapped with highlight tags and the rest HTML escaped.
+func highlightText(text string, terms [][]rune) []string {
+	if text == "" || len(terms) == 0 {
+		return nil
+	}
+	runes := []rune(text)
+	lower := lowerRunes(text)
+
+	// matched ranges, in rune offsets
+	type span struct{ start, end int }
+	matches := []span{}
+	for i := 0; i < len(lower); {
+		matched := 0
+		for _, term := range terms {
+			if len(term) > matched && i+len(term) <= len(lower) && string(lower[i:i+len(term)]) == string(term) {
+				matched = len(term)
+			}
+		}
+		if matched == 0 {
+			i++
+			continue
+		}
+		matches = append(matches, span{i, i + matched})
+		i += matched
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	fragments := []string{}
+	for m := 0; m < len(matches) && len(fragments) < maxHighlightFragments; {
+		start := max(matches[m].start-highlightFragmentSize/2, 0)
+		end := min(start+highlightFragmentSize, len(runes))
+
+		sb := strings.Builder{}
+		if start > 0 {
+			sb.WriteString("...")
+		}
+		pos := start
+		for ; m < len(matches) && matches[m].end <= end; m++ {
+			sb.WriteString(html.EscapeString(string(runes[pos:matches[m].start])))
+			sb.WriteString(highlightPreTag)
+			sb.WriteString(html.EscapeString(string(runes[matches[m].start:matches[m].end])))
+			sb.WriteString(highlightPostTag)
+			pos = matches[m].end
+		}
+		if pos == start {
+			// a match longer than the fragment
+			end = matches[m].end
+			sb.WriteString(html.EscapeString(string(runes[pos:matches[m].start])))
+			sb.WriteString(highlightPreTag)
+			sb.WriteString(html.EscapeString(string(runes[matches[m].start:end])))
+			sb.WriteString(highlightPostTag)
+			pos = end
+			m++
+		}
+		sb.WriteString(html.EscapeString(string(runes[pos:end])))
+		if end < len(runes) {
+			sb.WriteString("...")
+		}
+		fragments = append(fragments, sb.String())
+	}
+	return fragments
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightText(t *testing.T) {
+	got := highlightText("How to configure the Coco <server> connector?", highlightTerms("coco CONNECTOR"))
+	want := "How to configure the <em>Coco</em> &lt;server&gt; <em>connector</em>?"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestHighlightTextFragments(t *testing.T) {
+	text := "apple " + strings.Repeat("x", 300) + " apple"
+	got := highlightText(text, highlightTerms("apple"))
+	if len(got) != 2 {
+		t.Fatalf("expected 2 fragments, got %d: %q", len(got), got)
+	}
+	if !strings.HasPrefix(got[0], "<em>apple</em>") || !strings.HasSuffix(got[0], "...") {
+		t.Fatalf("unexpected first fragment: %q", got[0])
+	}
+	if !strings.HasPrefix(got[1], "...") || !strings.HasSuffix(got[1], "<em>apple</em>") {
+		t.Fatalf("unexpected second fragment: %q", got[1])
+	}
+}
+
+func TestHighlightTextCJK(t *testing.T) {
+	got := highlightText("如何配置搜索服务", highlightTerms("搜索"))
+	if len(got) != 1 || got[0] != "如何配置<em>搜索</em>服务" {
+		t.Fatalf("unexpected highlight: %q", got)
+	}
+	if got := highlightText("nothing here", highlightTerms("missing")); got != nil {
+		t.Fatalf("expected no fragments, got %q", got)
+	}
+}
@@ -38,11 +38,17 @@ type RAGContext struct {
 
 	QueryIntent  *QueryIntent
 	PickedDocIDS []string
+	// ReferenceDocs are the documents given to the answering model, in the
+	// order of their reference numbers
+	ReferenceDocs []core.Document
 
 	AssistantCfg *core.Assistant
 
 	//user input values
 	InputValues map[string]any
+
+	// ResumeResearch is the ID of the deep research checkpoint to resume from
+	ResumeResearch string
 }
 
 func NewRagContext(req *http.Request, assistant *core.Assistant, sessionID string) (*RAGContext, error) {
@@ -122,6 +128,43 @@ func (r RAGContext) MustGetAnsweringModel() *core.ModelConfig {
 	return &model
 }
 
+// GetHistorySummaryModel resolves the model summarizing the chat history.
+func (r RAGContext) GetHistorySummaryModel() *core.ModelConfig {
+	return r.resolveAuxiliaryModel(r.AssistantCfg.ChatSettings.HistoryMessage.SummaryModel)
+}
+
+// GetSessionTitleModel resolves the model naming the chat sessions.
+func (r RAGContext) GetSessionTitleModel() *core.ModelConfig {
+	return r.resolveAuxiliaryModel(r.AssistantCfg.ChatSettings.AutoTitle.Model)
+}
+
+// resolveAuxiliaryModel resolves a model used for housekeeping of the chat:
+// the configured model, then the intent analysis model of the assistant and
+// the settings, and the answering model as a last resort. The prompt of the
+// fallback models does not apply and is dropped.
+func (r RAGContext) resolveAuxiliaryModel(cfg *core.ModelConfig) *core.ModelConfig {
+	model := core.ModelConfig{}
+	if cfg != nil {
+		model = *cfg
+	} else if r.AssistantCfg.DeepThinkConfig != nil {
+		model = r.AssistantCfg.DeepThinkConfig.IntentAnalysisModel
+		model.PromptConfig = nil
+	}
+
+	resolved := llmmodule.ResolveAssistantModel(core.AssistantModelUseIntentAnalysis, &core.ModelId{
+		ProviderID: model.ProviderID,
+		ID:         model.Name,
+	})
+	if resolved == nil {
+		answering := r.MustGetAnsweringModel()
+		answering.PromptConfig = nil
+		return answering
+	}
+	model.ProviderID = resolved.ProviderID
+	model.Name = resolved.ID
+	return &model
+}
+
 // GetAnsweringProvider resolves and returns the model provider for the answering model.
 func (r RAGContext) GetAnsweringProvider() *core.ModelProvider {
 	model := r.MustGetAnsweringModel()
@@ -0,0 +1,295 @@
+package deep_research
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/smallnest/langgraphgo/graph"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	defaultPlanApprovalTimeout = 30 * time.Minute
+	// planApprovalPollInterval is how often a paused research checks the store
+	// for a decision made on another node
+	planApprovalPollInterval = 3 * time.Second
+
+	PlanActionApprove = "approve"
+	PlanActionReject  = "reject"
+)
+
+var (
+	ErrPlanApprovalNotFound = errors.New("no research plan waiting for approval")
+	ErrPlanAlreadyDecided   = errors.New("research plan is already decided")
+)
+
+// planApprovals wakes up the research paused on this node when its plan is
+// decided, keyed by the ID of the reply message.
+var planApprovals = sync.Map{}
+
+// PlanDecision is the decision of the user on a research plan. The plan is
+// approved as is, or as edited in Plan, less the RejectedSteps, numbered from
+// 1; or rejected as a whole, which stops the research.
+type PlanDecision struct {
+	Action        string   `json:"action"`
+	Plan          []string `json:"plan,omitempty"`
+	RejectedSteps []int    `json:"rejected_steps,omitempty"`
+	Comment       string   `json:"comment,omitempty"`
+}
+
+// PlanApprovalNode pauses the research once planned, when the approval of the
+// plan is required, until the user decides on it or it expires. The wait is
+// bounded by the approval timeout alone, the timeouts of the request and of
+// the research are paused meanwhile.
+func PlanApprovalNode(ctx context.Context, state interface{}) (interface{}, error) {
+	s := state.(*State)
+	// decided before the research was resumed
+	if !s.Config.PlanApproval || len(s.Plan) == 0 || s.PlanApprovalStatus != "" {
+		return s, nil
+	}
+
+	timeout := defaultPlanApprovalTimeout
+	if s.Config.PlanApprovalTimeout != "" {
+		if d, err := time.ParseDuration(s.Config.PlanApprovalTimeout); err == nil {
+			timeout = d
+		}
+	}
+	expires := time.Now().Add(timeout)
+
+	record := &core.ResearchPlanApproval{
+		SessionID:        s.SessionID,
+		RequestMessageID: s.RequestMessageID,
+		Query:            s.Request.Query,
+		Plan:             s.Plan,
+		MaxSteps:         s.Config.MaxSteps,
+		Status:           core.PlanApprovalPending,
+		Expires:          &expires,
+	}
+	record.ID = s.ReplyMessageID
+
+	notify := make(chan struct{}, 1)
+	planApprovals.Store(record.ID, notify)
+	defer planApprovals.Delete(record.ID)
+
+	if err := savePlanApproval(ctx, record); err != nil {
+		return nil, err
+	}
+	s.sendAndCollect(common.ResearchPlanApproval, util.MustToJSON(util.MapStr{
+		"status":  record.Status,
+		"plan":    record.Plan,
+		"expires": expires,
+	}))
+
+	resume := common.PauseTimeouts(ctx)
+	decided, err := waitForPlanDecision(ctx, record, notify)
+	resume()
+	if err != nil {
+		return nil, err
+	}
+
+	s.PlanApprovalStatus = decided.Status
+	if decided.Status == core.PlanApprovalApproved {
+		s.Plan = decided.Plan
+	}
+	s.sendAndCollect(common.ResearchPlanApproval, util.MustToJSON(util.MapStr{
+		"status":  decided.Status,
+		"plan":    decided.Plan,
+		"comment": decided.Comment,
+	}))
+	if decided.Status == core.PlanApprovalApproved {
+		s.sendAndCollect(common.ResearchPlanUpdated, util.MustToJSON(s.Plan))
+	}
+	s.checkpoint(ctx, "plan_approval")
+	return s, nil
+}
+
+// planApproved routes a research to the researcher unless its plan was
+// rejected or expired.
+func planApproved(ctx context.Context, state interface{}) string {
+	s := state.(*State)
+	switch s.PlanApprovalStatus {
+	case core.PlanApprovalRejected, core.PlanApprovalExpired:
+		return graph.END
+	}
+	return "researcher"
+}
+
+// waitForPlanDecision returns the plan once decided, or expired. The plan is
+// expired only if still pending, a decision made meanwhile is kept.
+func waitForPlanDecision(ctx context.Context, record *core.ResearchPlanApproval, notify <-chan struct{}) (*core.ResearchPlanApproval, error) {
+	ticker := time.NewTicker(planApprovalPollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(time.Until(*record.Expires))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the research was cancelled, the plan can no longer be decided
+			if _, err := expirePlanApproval(context.WithoutCancel(ctx), record); err != nil {
+				_ = log.Warnf("failed to expire research plan [%v]: %v", record.ID, err)
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+			decided, err := expirePlanApproval(ctx, record)
+			if err != nil {
+				_ = log.Warnf("failed to expire research plan [%v]: %v", record.ID, err)
+				decided = record
+				decided.Status = core.PlanApprovalExpired
+			}
+			if decided.Status == core.PlanApprovalExpired {
+				log.Infof("research plan [%v] was not approved in time", record.ID)
+			}
+			return decided, nil
+		case <-notify:
+		case <-ticker.C:
+		}
+
+		decided, err := getPlanApproval(ctx, record.ID)
+		if err != nil {
+			_ = log.Warnf("failed to check research plan [%v]: %v", record.ID, err)
+			continue
+		}
+		if decided.Status != core.PlanApprovalPending {
+			return decided, nil
+		}
+	}
+}
+
+// expirePlanApproval expires a pending plan, and returns the plan as stored:
+// expired, or as decided by the user just before.
+func expirePlanApproval(ctx context.Context, record *core.ResearchPlanApproval) (*core.ResearchPlanApproval, error) {
+	expired := *record
+	expired.Status = core.PlanApprovalExpired
+	err := common.UpdateIfUnchanged(expired.ID, &expired, "status", core.PlanApprovalPending, false)
+	if err == nil {
+		return &expired, nil
+	}
+	if !errors.Is(err, common.ErrConcurrentUpdate) {
+		return nil, err
+	}
+	return getPlanApproval(ctx, record.ID)
+}
+
+// GetPlanApproval returns the research plan of a reply of a session, to show
+// it again after a page reload.
+func GetPlanApproval(ctx context.Context, sessionID, replyMessageID string) (*core.ResearchPlanApproval, error) {
+	record, err := getPlanApproval(ctx, replyMessageID)
+	if err != nil {
+		return nil, err
+	}
+	if record.SessionID != sessionID {
+		return nil, ErrPlanApprovalNotFound
+	}
+	// the research may have stopped without expiring the plan, e.g. on restart
+	if record.Status == core.PlanApprovalPending && time.Now().After(*record.Expires) {
+		record.Status = core.PlanApprovalExpired
+	}
+	return record, nil
+}
+
+// DecidePlanApproval records the decision of the user on a research plan and
+// resumes the research, on this node or the one running it. A plan is decided
+// once, the decisions racing with another one or with the expiry fail.
+func DecidePlanApproval(ctx context.Context, sessionID, replyMessageID string, decision PlanDecision) (*core.ResearchPlanApproval, error) {
+	record, err := GetPlanApproval(ctx, sessionID, replyMessageID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != core.PlanApprovalPending {
+		return nil, fmt.Errorf("%w, it is %v", ErrPlanAlreadyDecided, record.Status)
+	}
+
+	switch decision.Action {
+	case PlanActionApprove:
+		plan, err := applyPlanDecision(record.Plan, decision, record.MaxSteps)
+		if err != nil {
+			return nil, err
+		}
+		record.Plan = plan
+		record.Status = core.PlanApprovalApproved
+	case PlanActionReject:
+		record.Status = core.PlanApprovalRejected
+	default:
+		return nil, fmt.Errorf("action must be %v or %v", PlanActionApprove, PlanActionReject)
+	}
+	record.Comment = decision.Comment
+
+	if err := common.UpdateIfUnchanged(record.ID, record, "status", core.PlanApprovalPending, false); err != nil {
+		if errors.Is(err, common.ErrConcurrentUpdate) {
+			return nil, ErrPlanAlreadyDecided
+		}
+		return nil, err
+	}
+	if v, ok := planApprovals.Load(replyMessageID); ok {
+		select {
+		case v.(chan struct{}) <- struct{}{}:
+		default:
+		}
+	}
+	return record, nil
+}
+
+// applyPlanDecision returns the plan approved by the user.
+func applyPlanDecision(plan []string, decision PlanDecision, maxSteps int) ([]string, error) {
+	if decision.Plan != nil {
+		plan = nil
+		for _, step := range decision.Plan {
+			if step = strings.TrimSpace(step); step != "" {
+				plan = append(plan, step)
+			}
+		}
+	}
+
+	rejected := map[int]bool{}
+	for _, n := range decision.RejectedSteps {
+		if n < 1 || n > len(plan) {
+			return nil, fmt.Errorf("invalid rejected step [%v], the plan has %v steps", n, len(plan))
+		}
+		rejected[n] = true
+	}
+	approved := make([]string, 0, len(plan))
+	for i, step := range plan {
+		if !rejected[i+1] {
+			approved = append(approved, step)
+		}
+	}
+
+	if len(approved) == 0 {
+		return nil, fmt.Errorf("no step is left in the plan, reject it instead")
+	}
+	if maxSteps > 0 && len(approved) > maxSteps {
+		return nil, fmt.Errorf("the plan has %v steps, no more than %v are allowed", len(approved), maxSteps)
+	}
+	return approved, nil
+}
+
+func getPlanApproval(ctx context.Context, id string) (*core.ResearchPlanApproval, error) {
+	record := &core.ResearchPlanApproval{}
+	record.ID = id
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectReadAccess()
+	exists, err := orm.GetV2(ctx1, record)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrPlanApprovalNotFound
+	}
+	return record, nil
+}
+
+func savePlanApproval(ctx context.Context, record *core.ResearchPlanApproval) error {
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.ImmediatelyRefresh
+	return orm.Save(ctx1, record)
+}
@@ -0,0 +1,86 @@
+package deep_research
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/smallnest/langgraphgo/graph"
+	"infini.sh/coco/core"
+)
+
+func TestApplyPlanDecision(t *testing.T) {
+	plan := []string{"history", "market", "outlook"}
+	tests := []struct {
+		name     string
+		decision PlanDecision
+		maxSteps int
+		want     []string
+		wantErr  bool
+	}{
+		{name: "as is", decision: PlanDecision{Action: PlanActionApprove}, want: plan},
+		{name: "rejected steps", decision: PlanDecision{Action: PlanActionApprove, RejectedSteps: []int{2}}, want: []string{"history", "outlook"}},
+		{name: "edited", decision: PlanDecision{Action: PlanActionApprove, Plan: []string{" pricing ", "", "competitors"}}, want: []string{"pricing", "competitors"}},
+		{name: "edited and rejected", decision: PlanDecision{Action: PlanActionApprove, Plan: []string{"pricing", "competitors"}, RejectedSteps: []int{1}}, want: []string{"competitors"}},
+		{name: "step out of range", decision: PlanDecision{Action: PlanActionApprove, RejectedSteps: []int{4}}, wantErr: true},
+		{name: "step zero", decision: PlanDecision{Action: PlanActionApprove, RejectedSteps: []int{0}}, wantErr: true},
+		{name: "all rejected", decision: PlanDecision{Action: PlanActionApprove, RejectedSteps: []int{1, 2, 3}}, wantErr: true},
+		{name: "emptied", decision: PlanDecision{Action: PlanActionApprove, Plan: []string{" "}}, wantErr: true},
+		{name: "too many steps", decision: PlanDecision{Action: PlanActionApprove}, maxSteps: 2, wantErr: true},
+		{name: "within max steps", decision: PlanDecision{Action: PlanActionApprove, RejectedSteps: []int{3}}, maxSteps: 2, want: []string{"history", "market"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyPlanDecision(plan, tt.decision, tt.maxSteps)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+	if !reflect.DeepEqual(plan, []string{"history", "market", "outlook"}) {
+		t.Fatalf("expected the plan to be left untouched, got %v", plan)
+	}
+}
+
+func TestPlanApprovedRouting(t *testing.T) {
+	tests := map[string]string{
+		"":                        "researcher",
+		core.PlanApprovalApproved: "researcher",
+		core.PlanApprovalRejected: graph.END,
+		core.PlanApprovalExpired:  graph.END,
+	}
+	for status, want := range tests {
+		if got := planApproved(context.Background(), &State{PlanApprovalStatus: status}); got != want {
+			t.Fatalf("status %q: expected %v, got %v", status, want, got)
+		}
+		if msg := planNotApprovedMessage(status); (msg == "") != (want != graph.END) {
+			t.Fatalf("status %q: unexpected message %q", status, msg)
+		}
+	}
+}
+
+func TestPlanApprovalNodeSkipsWithoutApproval(t *testing.T) {
+	tests := []*State{
+		{Plan: []string{"history"}},
+		{Config: &core.DeepResearchConfig{PlanApproval: true}},
+		{Config: &core.DeepResearchConfig{PlanApproval: true}, Plan: []string{"history"}, PlanApprovalStatus: core.PlanApprovalApproved},
+	}
+	for _, s := range tests {
+		if s.Config == nil {
+			s.Config = &core.DeepResearchConfig{}
+		}
+		out, err := PlanApprovalNode(context.Background(), s)
+		if err != nil || out != s {
+			t.Fatalf("expected the state to pass through, got %v, %v", out, err)
+		}
+	}
+}
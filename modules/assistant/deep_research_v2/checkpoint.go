@@ -0,0 +1,152 @@
+package deep_research
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// checkpointStaleAfter is how long a running research can go without a
+// checkpoint before it is considered interrupted, e.g. by a restart.
+const checkpointStaleAfter = 10 * time.Minute
+
+var ErrCheckpointNotFound = errors.New("no deep research checkpoint found")
+
+// checkpoint saves the state of the research once a node, or a research step,
+// is completed. A failure is logged, the research goes on.
+func (s *State) checkpoint(ctx context.Context, node string) {
+	if s.ReplyMessageID == "" {
+		return
+	}
+	completed := 0
+	for _, r := range s.StepResults {
+		if r.Status == "completed" {
+			completed++
+		}
+	}
+	s.lastNode = node
+	s.saveCheckpoint(ctx, core.ResearchCheckpointRunning, "", completed)
+}
+
+// finishCheckpoint records the end of the research, a stopped research can be
+// resumed.
+func (s *State) finishCheckpoint(ctx context.Context, status string, reason string) {
+	if s.ReplyMessageID == "" || s.lastNode == "" {
+		return
+	}
+	completed := 0
+	for _, r := range s.StepResults {
+		if r.Status == "completed" {
+			completed++
+		}
+	}
+	s.saveCheckpoint(ctx, status, reason, completed)
+}
+
+func (s *State) saveCheckpoint(ctx context.Context, status, reason string, completed int) {
+	if s.checkpointCreated.IsZero() {
+		s.checkpointCreated = time.Now()
+	}
+	record := &core.ResearchCheckpoint{
+		SessionID:        s.SessionID,
+		RequestMessageID: s.RequestMessageID,
+		ResumedFrom:      s.ResumedFrom,
+		Status:           status,
+		Error:            reason,
+		Node:             s.lastNode,
+		CompletedSteps:   completed,
+		TotalSteps:       len(s.Plan),
+		State:            util.MustToJSONBytes(s),
+		Chunks:           util.MustToJSONBytes(s.Chunks),
+	}
+	record.ID = s.ReplyMessageID
+	record.Created = &s.checkpointCreated
+
+	// the state must be saved even if the research was cancelled
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	ctx1 := orm.NewContextWithParent(saveCtx)
+	ctx1.DirectAccess()
+	if err := orm.Save(ctx1, record); err != nil {
+		_ = log.Warnf("failed to save checkpoint of deep research [%v]: %v", record.ID, err)
+	}
+}
+
+// GetCheckpoint returns the checkpoint of a deep research reply of a session.
+func GetCheckpoint(ctx context.Context, sessionID, replyMessageID string) (*core.ResearchCheckpoint, error) {
+	record := &core.ResearchCheckpoint{}
+	record.ID = replyMessageID
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectReadAccess()
+	exists, err := orm.GetV2(ctx1, record)
+	if err != nil {
+		return nil, err
+	}
+	if !exists || record.SessionID != sessionID {
+		return nil, ErrCheckpointNotFound
+	}
+	return record, nil
+}
+
+// CheckResumable returns why a research cannot be resumed from a checkpoint,
+// nil if it can.
+func CheckResumable(record *core.ResearchCheckpoint) error {
+	switch record.Status {
+	case core.ResearchCheckpointCompleted:
+		return fmt.Errorf("the research is completed")
+	case core.ResearchCheckpointRunning:
+		if record.Updated != nil && time.Since(*record.Updated) < checkpointStaleAfter {
+			return fmt.Errorf("the research is still running")
+		}
+	}
+	return nil
+}
+
+// restoreCheckpoint loads the state of a checkpoint into the state of a new
+// run, and replays the chunks streamed so far.
+func (s *State) restoreCheckpoint(record *core.ResearchCheckpoint) error {
+	if err := util.FromJSONBytes(record.State, s); err != nil {
+		return fmt.Errorf("invalid checkpoint [%v]: %w", record.ID, err)
+	}
+	chunks := []ChunkRecord{}
+	if len(record.Chunks) > 0 {
+		if err := util.FromJSONBytes(record.Chunks, &chunks); err != nil {
+			return fmt.Errorf("invalid checkpoint [%v]: %w", record.ID, err)
+		}
+	}
+
+	s.ResumedFrom = record.ID
+	s.lastNode = record.Node
+	s.MaterialRegistry = make(map[string]bool)
+	for _, content := range s.ChapterContents {
+		for _, m := range content.Materials {
+			s.MaterialRegistry[m.ID] = true
+		}
+	}
+	for _, c := range chunks {
+		s.sendAndCollect(c.ChunkType, c.MessageChunk)
+	}
+	return nil
+}
+
+// DeleteSessionResearchState deletes the checkpoints and the plans of the deep
+// researches of a session.
+func DeleteSessionResearchState(ctx context.Context, sessionID string) error {
+	for _, model := range []interface{}{&core.ResearchCheckpoint{}, &core.ResearchPlanApproval{}} {
+		builder := orm.NewQuery()
+		builder.Filter(orm.TermQuery("session_id", sessionID))
+		ctx1 := orm.NewContextWithParent(ctx)
+		ctx1.DirectAccess()
+		orm.WithModel(ctx1, model)
+		if _, err := orm.DeleteByQuery(ctx1, builder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
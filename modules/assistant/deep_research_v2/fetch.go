@@ -0,0 +1,299 @@
+package deep_research
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/coco/plugins/processors/fileproc"
+)
+
+const (
+	defaultPageFetchTimeout = 15 * time.Second
+	// maxFetchedPageSize caps the size of a page read, PDFs included
+	maxFetchedPageSize = 10 << 20
+	pageFetchAgent     = "Mozilla/5.0 (compatible; CocoResearch/1.0)"
+	// minMainContentLength is the length of text under which a main element,
+	// e.g. an empty <article>, is not taken as the content of the page
+	minMainContentLength = 300
+)
+
+var (
+	// pageFetchClient fetches the pages of the search results, which may
+	// point anywhere, so only the public addresses are connected to
+	pageFetchClient = common.NewPublicHTTPClient(0)
+	// boilerplateRegex matches the class or ID of elements around the content
+	boilerplateRegex = regexp.MustCompile(`(?i)comment|sidebar|menu|navbar|footer|header|banner|share|social|advert|promo|cookie|related|breadcrumb|subscribe|newsletter|popup|modal`)
+	whitespaceRegex  = regexp.MustCompile(`\s+`)
+)
+
+// fetchPages replaces the snippets of the top external results by the main
+// content of their pages, a page failing to be fetched keeps its snippet.
+func fetchPages(ctx context.Context, cfg *core.DeepResearchPageFetchConfig, results []SearchResult) []SearchResult {
+	if !cfg.IsEnabled() || cfg.MaxPages <= 0 {
+		return results
+	}
+	timeout := defaultPageFetchTimeout
+	if cfg.Timeout != "" {
+		if d, err := time.ParseDuration(cfg.Timeout); err == nil && d > 0 {
+			timeout = d
+		}
+	}
+
+	var wg sync.WaitGroup
+	fetched := 0
+	for i := range results {
+		if fetched >= cfg.MaxPages {
+			break
+		}
+		r := &results[i]
+		if r.Source == "internal" || r.Fetched || !fetchableURL(r.URL) {
+			continue
+		}
+		fetched++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pageCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			content, err := fetchPageContent(pageCtx, cfg, r.URL)
+			if err != nil {
+				log.Debugf("failed to fetch page [%v]: %v", r.URL, err)
+				return
+			}
+			content = truncateRunes(content, cfg.MaxContentLength)
+			if len(content) > len(r.Content) {
+				r.Content = content
+				r.Fetched = true
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// fetchableURL rejects the URLs which are not http(s), or which point to the
+// local network. The host names are checked once resolved, when connecting.
+func fetchableURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return common.IsPublicIP(ip)
+	}
+	return true
+}
+
+// fetchPageContent returns the main text of a page, HTML, PDF or plain text.
+func fetchPageContent(ctx context.Context, cfg *core.DeepResearchPageFetchConfig, pageURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", pageFetchAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/pdf,text/plain;q=0.9,*/*;q=0.5")
+
+	resp, err := pageFetchClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchedPageSize))
+	if err != nil {
+		return "", err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(body)
+		mediaType, _, _ = mime.ParseMediaType(mediaType)
+	}
+	switch {
+	case mediaType == "application/pdf":
+		return extractPDFText(ctx, cfg, body)
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return extractMainContent(bytes.NewReader(body))
+	case mediaType == "text/plain" || mediaType == "text/markdown":
+		return normalizeText(string(body)), nil
+	}
+	return "", fmt.Errorf("unsupported content type [%v]", mediaType)
+}
+
+// extractPDFText extracts the text of a PDF with Tika.
+func extractPDFText(ctx context.Context, cfg *core.DeepResearchPageFetchConfig, body []byte) (string, error) {
+	if cfg.TikaEndpoint == "" {
+		return "", fmt.Errorf("no tika endpoint to extract the text of a PDF")
+	}
+	file, err := os.CreateTemp("", "coco-research-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(body); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	timeout := int(defaultPageFetchTimeout / time.Second)
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := int(time.Until(deadline) / time.Second); left > 0 {
+			timeout = left
+		}
+	}
+	rc, err := fileproc.TikaGetTextPlain(ctx, cfg.TikaEndpoint, timeout, file.Name())
+	if err != nil {
+		return "", err
+	}
+	defer fileproc.DeferClose(rc)
+	text, err := io.ReadAll(rc)
+	if err != nil {
+		return "", err
+	}
+	return normalizeText(string(text)), nil
+}
+
+// extractMainContent extracts the main text of an HTML page, readability
+// style: the boilerplate is dropped, then the content is taken from the main
+// element of the page if any, or from the element holding most of the text of
+// the paragraphs.
+func extractMainContent(r io.Reader) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return "", err
+	}
+	doc.Find("script, style, noscript, template, iframe, svg, canvas, form, button, nav, header, footer, aside").Remove()
+	// a wrapper of the page may have a boilerplate class too, e.g. has-sidebar
+	pageLen := len(normalizeText(doc.Find("body").Text()))
+	doc.Find("div, section, ul, ol, table").Each(func(_ int, sel *goquery.Selection) {
+		class, _ := sel.Attr("class")
+		id, _ := sel.Attr("id")
+		if !boilerplateRegex.MatchString(class + " " + id) {
+			return
+		}
+		if sel.Find("article, main, [role=main]").Length() > 0 || len(normalizeText(sel.Text())) > pageLen/2 {
+			return
+		}
+		sel.Remove()
+	})
+
+	for _, selector := range []string{"article", "main", "[role=main]"} {
+		var best string
+		doc.Find(selector).Each(func(_ int, sel *goquery.Selection) {
+			if text := blockText(sel); len(text) > len(best) {
+				best = text
+			}
+		})
+		if len(best) >= minMainContentLength {
+			return best, nil
+		}
+	}
+
+	// score the parents of the paragraphs by the text they hold
+	type candidate struct {
+		sel   *goquery.Selection
+		score float64
+	}
+	var best *candidate
+	candidates := map[interface{}]*candidate{}
+	doc.Find("p, pre, blockquote").Each(func(_ int, p *goquery.Selection) {
+		text := normalizeText(p.Text())
+		if len(text) < 25 || linkDensity(p, text) > 0.5 {
+			return
+		}
+		parent := p.Parent()
+		if parent.Length() == 0 {
+			return
+		}
+		c, ok := candidates[parent.Get(0)]
+		if !ok {
+			c = &candidate{sel: parent}
+			candidates[parent.Get(0)] = c
+		}
+		c.score += 1 + float64(strings.Count(text, ",")) + float64(min(len(text)/100, 3))
+		if best == nil || c.score > best.score {
+			best = c
+		}
+	})
+	if best != nil {
+		if text := blockText(best.sel); text != "" {
+			return text, nil
+		}
+	}
+
+	if text := normalizeText(doc.Find("body").Text()); text != "" {
+		return text, nil
+	}
+	return "", fmt.Errorf("no content found")
+}
+
+// blockText returns the text of the paragraphs, headings and list items of an
+// element, one per line, or its whole text if it has none.
+func blockText(sel *goquery.Selection) string {
+	var blocks []string
+	sel.Find("h1, h2, h3, h4, h5, h6, p, pre, blockquote, li, td").Each(func(_ int, block *goquery.Selection) {
+		// the text of nested blocks is taken once, from the innermost
+		if block.Find("p, pre, blockquote, li").Length() > 0 {
+			return
+		}
+		text := normalizeText(block.Text())
+		if text != "" && linkDensity(block, text) <= 0.5 {
+			blocks = append(blocks, text)
+		}
+	})
+	if len(blocks) == 0 {
+		return normalizeText(sel.Text())
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// linkDensity is the share of the text of an element which is link text.
+func linkDensity(sel *goquery.Selection, text string) float64 {
+	if len(text) == 0 {
+		return 0
+	}
+	linkLen := 0
+	sel.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linkLen += len(normalizeText(a.Text()))
+	})
+	return float64(linkLen) / float64(len(text))
+}
+
+func normalizeText(s string) string {
+	return strings.TrimSpace(whitespaceRegex.ReplaceAllString(s, " "))
+}
+
+func truncateRunes(s string, max int) string {
+	if max <= 0 {
+		return s
+	}
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max]) + "..."
+}
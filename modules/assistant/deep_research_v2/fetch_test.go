@@ -0,0 +1,109 @@
+package deep_research
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func TestFetchableURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/page", true},
+		{"http://8.8.8.8/", true},
+		{"ftp://example.com/file", false},
+		{"file:///etc/passwd", false},
+		{"https://", false},
+		{"http://localhost:8080/", false},
+		{"http://LOCALHOST/", false},
+		{"http://127.0.0.1/", false},
+		{"http://[::1]/", false},
+		{"http://10.0.0.1/", false},
+		{"http://192.168.1.1/admin", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://0.0.0.0/", false},
+		{"http://[fd00:ec2::254]/", false},
+		{"http://100.100.100.200/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := fetchableURL(tt.url); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFetchPageContentRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	// a public host name may resolve, or redirect, to an internal address
+	if _, err := fetchPageContent(context.Background(), &core.DeepResearchPageFetchConfig{}, server.URL); err == nil {
+		t.Fatalf("expected the connection to a loopback address to be refused")
+	}
+}
+
+func TestExtractMainContent(t *testing.T) {
+	paragraph := "Coco AI searches the documents of the enterprise, with the assistants answering from them, citing their sources, and keeping the answers up to date."
+	tests := []struct {
+		name    string
+		html    string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "article",
+			html: `<html><body><nav>Home | Products | Pricing</nav><div class="sidebar"><p>Subscribe to the newsletter of the company today</p></div>
+<article><h1>Release notes</h1><p>` + paragraph + `</p><p>` + paragraph + `</p><p>` + paragraph + `</p></article>
+<footer>Copyright</footer><script>var tracking = 1;</script></body></html>`,
+			want:    []string{"Release notes", paragraph},
+			notWant: []string{"Home | Products", "Subscribe", "Copyright", "tracking"},
+		},
+		{
+			name: "densest block",
+			html: `<html><body><div id="menu"><ul><li><a href="/a">First link of the menu</a></li><li><a href="/b">Second link of the menu</a></li></ul></div>
+<div class="content"><p>` + paragraph + `</p><p>` + paragraph + `, again, and again.</p></div>
+<div class="other"><p>A short unrelated paragraph, and some more words.</p></div></body></html>`,
+			want:    []string{paragraph},
+			notWant: []string{"menu", "unrelated"},
+		},
+		{
+			name: "plain body",
+			html: `<html><body>Just   some
+text</body></html>`,
+			want: []string{"Just some text"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractMainContent(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Fatalf("expected %q in:\n%v", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Fatalf("expected no %q in:\n%v", notWant, got)
+				}
+			}
+		})
+	}
+
+	if _, err := extractMainContent(strings.NewReader(`<html><body><script>1</script></body></html>`)); err == nil {
+		t.Fatalf("expected a page without text to fail")
+	}
+}
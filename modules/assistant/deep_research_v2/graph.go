@@ -2,8 +2,10 @@ package deep_research
 
 import (
 	"context"
+	"time"
 
 	"github.com/smallnest/langgraphgo/graph"
+	"github.com/tmc/langchaingo/embeddings"
 	"infini.sh/coco/core"
 )
 
@@ -90,19 +92,24 @@ type State struct {
 	StepResults     []StepResult `json:"step_results"`     // Detailed results per step
 	ResearchResults []string     `json:"research_results"` // Legacy format for backward compatibility
 
-	// MarkdownReport, HTMLReport and PDFReport hold the three rendering formats
-	// of the final report. All three are set inside the Reporter node (the last
-	// graph node) and are read only after graph.Invoke returns — no downstream
+	// MarkdownReport, HTMLReport, PDFReport, DOCXReport and PPTXReport hold the
+	// rendering formats of the final report. They are set inside the Reporter
+	// node (the last graph node) and are read only after graph.Invoke returns — no downstream
 	// node in the graph ever reads them. The json:"-" tag skips unnecessary
 	// serialization between node transitions.
 	MarkdownReport string `json:"-"`
 	HTMLReport     string `json:"-"`
 	PDFReport      []byte `json:"-"`
+	DOCXReport     []byte `json:"-"`
+	PPTXReport     []byte `json:"-"`
 
 	// ReportTitle is the AI-generated title used for the attachment name and
 	// the PDF cover page. It is produced in the Reporter node and consumed
 	// after graph.Invoke returns, so it does not need to cross node boundaries.
 	ReportTitle string `json:"-"`
+	// ReportFormat is the format the report was rendered in, markdown when
+	// the rendering in the configured format failed.
+	ReportFormat string `json:"-"`
 
 	//Step            int          `json:"step"`
 	// Chapter Management
@@ -116,6 +123,22 @@ type State struct {
 	StartTime   int64                    `json:"-"` // Unix timestamp for timing
 	Attachments []*core.Attachment       `json:"-"` // User-uploaded files; text is injected into the planner prompt.
 	Chunks      []ChunkRecord            `json:"-"` // Collected streaming chunks for persistence
+
+	// Plan approval
+	SessionID          string `json:"-"`
+	RequestMessageID   string `json:"-"`
+	ReplyMessageID     string `json:"-"`
+	PlanApprovalStatus string `json:"plan_approval_status,omitempty"` // set once the plan is decided, if its approval is required
+
+	// Checkpointing
+	ResumedFrom       string    `json:"-"` // ID of the checkpoint the research was resumed from
+	lastNode          string    // last node checkpointed
+	checkpointCreated time.Time // creation of the checkpoint of this run
+
+	// Relevance scoring
+	embedder          embeddings.EmbedderClient // nil without an embedding model
+	embedderResolved  bool
+	chapterEmbeddings [][]float32 // of the chapter outline, in order
 }
 
 // sendAndCollect sends a chunk to the client and records it for later persistence.
@@ -130,6 +153,7 @@ func NewGraph() (*graph.StateRunnable, error) {
 
 	// Add nodes
 	workflow.AddNode("planner", "Research planning node", PlannerNode)
+	workflow.AddNode("plan_approval", "Research plan approval node", PlanApprovalNode)
 	workflow.AddNode("researcher", "Research execution node", ResearcherNode)
 	workflow.AddNode("reporter", "Report generation node", ReporterNode)
 
@@ -137,8 +161,9 @@ func NewGraph() (*graph.StateRunnable, error) {
 	// Start -> Planner
 	workflow.SetEntryPoint("planner")
 
-	// Planner -> Researcher
-	workflow.AddEdge("planner", "researcher")
+	// Planner -> Plan approval -> Researcher, or END if the plan is rejected
+	workflow.AddEdge("planner", "plan_approval")
+	workflow.AddConditionalEdge("plan_approval", planApproved)
 
 	// Researcher -> Reporter
 	workflow.AddEdge("researcher", "reporter")
@@ -16,7 +16,9 @@ import (
 	"infini.sh/framework/core/util"
 )
 
-func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResearchConfig, reqMsg, replyMsg *core.ChatMessage, attachments []*core.Attachment, sender core.MessageSender) error {
+// RunDeepResearchV2 runs a deep research, or resumes the one of the
+// checkpoint resumeFrom if given.
+func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResearchConfig, reqMsg, replyMsg *core.ChatMessage, attachments []*core.Attachment, sender core.MessageSender, resumeFrom string) error {
 
 	//response
 	reasoningBuffer := strings.Builder{}
@@ -49,6 +51,24 @@ func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResea
 		}
 	}()
 
+	// record the end of the research in its checkpoint, a stopped research
+	// can be resumed
+	finished := false
+	defer func() {
+		if completedState == nil {
+			return
+		}
+		if r := recover(); r != nil {
+			completedState.finishCheckpoint(ctx, core.ResearchCheckpointStopped, fmt.Sprint(r))
+			panic(r)
+		}
+		if finished {
+			completedState.finishCheckpoint(ctx, core.ResearchCheckpointCompleted, "")
+		} else {
+			completedState.finishCheckpoint(ctx, core.ResearchCheckpointStopped, "")
+		}
+	}()
+
 	log.Infof("Starting Deep-Research research agent, query: %s\n", query)
 
 	graph, err := NewGraph()
@@ -63,6 +83,20 @@ func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResea
 		Request: Request{
 			Query: query,
 		},
+		SessionID:        replyMsg.SessionID,
+		RequestMessageID: reqMsg.ID,
+		ReplyMessageID:   replyMsg.ID,
+	}
+
+	if resumeFrom != "" {
+		checkpoint, err := GetCheckpoint(ctx, replyMsg.SessionID, resumeFrom)
+		if err != nil {
+			return err
+		}
+		if err := initialState.restoreCheckpoint(checkpoint); err != nil {
+			return err
+		}
+		log.Infof("resuming deep research [%v] after %v of %v steps", resumeFrom, checkpoint.CompletedSteps, checkpoint.TotalSteps)
 	}
 
 	// Apply timeout if configured
@@ -70,7 +104,7 @@ func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResea
 	if config.Timeout != "" {
 		if d, err := time.ParseDuration(config.Timeout); err == nil {
 			var cancel context.CancelFunc
-			invokeCtx, cancel = context.WithTimeout(ctx, d)
+			invokeCtx, cancel = common.WithPausableTimeout(ctx, d)
 			defer cancel()
 		}
 	}
@@ -87,10 +121,20 @@ func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResea
 
 	finalState := result.(*State)
 	completedState = finalState
+
+	// the research stops without a report when its plan is not approved
+	if msg := planNotApprovedMessage(finalState.PlanApprovalStatus); msg != "" {
+		messageBuffer.WriteString(msg)
+		sender.SendChunkMessage(core.MessageTypeAssistant, common.Response, msg, 0)
+		finished = true
+		return nil
+	}
+
 	log.Info("\n=== Final Report ===")
 	log.Info(finalState.MarkdownReport)
 
-	reportFormat := config.ReportFormat
+	// the format rendered, markdown if the configured one failed
+	reportFormat := finalState.ReportFormat
 	if reportFormat == "" {
 		reportFormat = "markdown"
 	}
@@ -103,6 +147,10 @@ func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResea
 		reportContent = finalState.HTMLReport
 	case "pdf":
 		reportBytes = finalState.PDFReport
+	case "docx":
+		reportBytes = finalState.DOCXReport
+	case "pptx":
+		reportBytes = finalState.PPTXReport
 	default:
 		reportContent = finalState.MarkdownReport
 	}
@@ -134,6 +182,10 @@ func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResea
 		log.Info("  HTMLReport length: ", len(finalState.HTMLReport))
 	case "pdf":
 		log.Info("  PDFReport length: ", len(finalState.PDFReport))
+	case "docx":
+		log.Info("  DOCXReport length: ", len(finalState.DOCXReport))
+	case "pptx":
+		log.Info("  PPTXReport length: ", len(finalState.PPTXReport))
 	default:
 		log.Info("  MarkdownReport length: ", len(finalState.MarkdownReport))
 	}
@@ -148,15 +200,28 @@ func RunDeepResearchV2(ctx context.Context, query string, config *core.DeepResea
 
 	//messageBuffer.WriteString(finalState.MarkdownReport)
 
+	finished = true
 	return nil
 }
 
+// planNotApprovedMessage is the reply to a research stopped at the approval of
+// its plan, empty if the research went on.
+func planNotApprovedMessage(status string) string {
+	switch status {
+	case core.PlanApprovalRejected:
+		return "The research plan was rejected, the research was stopped."
+	case core.PlanApprovalExpired:
+		return "The research plan was not approved in time, the research was stopped."
+	}
+	return ""
+}
+
 // saveReport persists a generated research report as an attachment.
 //
 // report is the text content (markdown or HTML) and reportBytes holds the
-// PDF binary. Exactly one is non-nil depending on reportFormat:
-//   - markdown/html → report is set, reportBytes is nil
-//   - pdf          → reportBytes is set, report is empty
+// binary of a PDF, DOCX or PPTX. Exactly one is non-nil depending on reportFormat:
+//   - markdown/html  → report is set, reportBytes is nil
+//   - pdf/docx/pptx  → reportBytes is set, report is empty
 //
 // title is the base name used for the attachment; the file extension is
 // appended automatically based on reportFormat so the download handler
@@ -179,6 +244,16 @@ func saveReport(ctx context.Context, reportFormat, report string, reportBytes []
 		attachment.MimeType = "application/pdf"
 		attachment.Size = int64(len(reportBytes))
 		content = reportBytes
+	case "docx":
+		attachment.Name = title + ".docx"
+		attachment.MimeType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		attachment.Size = int64(len(reportBytes))
+		content = reportBytes
+	case "pptx":
+		attachment.Name = title + ".pptx"
+		attachment.MimeType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		attachment.Size = int64(len(reportBytes))
+		content = reportBytes
 	default:
 		attachment.Name = title + ".md"
 		attachment.MimeType = "text/markdown; charset=UTF-8"
@@ -186,6 +261,9 @@ func saveReport(ctx context.Context, reportFormat, report string, reportBytes []
 		attachment.Size = int64(len(report))
 		content = []byte(report)
 	}
+	if len(content) == 0 {
+		panic(errors.Errorf("failed to save report: the %v report is empty", reportFormat))
+	}
 	attachment.Icon = "book-open"
 	attachment.URL = fmt.Sprintf("/attachment/%v", attachment.ID)
 	ctx1 := orm.NewContextWithParent(ctx)
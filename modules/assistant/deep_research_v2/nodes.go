@@ -24,6 +24,11 @@ import (
 func PlannerNode(ctx context.Context, state interface{}) (interface{}, error) {
 	s := state.(*State)
 
+	// resumed from a checkpoint, the plan was streamed again already
+	if len(s.Plan) > 0 {
+		return s, nil
+	}
+
 	s.sendAndCollect(common.ResearchPlannerStart, "")
 
 	planningModel, err := resolveStageModel(s.Config.PlanningModel, "planning")
@@ -102,6 +107,7 @@ Respond in %s.`, s.Request.Query, maxSteps, depthHint, s.Config.ResearchDepth, r
 	}
 
 	s.sendAndCollect(common.ResearchPlannerEnd, util.MustToJSON(s.Plan))
+	s.checkpoint(ctx, "planner")
 
 	return s, nil
 }
@@ -122,7 +128,12 @@ func ResearcherNode(ctx context.Context, state interface{}) (interface{}, error)
 	// Initialize state components if not present
 	if s.StartTime == 0 {
 		s.StartTime = time.Now().Unix()
+	}
+	// restored from a checkpoint, or not
+	if s.MaterialRegistry == nil {
 		s.MaterialRegistry = make(map[string]bool)
+	}
+	if s.ChapterContents == nil {
 		s.ChapterContents = make(map[string]*ChapterContent)
 	}
 
@@ -173,6 +184,11 @@ func ResearcherNode(ctx context.Context, state interface{}) (interface{}, error)
 			log.Infof("Reached MaxResearcherIterations (%d), stopping", maxIter)
 			break
 		}
+		// completed before the research was resumed
+		if stepIndex < len(s.StepResults) && s.StepResults[stepIndex].Status == "completed" {
+			results = append(results, s.StepResults[stepIndex].Analysis)
+			continue
+		}
 		stepStartTime := time.Now()
 
 		payload := util.MapStr{}
@@ -251,12 +267,15 @@ Generate a more specific search query to obtain more detailed or relevant inform
 				refinedCollection, err := SearchWithConfig(ctx, refinementQuery, s.Config, false) // external first
 				if err == nil {
 					// Combine initial and refined results
-					initialSearchCollection.Results = append(initialSearchCollection.Results, refinedCollection.Results...)
+					initialSearchCollection.Results = dedupeResults(append(initialSearchCollection.Results, refinedCollection.Results...))
 					initialSearchCollection.evaluateSearchQuality() // Re-evaluate
 				}
 			}
 		}
 
+		// Rank the sources by relevance to the step
+		s.scoreResults(ctx, step, initialSearchCollection)
+
 		// Send step end with all combined results (initial + refined)
 		searchPayload = util.MapStr{}
 		searchPayload["plan"] = step
@@ -276,7 +295,7 @@ Generate a more specific search query to obtain more detailed or relevant inform
 		stepMaterials = s.convertToMaterials(initialSearchCollection, stepIndex+1)
 
 		// Step 4: Distribute materials to relevant chapters
-		allocatedMaterials := s.distributeMaterialsToChapters(stepMaterials, stepIndex)
+		allocatedMaterials := s.distributeMaterialsToChapters(ctx, stepMaterials, stepIndex)
 
 		// Step 5: Analyze and synthesize findings with chapter-aware context
 		findingsPrompt := s.generateChapterAwareAnalysisPrompt(step, allocatedMaterials, stepIndex)
@@ -315,9 +334,12 @@ Generate a more specific search query to obtain more detailed or relevant inform
 		s.updateChapterProgress(stepIndex, allocatedMaterials)
 
 		s.sendAndCollect(common.ResearchResearcherEnd, util.MustToJSON(payload))
+		s.ResearchResults = results
+		s.checkpoint(ctx, "researcher")
 	}
 
 	s.ResearchResults = results
+	s.checkpoint(ctx, "researcher")
 
 	return s, nil
 }
@@ -428,11 +450,7 @@ func (s *State) convertToMaterials(collection *SearchResultCollection, stepNumbe
 		}
 
 		// Generate summary using first 300 characters
-		if len(result.Content) > 300 {
-			material.Summary = result.Content[:300] + "..."
-		} else {
-			material.Summary = result.Content
-		}
+		material.Summary = truncateRunes(result.Content, 300)
 
 		materials = append(materials, material)
 		s.MaterialRegistry[materialID] = true
@@ -443,7 +461,7 @@ func (s *State) convertToMaterials(collection *SearchResultCollection, stepNumbe
 }
 
 // distributeMaterialsToChapters intelligently assigns materials to relevant chapters
-func (s *State) distributeMaterialsToChapters(materials []MaterialReference, stepIndex int) []MaterialReference {
+func (s *State) distributeMaterialsToChapters(ctx context.Context, materials []MaterialReference, stepIndex int) []MaterialReference {
 	if len(s.ChapterOutline) == 0 {
 		log.Warn("No chapter outline available, skipping material distribution")
 		return materials
@@ -451,13 +469,21 @@ func (s *State) distributeMaterialsToChapters(materials []MaterialReference, ste
 
 	var allocatedMaterials []MaterialReference
 
+	// Embedding similarity, or keywords without an embedding model
+	scores := s.chapterScores(ctx, materials)
+
 	for i, material := range materials {
 		// Find best matching chapter based on content relevance
 		bestChapterID := ""
 		bestScore := 0.0
 
-		for _, chapter := range s.ChapterOutline {
-			score := s.calculateRelevance(material.Summary, chapter.Keywords, []string{chapter.Title})
+		for j, chapter := range s.ChapterOutline {
+			var score float64
+			if scores != nil {
+				score = scores[i][j]
+			} else {
+				score = s.calculateRelevance(material.Summary, chapter.Keywords, []string{chapter.Title})
+			}
 			if score > bestScore {
 				bestScore = score
 				bestChapterID = chapter.ID
@@ -486,8 +512,8 @@ func (s *State) distributeMaterialsToChapters(materials []MaterialReference, ste
 	return allocatedMaterials
 }
 
-// TODO move it to LLM
-// calculateRelevance calculates relevance score between content and keywords
+// calculateRelevance calculates relevance score between content and keywords,
+// when there is no embedding model
 func (s *State) calculateRelevance(content string, keywords []string, additionalTerms []string) float64 {
 	if len(keywords) == 0 && len(additionalTerms) == 0 {
 		return 0.0
@@ -551,7 +577,7 @@ func (s *State) generateChapterAwareAnalysisPrompt(step string, allocatedMateria
 	if len(allocatedMaterials) > 0 {
 		materialsInfo = "\nAllocated materials:\n"
 		for _, material := range allocatedMaterials {
-			materialsInfo += fmt.Sprintf("- %s (%s)\n", material.Title, material.Summary[:min(len(material.Summary), 100)])
+			materialsInfo += fmt.Sprintf("- %s (%s)\n", material.Title, material.Summary)
 		}
 	}
 
@@ -619,6 +645,10 @@ func (s *State) generateChapterContent(ctx context.Context, llm llms.Model, inde
 			s.initializeChapterContent(chapterID)
 			content = s.ChapterContents[chapterID]
 		}
+		// written before the research was stopped and resumed
+		if content.Status == "completed" && content.Content != "" {
+			continue
+		}
 		content.Status = "generating"
 
 		i18n := getReportI18n(s.Config.ReportLang)
@@ -678,6 +708,8 @@ Generate the chapter content directly, do not add explanatory text.`,
 
 		content.LastUpdated = time.Now().Format("2006-01-02 15:04:05")
 		s.ChapterContents[chapterID] = content
+		// a resumed research does not write the chapter again
+		s.checkpoint(ctx, "reporter")
 	}
 	log.Info("Chapter content generation completed")
 	return s.ChapterContents
@@ -722,7 +754,7 @@ func (s *State) buildMaterialsInfo(materials []MaterialReference, indexMap map[s
 		if material.URL != "" {
 			builder.WriteString(fmt.Sprintf("- Link: %s\n", material.URL))
 		}
-		builder.WriteString(fmt.Sprintf("- Content Summary: %s\n", material.Summary))
+		builder.WriteString(fmt.Sprintf("- Content: %s\n", truncateRunes(material.Content, maxMaterialExcerptLength)))
 		builder.WriteString("\n")
 	}
 	return builder.String()
@@ -903,6 +935,7 @@ func (s *State) generateChapterBasedReport(ctx context.Context, llm llms.Model)
 		}
 	}
 
+	s.ReportFormat = s.Config.ReportFormat
 	switch s.Config.ReportFormat {
 	case "html":
 		// Convert to HTML for final report
@@ -916,11 +949,30 @@ func (s *State) generateChapterBasedReport(ctx context.Context, llm llms.Model)
 		s.HTMLReport = string(markdown.Render(doc, renderer))
 	case "pdf":
 		s.PDFReport = renderPDF(ctx, body, s.ReportTitle, i18n.TableOfContents)
+		s.checkRenderedReport(s.PDFReport, nil)
+	case "docx":
+		report, err := renderDOCX(body, s.ReportTitle, i18n.TableOfContents)
+		s.DOCXReport = report
+		s.checkRenderedReport(report, err)
+	case "pptx":
+		report, err := renderPPTX(body, s.ReportTitle, i18n.TableOfContents)
+		s.PPTXReport = report
+		s.checkRenderedReport(report, err)
 	}
 
 	return s, nil
 }
 
+// checkRenderedReport falls back to the markdown report when the report could
+// not be rendered in the configured format, rather than saving an empty file.
+func (s *State) checkRenderedReport(report []byte, err error) {
+	if err == nil && len(report) > 0 {
+		return
+	}
+	log.Errorf("failed to render the %v report, falling back to markdown: %v", s.Config.ReportFormat, err)
+	s.ReportFormat = "markdown"
+}
+
 // reportI18n holds UI strings for generated report sections.
 type reportI18n struct {
 	Summary          string
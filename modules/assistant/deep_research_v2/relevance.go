@@ -0,0 +1,238 @@
+package deep_research
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"github.com/tmc/langchaingo/embeddings"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/langchain"
+	"infini.sh/coco/modules/common"
+	llmmodule "infini.sh/coco/modules/llm"
+)
+
+const (
+	// nearDuplicateSimilarity is the share of word shingles two contents have
+	// in common from which they are taken as the same page
+	nearDuplicateSimilarity = 0.8
+	shingleSize             = 5
+	// maxEmbeddedLength caps the text of a source embedded to score it
+	maxEmbeddedLength = 2000
+	// maxMaterialExcerptLength caps the content of a material given to write
+	// a chapter
+	maxMaterialExcerptLength = 1500
+	// minChapterSimilarity is the similarity under which a material is not
+	// allocated to a chapter
+	minChapterSimilarity = 0.3
+)
+
+// dedupeResults drops the results of the same page, by URL or by a near
+// identical content, keeping the best scored, or the longest, of them.
+func dedupeResults(results []SearchResult) []SearchResult {
+	kept := make([]SearchResult, 0, len(results))
+	keptShingles := make([]map[string]struct{}, 0, len(results))
+	for _, r := range results {
+		shingles := contentShingles(r.Content)
+		dup := -1
+		for i, k := range kept {
+			if (r.URL != "" && normalizeResultURL(r.URL) == normalizeResultURL(k.URL)) ||
+				jaccard(shingles, keptShingles[i]) >= nearDuplicateSimilarity {
+				dup = i
+				break
+			}
+		}
+		if dup < 0 {
+			kept = append(kept, r)
+			keptShingles = append(keptShingles, shingles)
+			continue
+		}
+		if r.Score > kept[dup].Score || (r.Score == kept[dup].Score && len(r.Content) > len(kept[dup].Content)) {
+			kept[dup] = r
+			keptShingles[dup] = shingles
+		}
+	}
+	return kept
+}
+
+// normalizeResultURL reduces the variants of the URL of a page to one, e.g.
+// with or without www, a trailing slash or tracking parameters.
+func normalizeResultURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimSpace(rawURL))
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	query := u.Query()
+	for k := range query {
+		if strings.HasPrefix(k, "utm_") {
+			query.Del(k)
+		}
+	}
+	normalized := host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if len(query) > 0 {
+		normalized += "?" + query.Encode()
+	}
+	return normalized
+}
+
+// contentShingles returns the word shingles of a content, nil if it is too
+// short to be compared.
+func contentShingles(content string) map[string]struct{} {
+	words := strings.Fields(strings.ToLower(content))
+	if len(words) < shingleSize*4 {
+		return nil
+	}
+	shingles := make(map[string]struct{}, len(words))
+	for i := 0; i+shingleSize <= len(words); i++ {
+		shingles[strings.Join(words[i:i+shingleSize], " ")] = struct{}{}
+	}
+	return shingles
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for k := range a {
+		if _, ok := b[k]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// getEmbedder returns the client of the embedding model of the research, nil
+// without one, the relevance is then scored with keywords.
+func (s *State) getEmbedder() embeddings.EmbedderClient {
+	if s.embedderResolved {
+		return s.embedder
+	}
+	s.embedderResolved = true
+
+	modelID := llmmodule.ResolveModel(core.LLMTypeEmbedding, &core.ModelId{
+		ProviderID: s.Config.EmbeddingModel.ProviderID,
+		ID:         s.Config.EmbeddingModel.Name,
+	})
+	if modelID == nil {
+		log.Debug("no embedding model configured, the relevance of sources is scored with keywords")
+		return nil
+	}
+	provider, err := common.GetModelProvider(modelID.ProviderID)
+	if err != nil {
+		_ = log.Warnf("failed to get the provider of embedding model [%v]: %v", modelID.ID, err)
+		return nil
+	}
+	model := langchain.GetEmbeddingLLM(provider.BaseURL, provider.APIType, modelID.ID, provider.APIKey, 0)
+	embedder, ok := model.(embeddings.EmbedderClient)
+	if !ok {
+		_ = log.Warnf("model [%v/%v] does not support embeddings", modelID.ProviderID, modelID.ID)
+		return nil
+	}
+	s.embedder = embedder
+	return embedder
+}
+
+// embed returns the embeddings of texts, nil if there is no embedding model or
+// it failed.
+func (s *State) embed(ctx context.Context, texts []string) [][]float32 {
+	embedder := s.getEmbedder()
+	if embedder == nil || len(texts) == 0 {
+		return nil
+	}
+	for i := range texts {
+		texts[i] = truncateRunes(texts[i], maxEmbeddedLength)
+	}
+	vectors, err := embedder.CreateEmbedding(ctx, texts)
+	if err != nil || len(vectors) != len(texts) {
+		_ = log.Warnf("failed to embed %d texts, falling back to keywords: %v", len(texts), err)
+		return nil
+	}
+	return vectors
+}
+
+// scoreResults scores the results of a search by the similarity of their
+// content to the query, the best first.
+func (s *State) scoreResults(ctx context.Context, query string, collection *SearchResultCollection) {
+	if len(collection.Results) == 0 {
+		return
+	}
+	texts := []string{query}
+	for _, r := range collection.Results {
+		texts = append(texts, r.Title+"\n"+r.Content)
+	}
+	vectors := s.embed(ctx, texts)
+	if vectors == nil {
+		return
+	}
+	for i := range collection.Results {
+		similarity := math.Max(cosineSimilarity(vectors[0], vectors[i+1]), 0)
+		collection.Results[i].Relevance = similarity
+		collection.Results[i].Score = 0.4*collection.Results[i].Score + 0.6*similarity
+	}
+	sort.SliceStable(collection.Results, func(i, j int) bool {
+		return collection.Results[i].Score > collection.Results[j].Score
+	})
+	collection.evaluateSearchQuality()
+}
+
+// chapterScores returns the similarity of each material to each chapter of
+// the outline, nil if there is no embedding model.
+func (s *State) chapterScores(ctx context.Context, materials []MaterialReference) [][]float64 {
+	if len(materials) == 0 || len(s.ChapterOutline) == 0 {
+		return nil
+	}
+	if s.chapterEmbeddings == nil {
+		texts := make([]string, 0, len(s.ChapterOutline))
+		for _, chapter := range s.ChapterOutline {
+			texts = append(texts, fmt.Sprintf("%s\n%s\n%s", chapter.Title, chapter.Description, strings.Join(chapter.Keywords, ", ")))
+		}
+		s.chapterEmbeddings = s.embed(ctx, texts)
+		if s.chapterEmbeddings == nil {
+			return nil
+		}
+	}
+
+	texts := make([]string, 0, len(materials))
+	for _, m := range materials {
+		texts = append(texts, m.Title+"\n"+m.Content)
+	}
+	vectors := s.embed(ctx, texts)
+	if vectors == nil {
+		return nil
+	}
+	scores := make([][]float64, len(materials))
+	for i := range materials {
+		scores[i] = make([]float64, len(s.ChapterOutline))
+		for j := range s.ChapterOutline {
+			if similarity := cosineSimilarity(vectors[i], s.chapterEmbeddings[j]); similarity >= minChapterSimilarity {
+				scores[i][j] = similarity
+			}
+		}
+	}
+	return scores
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,57 @@
+package deep_research
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeResultURL(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"https://www.example.com/page/", "http://example.com/page", true},
+		{"https://example.com/page?utm_source=x&utm_medium=y", "https://example.com/page", true},
+		{"https://EXAMPLE.com/page?id=1&utm_campaign=z", "https://example.com/page?id=1", true},
+		{"https://example.com/page?id=1", "https://example.com/page?id=2", false},
+		{"https://example.com/a", "https://example.com/b", false},
+		{"https://blog.example.com/a", "https://example.com/a", false},
+		{" Not a URL ", "not a url", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := normalizeResultURL(tt.a) == normalizeResultURL(tt.b); got != tt.same {
+				t.Fatalf("expected same=%v, got %q and %q", tt.same, normalizeResultURL(tt.a), normalizeResultURL(tt.b))
+			}
+		})
+	}
+}
+
+func TestDedupeResults(t *testing.T) {
+	article := strings.Repeat("the quarterly revenue of the company grew in every region it operates in ", 4)
+	other := strings.Repeat("a completely different page about the history of search engines and indexes ", 4)
+
+	results := []SearchResult{
+		{Title: "snippet", URL: "https://www.example.com/report/", Content: "short", Score: 0.5},
+		{Title: "page", URL: "https://example.com/report?utm_source=feed", Content: article, Score: 0.5},
+		{Title: "mirror", URL: "https://mirror.example.org/copy", Content: article + " copied", Score: 0.9},
+		{Title: "other", URL: "https://example.net/history", Content: other, Score: 0.4},
+		{Title: "no url", Content: "internal note", Score: 0.1},
+	}
+	got := dedupeResults(results)
+
+	titles := []string{}
+	for _, r := range got {
+		titles = append(titles, r.Title)
+	}
+	want := []string{"mirror", "other", "no url"}
+	if strings.Join(titles, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, titles)
+	}
+
+	// same score, the longest content is kept
+	got = dedupeResults(results[:2])
+	if len(got) != 1 || got[0].Title != "page" {
+		t.Fatalf("expected the longest of the same page to be kept, got %+v", got)
+	}
+}
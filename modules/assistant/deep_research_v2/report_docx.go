@@ -0,0 +1,319 @@
+package deep_research
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	docxMainNS = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	relStyles    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles"
+	relSettings  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/settings"
+	relNumbering = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering"
+
+	// the abstract numberings of numbering.xml
+	docxBulletNumID = 1
+	docxAbstractNum = `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>%s</w:abstractNum>`
+)
+
+// renderDOCX renders a markdown report to a Word document, with a cover page
+// and a table of contents. The body is the report without its title and
+// table of contents, as rendered to PDF.
+func renderDOCX(body, title, tocTitle string) ([]byte, error) {
+	return buildDOCX(markdownToBlocks(body), title, tocTitle, time.Now())
+}
+
+func buildDOCX(blocks []reportBlock, title, tocTitle string, created time.Time) ([]byte, error) {
+	d := &docxWriter{}
+	d.rels.add(relStyles, "styles.xml", false)
+	d.rels.add(relSettings, "settings.xml", false)
+	d.rels.add(relNumbering, "numbering.xml", false)
+
+	// cover page
+	d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Title"/></w:pPr>` + docxRun(reportRun{Text: title}) + `</w:p>`)
+	d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="Subtitle"/></w:pPr>` + docxRun(reportRun{Text: created.Format("2006-01-02")}) + `</w:p>`)
+	d.body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+
+	d.writeTOC(blocks, tocTitle)
+
+	for _, b := range blocks {
+		d.writeBlock(b)
+	}
+
+	d.body.WriteString(`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/>` +
+		`<w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>`)
+
+	document := xmlHeader + `<w:document ` + docxMainNS + `><w:body>` + d.body.String() + `</w:body></w:document>`
+
+	pkgRels := &officeRels{}
+	pkgRels.add(relOfficeDocument, "word/document.xml", false)
+	pkgRels.add(relCoreProperties, "docProps/core.xml", false)
+
+	return writeOfficePackage([]officeFile{
+		{Name: "[Content_Types].xml", Content: docxContentTypes},
+		{Name: "_rels/.rels", Content: pkgRels.xml()},
+		{Name: "docProps/core.xml", Content: officeCoreProperties(title, created.UTC().Format(time.RFC3339))},
+		{Name: "word/document.xml", Content: document},
+		{Name: "word/_rels/document.xml.rels", Content: d.rels.xml()},
+		{Name: "word/styles.xml", Content: docxStyles},
+		{Name: "word/settings.xml", Content: docxSettings},
+		{Name: "word/numbering.xml", Content: d.numbering()},
+	})
+}
+
+type docxWriter struct {
+	body  strings.Builder
+	rels  officeRels
+	links map[string]string // relationship IDs of the hyperlinks
+	lists []int             // numbered lists, each restarting from 1
+}
+
+// writeTOC writes a table of contents field, listing the headings of the
+// report until Word updates it with the page numbers.
+func (d *docxWriter) writeTOC(blocks []reportBlock, tocTitle string) {
+	d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="TOCHeading"/></w:pPr>` + docxRun(reportRun{Text: tocTitle}) + `</w:p>`)
+	d.body.WriteString(`<w:p><w:r><w:fldChar w:fldCharType="begin" w:dirty="true"/></w:r>` +
+		`<w:r><w:instrText xml:space="preserve"> TOC \o "1-2" \h \z \u </w:instrText></w:r>` +
+		`<w:r><w:fldChar w:fldCharType="separate"/></w:r></w:p>`)
+	for _, b := range blocks {
+		if b.Kind != blockHeading || docxHeadingLevel(b.Level) > 2 {
+			continue
+		}
+		style := "TOC" + strconv.Itoa(docxHeadingLevel(b.Level))
+		d.body.WriteString(`<w:p><w:pPr><w:pStyle w:val="` + style + `"/></w:pPr>` + docxRun(reportRun{Text: b.text()}) + `</w:p>`)
+	}
+	d.body.WriteString(`<w:p><w:r><w:fldChar w:fldCharType="end"/></w:r></w:p>`)
+	d.body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+}
+
+// docxHeadingLevel maps a markdown heading to a Word heading, the chapters of
+// the report being headings of level 2, under the title.
+func docxHeadingLevel(level int) int {
+	if level > 1 {
+		level--
+	}
+	if level > 3 {
+		level = 3
+	}
+	return level
+}
+
+func (d *docxWriter) writeBlock(b reportBlock) {
+	switch b.Kind {
+	case blockHeading:
+		d.writeParagraph(`<w:pStyle w:val="Heading`+strconv.Itoa(docxHeadingLevel(b.Level))+`"/>`, b.Runs)
+	case blockBullet:
+		d.writeParagraph(fmt.Sprintf(`<w:pStyle w:val="ListParagraph"/><w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`,
+			min(b.Level, 2), docxBulletNumID), b.Runs)
+	case blockNumbered:
+		d.writeParagraph(fmt.Sprintf(`<w:pStyle w:val="ListParagraph"/><w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`,
+			min(b.Level, 2), d.numberedListID(b.List)), b.Runs)
+	case blockCode:
+		d.writeParagraph(`<w:pStyle w:val="Code"/>`, b.Runs)
+	case blockQuote:
+		d.writeParagraph(`<w:pStyle w:val="Quote"/>`, b.Runs)
+	case blockTable:
+		d.writeTable(b.Rows)
+	default:
+		d.writeParagraph("", b.Runs)
+	}
+}
+
+func (d *docxWriter) writeParagraph(props string, runs []reportRun) {
+	d.body.WriteString(`<w:p>`)
+	if props != "" {
+		d.body.WriteString(`<w:pPr>` + props + `</w:pPr>`)
+	}
+	d.writeRuns(runs)
+	d.body.WriteString(`</w:p>`)
+}
+
+func (d *docxWriter) writeRuns(runs []reportRun) {
+	for _, r := range runs {
+		if r.Link == "" || strings.HasPrefix(r.Link, "#") {
+			d.body.WriteString(docxRun(r))
+			continue
+		}
+		if d.links == nil {
+			d.links = map[string]string{}
+		}
+		id, ok := d.links[r.Link]
+		if !ok {
+			id = d.rels.add(relHyperlink, r.Link, true)
+			d.links[r.Link] = id
+		}
+		d.body.WriteString(`<w:hyperlink r:id="` + id + `" w:history="1">` + docxRun(r) + `</w:hyperlink>`)
+	}
+}
+
+func (d *docxWriter) writeTable(rows [][][]reportRun) {
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	if cols == 0 {
+		return
+	}
+	// the width of the page between the margins, in twentieths of a point
+	colWidth := 9026 / cols
+
+	d.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="5000" w:type="pct"/></w:tblPr><w:tblGrid>`)
+	for i := 0; i < cols; i++ {
+		d.body.WriteString(`<w:gridCol w:w="` + strconv.Itoa(colWidth) + `"/>`)
+	}
+	d.body.WriteString(`</w:tblGrid>`)
+	for i, row := range rows {
+		d.body.WriteString(`<w:tr>`)
+		if i == 0 {
+			d.body.WriteString(`<w:trPr><w:tblHeader/></w:trPr>`)
+		}
+		for c := 0; c < cols; c++ {
+			var cell []reportRun
+			if c < len(row) {
+				cell = row[c]
+			}
+			if i == 0 {
+				bold := make([]reportRun, len(cell))
+				for j, r := range cell {
+					r.Bold = true
+					bold[j] = r
+				}
+				cell = bold
+			}
+			d.body.WriteString(`<w:tc><w:tcPr><w:tcW w:w="` + strconv.Itoa(colWidth) + `" w:type="dxa"/>`)
+			if i == 0 {
+				d.body.WriteString(`<w:shd w:val="clear" w:color="auto" w:fill="D9E2F3"/>`)
+			}
+			d.body.WriteString(`</w:tcPr>`)
+			d.writeParagraph(`<w:spacing w:after="0"/>`, cell)
+			d.body.WriteString(`</w:tc>`)
+		}
+		d.body.WriteString(`</w:tr>`)
+	}
+	d.body.WriteString(`</w:tbl>`)
+	// a table is followed by a paragraph, or tables would merge
+	d.body.WriteString(`<w:p/>`)
+}
+
+// numberedListID returns the numbering of a numbered list of the report.
+func (d *docxWriter) numberedListID(list int) int {
+	for i, l := range d.lists {
+		if l == list {
+			return docxBulletNumID + 1 + i
+		}
+	}
+	d.lists = append(d.lists, list)
+	return docxBulletNumID + len(d.lists)
+}
+
+func (d *docxWriter) numbering() string {
+	var sb strings.Builder
+	sb.WriteString(xmlHeader + `<w:numbering ` + docxMainNS + `>`)
+
+	var bulletLevels, decimalLevels strings.Builder
+	for i, char := range []string{"•", "◦", "▪"} {
+		indent := 720 * (i + 1)
+		bulletLevels.WriteString(fmt.Sprintf(`<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/>`+
+			`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, i, char, indent))
+		decimalLevels.WriteString(fmt.Sprintf(`<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%%%d."/><w:lvlJc w:val="left"/>`+
+			`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, i, []string{"decimal", "lowerLetter", "lowerRoman"}[i], i+1, indent))
+	}
+	sb.WriteString(fmt.Sprintf(docxAbstractNum, 0, bulletLevels.String()))
+	sb.WriteString(fmt.Sprintf(docxAbstractNum, 1, decimalLevels.String()))
+
+	sb.WriteString(fmt.Sprintf(`<w:num w:numId="%d"><w:abstractNumId w:val="0"/></w:num>`, docxBulletNumID))
+	for i := range d.lists {
+		sb.WriteString(fmt.Sprintf(`<w:num w:numId="%d"><w:abstractNumId w:val="1"/>`+
+			`<w:lvlOverride w:ilvl="0"><w:startOverride w:val="1"/></w:lvlOverride></w:num>`, docxBulletNumID+1+i))
+	}
+	sb.WriteString(`</w:numbering>`)
+	return sb.String()
+}
+
+// docxRun renders a run of text, the line breaks of a code block included.
+func docxRun(r reportRun) string {
+	var props strings.Builder
+	if r.Link != "" && !strings.HasPrefix(r.Link, "#") {
+		props.WriteString(`<w:rStyle w:val="Hyperlink"/>`)
+	}
+	if r.Code {
+		props.WriteString(`<w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/>`)
+	}
+	if r.Bold {
+		props.WriteString(`<w:b/>`)
+	}
+	if r.Italic {
+		props.WriteString(`<w:i/>`)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<w:r>`)
+	if props.Len() > 0 {
+		sb.WriteString(`<w:rPr>` + props.String() + `</w:rPr>`)
+	}
+	for i, line := range strings.Split(r.Text, "\n") {
+		if i > 0 {
+			sb.WriteString(`<w:br/>`)
+		}
+		sb.WriteString(`<w:t xml:space="preserve">` + xmlText(line) + `</w:t>`)
+	}
+	sb.WriteString(`</w:r>`)
+	return sb.String()
+}
+
+const docxContentTypes = xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+	`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+	`<Override PartName="/word/settings.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.settings+xml"/>` +
+	`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>` +
+	`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+	`</Types>`
+
+// the fields, i.e. the table of contents, are updated when the document is
+// opened
+const docxSettings = xmlHeader + `<w:settings ` + docxMainNS + `><w:updateFields w:val="true"/>` +
+	`<w:defaultTabStop w:val="720"/><w:compat><w:compatSetting w:name="compatibilityMode" ` +
+	`w:uri="http://schemas.microsoft.com/office/word" w:val="15"/></w:compat></w:settings>`
+
+var docxStyles = xmlHeader + `<w:styles ` + docxMainNS + `>` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:eastAsia="Microsoft YaHei" w:cs="Calibri"/>` +
+	`<w:sz w:val="22"/><w:szCs w:val="22"/><w:lang w:val="en-US" w:eastAsia="zh-CN"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="160" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:spacing w:before="2400" w:after="240"/><w:jc w:val="center"/></w:pPr><w:rPr><w:b/><w:color w:val="1F3864"/><w:sz w:val="52"/><w:szCs w:val="52"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Subtitle"><w:name w:val="Subtitle"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:jc w:val="center"/></w:pPr><w:rPr><w:color w:val="595959"/><w:sz w:val="26"/><w:szCs w:val="26"/></w:rPr></w:style>` +
+	docxHeadingStyle(1, 32, "2F5496") + docxHeadingStyle(2, 26, "2F5496") + docxHeadingStyle(3, 24, "1F3763") +
+	`<w:style w:type="paragraph" w:styleId="TOCHeading"><w:name w:val="TOC Heading"/><w:basedOn w:val="Heading1"/><w:next w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:outlineLvl w:val="9"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOC1"><w:name w:val="toc 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:spacing w:after="100"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOC2"><w:name w:val="toc 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:spacing w:after="100"/><w:ind w:left="220"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:spacing w:after="60"/><w:ind w:left="720"/><w:contextualSpacing/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/>` +
+	`<w:pPr><w:shd w:val="clear" w:color="auto" w:fill="F2F2F2"/><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr>` +
+	`<w:rPr><w:rFonts w:ascii="Consolas" w:hAnsi="Consolas" w:cs="Consolas"/><w:sz w:val="18"/><w:szCs w:val="18"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:qFormat/>` +
+	`<w:pPr><w:ind w:left="720" w:right="720"/></w:pPr><w:rPr><w:i/><w:color w:val="404040"/></w:rPr></w:style>` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>` +
+	`<w:style w:type="table" w:default="1" w:styleId="TableNormal"><w:name w:val="Normal Table"/><w:tblPr><w:tblInd w:w="0" w:type="dxa"/>` +
+	`<w:tblCellMar><w:top w:w="0" w:type="dxa"/><w:left w:w="108" w:type="dxa"/><w:bottom w:w="0" w:type="dxa"/><w:right w:w="108" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>` +
+	`<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:basedOn w:val="TableNormal"/><w:tblPr><w:tblBorders>` +
+	`<w:top w:val="single" w:sz="4" w:space="0" w:color="A6A6A6"/><w:left w:val="single" w:sz="4" w:space="0" w:color="A6A6A6"/>` +
+	`<w:bottom w:val="single" w:sz="4" w:space="0" w:color="A6A6A6"/><w:right w:val="single" w:sz="4" w:space="0" w:color="A6A6A6"/>` +
+	`<w:insideH w:val="single" w:sz="4" w:space="0" w:color="A6A6A6"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="A6A6A6"/>` +
+	`</w:tblBorders></w:tblPr></w:style>` +
+	`</w:styles>`
+
+func docxHeadingStyle(level, size int, color string) string {
+	n := strconv.Itoa(level)
+	return `<w:style w:type="paragraph" w:styleId="Heading` + n + `"><w:name w:val="heading ` + n + `"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>` +
+		`<w:pPr><w:keepNext/><w:spacing w:before="` + strconv.Itoa(480/level) + `" w:after="120"/><w:outlineLvl w:val="` + strconv.Itoa(level-1) + `"/></w:pPr>` +
+		`<w:rPr><w:b/><w:color w:val="` + color + `"/><w:sz w:val="` + strconv.Itoa(size) + `"/><w:szCs w:val="` + strconv.Itoa(size) + `"/></w:rPr></w:style>`
+}
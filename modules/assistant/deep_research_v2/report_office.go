@@ -0,0 +1,267 @@
+package deep_research
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// Kinds of the blocks of a report rendered to an Office document.
+const (
+	blockHeading   = "heading"
+	blockParagraph = "paragraph"
+	blockBullet    = "bullet"
+	blockNumbered  = "numbered"
+	blockTable     = "table"
+	blockCode      = "code"
+	blockQuote     = "quote"
+)
+
+// reportRun is a piece of text of a block with a single style.
+type reportRun struct {
+	Text   string
+	Bold   bool
+	Italic bool
+	Code   bool
+	Link   string
+}
+
+// reportBlock is a block of a markdown report, as rendered to an Office
+// document.
+type reportBlock struct {
+	Kind  string
+	Level int // of a heading, or the depth of a list item from 0
+	List  int // numbered list of an item, from 1
+	Runs  []reportRun
+	Rows  [][][]reportRun // of a table, the header first
+}
+
+func (b reportBlock) text() string {
+	return runsText(b.Runs)
+}
+
+func runsText(runs []reportRun) string {
+	var sb strings.Builder
+	for _, r := range runs {
+		sb.WriteString(r.Text)
+	}
+	return sb.String()
+}
+
+// markdownToBlocks parses a markdown report into the blocks rendered to an
+// Office document.
+func markdownToBlocks(markdown string) []reportBlock {
+	doc := parser.NewWithExtensions(parser.CommonExtensions).Parse([]byte(markdown))
+	c := &blockCollector{}
+	for _, child := range doc.GetChildren() {
+		c.collect(child, 0, 0)
+	}
+	return c.blocks
+}
+
+type blockCollector struct {
+	blocks []reportBlock
+	lists  int
+}
+
+func (c *blockCollector) collect(node ast.Node, depth int, list int) {
+	switch n := node.(type) {
+	case *ast.Heading:
+		c.blocks = append(c.blocks, reportBlock{Kind: blockHeading, Level: n.Level, Runs: inlineRuns(n)})
+	case *ast.Paragraph:
+		c.blocks = append(c.blocks, reportBlock{Kind: blockParagraph, Runs: inlineRuns(n)})
+	case *ast.List:
+		kind := blockBullet
+		if n.ListFlags&ast.ListTypeOrdered != 0 {
+			kind = blockNumbered
+			c.lists++
+			list = c.lists
+		}
+		for _, item := range n.GetChildren() {
+			for _, child := range item.GetChildren() {
+				switch child.(type) {
+				case *ast.Paragraph:
+					c.blocks = append(c.blocks, reportBlock{Kind: kind, Level: depth, List: list, Runs: inlineRuns(child)})
+				case *ast.List:
+					c.collect(child, depth+1, list)
+				default:
+					c.collect(child, depth, list)
+				}
+			}
+		}
+	case *ast.Table:
+		block := reportBlock{Kind: blockTable}
+		ast.WalkFunc(n, func(node ast.Node, entering bool) ast.WalkStatus {
+			row, ok := node.(*ast.TableRow)
+			if !ok || !entering {
+				return ast.GoToNext
+			}
+			var cells [][]reportRun
+			for _, cell := range row.GetChildren() {
+				cells = append(cells, inlineRuns(cell))
+			}
+			block.Rows = append(block.Rows, cells)
+			return ast.SkipChildren
+		})
+		if len(block.Rows) > 0 {
+			c.blocks = append(c.blocks, block)
+		}
+	case *ast.CodeBlock:
+		c.blocks = append(c.blocks, reportBlock{Kind: blockCode, Runs: []reportRun{{Text: strings.TrimRight(string(n.Literal), "\n"), Code: true}}})
+	case *ast.MathBlock:
+		c.blocks = append(c.blocks, reportBlock{Kind: blockCode, Runs: []reportRun{{Text: strings.TrimSpace(string(n.Literal)), Code: true}}})
+	case *ast.BlockQuote:
+		for _, child := range n.GetChildren() {
+			if _, ok := child.(*ast.Paragraph); ok {
+				c.blocks = append(c.blocks, reportBlock{Kind: blockQuote, Runs: inlineRuns(child)})
+			} else {
+				c.collect(child, depth, list)
+			}
+		}
+	case *ast.HorizontalRule, *ast.HTMLBlock:
+	default:
+		for _, child := range node.GetChildren() {
+			c.collect(child, depth, list)
+		}
+	}
+}
+
+// inlineRuns returns the styled text of the inline children of a node.
+func inlineRuns(node ast.Node) []reportRun {
+	var runs []reportRun
+	collectRuns(node, reportRun{}, &runs)
+	return runs
+}
+
+func collectRuns(node ast.Node, style reportRun, runs *[]reportRun) {
+	appendRun := func(text string, style reportRun) {
+		if text == "" {
+			return
+		}
+		// merge with the previous run of the same style
+		if last := len(*runs) - 1; last >= 0 {
+			prev := (*runs)[last]
+			prev.Text = ""
+			current := style
+			current.Text = ""
+			if prev == current {
+				(*runs)[last].Text += text
+				return
+			}
+		}
+		style.Text = text
+		*runs = append(*runs, style)
+	}
+
+	for _, child := range node.GetChildren() {
+		switch n := child.(type) {
+		case *ast.Text:
+			appendRun(string(n.Literal), style)
+		case *ast.Code:
+			s := style
+			s.Code = true
+			appendRun(string(n.Literal), s)
+		case *ast.Math:
+			appendRun(string(n.Literal), style)
+		case *ast.Softbreak:
+			appendRun(" ", style)
+		case *ast.Hardbreak:
+			appendRun("\n", style)
+		case *ast.Strong:
+			s := style
+			s.Bold = true
+			collectRuns(n, s, runs)
+		case *ast.Emph:
+			s := style
+			s.Italic = true
+			collectRuns(n, s, runs)
+		case *ast.Link:
+			s := style
+			s.Link = string(n.Destination)
+			collectRuns(n, s, runs)
+		case *ast.Image:
+			// the alt text
+			collectRuns(n, style, runs)
+		case *ast.HTMLSpan:
+		default:
+			collectRuns(child, style, runs)
+		}
+	}
+}
+
+// officeFile is a part of an Office Open XML package.
+type officeFile struct {
+	Name    string
+	Content string
+}
+
+func writeOfficePackage(files []officeFile) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(f.Content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xmlText escapes text for XML, the characters not allowed in XML are
+// replaced.
+func xmlText(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+// officeRels is the relationships part of a package part.
+type officeRels struct {
+	rels []string
+}
+
+// add adds a relationship and returns its ID.
+func (r *officeRels) add(relType, target string, external bool) string {
+	id := "rId" + strconv.Itoa(len(r.rels)+1)
+	mode := ""
+	if external {
+		mode = ` TargetMode="External"`
+	}
+	r.rels = append(r.rels, `<Relationship Id="`+id+`" Type="`+relType+`" Target="`+xmlText(target)+`"`+mode+`/>`)
+	return id
+}
+
+func (r *officeRels) xml() string {
+	return xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		strings.Join(r.rels, "") + `</Relationships>`
+}
+
+const (
+	relOfficeDocument = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument"
+	relCoreProperties = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties"
+	relHyperlink      = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink"
+	relTheme          = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/theme"
+)
+
+// officeCoreProperties is the docProps/core.xml part of a document.
+func officeCoreProperties(title, created string) string {
+	return xmlHeader + `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" ` +
+		`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" ` +
+		`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<dc:title>` + xmlText(title) + `</dc:title><dc:creator>Coco AI</dc:creator>` +
+		`<dcterms:created xsi:type="dcterms:W3CDTF">` + created + `</dcterms:created>` +
+		`</cp:coreProperties>`
+}
@@ -0,0 +1,209 @@
+package deep_research
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"infini.sh/coco/core"
+)
+
+const officeTestReport = `## Summary
+
+- Revenue grew in **every** region
+- Costs of R&D > 5% were *flat*
+
+## Market
+
+The [source](https://example.com/report?a=1&b=2) has the figures, with ` + "`code`" + `.
+
+1. First step
+2. Second step
+
+| Region | Growth |
+|--------|--------|
+| EMEA   | 12%    |
+| APAC   | 9%     |
+
+> A quote of the report.
+`
+
+var relTargetRegex = regexp.MustCompile(`Target="([^"]+)"( TargetMode="External")?`)
+
+// readOfficePackage unzips an Office document, checking every XML part is
+// well formed and every internal relationship points to a part.
+func readOfficePackage(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %v: %v", f.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read %v: %v", f.Name, err)
+		}
+		parts[f.Name] = string(content)
+
+		dec := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := dec.Token(); err != nil {
+				if err != io.EOF {
+					t.Fatalf("malformed XML in %v: %v", f.Name, err)
+				}
+				break
+			}
+		}
+	}
+
+	for name, content := range parts {
+		if !strings.HasSuffix(name, ".rels") {
+			continue
+		}
+		// the targets are relative to the folder of the source part
+		base := path.Dir(path.Dir(name))
+		for _, m := range relTargetRegex.FindAllStringSubmatch(content, -1) {
+			if m[2] != "" {
+				continue
+			}
+			target := path.Clean(path.Join(base, m[1]))
+			if _, ok := parts[target]; !ok {
+				t.Fatalf("relationship of %v points to the missing part %v", name, target)
+			}
+		}
+	}
+
+	types := parts["[Content_Types].xml"]
+	for name := range parts {
+		if strings.HasSuffix(name, ".xml") && name != "[Content_Types].xml" && !strings.Contains(types, `"/`+name+`"`) {
+			t.Fatalf("no content type for %v", name)
+		}
+	}
+	return parts
+}
+
+func TestMarkdownToBlocks(t *testing.T) {
+	blocks := markdownToBlocks(officeTestReport)
+	kinds := []string{}
+	for _, b := range blocks {
+		kinds = append(kinds, b.Kind)
+	}
+	want := []string{blockHeading, blockBullet, blockBullet, blockHeading, blockParagraph, blockNumbered, blockNumbered, blockTable, blockQuote}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected blocks %v, got %v", want, kinds)
+	}
+	if blocks[0].Level != 2 || blocks[0].text() != "Summary" {
+		t.Fatalf("unexpected heading: %+v", blocks[0])
+	}
+	if blocks[2].text() != "Costs of R&D > 5% were flat" {
+		t.Fatalf("unexpected item text: %q", blocks[2].text())
+	}
+	if blocks[5].List != blocks[6].List || blocks[5].List == 0 {
+		t.Fatalf("expected the numbered items in one list: %+v %+v", blocks[5], blocks[6])
+	}
+	if rows := blocks[7].Rows; len(rows) != 3 || runsText(rows[2][0]) != "APAC" {
+		t.Fatalf("unexpected table: %+v", rows)
+	}
+	links := 0
+	for _, r := range blocks[4].Runs {
+		if r.Link == "https://example.com/report?a=1&b=2" {
+			links++
+		}
+	}
+	if links != 1 {
+		t.Fatalf("expected the link to be kept: %+v", blocks[4].Runs)
+	}
+}
+
+func TestBuildDOCX(t *testing.T) {
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	data, err := buildDOCX(markdownToBlocks(officeTestReport), "Growth & Costs", "Contents", created)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parts := readOfficePackage(t, data)
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "docProps/core.xml", "word/document.xml", "word/styles.xml", "word/numbering.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("missing part %v", name)
+		}
+	}
+	document := parts["word/document.xml"]
+	for _, want := range []string{"Growth &amp; Costs", "2025-03-01", "Contents", "R&amp;D &gt; 5%", `w:val="Heading`, "APAC"} {
+		if !strings.Contains(document, want) {
+			t.Fatalf("expected %q in the document", want)
+		}
+	}
+	if !strings.Contains(parts["word/_rels/document.xml.rels"], `Target="https://example.com/report?a=1&amp;b=2" TargetMode="External"`) {
+		t.Fatalf("expected the hyperlink relationship:\n%v", parts["word/_rels/document.xml.rels"])
+	}
+	if !strings.Contains(parts["docProps/core.xml"], "Growth &amp; Costs") {
+		t.Fatalf("expected the title in the core properties")
+	}
+}
+
+func TestBuildPPTX(t *testing.T) {
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	blocks := markdownToBlocks(officeTestReport + "\n## Long\n\n" + strings.Repeat("- A long item of the chapter, repeated to overflow the slide\n", 20))
+	data, err := buildPPTX(blocks, "Growth & Costs", "Contents", created)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parts := readOfficePackage(t, data)
+
+	slides := 0
+	for name := range parts {
+		if strings.HasPrefix(name, "ppt/slides/slide") && strings.HasSuffix(name, ".xml") {
+			slides++
+		}
+	}
+	presentation := parts["ppt/presentation.xml"]
+	if got := strings.Count(presentation, "<p:sldId "); got != slides {
+		t.Fatalf("expected %v slides listed, got %v", slides, got)
+	}
+	// cover, contents, the 3 chapters, the long one split
+	if slides < 6 {
+		t.Fatalf("expected the long chapter to be split, got %v slides", slides)
+	}
+	if !strings.Contains(parts["ppt/slides/slide1.xml"], "Growth &amp; Costs") {
+		t.Fatalf("expected the title on the cover")
+	}
+	if !strings.Contains(parts["ppt/slides/slide2.xml"], "Contents") {
+		t.Fatalf("expected the table of contents on the second slide")
+	}
+}
+
+func TestCheckRenderedReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		report []byte
+		err    error
+		want   string
+	}{
+		{name: "rendered", report: []byte("PK"), want: "docx"},
+		{name: "error", report: []byte("PK"), err: errors.New("broken"), want: "markdown"},
+		{name: "empty", want: "markdown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &State{Config: &core.DeepResearchConfig{ReportFormat: "docx"}, ReportFormat: "docx"}
+			s.checkRenderedReport(tt.report, tt.err)
+			if s.ReportFormat != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, s.ReportFormat)
+			}
+		})
+	}
+}
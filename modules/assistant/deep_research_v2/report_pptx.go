@@ -0,0 +1,410 @@
+package deep_research
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pptxNS = `xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" ` +
+		`xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main"`
+	relSlide       = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/slide"
+	relSlideMaster = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/slideMaster"
+	relSlideLayout = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/slideLayout"
+
+	// 16:9 slides, in EMU
+	pptxSlideWidth  = 12192000
+	pptxSlideHeight = 6858000
+	pptxMargin      = 609600
+
+	// the content of a slide is split over several slides beyond these
+	pptxMaxSlideChars  = 900
+	pptxMaxSlideItems  = 9
+	pptxMaxTableRows   = 8
+	pptxMaxItemChars   = 400
+	pptxFirstSlideID   = 256
+	pptxMasterID       = 2147483648
+	pptxLayoutID       = 2147483649
+	pptxBodyFontSize   = 1800
+	pptxTableFontSize  = 1200
+	pptxTitleFontSize  = 3000
+	pptxNumberedFormat = "arabicPeriod"
+)
+
+// pptxSlide is the content of a slide, a title and either items or a table.
+type pptxSlide struct {
+	Title    string
+	Subtitle string // of the cover
+	Items    []reportBlock
+	Table    [][][]reportRun
+}
+
+// renderPPTX renders a markdown report to a slide deck: a cover, the table of
+// contents, then the slides of each chapter of the report.
+func renderPPTX(body, title, tocTitle string) ([]byte, error) {
+	return buildPPTX(markdownToBlocks(body), title, tocTitle, time.Now())
+}
+
+func buildPPTX(blocks []reportBlock, title, tocTitle string, created time.Time) ([]byte, error) {
+	slides := pptxSlides(blocks, title, tocTitle, created)
+
+	files := []officeFile{
+		{Name: "_rels/.rels", Content: pptxPackageRels()},
+		{Name: "docProps/core.xml", Content: officeCoreProperties(title, created.UTC().Format(time.RFC3339))},
+		{Name: "ppt/theme/theme1.xml", Content: pptxTheme},
+		{Name: "ppt/slideMasters/slideMaster1.xml", Content: pptxSlideMaster},
+		{Name: "ppt/slideMasters/_rels/slideMaster1.xml.rels", Content: pptxMasterRels()},
+		{Name: "ppt/slideLayouts/slideLayout1.xml", Content: pptxSlideLayout},
+		{Name: "ppt/slideLayouts/_rels/slideLayout1.xml.rels", Content: pptxLayoutRels()},
+	}
+
+	presRels := &officeRels{}
+	presRels.add(relSlideMaster, "slideMasters/slideMaster1.xml", false)
+	presRels.add(relTheme, "theme/theme1.xml", false)
+	var slideIDs strings.Builder
+	var overrides strings.Builder
+	for i, slide := range slides {
+		n := strconv.Itoa(i + 1)
+		rels := &officeRels{}
+		rels.add(relSlideLayout, "../slideLayouts/slideLayout1.xml", false)
+		files = append(files,
+			officeFile{Name: "ppt/slides/slide" + n + ".xml", Content: pptxSlideXML(slide, i == 0, rels)},
+			officeFile{Name: "ppt/slides/_rels/slide" + n + ".xml.rels", Content: rels.xml()},
+		)
+		id := presRels.add(relSlide, "slides/slide"+n+".xml", false)
+		slideIDs.WriteString(fmt.Sprintf(`<p:sldId id="%d" r:id="%s"/>`, pptxFirstSlideID+i, id))
+		overrides.WriteString(`<Override PartName="/ppt/slides/slide` + n + `.xml" ` +
+			`ContentType="application/vnd.openxmlformats-officedocument.presentationml.slide+xml"/>`)
+	}
+
+	presentation := xmlHeader + `<p:presentation ` + pptxNS + ` saveSubsetFonts="1">` +
+		`<p:sldMasterIdLst><p:sldMasterId id="` + strconv.Itoa(pptxMasterID) + `" r:id="rId1"/></p:sldMasterIdLst>` +
+		`<p:sldIdLst>` + slideIDs.String() + `</p:sldIdLst>` +
+		fmt.Sprintf(`<p:sldSz cx="%d" cy="%d"/><p:notesSz cx="6858000" cy="9144000"/>`, pptxSlideWidth, pptxSlideHeight) +
+		`</p:presentation>`
+
+	files = append(files,
+		officeFile{Name: "[Content_Types].xml", Content: pptxContentTypes(overrides.String())},
+		officeFile{Name: "ppt/presentation.xml", Content: presentation},
+		officeFile{Name: "ppt/_rels/presentation.xml.rels", Content: presRels.xml()},
+	)
+	return writeOfficePackage(files)
+}
+
+// pptxSlides splits the report into slides, a chapter being a heading of
+// level 2 or less.
+func pptxSlides(blocks []reportBlock, title, tocTitle string, created time.Time) []pptxSlide {
+	slides := []pptxSlide{{Title: title, Subtitle: created.Format("2006-01-02")}}
+
+	toc := pptxSlide{Title: tocTitle}
+	for _, b := range blocks {
+		if b.Kind == blockHeading && b.Level <= 2 {
+			toc.Items = append(toc.Items, reportBlock{Kind: blockNumbered, Runs: []reportRun{{Text: b.text()}}})
+		}
+	}
+	if len(toc.Items) > 0 {
+		slides = append(slides, splitSlideItems(toc)...)
+	}
+
+	current := pptxSlide{Title: title}
+	flush := func() {
+		if len(current.Items) > 0 {
+			slides = append(slides, splitSlideItems(current)...)
+		}
+		current.Items = nil
+	}
+	for _, b := range blocks {
+		switch {
+		case b.Kind == blockHeading && b.Level <= 2:
+			flush()
+			current.Title = b.text()
+		case b.Kind == blockHeading:
+			// a section of a chapter is a bold item
+			runs := make([]reportRun, len(b.Runs))
+			for i, r := range b.Runs {
+				r.Bold = true
+				runs[i] = r
+			}
+			current.Items = append(current.Items, reportBlock{Kind: blockParagraph, Runs: runs})
+		case b.Kind == blockTable:
+			flush()
+			// the header is repeated on each slide of a long table
+			header, rows := b.Rows[0], b.Rows[1:]
+			for {
+				n := min(pptxMaxTableRows, len(rows))
+				table := append([][][]reportRun{header}, rows[:n]...)
+				slides = append(slides, pptxSlide{Title: current.Title, Table: table})
+				rows = rows[n:]
+				if len(rows) == 0 {
+					break
+				}
+			}
+		default:
+			current.Items = append(current.Items, b)
+		}
+	}
+	flush()
+	return slides
+}
+
+// splitSlideItems splits the items of a slide over as many slides as needed
+// to fit in a slide each, the long items being shortened.
+func splitSlideItems(slide pptxSlide) []pptxSlide {
+	var slides []pptxSlide
+	current := pptxSlide{Title: slide.Title}
+	chars := 0
+	for _, item := range slide.Items {
+		item.Runs = shortenRuns(item.Runs, pptxMaxItemChars)
+		n := len([]rune(item.text()))
+		if len(current.Items) > 0 && (len(current.Items) >= pptxMaxSlideItems || chars+n > pptxMaxSlideChars) {
+			slides = append(slides, current)
+			current = pptxSlide{Title: slide.Title}
+			chars = 0
+		}
+		current.Items = append(current.Items, item)
+		chars += n
+	}
+	if len(current.Items) > 0 || len(slides) == 0 {
+		slides = append(slides, current)
+	}
+	return slides
+}
+
+// shortenRuns cuts the text of runs beyond max characters.
+func shortenRuns(runs []reportRun, max int) []reportRun {
+	out := make([]reportRun, 0, len(runs))
+	left := max
+	for _, r := range runs {
+		text := []rune(r.Text)
+		if len(text) > left {
+			r.Text = string(text[:left]) + "..."
+			return append(out, r)
+		}
+		left -= len(text)
+		out = append(out, r)
+	}
+	return out
+}
+
+func pptxSlideXML(slide pptxSlide, cover bool, rels *officeRels) string {
+	var shapes strings.Builder
+	contentWidth := pptxSlideWidth - 2*pptxMargin
+
+	if cover {
+		shapes.WriteString(pptxTextShape(2, "Title", pptxMargin, 2057400, contentWidth, 1371600,
+			`<a:bodyPr anchor="b"><a:normAutofit/></a:bodyPr>`,
+			pptxParagraph(`<a:pPr algn="ctr"/>`, []reportRun{{Text: slide.Title, Bold: true}}, 4000, rels)))
+		shapes.WriteString(pptxTextShape(3, "Subtitle", pptxMargin, 3581400, contentWidth, 609600,
+			`<a:bodyPr anchor="t"/>`,
+			pptxParagraph(`<a:pPr algn="ctr"/>`, []reportRun{{Text: slide.Subtitle}}, 2000, rels)))
+	} else {
+		shapes.WriteString(pptxTextShape(2, "Title", pptxMargin, 304800, contentWidth, 914400,
+			`<a:bodyPr anchor="b"><a:normAutofit/></a:bodyPr>`,
+			pptxParagraph("", []reportRun{{Text: slide.Title, Bold: true}}, pptxTitleFontSize, rels)))
+		top := 1371600
+		height := pptxSlideHeight - top - pptxMargin/2
+		if slide.Table != nil {
+			shapes.WriteString(pptxTable(3, pptxMargin, top, contentWidth, slide.Table, rels))
+		} else {
+			var paragraphs strings.Builder
+			for _, item := range slide.Items {
+				paragraphs.WriteString(pptxItem(item, rels))
+			}
+			shapes.WriteString(pptxTextShape(3, "Content", pptxMargin, top, contentWidth, height,
+				`<a:bodyPr anchor="t"><a:normAutofit/></a:bodyPr>`, paragraphs.String()))
+		}
+	}
+
+	return xmlHeader + `<p:sld ` + pptxNS + `><p:cSld><p:spTree>` +
+		`<p:nvGrpSpPr><p:cNvPr id="1" name=""/><p:cNvGrpSpPr/><p:nvPr/></p:nvGrpSpPr>` +
+		`<p:grpSpPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="0" cy="0"/><a:chOff x="0" y="0"/><a:chExt cx="0" cy="0"/></a:xfrm></p:grpSpPr>` +
+		shapes.String() +
+		`</p:spTree></p:cSld><p:clrMapOvr><a:masterClrMapping/></p:clrMapOvr></p:sld>`
+}
+
+func pptxTextShape(id int, name string, x, y, cx, cy int, bodyPr, paragraphs string) string {
+	return fmt.Sprintf(`<p:sp><p:nvSpPr><p:cNvPr id="%d" name="%s"/><p:cNvSpPr txBox="1"/><p:nvPr/></p:nvSpPr>`+
+		`<p:spPr><a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom><a:noFill/></p:spPr>`+
+		`<p:txBody>%s<a:lstStyle/>%s</p:txBody></p:sp>`, id, name, x, y, cx, cy, bodyPr, paragraphs)
+}
+
+// pptxItem renders an item of a slide: a bullet, a numbered item, or a
+// paragraph without a bullet.
+func pptxItem(item reportBlock, rels *officeRels) string {
+	level := min(item.Level, 4)
+	indent := 342900
+	marL := indent * (level + 1)
+	var props string
+	switch item.Kind {
+	case blockBullet:
+		props = fmt.Sprintf(`<a:pPr marL="%d" lvl="%d" indent="-%d"><a:spcBef><a:spcPts val="600"/></a:spcBef><a:buFont typeface="Arial"/><a:buChar char="•"/></a:pPr>`,
+			marL, level, indent)
+	case blockNumbered:
+		props = fmt.Sprintf(`<a:pPr marL="%d" lvl="%d" indent="-%d"><a:spcBef><a:spcPts val="600"/></a:spcBef><a:buFont typeface="+mj-lt"/><a:buAutoNum type="%s"/></a:pPr>`,
+			marL, level, indent, pptxNumberedFormat)
+	default:
+		props = `<a:pPr marL="0" indent="0"><a:spcBef><a:spcPts val="600"/></a:spcBef><a:buNone/></a:pPr>`
+	}
+	return pptxParagraph(props, item.Runs, pptxBodyFontSize, rels)
+}
+
+func pptxParagraph(props string, runs []reportRun, size int, rels *officeRels) string {
+	var sb strings.Builder
+	sb.WriteString(`<a:p>` + props)
+	for _, r := range runs {
+		for i, line := range strings.Split(r.Text, "\n") {
+			if i > 0 {
+				sb.WriteString(`<a:br><a:rPr lang="en-US" sz="` + strconv.Itoa(size) + `"/></a:br>`)
+			}
+			if line == "" {
+				continue
+			}
+			sb.WriteString(`<a:r>` + pptxRunProps(r, size, rels) + `<a:t>` + xmlText(line) + `</a:t></a:r>`)
+		}
+	}
+	sb.WriteString(`<a:endParaRPr lang="en-US" sz="` + strconv.Itoa(size) + `"/></a:p>`)
+	return sb.String()
+}
+
+func pptxRunProps(r reportRun, size int, rels *officeRels) string {
+	attrs := ` lang="en-US" sz="` + strconv.Itoa(size) + `"`
+	if r.Bold {
+		attrs += ` b="1"`
+	}
+	if r.Italic {
+		attrs += ` i="1"`
+	}
+	var children string
+	if r.Code {
+		children += `<a:latin typeface="Consolas"/><a:cs typeface="Consolas"/>`
+	}
+	if r.Link != "" && !strings.HasPrefix(r.Link, "#") {
+		children += `<a:hlinkClick r:id="` + rels.add(relHyperlink, r.Link, true) + `"/>`
+	}
+	if children == "" {
+		return `<a:rPr` + attrs + `/>`
+	}
+	return `<a:rPr` + attrs + `>` + children + `</a:rPr>`
+}
+
+func pptxTable(id, x, y, cx int, rows [][][]reportRun, rels *officeRels) string {
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	colWidth := cx / max(cols, 1)
+	rowHeight := 370840
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(`<p:graphicFrame><p:nvGraphicFramePr><p:cNvPr id="%d" name="Table"/><p:cNvGraphicFramePr><a:graphicFrameLocks noGrp="1"/></p:cNvGraphicFramePr><p:nvPr/></p:nvGraphicFramePr>`+
+		`<p:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></p:xfrm>`+
+		`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/table"><a:tbl><a:tblPr firstRow="1" bandRow="1"/><a:tblGrid>`,
+		id, x, y, colWidth*cols, rowHeight*len(rows)))
+	for i := 0; i < cols; i++ {
+		sb.WriteString(`<a:gridCol w="` + strconv.Itoa(colWidth) + `"/>`)
+	}
+	sb.WriteString(`</a:tblGrid>`)
+	for i, row := range rows {
+		sb.WriteString(`<a:tr h="` + strconv.Itoa(rowHeight) + `">`)
+		for c := 0; c < cols; c++ {
+			var cell []reportRun
+			if c < len(row) {
+				cell = shortenRuns(row[c], pptxMaxItemChars/2)
+			}
+			fill := "F2F2F2"
+			if i == 0 {
+				fill = "D9E2F3"
+				bold := make([]reportRun, len(cell))
+				for j, r := range cell {
+					r.Bold = true
+					bold[j] = r
+				}
+				cell = bold
+			}
+			sb.WriteString(`<a:tc><a:txBody><a:bodyPr/><a:lstStyle/>` + pptxParagraph("", cell, pptxTableFontSize, rels) + `</a:txBody>` +
+				`<a:tcPr>` + pptxCellBorders + `<a:solidFill><a:srgbClr val="` + fill + `"/></a:solidFill></a:tcPr></a:tc>`)
+		}
+		sb.WriteString(`</a:tr>`)
+	}
+	sb.WriteString(`</a:tbl></a:graphicData></a:graphic></p:graphicFrame>`)
+	return sb.String()
+}
+
+var pptxCellBorders = func() string {
+	var sb strings.Builder
+	for _, side := range []string{"lnL", "lnR", "lnT", "lnB"} {
+		sb.WriteString(`<a:` + side + ` w="6350"><a:solidFill><a:srgbClr val="A6A6A6"/></a:solidFill></a:` + side + `>`)
+	}
+	return sb.String()
+}()
+
+func pptxPackageRels() string {
+	rels := &officeRels{}
+	rels.add(relOfficeDocument, "ppt/presentation.xml", false)
+	rels.add(relCoreProperties, "docProps/core.xml", false)
+	return rels.xml()
+}
+
+func pptxMasterRels() string {
+	rels := &officeRels{}
+	rels.add(relSlideLayout, "../slideLayouts/slideLayout1.xml", false)
+	rels.add(relTheme, "../theme/theme1.xml", false)
+	return rels.xml()
+}
+
+func pptxLayoutRels() string {
+	rels := &officeRels{}
+	rels.add(relSlideMaster, "../slideMasters/slideMaster1.xml", false)
+	return rels.xml()
+}
+
+func pptxContentTypes(slideOverrides string) string {
+	return xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/ppt/presentation.xml" ContentType="application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml"/>` +
+		`<Override PartName="/ppt/slideMasters/slideMaster1.xml" ContentType="application/vnd.openxmlformats-officedocument.presentationml.slideMaster+xml"/>` +
+		`<Override PartName="/ppt/slideLayouts/slideLayout1.xml" ContentType="application/vnd.openxmlformats-officedocument.presentationml.slideLayout+xml"/>` +
+		`<Override PartName="/ppt/theme/theme1.xml" ContentType="application/vnd.openxmlformats-officedocument.theme+xml"/>` +
+		`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+		slideOverrides + `</Types>`
+}
+
+const pptxEmptySpTree = `<p:spTree><p:nvGrpSpPr><p:cNvPr id="1" name=""/><p:cNvGrpSpPr/><p:nvPr/></p:nvGrpSpPr>` +
+	`<p:grpSpPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="0" cy="0"/><a:chOff x="0" y="0"/><a:chExt cx="0" cy="0"/></a:xfrm></p:grpSpPr></p:spTree>`
+
+var pptxSlideMaster = xmlHeader + `<p:sldMaster ` + pptxNS + `><p:cSld><p:bg><p:bgRef idx="1001"><a:schemeClr val="bg1"/></p:bgRef></p:bg>` +
+	pptxEmptySpTree + `</p:cSld>` +
+	`<p:clrMap bg1="lt1" tx1="dk1" bg2="lt2" tx2="dk2" accent1="accent1" accent2="accent2" accent3="accent3" accent4="accent4" ` +
+	`accent5="accent5" accent6="accent6" hlink="hlink" folHlink="folHlink"/>` +
+	`<p:sldLayoutIdLst><p:sldLayoutId id="` + strconv.Itoa(pptxLayoutID) + `" r:id="rId1"/></p:sldLayoutIdLst>` +
+	`<p:txStyles><p:titleStyle><a:lvl1pPr><a:defRPr sz="3000"><a:solidFill><a:schemeClr val="tx2"/></a:solidFill><a:latin typeface="+mj-lt"/><a:ea typeface="+mj-ea"/></a:defRPr></a:lvl1pPr></p:titleStyle>` +
+	`<p:bodyStyle><a:lvl1pPr><a:defRPr sz="1800"><a:solidFill><a:schemeClr val="tx1"/></a:solidFill><a:latin typeface="+mn-lt"/><a:ea typeface="+mn-ea"/></a:defRPr></a:lvl1pPr></p:bodyStyle>` +
+	`<p:otherStyle><a:lvl1pPr><a:defRPr><a:solidFill><a:schemeClr val="tx1"/></a:solidFill><a:latin typeface="+mn-lt"/><a:ea typeface="+mn-ea"/></a:defRPr></a:lvl1pPr></p:otherStyle></p:txStyles>` +
+	`</p:sldMaster>`
+
+var pptxSlideLayout = xmlHeader + `<p:sldLayout ` + pptxNS + ` type="blank" preserve="1"><p:cSld name="Blank">` +
+	pptxEmptySpTree + `</p:cSld><p:clrMapOvr><a:masterClrMapping/></p:clrMapOvr></p:sldLayout>`
+
+const pptxTheme = xmlHeader + `<a:theme xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" name="Coco"><a:themeElements>` +
+	`<a:clrScheme name="Coco"><a:dk1><a:srgbClr val="262626"/></a:dk1><a:lt1><a:srgbClr val="FFFFFF"/></a:lt1>` +
+	`<a:dk2><a:srgbClr val="1F3864"/></a:dk2><a:lt2><a:srgbClr val="E7E6E6"/></a:lt2>` +
+	`<a:accent1><a:srgbClr val="2F5496"/></a:accent1><a:accent2><a:srgbClr val="ED7D31"/></a:accent2>` +
+	`<a:accent3><a:srgbClr val="A5A5A5"/></a:accent3><a:accent4><a:srgbClr val="FFC000"/></a:accent4>` +
+	`<a:accent5><a:srgbClr val="5B9BD5"/></a:accent5><a:accent6><a:srgbClr val="70AD47"/></a:accent6>` +
+	`<a:hlink><a:srgbClr val="0563C1"/></a:hlink><a:folHlink><a:srgbClr val="954F72"/></a:folHlink></a:clrScheme>` +
+	`<a:fontScheme name="Coco"><a:majorFont><a:latin typeface="Calibri Light"/><a:ea typeface=""/><a:cs typeface=""/></a:majorFont>` +
+	`<a:minorFont><a:latin typeface="Calibri"/><a:ea typeface=""/><a:cs typeface=""/></a:minorFont></a:fontScheme>` +
+	`<a:fmtScheme name="Coco"><a:fillStyleLst>` +
+	`<a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill>` +
+	`</a:fillStyleLst><a:lnStyleLst>` +
+	`<a:ln w="6350"><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:ln><a:ln w="12700"><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:ln>` +
+	`<a:ln w="19050"><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:ln>` +
+	`</a:lnStyleLst><a:effectStyleLst>` +
+	`<a:effectStyle><a:effectLst/></a:effectStyle><a:effectStyle><a:effectLst/></a:effectStyle><a:effectStyle><a:effectLst/></a:effectStyle>` +
+	`</a:effectStyleLst><a:bgFillStyleLst>` +
+	`<a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill>` +
+	`</a:bgFillStyleLst></a:fmtScheme></a:themeElements><a:objectDefaults/><a:extraClrSchemeLst/></a:theme>`
@@ -15,11 +15,16 @@ import (
 
 // SearchResult represents a single search result
 type SearchResult struct {
-	Source  string  `json:"source"` // "internal", "external", "duckduckgo", "wikipedia"
+	Source  string  `json:"source"` // "internal", "external", "duckduckgo", "wikipedia", or a web search engine
 	Title   string  `json:"title"`
 	URL     string  `json:"url"`
 	Content string  `json:"content"`
 	Score   float64 `json:"score"`
+	// Relevance is the similarity of the content to the query, with an
+	// embedding model
+	Relevance float64 `json:"relevance,omitempty"`
+	// Fetched is set when Content is the main content of the page, not a snippet
+	Fetched bool `json:"fetched,omitempty"`
 }
 
 // SearchResultCollection manages search results with quality assessment
@@ -67,6 +72,8 @@ func SearchWithConfig(ctx context.Context, query string, cfg *core.DeepResearchC
 		doInternal()
 	}
 
+	// Replace the snippets of the top external results by their pages
+	collection.Results = dedupeResults(fetchPages(ctx, &cfg.PageFetch, collection.Results))
 	collection.evaluateSearchQuality()
 
 	collection.IsSufficient = isContentSufficient(collection, cfg.ResearchDepth)
@@ -111,6 +118,21 @@ func runExternalEngine(ctx context.Context, cfg *core.DeepResearchConfig, query
 		wikiURL := fmt.Sprintf("https://en.wikipedia.org/wiki/%s", strings.ReplaceAll(query, " ", "_"))
 		return []SearchResult{{Source: "wikipedia", Title: query, URL: wikiURL, Content: result, Score: 0.75}}, nil
 
+	case core.WebSearchEngineSearXNG, core.WebSearchEngineBing, core.WebSearchEngineBrave, core.WebSearchEngineGoogle:
+		provider, err := tools.NewWebSearchProvider(&cfg.ExternalSearch.WebSearchConfig)
+		if err != nil {
+			return nil, fmt.Errorf("%s init failed: %w", cfg.ExternalSearch.Engine, err)
+		}
+		found, err := provider.Search(ctx, query, maxResults)
+		if err != nil {
+			return nil, fmt.Errorf("%s search failed: %w", provider.Name(), err)
+		}
+		results := make([]SearchResult, 0, len(found))
+		for _, r := range found {
+			results = append(results, SearchResult{Source: provider.Name(), Title: r.Title, URL: r.URL, Content: r.Content, Score: 0.6})
+		}
+		return results, nil
+
 	default:
 		return nil, fmt.Errorf("unknown external search engine: %q", cfg.ExternalSearch.Engine)
 	}
@@ -102,6 +102,7 @@ func RunDeepSearchTask(ctx context.Context, userID string, params *common2.RAGCo
 			var fetchSize = 50
 			docs, _ := tools.InitialDocumentBriefSearch(ctx, userID, reqMsg, replyMsg, params, 0, fetchSize, sender)
 			params.InputValues["references"] = tools.FormatDocumentForReplyReferences(docs)
+			params.ReferenceDocs = docs
 
 			if len(docs) > 10 {
 				//re-pick top docs
@@ -0,0 +1,197 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package external_workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/tmc/langchaingo/llms"
+	"infini.sh/coco/core"
+	common2 "infini.sh/coco/modules/assistant/common"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/util"
+)
+
+// maxErrorBodySize bounds how much of a failed response is kept for the error message.
+const maxErrorBodySize = 4096
+
+// workflowClient calls the workflow endpoints, which are given by the authors
+// of the assistants, so only public addresses. The timeout is the one of the
+// context of the call.
+var workflowClient = common.NewPublicHTTPClient(0)
+
+// RunExternalWorkflow forwards the conversation to the workflow endpoint configured
+// on the assistant and relays the reply to the sender as response chunks. The
+// complete answer is stored on replyMsg so it is persisted with the session.
+func RunExternalWorkflow(ctx context.Context, userID string, params *common2.RAGContext, reqMsg, replyMsg *core.ChatMessage, sender core.MessageSender) error {
+	cfg := params.AssistantCfg.ExternalWorkflowConfig
+	if cfg == nil {
+		return fmt.Errorf("external workflow is not configured for assistant [%v]", params.AssistantCfg.ID)
+	}
+
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body := buildRequestBody(ctx, cfg, userID, params, reqMsg)
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, cfg.Endpoint, bytes.NewReader(util.MustToJSONBytes(body)))
+	if err != nil {
+		return err
+	}
+	setRequestHeaders(req, cfg)
+
+	resp, err := workflowClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call external workflow: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return fmt.Errorf("external workflow returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(errBody)))
+	}
+
+	var answer strings.Builder
+	seq := 0
+	emit := func(text string) error {
+		if text == "" {
+			return nil
+		}
+		answer.WriteString(text)
+		seq++
+		return sender.SendChunkMessage(core.MessageTypeAssistant, common.Response, text, seq)
+	}
+
+	switch cfg.ResponseMode {
+	case "json":
+		err = readJSONResponse(resp.Body, &cfg.ResponseMapping, emit)
+	case "chunked":
+		err = readChunkedResponse(ctx, resp.Body, &cfg.ResponseMapping, emit)
+	default:
+		err = readSSEResponse(ctx, resp.Body, &cfg.ResponseMapping, emit)
+	}
+
+	replyMsg.Message = answer.String()
+	if err != nil {
+		return err
+	}
+	log.Debugf("external workflow replied %d chunks for session [%v]", seq, params.SessionID)
+	return nil
+}
+
+// buildRequestBody assembles the JSON body sent to the workflow endpoint.
+// ExtraBody is applied first so the mapped conversation fields always win.
+func buildRequestBody(ctx context.Context, cfg *core.ExternalWorkflowConfig, userID string, params *common2.RAGContext, reqMsg *core.ChatMessage) map[string]interface{} {
+	body := map[string]interface{}{}
+	if len(cfg.ExtraBody) > 0 {
+		// deep copy, the config is shared through the assistant cache
+		util.MustFromJSONBytes(util.MustToJSONBytes(cfg.ExtraBody), &body)
+	}
+
+	m := cfg.RequestMapping
+	setByPath(body, m.Query, reqMsg.Message)
+	setByPath(body, m.User, userID)
+	setByPath(body, m.SessionID, params.SessionID)
+	setByPath(body, m.History, formatHistory(ctx, params))
+
+	attachments := []map[string]interface{}{}
+	if v, ok := params.InputValues["attachments"]; ok {
+		if items, ok := v.([]*core.Attachment); ok {
+			attachments = formatAttachments(items)
+		}
+	}
+	setByPath(body, m.Attachments, attachments)
+
+	return body
+}
+
+func setRequestHeaders(req *http.Request, cfg *core.ExternalWorkflowConfig) {
+	req.Header.Set("Content-Type", "application/json")
+	switch cfg.ResponseMode {
+	case "sse":
+		req.Header.Set("Accept", "text/event-stream")
+	case "json":
+		req.Header.Set("Accept", "application/json")
+	}
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	switch cfg.Auth.Type {
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+cfg.Auth.Token)
+	case "basic":
+		req.SetBasicAuth(cfg.Auth.Username, cfg.Auth.Password)
+	case "header":
+		req.Header.Set(cfg.Auth.HeaderName, cfg.Auth.Token)
+	}
+}
+
+// formatHistory converts the loaded chat history into role/content pairs.
+func formatHistory(ctx context.Context, params *common2.RAGContext) []map[string]string {
+	out := []map[string]string{}
+	if params.ChatHistory == nil {
+		return out
+	}
+	messages, err := params.ChatHistory.Messages(ctx)
+	if err != nil {
+		_ = log.Warnf("failed to load chat history for external workflow: %v", err)
+		return out
+	}
+
+	// the last message is the current request, which is sent separately
+	if n := len(messages); n > 0 && messages[n-1].GetType() == llms.ChatMessageTypeHuman {
+		messages = messages[:n-1]
+	}
+
+	for _, msg := range messages {
+		var role string
+		switch msg.GetType() {
+		case llms.ChatMessageTypeHuman:
+			role = core.MessageTypeUser
+		case llms.ChatMessageTypeAI:
+			role = core.MessageTypeAssistant
+		case llms.ChatMessageTypeSystem:
+			role = core.MessageTypeSystem
+		default:
+			continue
+		}
+		out = append(out, map[string]string{"role": role, "content": msg.GetContent()})
+	}
+	return out
+}
+
+func formatAttachments(items []*core.Attachment) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(items))
+	endpoint := strings.TrimRight(common.AppConfig().ServerInfo.Endpoint, "/")
+	for _, a := range items {
+		if a == nil {
+			continue
+		}
+		url := a.URL
+		if strings.HasPrefix(url, "/") && endpoint != "" {
+			url = endpoint + url
+		}
+		out = append(out, map[string]interface{}{
+			"id":        a.ID,
+			"name":      a.Name,
+			"mime_type": a.MimeType,
+			"size":      a.Size,
+			"url":       url,
+			"text":      a.Text,
+		})
+	}
+	return out
+}
@@ -0,0 +1,189 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package external_workflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"infini.sh/coco/core"
+)
+
+// errStreamDone stops reading once a configured done event was received.
+var errStreamDone = errors.New("external workflow stream done")
+
+// maxLineSize bounds a single SSE/chunked line, large enough for events that
+// embed retrieved documents.
+const maxLineSize = 4 * 1024 * 1024
+
+// readSSEResponse consumes a text/event-stream body. Multi-line data fields
+// are joined as per the SSE spec; "[DONE]" terminates the stream.
+func readSSEResponse(ctx context.Context, r io.Reader, mapping *core.ExternalWorkflowResponseMapping, emit func(string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	var data []string
+	flush := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		payload := strings.Join(data, "\n")
+		data = data[:0]
+		if strings.TrimSpace(payload) == "[DONE]" {
+			return errStreamDone
+		}
+		return handleEvent(payload, mapping, emit)
+	}
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := flush(); err != nil {
+				return ignoreDone(err)
+			}
+		case strings.HasPrefix(line, ":"):
+			// comment / keepalive
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return ignoreDone(flush())
+}
+
+// readChunkedResponse consumes a chunked body of newline-delimited JSON
+// events; lines that are not JSON are forwarded as plain text.
+func readChunkedResponse(ctx context.Context, r io.Reader, mapping *core.ExternalWorkflowResponseMapping, emit func(string) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			trimmed := strings.TrimSpace(line)
+			var herr error
+			if json.Valid([]byte(trimmed)) && strings.HasPrefix(trimmed, "{") {
+				herr = handleEvent(trimmed, mapping, emit)
+			} else {
+				herr = emit(line)
+			}
+			if herr != nil {
+				return ignoreDone(herr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readJSONResponse reads a single JSON document and emits its answer at once.
+func readJSONResponse(r io.Reader, mapping *core.ExternalWorkflowResponseMapping, emit func(string) error) error {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return ignoreDone(handleEvent(string(buf), mapping, emit))
+}
+
+// handleEvent extracts the answer text from one event according to mapping.
+func handleEvent(payload string, mapping *core.ExternalWorkflowResponseMapping, emit func(string) error) error {
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		// not a JSON event, treat it as raw text
+		return emit(payload)
+	}
+
+	answer := getStringByPath(event, mapping.Answer)
+	if mapping.Event != "" {
+		eventType := getStringByPath(event, mapping.Event)
+		if slices.Contains(mapping.ErrorEvents, eventType) {
+			if answer == "" {
+				answer = payload
+			}
+			return fmt.Errorf("external workflow reported an error: %s", answer)
+		}
+		if len(mapping.MessageEvents) == 0 || slices.Contains(mapping.MessageEvents, eventType) {
+			if err := emit(answer); err != nil {
+				return err
+			}
+		}
+		if slices.Contains(mapping.DoneEvents, eventType) {
+			return errStreamDone
+		}
+		return nil
+	}
+	return emit(answer)
+}
+
+func ignoreDone(err error) error {
+	if errors.Is(err, errStreamDone) {
+		return nil
+	}
+	return err
+}
+
+// getStringByPath resolves a dot-separated path, non-string leaves are
+// encoded as JSON.
+func getStringByPath(obj map[string]interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	var cur interface{} = obj
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		cur, ok = m[key]
+		if !ok {
+			return ""
+		}
+	}
+	switch v := cur.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		buf, _ := json.Marshal(v)
+		return string(buf)
+	}
+}
+
+// setByPath assigns value at a dot-separated path, creating intermediate
+// objects as needed. An empty path or "-" skips the field.
+func setByPath(obj map[string]interface{}, path string, value interface{}) {
+	if path == "" || path == "-" {
+		return
+	}
+	keys := strings.Split(path, ".")
+	cur := obj
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			cur[key] = next
+		}
+		cur = next
+	}
+	cur[keys[len(keys)-1]] = value
+}
@@ -0,0 +1,104 @@
+package external_workflow
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func collect(t *testing.T, read func(emit func(string) error) error) string {
+	t.Helper()
+	var sb strings.Builder
+	err := read(func(s string) error {
+		sb.WriteString(s)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return sb.String()
+}
+
+func TestReadSSEResponseDifyStyle(t *testing.T) {
+	body := "event: message\n" +
+		"data: {\"event\":\"message\",\"answer\":\"Hello\"}\n\n" +
+		": ping\n\n" +
+		"data: {\"event\":\"workflow_started\",\"answer\":\"ignored\"}\n\n" +
+		"data: {\"event\":\"message\",\"answer\":\", world\"}\n\n" +
+		"data: {\"event\":\"message_end\"}\n\n" +
+		"data: {\"event\":\"message\",\"answer\":\"after end\"}\n\n"
+	mapping := &core.ExternalWorkflowResponseMapping{
+		Answer:        "answer",
+		Event:         "event",
+		MessageEvents: []string{"message"},
+		DoneEvents:    []string{"message_end"},
+	}
+
+	got := collect(t, func(emit func(string) error) error {
+		return readSSEResponse(context.Background(), strings.NewReader(body), mapping, emit)
+	})
+	if got != "Hello, world" {
+		t.Fatalf("expected %q, got %q", "Hello, world", got)
+	}
+}
+
+func TestReadSSEResponseErrorEvent(t *testing.T) {
+	body := "data: {\"event\":\"error\",\"message\":\"quota exceeded\"}\n\n"
+	mapping := &core.ExternalWorkflowResponseMapping{
+		Answer:      "message",
+		Event:       "event",
+		ErrorEvents: []string{"error"},
+	}
+	err := readSSEResponse(context.Background(), strings.NewReader(body), mapping, func(string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+}
+
+func TestReadChunkedResponse(t *testing.T) {
+	body := "{\"type\":\"item\",\"content\":\"foo\"}\n" +
+		"{\"type\":\"item\",\"content\":\"bar\"}\n" +
+		"{\"type\":\"end\"}\n"
+	mapping := &core.ExternalWorkflowResponseMapping{
+		Answer:        "content",
+		Event:         "type",
+		MessageEvents: []string{"item"},
+		DoneEvents:    []string{"end"},
+	}
+	got := collect(t, func(emit func(string) error) error {
+		return readChunkedResponse(context.Background(), strings.NewReader(body), mapping, emit)
+	})
+	if got != "foobar" {
+		t.Fatalf("expected %q, got %q", "foobar", got)
+	}
+}
+
+func TestReadJSONResponseNestedPath(t *testing.T) {
+	mapping := &core.ExternalWorkflowResponseMapping{Answer: "data.output"}
+	got := collect(t, func(emit func(string) error) error {
+		return readJSONResponse(strings.NewReader(`{"data":{"output":"42"}}`), mapping, emit)
+	})
+	if got != "42" {
+		t.Fatalf("expected %q, got %q", "42", got)
+	}
+}
+
+func TestSetByPath(t *testing.T) {
+	body := map[string]interface{}{"inputs": map[string]interface{}{"lang": "en"}}
+	setByPath(body, "inputs.question", "why?")
+	setByPath(body, "user", "u1")
+	setByPath(body, "-", "skipped")
+
+	inputs := body["inputs"].(map[string]interface{})
+	if inputs["question"] != "why?" || inputs["lang"] != "en" {
+		t.Fatalf("unexpected inputs: %v", inputs)
+	}
+	if body["user"] != "u1" {
+		t.Fatalf("unexpected user: %v", body["user"])
+	}
+	if _, ok := body["-"]; ok {
+		t.Fatalf("expected '-' path to be skipped")
+	}
+}
@@ -0,0 +1,63 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package langchain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/util"
+)
+
+// AnswerJudgement is the score, from 0 to 1, given by a judge model to an
+// answer compared to the expected one.
+type AnswerJudgement struct {
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// JudgeAnswer scores how well answer matches the expected answer of question.
+func JudgeAnswer(ctx context.Context, model *core.ModelConfig, question, expected, answer string) (*AnswerJudgement, error) {
+	llm, err := SimplyGetLLM(model.ProviderID, model.Name, model.Keepalive)
+	if err != nil {
+		return nil, err
+	}
+
+	inputValues := map[string]any{
+		"question": util.SubStringWithSuffix(question, 2000, "..."),
+		"expected": util.SubStringWithSuffix(expected, 4000, "..."),
+		"answer":   util.SubStringWithSuffix(answer, 8000, "..."),
+	}
+	prompt, err := GetPromptStringByTemplateArgs(model, common.AnswerJudgePromptTemplate, []string{"question", "expected", "answer"}, inputValues)
+	if err != nil {
+		return nil, err
+	}
+
+	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt,
+		llms.WithTemperature(GetTemperature(model, 0)),
+		llms.WithMaxTokens(GetMaxTokens(model, 512)))
+	if err != nil {
+		return nil, err
+	}
+	return parseAnswerJudgement(completion)
+}
+
+func parseAnswerJudgement(completion string) (*AnswerJudgement, error) {
+	judgement := AnswerJudgement{}
+	if err := util.FromJSONBytes([]byte(extractJSON(completion)), &judgement); err != nil {
+		return nil, fmt.Errorf("invalid answer judgement: %w", err)
+	}
+	if math.IsNaN(judgement.Score) {
+		return nil, fmt.Errorf("invalid answer judgement score")
+	}
+	judgement.Score = math.Min(math.Max(judgement.Score, 0), 1)
+	judgement.Reason = strings.TrimSpace(judgement.Reason)
+	return &judgement, nil
+}
@@ -0,0 +1,22 @@
+package langchain
+
+import "testing"
+
+func TestParseAnswerJudgement(t *testing.T) {
+	judgement, err := parseAnswerJudgement("<JSON>\n{\"score\": 0.5, \"reason\": \" Misses the second step. \"}\n</JSON>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if judgement.Score != 0.5 || judgement.Reason != "Misses the second step." {
+		t.Fatalf("unexpected judgement: %+v", judgement)
+	}
+
+	judgement, err = parseAnswerJudgement("<JSON>{\"score\": 3}</JSON>")
+	if err != nil || judgement.Score != 1 {
+		t.Fatalf("expected the score to be clamped to 1, got %+v, %v", judgement, err)
+	}
+
+	if _, err := parseAnswerJudgement("no judgement"); err == nil {
+		t.Fatalf("expected an error without a JSON result")
+	}
+}
@@ -0,0 +1,148 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package langchain
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"infini.sh/coco/core"
+)
+
+// citationMarkerRegex matches markers like [1] or [12]; grouped markers such
+// as [1, 3] are matched as well.
+var citationMarkerRegex = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// sentenceBoundaries splits the answer into the sentences preceding markers.
+const sentenceBoundaries = ".!?。！？\n"
+
+// ExtractCitations maps the citation markers of answer to docs, numbered from
+// 1 in the order they were given to the model. Markers pointing outside of
+// docs, or to a document not in allowedIDs when it is not empty, are dropped.
+// The result is sorted by number and has one entry per cited document.
+func ExtractCitations(answer string, docs []core.Document, allowedIDs []string) []core.Citation {
+	if len(docs) == 0 || answer == "" {
+		return nil
+	}
+
+	// cited sentences per reference number
+	cited := map[int][]string{}
+	for _, m := range citationMarkerRegex.FindAllStringSubmatchIndex(answer, -1) {
+		sentence := precedingSentence(answer[:m[0]])
+		for _, part := range strings.Split(answer[m[2]:m[3]], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n < 1 || n > len(docs) {
+				continue
+			}
+			if len(allowedIDs) > 0 && !slices.Contains(allowedIDs, docs[n-1].ID) {
+				continue
+			}
+			cited[n] = append(cited[n], sentence)
+		}
+	}
+
+	numbers := make([]int, 0, len(cited))
+	for n := range cited {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	citations := make([]core.Citation, 0, len(numbers))
+	for _, n := range numbers {
+		doc := docs[n-1]
+		citation := core.Citation{
+			Number:     n,
+			DocumentID: doc.ID,
+			Title:      doc.Title,
+			URL:        doc.URL,
+			Source:     doc.Source.Name,
+			PageRange:  matchChunkRange(doc.Chunks, cited[n]),
+		}
+		citations = append(citations, citation)
+	}
+	return citations
+}
+
+// precedingSentence returns the sentence right before a marker, skipping the
+// markers directly in front of it.
+func precedingSentence(text string) string {
+	text = strings.TrimRightFunc(text, unicode.IsSpace)
+	for {
+		loc := citationMarkerRegex.FindAllStringIndex(text, -1)
+		if len(loc) == 0 || loc[len(loc)-1][1] != len(text) {
+			break
+		}
+		text = strings.TrimRightFunc(text[:loc[len(loc)-1][0]], unicode.IsSpace)
+	}
+	text = strings.TrimRight(text, sentenceBoundaries)
+	if i := strings.LastIndexAny(text, sentenceBoundaries); i >= 0 {
+		text = text[i+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// matchChunkRange returns the page range covering the chunks sharing the
+// most words with each cited sentence.
+func matchChunkRange(chunks []core.DocumentChunk, sentences []string) *core.ChunkRange {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	chunkWords := make([]map[string]struct{}, len(chunks))
+	for i, c := range chunks {
+		chunkWords[i] = wordSet(c.Text)
+	}
+
+	var out *core.ChunkRange
+	for _, sentence := range sentences {
+		words := wordSet(sentence)
+		best, bestScore := -1, 0
+		for i := range chunks {
+			score := 0
+			for w := range words {
+				if _, ok := chunkWords[i][w]; ok {
+					score++
+				}
+			}
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		r := chunks[best].Range
+		if out == nil {
+			out = &core.ChunkRange{Start: r.Start, End: r.End}
+			continue
+		}
+		out.Start = min(out.Start, r.Start)
+		out.End = max(out.End, r.End)
+	}
+	return out
+}
+
+func wordSet(text string) map[string]struct{} {
+	words := map[string]struct{}{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		// CJK text has no spaces, fall back to single characters
+		if len([]rune(w)) > 1 && unicode.Is(unicode.Han, []rune(w)[0]) {
+			for _, r := range w {
+				words[string(r)] = struct{}{}
+			}
+			continue
+		}
+		if len(w) > 2 {
+			words[w] = struct{}{}
+		}
+	}
+	return words
+}
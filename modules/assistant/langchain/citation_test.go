@@ -0,0 +1,48 @@
+package langchain
+
+import (
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func citationTestDocs() []core.Document {
+	docs := []core.Document{{Title: "Install guide", URL: "https://example.com/install"}, {Title: "Release notes"}}
+	docs[0].ID = "doc-a"
+	docs[1].ID = "doc-b"
+	docs[0].Chunks = []core.DocumentChunk{
+		{Range: core.ChunkRange{Start: 1, End: 1}, Text: "Download the package from the website."},
+		{Range: core.ChunkRange{Start: 2, End: 3}, Text: "Run the installer and restart the server afterwards."},
+	}
+	return docs
+}
+
+func TestExtractCitations(t *testing.T) {
+	answer := "Run the installer, then restart the server [1]. Version 2 adds SSO [2][7]. See also [1, 2]."
+	citations := ExtractCitations(answer, citationTestDocs(), nil)
+	if len(citations) != 2 {
+		t.Fatalf("expected 2 citations, got %d: %+v", len(citations), citations)
+	}
+	if citations[0].Number != 1 || citations[0].DocumentID != "doc-a" || citations[0].URL != "https://example.com/install" {
+		t.Fatalf("unexpected first citation: %+v", citations[0])
+	}
+	if citations[0].PageRange == nil || citations[0].PageRange.Start != 2 || citations[0].PageRange.End != 3 {
+		t.Fatalf("unexpected page range: %+v", citations[0].PageRange)
+	}
+	if citations[1].Number != 2 || citations[1].DocumentID != "doc-b" || citations[1].PageRange != nil {
+		t.Fatalf("unexpected second citation: %+v", citations[1])
+	}
+}
+
+func TestExtractCitationsRespectsPickedDocs(t *testing.T) {
+	citations := ExtractCitations("Fact one [1]. Fact two [2].", citationTestDocs(), []string{"doc-b"})
+	if len(citations) != 1 || citations[0].DocumentID != "doc-b" {
+		t.Fatalf("expected only doc-b to be cited, got %+v", citations)
+	}
+}
+
+func TestExtractCitationsNoMarkers(t *testing.T) {
+	if citations := ExtractCitations("No sources used.", citationTestDocs(), nil); len(citations) != 0 {
+		t.Fatalf("expected no citations, got %+v", citations)
+	}
+}
@@ -0,0 +1,250 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package langchain
+
+import (
+	"fmt"
+	"strings"
+
+	"infini.sh/coco/core"
+	common2 "infini.sh/coco/modules/assistant/common"
+	"infini.sh/coco/modules/common"
+)
+
+const (
+	// defaultContextLength is used when neither the assistant nor the model
+	// provider tells the context window of the answering model.
+	defaultContextLength = 8192
+	// contextSafetyMargin covers the section headings and the chat template
+	// tokens added by the providers.
+	contextSafetyMargin = 256
+	// minContextBudget keeps some context even if the prompt and the answer
+	// seem to fill the context window already.
+	minContextBudget = 512
+)
+
+// Sections of the answering prompt context.
+const (
+	ContextSectionHistory     = "history"
+	ContextSectionReferences  = "references"
+	ContextSectionAttachments = "attachments"
+	ContextSectionToolsOutput = "tools_output"
+)
+
+// defaultContextShares are the guaranteed shares of the budget, the order is
+// the priority used to hand out the tokens left by smaller sections.
+var defaultContextShares = []struct {
+	name  string
+	share float64
+}{
+	{ContextSectionAttachments, 0.25},
+	{ContextSectionToolsOutput, 0.15},
+	{ContextSectionReferences, 0.4},
+	{ContextSectionHistory, 0.2},
+}
+
+// ContextBudgetReport describes how the context of the answering prompt was
+// fitted into the context window, it is persisted as the payload of the
+// context_budget detail when a section was truncated.
+type ContextBudgetReport struct {
+	ContextLength int                   `json:"context_length"`
+	Budget        int                   `json:"budget"`
+	Sections      []ContextSectionUsage `json:"sections"`
+}
+
+// ContextSectionUsage is the number of tokens of a section before and after
+// fitting it into its budget.
+type ContextSectionUsage struct {
+	Name           string `json:"name"`
+	Tokens         int    `json:"tokens"`
+	OriginalTokens int    `json:"original_tokens"`
+	Truncated      bool   `json:"truncated,omitempty"`
+}
+
+// Truncated reports whether any section was cut.
+func (r *ContextBudgetReport) Truncated() bool {
+	for _, s := range r.Sections {
+		if s.Truncated {
+			return true
+		}
+	}
+	return false
+}
+
+// String lists the truncated sections.
+func (r *ContextBudgetReport) String() string {
+	parts := []string{}
+	for _, s := range r.Sections {
+		if s.Truncated {
+			parts = append(parts, fmt.Sprintf("%s: %d -> %d tokens", s.Name, s.OriginalTokens, s.Tokens))
+		}
+	}
+	return fmt.Sprintf("context truncated to fit %d tokens (%s)", r.Budget, strings.Join(parts, ", "))
+}
+
+// GetContextLength returns the context window of the answering model.
+func GetContextLength(assistant *core.Assistant, model *core.ModelConfig) int {
+	if assistant.ChatSettings.ContextBudget.ContextLength > 0 {
+		return assistant.ChatSettings.ContextBudget.ContextLength
+	}
+	if provider, err := common.GetModelProvider(model.ProviderID); err == nil {
+		if m := provider.GetModel(model.Name); m != nil && m.ContextLength > 0 {
+			return m.ContextLength
+		}
+	}
+	return defaultContextLength
+}
+
+// answeringContextBudget returns the context window of the answering model
+// and the tokens left for the context of the prompt, once the role prompt,
+// the rest of the prompt template and the answer are accounted for.
+func answeringContextBudget(params *common2.RAGContext, model *core.ModelConfig, template string, inputValues map[string]any) (int, int) {
+	contextLength := GetContextLength(params.AssistantCfg, model)
+
+	values := make(map[string]any, len(inputValues)+1)
+	for k, v := range inputValues {
+		values[k] = v
+	}
+	values["context"] = ""
+	basePrompt, _ := GetPromptStringByTemplateArgs(model, template, []string{"query", "context"}, values)
+
+	budget := contextLength - GetMaxTokens(model, 1024) - CountTokens(params.AssistantCfg.RolePrompt) - CountTokens(basePrompt) - contextSafetyMargin
+	if len(params.ReferenceDocs) > 0 {
+		budget -= CountTokens(common.CitationInstruction)
+	}
+	return contextLength, max(budget, minContextBudget)
+}
+
+// FitContextSections cuts sections, keyed by section name, so that they fit
+// into budget tokens together. It returns the fitted sections and the report.
+func FitContextSections(cfg core.ContextBudget, contextLength, budget int, sections map[string]string) (map[string]string, *ContextBudgetReport) {
+	shares := map[string]float64{
+		ContextSectionHistory:     cfg.History,
+		ContextSectionReferences:  cfg.References,
+		ContextSectionAttachments: cfg.Attachments,
+		ContextSectionToolsOutput: cfg.ToolsOutput,
+	}
+
+	names := make([]string, 0, len(defaultContextShares))
+	sizes := make([]int, 0, len(defaultContextShares))
+	ratios := make([]float64, 0, len(defaultContextShares))
+	for _, v := range defaultContextShares {
+		text, ok := sections[v.name]
+		if !ok || text == "" {
+			continue
+		}
+		share := shares[v.name]
+		if share <= 0 {
+			share = v.share
+		}
+		names = append(names, v.name)
+		sizes = append(sizes, CountTokens(text))
+		ratios = append(ratios, share)
+	}
+
+	allocated := allocateContextBudget(budget, sizes, ratios)
+
+	report := &ContextBudgetReport{ContextLength: contextLength, Budget: budget}
+	fitted := make(map[string]string, len(names))
+	for i, name := range names {
+		usage := ContextSectionUsage{Name: name, OriginalTokens: sizes[i], Tokens: sizes[i]}
+		text := sections[name]
+		if sizes[i] > allocated[i] {
+			text = fitContextSection(name, text, allocated[i])
+			usage.Tokens = CountTokens(text)
+			usage.Truncated = true
+		}
+		fitted[name] = text
+		report.Sections = append(report.Sections, usage)
+	}
+	return fitted, report
+}
+
+// allocateContextBudget gives each section its share of budget, capped by its
+// size, then hands out what is left in the order of the sections.
+func allocateContextBudget(budget int, sizes []int, shares []float64) []int {
+	total := 0.0
+	for _, share := range shares {
+		total += share
+	}
+
+	allocated := make([]int, len(sizes))
+	left := budget
+	for i, size := range sizes {
+		allocated[i] = min(size, int(float64(budget)*shares[i]/total))
+		left -= allocated[i]
+	}
+	for i, size := range sizes {
+		if left <= 0 {
+			break
+		}
+		extra := min(size-allocated[i], left)
+		allocated[i] += extra
+		left -= extra
+	}
+	return allocated
+}
+
+// truncatedMark is appended or prepended where a section was cut, its tokens
+// are kept out of the section budget.
+const (
+	truncatedMark       = "\n...(truncated)"
+	truncatedMarkTokens = 8
+)
+
+// fitContextSection cuts text to maxTokens: the oldest messages of the
+// history are dropped, the references are dropped from the least relevant
+// one, the other sections keep their beginning.
+func fitContextSection(name, text string, maxTokens int) string {
+	maxTokens -= truncatedMarkTokens
+	if maxTokens <= 0 {
+		return ""
+	}
+
+	switch name {
+	case ContextSectionHistory:
+		tail := TruncateTokens(text, maxTokens, true)
+		// start from a complete message
+		if i := strings.Index(tail, "\n\n"); i >= 0 && i < len(tail)/2 {
+			tail = tail[i+2:]
+		}
+		return "<conversation>" + truncatedMark + "\n\n" + tail
+	case ContextSectionReferences:
+		return fitReferences(text, maxTokens)
+	default:
+		return TruncateTokens(text, maxTokens, false) + truncatedMark
+	}
+}
+
+// fitReferences keeps the leading documents of the references formatted by
+// tools.FormatDocumentForReplyReferences, so their numbers stay valid for the
+// citations.
+func fitReferences(text string, maxTokens int) string {
+	const docTag = "<Doc>"
+	parts := strings.Split(text, docTag)
+	if len(parts) < 2 {
+		return TruncateTokens(text, maxTokens, false) + truncatedMark
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(parts[0])
+	used := CountTokens(parts[0])
+	for i, doc := range parts[1:] {
+		doc = docTag + strings.TrimSuffix(doc, "</REFERENCES>")
+		tokens := CountTokens(doc)
+		if used+tokens > maxTokens {
+			if i == 0 {
+				// the most relevant document alone is too large
+				sb.WriteString(TruncateTokens(doc, maxTokens-used, false))
+			}
+			sb.WriteString(truncatedMark)
+			break
+		}
+		sb.WriteString(doc)
+		used += tokens
+	}
+	sb.WriteString("\n</REFERENCES>")
+	return sb.String()
+}
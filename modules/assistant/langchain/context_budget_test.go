@@ -0,0 +1,68 @@
+package langchain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllocateContextBudget(t *testing.T) {
+	// attachments, tools output, references, history
+	shares := []float64{0.25, 0.15, 0.4, 0.2}
+
+	got := allocateContextBudget(1000, []int{100, 0, 2000, 2000}, shares)
+	// attachments keep all, the tokens they leave go to the references first
+	want := []int{100, 0, 700, 200}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	got = allocateContextBudget(1000, []int{10, 20, 30, 40}, shares)
+	want = []int{10, 20, 30, 40}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected sections to be kept whole, got %v", got)
+		}
+	}
+}
+
+func TestFitReferencesKeepsLeadingDocs(t *testing.T) {
+	doc := func(n string) string {
+		return "<Doc>ID #" + n + " - doc" + n + "\nContent: " + strings.Repeat("lorem ipsum ", 200) + "\n</Doc>\n"
+	}
+	text := "<REFERENCES>\n" + doc("1") + doc("2") + doc("3") + "</REFERENCES>"
+
+	fitted := fitReferences(text, CountTokens(doc("1")+doc("2"))+20)
+	if !strings.Contains(fitted, "ID #1") || !strings.Contains(fitted, "ID #2") {
+		t.Fatalf("expected the leading documents to be kept: %q", fitted)
+	}
+	if strings.Contains(fitted, "ID #3") {
+		t.Fatalf("expected the last document to be dropped")
+	}
+	if !strings.HasSuffix(fitted, "</REFERENCES>") {
+		t.Fatalf("expected the references to be closed: %q", fitted)
+	}
+}
+
+func TestCountTokensUsesEmbeddedEncoding(t *testing.T) {
+	// no download, the counts are exact from the first call
+	if getTokenEncoder() == nil {
+		t.Fatal("expected the embedded token encoding to load")
+	}
+	if got := CountTokens("hello world"); got != 2 {
+		t.Fatalf("expected 2 tokens, got %d", got)
+	}
+	if got := TruncateTokens("hello world", 1, false); got != "hello" {
+		t.Fatalf("expected the first token, got %q", got)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if got := estimateTokens("abcdefgh"); got != 2 {
+		t.Fatalf("expected 2 tokens, got %d", got)
+	}
+	if got := estimateTokens("你好世界"); got != 4 {
+		t.Fatalf("expected 4 tokens, got %d", got)
+	}
+}
@@ -17,14 +17,9 @@ import (
 	"infini.sh/framework/core/util"
 )
 
-// Hard-coded attachment prompt budget. Mirrors the references truncation
-// pattern (`util.SubString(..., 0, 4096*2)` elsewhere in this file) until a
-// proper ChatSettings.ContextBudget configuration block lands.
-//
-// TODO: move attachmentsSectionMaxChars / perAttachmentMaxChars /
-// maxAttachmentsInPrompt to ChatSettings.ContextBudget alongside references
-// and history, so deployments can tune the prompt budget without a code
-// change.
+// Hard-coded attachment limits. The rendered section is then fitted into the
+// share of ChatSettings.ContextBudget given to the attachments, these limits
+// only keep a single attachment from taking all of it.
 const (
 	attachmentsSectionMaxChars = 4096 * 4
 	perAttachmentMaxChars      = 4096
@@ -33,8 +28,8 @@ const (
 
 // FormatAttachmentsSection renders the attachment metadata + extracted text
 // into a single prompt-ready string. It returns an empty string when there
-// is nothing to inject. Truncation is character-based (not token-aware) to
-// stay consistent with how references are clipped.
+// is nothing to inject. Truncation is character-based, the token budget is
+// applied by FitContextSections.
 func FormatAttachmentsSection(value any) string {
 	atts, ok := value.([]*core.Attachment)
 	if !ok || len(atts) == 0 {
@@ -439,39 +434,65 @@ func GenerateFinalResponse(taskCtx context.Context, reqMsg, replyMsg *core.ChatM
 		}))
 	}
 
+	template := common.GenerateAnswerPromptTemplate
+	if params.AssistantCfg.AnsweringModel.PromptConfig != nil && params.AssistantCfg.AnsweringModel.PromptConfig.PromptTemplate != "" {
+		template = params.AssistantCfg.AnsweringModel.PromptConfig.PromptTemplate
+	}
+
 	//for context is not set
 	if _, ok := inputValues["context"]; !ok {
-		contextPrompt := ``
+		sections := map[string]string{}
 
 		if v, ok := inputValues["history"]; ok {
 			text, ok := v.(string)
 			if ok {
-				if params.AssistantCfg.ChatSettings.HistoryMessage.CompressionThreshold > 0 && len(text) > params.AssistantCfg.ChatSettings.HistoryMessage.CompressionThreshold {
-					//log.Error("history is too large: %v, compressing, target size: %v", len(text), params.AssistantCfg.ChatSettings.HistoryMessage.CompressionThreshold)
-					//TODO compress history
+				// older turns are folded into the session summary when the history is
+				// fetched, trim what still exceeds the threshold
+				if threshold := params.AssistantCfg.ChatSettings.HistoryMessage.CompressionThreshold; threshold > 0 && len(text) > threshold {
+					log.Debugf("history is too large: %v, trimming, target size: %v", len(text), threshold)
+					text = TrimHistory(text, threshold)
 				}
-				contextPrompt += fmt.Sprintf("\nConversation:\n%v\n", text)
+				sections[ContextSectionHistory] = text
 			}
 		}
 
 		if v, ok := inputValues["references"]; ok {
-			contextPrompt += util.SubString(fmt.Sprintf("\nReferences:\n%v\n", v), 0, 4096*2) //TODO
+			sections[ContextSectionReferences] = fmt.Sprintf("%v", v)
 		}
 
 		if v, ok := inputValues["attachments"]; ok {
-			contextPrompt += FormatAttachmentsSection(v)
+			sections[ContextSectionAttachments] = FormatAttachmentsSection(v)
 		}
 
 		if v, ok := inputValues["tools_output"]; ok {
-			contextPrompt += fmt.Sprintf("\nTools Output:\n%v\n", v)
+			sections[ContextSectionToolsOutput] = fmt.Sprintf("%v", v)
 		}
 
-		inputValues["context"] = contextPrompt
-	}
+		contextLength, budget := answeringContextBudget(params, answeringModel, template, inputValues)
+		sections, report := FitContextSections(params.AssistantCfg.ChatSettings.ContextBudget, contextLength, budget, sections)
+		if report.Truncated() {
+			log.Debugf("message %v: %v", reqMsg.ID, report)
+			replyMsg.Details = append(replyMsg.Details, core.ProcessingDetails{Order: 45, Type: common.ContextBudget, Description: report.String(), Payload: report})
+		}
 
-	template := common.GenerateAnswerPromptTemplate
-	if params.AssistantCfg.AnsweringModel.PromptConfig != nil && params.AssistantCfg.AnsweringModel.PromptConfig.PromptTemplate != "" {
-		template = params.AssistantCfg.AnsweringModel.PromptConfig.PromptTemplate
+		contextPrompt := ``
+		if text, ok := sections[ContextSectionHistory]; ok {
+			contextPrompt += fmt.Sprintf("\nConversation:\n%v\n", text)
+		}
+		if text, ok := sections[ContextSectionReferences]; ok {
+			contextPrompt += fmt.Sprintf("\nReferences:\n%v\n", text)
+			if len(params.ReferenceDocs) > 0 {
+				contextPrompt += common.CitationInstruction
+			}
+		}
+		if text, ok := sections[ContextSectionAttachments]; ok {
+			contextPrompt += text
+		}
+		if text, ok := sections[ContextSectionToolsOutput]; ok {
+			contextPrompt += fmt.Sprintf("\nTools Output:\n%v\n", text)
+		}
+
+		inputValues["context"] = contextPrompt
 	}
 
 	// Create the prompt template
@@ -494,5 +515,13 @@ func GenerateFinalResponse(taskCtx context.Context, reqMsg, replyMsg *core.ChatM
 
 	chunkSeq += 1
 
+	if len(params.ReferenceDocs) > 0 {
+		citations := ExtractCitations(messageBuffer.String(), params.ReferenceDocs, params.PickedDocIDS)
+		if len(citations) > 0 {
+			replyMsg.Citations = citations
+			_ = sender.SendChunkMessage(core.MessageTypeAssistant, common.Citation, util.MustToJSON(citations), chunkSeq)
+		}
+	}
+
 	return nil
 }
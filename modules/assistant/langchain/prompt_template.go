@@ -0,0 +1,203 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package langchain
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"text/template/parse"
+
+	"github.com/tmc/langchaingo/prompts"
+	"infini.sh/coco/core"
+)
+
+// PipelineVariables are the variables each step of the chat pipeline supplies
+// to its prompt template.
+var PipelineVariables = map[string][]string{
+	core.PromptUsageAnswering:      {"query", "history", "references", "attachments", "tools_output", "context"},
+	core.PromptUsageIntentAnalysis: {"query", "history", "tool_list", "network_sources"},
+	core.PromptUsagePickingDoc:     {"query", "intent", "docs"},
+}
+
+// TemplateVariables returns the top-level variables a template references,
+// sorted, the fields of the values iterated with range or with are not.
+func TemplateVariables(template string) ([]string, error) {
+	// the functions are those of the renderer, not known here
+	tree := parse.New("prompt")
+	tree.Mode = parse.SkipFuncCheck
+	trees := map[string]*parse.Tree{}
+	if _, err := tree.Parse(template, "", "", trees); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, t := range trees {
+		if t.Root != nil {
+			collectVariables(t.Root, true, seen)
+		}
+	}
+	vars := make([]string, 0, len(seen))
+	for v := range seen {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	return vars, nil
+}
+
+// collectVariables walks a template, top tells whether the dot is the root of
+// the values at node.
+func collectVariables(node parse.Node, top bool, seen map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			collectVariables(c, top, seen)
+		}
+	case *parse.ActionNode:
+		collectVariables(n.Pipe, top, seen)
+	case *parse.TemplateNode:
+		if n.Pipe != nil {
+			collectVariables(n.Pipe, top, seen)
+		}
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			collectVariables(c, top, seen)
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			collectVariables(a, top, seen)
+		}
+	case *parse.ChainNode:
+		collectVariables(n.Node, top, seen)
+	case *parse.FieldNode:
+		if top && len(n.Ident) > 0 {
+			seen[n.Ident[0]] = true
+		}
+	case *parse.VariableNode:
+		// $.name always refers to the root
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			seen[n.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, top, top, seen)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, false, top, seen)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, false, top, seen)
+	}
+}
+
+func collectBranch(n *parse.BranchNode, bodyTop, top bool, seen map[string]bool) {
+	collectVariables(n.Pipe, top, seen)
+	collectVariables(n.List, bodyTop, seen)
+	if n.ElseList != nil {
+		collectVariables(n.ElseList, top, seen)
+	}
+}
+
+// ValidatePromptTemplate checks a template of a step of the chat pipeline:
+// the template must render, and every variable it references must be either
+// supplied by the step or declared.
+func ValidatePromptTemplate(usage, template string, variables []core.PromptVariable) error {
+	supplied, ok := PipelineVariables[usage]
+	if !ok {
+		return fmt.Errorf("invalid usage [%v] of prompt template", usage)
+	}
+
+	declared := map[string]*core.PromptVariable{}
+	for i := range variables {
+		v := &variables[i]
+		if err := v.Validate(); err != nil {
+			return err
+		}
+		if declared[v.Name] != nil {
+			return fmt.Errorf("variable [%v] is declared twice", v.Name)
+		}
+		declared[v.Name] = v
+		if v.Required && v.Default == nil && !slices.Contains(supplied, v.Name) {
+			return fmt.Errorf("variable [%v] is required, but is not supplied to %v prompts and has no default", v.Name, usage)
+		}
+	}
+
+	referenced, err := TemplateVariables(template)
+	if err != nil {
+		return fmt.Errorf("invalid prompt template: %w", err)
+	}
+	for _, name := range referenced {
+		if declared[name] == nil && !slices.Contains(supplied, name) {
+			return fmt.Errorf("variable [%v] is not supplied to %v prompts, supplied variables are %v, declare it with a default value", name, usage, supplied)
+		}
+	}
+
+	// render with sample values to catch the errors of the functions
+	samples := map[string]any{}
+	for _, name := range referenced {
+		samples[name] = sampleValue(declared[name])
+	}
+	if _, err := prompts.RenderTemplate(template, prompts.TemplateFormatGoTemplate, samples); err != nil {
+		return fmt.Errorf("invalid prompt template: %w", err)
+	}
+	return nil
+}
+
+func sampleValue(v *core.PromptVariable) any {
+	if v == nil {
+		return "sample"
+	}
+	if v.Default != nil {
+		return v.Default
+	}
+	switch v.Type {
+	case core.PromptVariableNumber:
+		return 1
+	case core.PromptVariableBoolean:
+		return true
+	case core.PromptVariableList:
+		return []any{"sample"}
+	case core.PromptVariableObject:
+		return map[string]any{}
+	}
+	return "sample"
+}
+
+// RenderPromptTemplate renders a template with the given inputs, the declared
+// defaults, and empty values for the other variables, which are returned as
+// missing.
+func RenderPromptTemplate(template string, variables []core.PromptVariable, inputs map[string]any) (string, []string, error) {
+	referenced, err := TemplateVariables(template)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+
+	values := map[string]any{}
+	for _, v := range variables {
+		if v.Default != nil {
+			values[v.Name] = v.Default
+		}
+	}
+	for k, v := range inputs {
+		values[k] = v
+	}
+
+	missing := []string{}
+	for _, name := range referenced {
+		if _, ok := values[name]; !ok {
+			values[name] = ""
+			missing = append(missing, name)
+		}
+	}
+
+	prompt, err := prompts.RenderTemplate(template, prompts.TemplateFormatGoTemplate, values)
+	if err != nil {
+		return "", missing, err
+	}
+	return prompt, missing, nil
+}
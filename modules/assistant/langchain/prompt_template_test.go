@@ -0,0 +1,67 @@
+package langchain
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func TestTemplateVariables(t *testing.T) {
+	vars, err := TemplateVariables(`{{.query}} {{if .history}}{{.history}}{{end}}{{range .items}}{{.name}} {{$.tone}}{{end}}{{upper .references}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"history", "items", "query", "references", "tone"}; !slices.Equal(vars, want) {
+		t.Fatalf("unexpected variables: got %v, want %v", vars, want)
+	}
+
+	if _, err := TemplateVariables(`{{.query`); err == nil {
+		t.Fatalf("expected a parse error")
+	}
+}
+
+func TestValidatePromptTemplate(t *testing.T) {
+	if err := ValidatePromptTemplate(core.PromptUsageAnswering, `{{.context}} {{.query}}`, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := ValidatePromptTemplate(core.PromptUsageAnswering, `{{.query}} in {{.tone}}`, nil)
+	if err == nil || !strings.Contains(err.Error(), "[tone]") {
+		t.Fatalf("expected the undeclared variable to be reported, got %v", err)
+	}
+
+	tone := []core.PromptVariable{{Name: "tone", Default: "a friendly tone"}}
+	if err := ValidatePromptTemplate(core.PromptUsageAnswering, `{{.query}} in {{.tone}}`, tone); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	required := []core.PromptVariable{{Name: "audience", Required: true}}
+	if err := ValidatePromptTemplate(core.PromptUsageAnswering, `{{.query}} for {{.audience}}`, required); err == nil {
+		t.Fatalf("expected a required variable without default to be rejected")
+	}
+
+	invalid := []core.PromptVariable{{Name: "limit", Type: core.PromptVariableNumber, Default: "ten"}}
+	if err := ValidatePromptTemplate(core.PromptUsageAnswering, `{{.query}}`, invalid); err == nil {
+		t.Fatalf("expected a default of the wrong type to be rejected")
+	}
+
+	if err := ValidatePromptTemplate("unknown", `{{.query}}`, nil); err == nil {
+		t.Fatalf("expected an unknown usage to be rejected")
+	}
+}
+
+func TestRenderPromptTemplate(t *testing.T) {
+	tone := []core.PromptVariable{{Name: "tone", Default: "friendly"}}
+	prompt, missing, err := RenderPromptTemplate(`{{.query}} ({{.tone}}){{.history}}`, tone, map[string]any{"query": "hi"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt != "hi (friendly)" {
+		t.Fatalf("unexpected prompt: %q", prompt)
+	}
+	if !slices.Equal(missing, []string{"history"}) {
+		t.Fatalf("unexpected missing variables: %v", missing)
+	}
+}
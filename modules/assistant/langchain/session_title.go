@@ -0,0 +1,67 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package langchain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	maxSessionTitleLength   = 50
+	maxSessionSummaryLength = 500
+)
+
+// SessionTitle is the generated name of a chat session.
+type SessionTitle struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+// GenerateSessionTitle names a chat session after its first exchange.
+func GenerateSessionTitle(ctx context.Context, model *core.ModelConfig, query, answer string) (*SessionTitle, error) {
+	llm, err := SimplyGetLLM(model.ProviderID, model.Name, model.Keepalive)
+	if err != nil {
+		return nil, err
+	}
+
+	inputValues := map[string]any{
+		"query":  util.SubStringWithSuffix(query, 2000, "..."),
+		"answer": util.SubStringWithSuffix(answer, 2000, "..."),
+	}
+	prompt, err := GetPromptStringByTemplateArgs(model, common.SessionTitlePromptTemplate, []string{"query", "answer"}, inputValues)
+	if err != nil {
+		return nil, err
+	}
+
+	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt,
+		llms.WithTemperature(GetTemperature(model, 0.3)),
+		llms.WithMaxTokens(GetMaxTokens(model, 256)))
+	if err != nil {
+		return nil, err
+	}
+	return parseSessionTitle(completion)
+}
+
+func parseSessionTitle(completion string) (*SessionTitle, error) {
+	title := SessionTitle{}
+	if err := util.FromJSONBytes([]byte(extractJSON(completion)), &title); err != nil {
+		return nil, fmt.Errorf("invalid session title: %w", err)
+	}
+
+	title.Title = strings.TrimRight(strings.Trim(strings.TrimSpace(title.Title), `"'“”「」`), ".。!！")
+	if title.Title == "" {
+		return nil, fmt.Errorf("empty session title")
+	}
+	title.Title = util.SubString(title.Title, 0, maxSessionTitleLength)
+	title.Summary = util.SubString(strings.TrimSpace(title.Summary), 0, maxSessionSummaryLength)
+	return &title, nil
+}
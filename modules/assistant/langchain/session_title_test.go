@@ -0,0 +1,20 @@
+package langchain
+
+import "testing"
+
+func TestParseSessionTitle(t *testing.T) {
+	title, err := parseSessionTitle("Sure!\n<JSON>\n{\"title\": \"\\\"Deploying Coco on Kubernetes.\\\"\", \"summary\": \"The user asks how to deploy Coco.\"}\n</JSON>")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if title.Title != "Deploying Coco on Kubernetes" {
+		t.Fatalf("unexpected title: %q", title.Title)
+	}
+	if title.Summary != "The user asks how to deploy Coco." {
+		t.Fatalf("unexpected summary: %q", title.Summary)
+	}
+
+	if _, err := parseSessionTitle("<JSON>{\"title\": \"  \"}</JSON>"); err == nil {
+		t.Fatalf("expected an error for an empty title")
+	}
+}
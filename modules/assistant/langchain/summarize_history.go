@@ -0,0 +1,63 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package langchain
+
+import (
+	"context"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/util"
+)
+
+// SummarizeConversation folds history, the older turns of a conversation,
+// into summary, the running summary of the session, and returns the updated
+// summary of at most maxLength characters.
+func SummarizeConversation(ctx context.Context, model *core.ModelConfig, summary, history string, maxLength int) (string, error) {
+	llm, err := SimplyGetLLM(model.ProviderID, model.Name, model.Keepalive)
+	if err != nil {
+		return "", err
+	}
+
+	if summary == "" {
+		summary = "</empty>"
+	}
+	inputValues := map[string]any{
+		"summary":    summary,
+		"history":    history,
+		"max_length": maxLength,
+	}
+	prompt, err := GetPromptStringByTemplateArgs(model, common.SummarizeHistoryPromptTemplate, []string{"summary", "history"}, inputValues)
+	if err != nil {
+		return "", err
+	}
+
+	options := []llms.CallOption{
+		llms.WithTemperature(GetTemperature(model, 0.3)),
+		llms.WithMaxTokens(GetMaxTokens(model, 1024)),
+	}
+	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt, options...)
+	if err != nil {
+		return "", err
+	}
+	return util.SubString(strings.TrimSpace(completion), 0, maxLength), nil
+}
+
+// TrimHistory keeps the newest part of the formatted history when it is still
+// larger than size, e.g. the summary could not be updated.
+func TrimHistory(history string, size int) string {
+	runes := []rune(history)
+	if size <= 0 || len(runes) <= size {
+		return history
+	}
+	tail := string(runes[len(runes)-size:])
+	// start from a complete message
+	if i := strings.Index(tail, "\n\n"); i >= 0 && i < len(tail)/2 {
+		tail = tail[i+2:]
+	}
+	return "<conversation>\n...\n\n" + tail
+}
@@ -82,6 +82,15 @@ func GetPromptTemplate(cfg *core.ModelConfig, defaultTemplate string, requiredVa
 		}
 	}
 
+	// the declared defaults of the variables not supplied
+	if cfg.PromptConfig != nil {
+		for _, v := range cfg.PromptConfig.Variables {
+			if _, exists := inputValues[v.Name]; !exists && v.Default != nil {
+				missingVars[v.Name] = v.Default
+			}
+		}
+	}
+
 	prompt := prompts.NewPromptTemplate(template, inputVars)
 	prompt.PartialVariables = missingVars //default value for missing variable
 
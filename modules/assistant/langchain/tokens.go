@@ -0,0 +1,98 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package langchain
+
+import (
+	"sync"
+	"unicode"
+
+	log "github.com/cihub/seelog"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// tokenEncoding is used for all models, close enough for budgeting prompts
+// of models with other tokenizers.
+const tokenEncoding = "cl100k_base"
+
+// getTokenEncoder returns the tiktoken encoder. The encoding is embedded in
+// the binary, so the counts never depend on a download; the estimate is only
+// used if it can not be parsed.
+var getTokenEncoder = sync.OnceValue(func() *tiktoken.Tiktoken {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	encoder, err := tiktoken.GetEncoding(tokenEncoding)
+	if err != nil {
+		_ = log.Errorf("failed to load token encoding %v, token counts will be estimated: %v", tokenEncoding, err)
+		return nil
+	}
+	return encoder
+})
+
+// CountTokens returns the number of tokens of text.
+func CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	if encoder := getTokenEncoder(); encoder != nil {
+		return len(encoder.Encode(text, nil, nil))
+	}
+	return estimateTokens(text)
+}
+
+// TruncateTokens returns the first, or the last when keepEnd is set, maxTokens
+// tokens of text.
+func TruncateTokens(text string, maxTokens int, keepEnd bool) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	if encoder := getTokenEncoder(); encoder != nil {
+		tokens := encoder.Encode(text, nil, nil)
+		if len(tokens) <= maxTokens {
+			return text
+		}
+		if keepEnd {
+			return encoder.Decode(tokens[len(tokens)-maxTokens:])
+		}
+		return encoder.Decode(tokens[:maxTokens])
+	}
+
+	runes := []rune(text)
+	if keepEnd {
+		count := 0
+		for i := len(runes) - 1; i >= 0; i-- {
+			count += estimateRuneTokens(runes[i])
+			if count > maxTokens*4 {
+				return string(runes[i+1:])
+			}
+		}
+		return text
+	}
+	count := 0
+	for i, r := range runes {
+		count += estimateRuneTokens(r)
+		if count > maxTokens*4 {
+			return string(runes[:i])
+		}
+	}
+	return text
+}
+
+// estimateTokens approximates the token count: about 4 characters per token
+// for latin text, about one token per CJK character.
+func estimateTokens(text string) int {
+	count := 0
+	for _, r := range text {
+		count += estimateRuneTokens(r)
+	}
+	return (count + 3) / 4
+}
+
+// estimateRuneTokens returns the cost of r in quarters of a token.
+func estimateRuneTokens(r rune) int {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+		return 4
+	}
+	return 1
+}
@@ -6,6 +6,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -15,9 +16,19 @@ import (
 	"infini.sh/framework/core/util"
 )
 
-// GetAssistant retrieves the assistant object from the cache or database.
+// GetAssistant retrieves the assistant object from the cache or database,
+// at the revision pinned by the integration the request was authenticated by
+// if any. The integration header of the other requests pins nothing, any
+// client could send it.
 func GetAssistant(req *http.Request, assistantID string) (*core.Assistant, bool, error) {
+	// the assistants an API key is not allowed to use are not found
+	if !core.APIKeyAllowsAssistant(req.Context(), assistantID) {
+		return nil, false, nil
+	}
 	ctx := orm.NewContextWithParent(req.Context())
+	if integrationID := core.SessionIntegrationID(req.Context()); integrationID != "" {
+		return InternalGetIntegrationAssistant(ctx, integrationID, assistantID)
+	}
 	return InternalGetAssistant(ctx, assistantID)
 }
 
@@ -43,6 +54,21 @@ func InternalGetAssistant(ctx context.Context, assistantID string) (*core.Assist
 		return nil, false, nil
 	}
 
+	if err = common.DecryptAssistantSecrets(assistant); err != nil {
+		return nil, exists, err
+	}
+	if err = prepareAssistant(assistant); err != nil {
+		return nil, exists, err
+	}
+
+	// Cache the assistant object
+	common.GeneralObjectCache.Set(core.AssistantCachePrimary, assistantID, assistant, time.Duration(30)*time.Minute)
+	return assistant, true, nil
+}
+
+// prepareAssistant expands the wildcards, sets the default values and decodes
+// the type-specific configuration of a loaded assistant.
+func prepareAssistant(assistant *core.Assistant) error {
 	//expand datasource is the datasource is `*`
 	if util.ContainsAnyInArray("*", assistant.Datasource.IDs) {
 		ids, err := common.GetAllEnabledDatasourceIDs()
@@ -65,18 +91,25 @@ func InternalGetAssistant(ctx context.Context, assistantID string) (*core.Assist
 		assistant.MCPConfig.MaxIterations = 5
 	}
 
+	resolvePromptConfig(assistant.AnsweringModel.PromptConfig)
 	if assistant.AnsweringModel.PromptConfig == nil {
 		assistant.AnsweringModel.PromptConfig = &core.PromptConfig{PromptTemplate: common.GenerateAnswerPromptTemplate}
 	} else if assistant.AnsweringModel.PromptConfig.PromptTemplate == "" {
 		assistant.AnsweringModel.PromptConfig.PromptTemplate = common.GenerateAnswerPromptTemplate
 	}
 
+	if err := ValidateAssistantTools(assistant); err != nil {
+		return err
+	}
+
 	switch assistant.Type {
 	case core.AssistantTypeDeepThink:
 		cfg := core.DeepThinkConfig{}
 		buf := util.MustToJSONBytes(assistant.Config)
 		util.MustFromJSONBytes(buf, &cfg)
 
+		resolvePromptConfig(cfg.IntentAnalysisModel.PromptConfig)
+		resolvePromptConfig(cfg.PickingDocModel.PromptConfig)
 		if cfg.IntentAnalysisModel.PromptConfig == nil {
 			cfg.IntentAnalysisModel.PromptConfig = &core.PromptConfig{PromptTemplate: common.QueryIntentPromptTemplate}
 		} else if cfg.IntentAnalysisModel.PromptConfig.PromptTemplate == "" {
@@ -94,19 +127,36 @@ func InternalGetAssistant(ctx context.Context, assistantID string) (*core.Assist
 		// though the assistant record itself is valid.
 		core.MergeDeepResearchConfig(&userCfg)
 		// Validate the merged config
-		if err = userCfg.Validate(); err != nil {
-			return nil, exists, err
+		if err := userCfg.Validate(); err != nil {
+			return err
 		}
 		assistant.DeepResearchConfig = &userCfg
+	case core.AssistantTypeExternalWorkflow:
+		userCfg := core.ExternalWorkflowConfig{}
+		buf := util.MustToJSONBytes(assistant.Config)
+		util.MustFromJSONBytes(buf, &userCfg)
+		core.MergeExternalWorkflowConfig(&userCfg)
+		if err := userCfg.Validate(); err != nil {
+			return err
+		}
+		assistant.ExternalWorkflowConfig = &userCfg
 	}
 
 	if assistant.RolePrompt == "" {
 		assistant.RolePrompt = "You are a personal AI assistant designed by Coco AI(https://coco.rs), the backend team is behind INFINI Labs(https://infinilabs.com)."
 	}
+	return nil
+}
 
-	// Cache the assistant object
-	common.GeneralObjectCache.Set(core.AssistantCachePrimary, assistantID, assistant, time.Duration(30)*time.Minute)
-	return assistant, true, nil
+// ValidateAssistantTools checks the settings of the builtin tools of an
+// assistant, e.g. the credentials of its web search engine.
+func ValidateAssistantTools(assistant *core.Assistant) error {
+	if ws := assistant.ToolsConfig.BuiltinTools.WebSearch; ws != nil && ws.Engine != "" {
+		if err := ws.Validate(); err != nil {
+			return fmt.Errorf("tools.builtin.web_search: %w", err)
+		}
+	}
+	return nil
 }
 
 var TotalAssistantsCacheKey = "total_assistants"
@@ -0,0 +1,476 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/tools"
+	"infini.sh/coco/modules/common"
+	"infini.sh/coco/modules/document"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	// assistantJobTimeout bounds a run of a job, the answer and the deliveries
+	assistantJobTimeout = 15 * time.Minute
+	// minJobInterval prevents a job from flooding the assistant
+	minJobInterval = 10 * time.Minute
+
+	defaultJobDocuments = 50
+	maxJobDocuments     = 200
+	// maxJobDocumentContent is the size of the content of a document given to
+	// the assistant
+	maxJobDocumentContent = 2000
+
+	JobTriggerSchedule = "schedule"
+	JobTriggerManual   = "manual"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// NextJobRun returns the first time after the given one a job is scheduled.
+func NextJobRun(schedule core.JobSchedule, after time.Time) (time.Time, error) {
+	loc := time.Local
+	if schedule.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(schedule.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone [%v]: %w", schedule.Timezone, err)
+		}
+	}
+
+	if schedule.At == "" {
+		interval, err := time.ParseDuration(schedule.Interval)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid interval [%v]: %w", schedule.Interval, err)
+		}
+		if interval < minJobInterval {
+			return time.Time{}, fmt.Errorf("interval must be at least %v", minJobInterval)
+		}
+		return after.Add(interval), nil
+	}
+
+	at, err := time.Parse("15:04", schedule.At)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time of day [%v], expected HH:MM", schedule.At)
+	}
+	days := map[time.Weekday]bool{}
+	for _, name := range schedule.Weekdays {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return time.Time{}, fmt.Errorf("invalid weekday [%v]", name)
+		}
+		days[day] = true
+	}
+
+	local := after.In(loc)
+	for i := 0; i <= 7; i++ {
+		d := local.AddDate(0, 0, i)
+		candidate := time.Date(d.Year(), d.Month(), d.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+		if candidate.After(after) && (len(days) == 0 || days[candidate.Weekday()]) {
+			return candidate, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no time scheduled")
+}
+
+// RunDueAssistantJobs runs the enabled jobs due, one after the other.
+func RunDueAssistantJobs(ctx context.Context) error {
+	now := time.Now()
+	jobs := []core.AssistantJob{}
+	q := orm.Query{
+		Size:  1000,
+		Conds: orm.And(orm.Eq("enabled", true)),
+	}
+	err, _ := orm.SearchWithJSONMapper(&jobs, &q)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if job.NextRun != nil && job.NextRun.After(now) {
+			continue
+		}
+
+		// reschedule first, a failing job must not run again right away
+		next, err := NextJobRun(job.Schedule, now)
+		if err != nil {
+			_ = log.Errorf("invalid schedule of assistant job [%v]: %v", job.ID, err)
+			continue
+		}
+		scheduled := job.NextRun != nil
+		job.NextRun = &next
+		if err := saveAssistantJob(ctx, job, util.MapStr{"next_run": next}); err != nil {
+			_ = log.Errorf("failed to schedule assistant job [%v]: %v", job.ID, err)
+			continue
+		}
+		// a new job is scheduled, not run
+		if !scheduled {
+			continue
+		}
+
+		run, err := RunAssistantJob(ctx, job, JobTriggerSchedule)
+		if err != nil {
+			_ = log.Errorf("failed to run assistant job [%v]: %v", job.ID, err)
+			continue
+		}
+		log.Infof("assistant job [%v] run [%v]: %v", job.Name, run.ID, run.Status)
+	}
+	return nil
+}
+
+// StartAssistantJobRun runs a job in the background, out of its schedule,
+// the run can be followed with its ID.
+func StartAssistantJobRun(ctx context.Context, job *core.AssistantJob) (*core.AssistantJobRun, error) {
+	run, err := newAssistantJobRun(ctx, job, JobTriggerManual)
+	if err != nil {
+		return nil, err
+	}
+
+	// detach from the request, keeping the user for the ORM hooks
+	runCtx := security.CloneContext(ctx)
+	go func() {
+		executeAssistantJob(runCtx, job, run)
+		log.Infof("assistant job [%v] run [%v]: %v", job.Name, run.ID, run.Status)
+	}()
+	return run, nil
+}
+
+// RunAssistantJob runs a job and returns its run once finished.
+func RunAssistantJob(ctx context.Context, job *core.AssistantJob, trigger string) (*core.AssistantJobRun, error) {
+	run, err := newAssistantJobRun(ctx, job, trigger)
+	if err != nil {
+		return nil, err
+	}
+	executeAssistantJob(ctx, job, run)
+	return run, nil
+}
+
+func newAssistantJobRun(ctx context.Context, job *core.AssistantJob, trigger string) (*core.AssistantJobRun, error) {
+	run := &core.AssistantJobRun{
+		JobID:       job.ID,
+		JobName:     job.Name,
+		AssistantID: job.AssistantID,
+		Trigger:     trigger,
+		Status:      core.AssistantJobRunRunning,
+	}
+	run.SetOwnerID(job.GetOwnerID())
+	if err := saveJobRun(ctx, run, true); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// executeAssistantJob asks the assistant of a job its prompt, with the
+// documents of the job as references, stores the answer and delivers it. The
+// outcome is recorded on the run, and the job.
+func executeAssistantJob(ctx context.Context, job *core.AssistantJob, run *core.AssistantJobRun) {
+	ctx, cancel := context.WithTimeout(ctx, assistantJobTimeout)
+	defer cancel()
+
+	started := *run.Created
+	defer func() {
+		if r := recover(); r != nil {
+			run.Status = core.AssistantJobRunFailed
+			run.Error = fmt.Sprint(r)
+		}
+		now := time.Now()
+		run.Finished = &now
+		if err := saveJobRun(ctx, run, false); err != nil {
+			_ = log.Errorf("failed to save run of assistant job [%v]: %v", job.ID, err)
+		}
+
+		job.LastRun = &started
+		fields := util.MapStr{"last_run": started}
+		if run.Status == core.AssistantJobRunComplete {
+			job.LastSuccess = &started
+			fields["last_success"] = started
+		}
+		if err := saveAssistantJob(ctx, job, fields); err != nil {
+			_ = log.Errorf("failed to save assistant job [%v]: %v", job.ID, err)
+		}
+	}()
+
+	if err := runAssistantJob(ctx, job, run); err != nil {
+		run.Status = core.AssistantJobRunFailed
+		run.Error = err.Error()
+		return
+	}
+	run.Status = core.AssistantJobRunComplete
+	for _, d := range run.Deliveries {
+		if d.Error != "" {
+			run.Status = core.AssistantJobRunFailed
+			run.Error = fmt.Sprintf("failed to deliver to %v", d.Target)
+		}
+	}
+}
+
+func runAssistantJob(ctx context.Context, job *core.AssistantJob, run *core.AssistantJobRun) error {
+	vars := map[string]any{}
+	if len(job.Datasources) > 0 {
+		docs, err := collectJobDocuments(ctx, job)
+		if err != nil {
+			return fmt.Errorf("failed to collect documents: %w", err)
+		}
+		run.Documents = len(docs)
+		vars["references"] = tools.FormatDocumentForReplyReferences(docs)
+	}
+
+	answer, reply, err := AskAssistantSyncWithReply(ctx, job.GetOwnerID(), job.AssistantID, job.Prompt, vars)
+	if err != nil {
+		return err
+	}
+	run.Answer = answer
+	title := fmt.Sprintf("%v - %v", job.Name, run.Created.Format("2006-01-02"))
+
+	switch job.Output {
+	case core.AssistantJobOutputAttachment:
+		attachment, err := saveJobAttachment(ctx, job, title, answer)
+		if err != nil {
+			return err
+		}
+		run.AttachmentID = attachment.ID
+	default:
+		if err := publishJobSession(ctx, job, reply.SessionID, title); err != nil {
+			return err
+		}
+		run.SessionID = reply.SessionID
+	}
+
+	if w := job.Delivery.Webhook; w != nil {
+		delivered := core.JobDelivered{Type: "webhook", Target: w.URL}
+		if err := deliverToWebhook(ctx, w, jobWebhookPayload(job, run)); err != nil {
+			delivered.Error = err.Error()
+		}
+		run.Deliveries = append(run.Deliveries, delivered)
+	}
+	if e := job.Delivery.Email; e != nil {
+		delivered := core.JobDelivered{Type: "email", Target: strings.Join(e.To, ",")}
+		subject := e.Subject
+		if subject == "" {
+			subject = title
+		}
+		if err := SendMail(common.AppConfig().SMTP, e.To, subject, jobMailBody(job, run)); err != nil {
+			delivered.Error = err.Error()
+		}
+		run.Deliveries = append(run.Deliveries, delivered)
+	}
+	return nil
+}
+
+// collectJobDocuments returns the documents of the datasources of a job
+// updated within its window, the most recently updated first. Only the
+// documents the owner of the job can access are collected.
+func collectJobDocuments(ctx context.Context, job *core.AssistantJob) ([]core.Document, error) {
+	ownerID := job.GetOwnerID()
+	access, err := document.ResolveDocumentAccess(ctx, ownerID, tools.GetTeamsIDByUserID(ctx, ownerID), job.Datasources, "")
+	if err != nil {
+		return nil, err
+	}
+	if access == nil {
+		return nil, errors.New("the datasources of the job are not accessible to its owner")
+	}
+	filters := access.Filters()
+	var since *time.Time
+	switch job.UpdatedWithin {
+	case "":
+	case core.AssistantJobUpdatedSinceLastRun:
+		since = job.LastSuccess
+	default:
+		window, err := time.ParseDuration(job.UpdatedWithin)
+		if err != nil {
+			return nil, err
+		}
+		t := time.Now().Add(-window)
+		since = &t
+	}
+	if since != nil {
+		filters = append(filters, util.MapStr{"range": util.MapStr{"updated": util.MapStr{"gte": since.Format(time.RFC3339)}}})
+	}
+
+	size := job.MaxDocuments
+	if size <= 0 {
+		size = defaultJobDocuments
+	}
+	if size > maxJobDocuments {
+		size = maxJobDocuments
+	}
+
+	dsl := util.MapStr{
+		"size":    size,
+		"sort":    []util.MapStr{{"updated": util.MapStr{"order": "desc"}}},
+		"_source": util.MapStr{"excludes": []string{"payload.*", "document_chunk", "ai_insights.embedding"}},
+		"query":   util.MapStr{"bool": util.MapStr{"filter": filters}},
+	}
+	q := orm.Query{RawQuery: util.MustToJSONBytes(dsl)}
+	docs := []core.Document{}
+	err, _ = orm.SearchWithJSONMapper(&docs, &q)
+	if err != nil {
+		return nil, err
+	}
+	for i := range docs {
+		docs[i].Content = util.SubStringWithSuffix(docs[i].Content, maxJobDocumentContent, "...")
+	}
+	return docs, nil
+}
+
+// publishJobSession lists the session of the answer in the chat history of
+// the owner of the job.
+func publishJobSession(ctx context.Context, job *core.AssistantJob, sessionID, title string) error {
+	session := core.Session{}
+	session.ID = sessionID
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.WaitForRefresh
+	exists, err := orm.GetV2(ctx1, &session)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("session [%v] not found", sessionID)
+	}
+	session.Title = title
+	session.ManuallyRenamedTitle = true
+	session.Visible = true
+	session.SetOwnerID(job.GetOwnerID())
+	return orm.Update(ctx1, &session)
+}
+
+func saveJobAttachment(ctx context.Context, job *core.AssistantJob, title, answer string) (*core.Attachment, error) {
+	attachment := &core.Attachment{}
+	attachment.ID = util.GetUUID()
+	attachment.Name = title + ".md"
+	attachment.MimeType = "text/markdown; charset=UTF-8"
+	attachment.Text = answer
+	attachment.Size = int64(len(answer))
+	attachment.Icon = "book-open"
+	attachment.URL = fmt.Sprintf("/attachment/%v", attachment.ID)
+	attachment.SetOwnerID(job.GetOwnerID())
+
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	if err := orm.Save(ctx1, attachment); err != nil {
+		return nil, err
+	}
+	if err := kv.AddValue(core.AttachmentKVBucket, []byte(attachment.ID), []byte(answer)); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// jobRunLink is the link to the answer of a run in the web UI, empty if it
+// is not known.
+func jobRunLink(run *core.AssistantJobRun) string {
+	endpoint := strings.TrimRight(common.AppConfig().ServerInfo.Endpoint, "/")
+	switch {
+	case run.SessionID != "":
+		return fmt.Sprintf("%v/chat/%v/_export?format=html", endpoint, run.SessionID)
+	case run.AttachmentID != "":
+		return fmt.Sprintf("%v/attachment/%v", endpoint, run.AttachmentID)
+	}
+	return ""
+}
+
+func jobWebhookPayload(job *core.AssistantJob, run *core.AssistantJobRun) util.MapStr {
+	return util.MapStr{
+		"job_id":        job.ID,
+		"job_name":      job.Name,
+		"run_id":        run.ID,
+		"assistant_id":  job.AssistantID,
+		"created":       run.Created,
+		"documents":     run.Documents,
+		"answer":        run.Answer,
+		"session_id":    run.SessionID,
+		"attachment_id": run.AttachmentID,
+		"link":          jobRunLink(run),
+	}
+}
+
+// webhookClient posts to the webhooks of the jobs, which are given by users,
+// so only to public addresses.
+var webhookClient = common.NewPublicHTTPClient(30 * time.Second)
+
+// deliverToWebhook posts the payload of a run to a webhook.
+func deliverToWebhook(ctx context.Context, w *core.WebhookDelivery, body util.MapStr) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(util.MustToJSONBytes(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %v", resp.Status)
+	}
+	return nil
+}
+
+func jobMailBody(job *core.AssistantJob, run *core.AssistantJobRun) string {
+	sb := strings.Builder{}
+	sb.WriteString(run.Answer)
+	sb.WriteString("\n\n--\n")
+	sb.WriteString(fmt.Sprintf("Sent by the assistant job %q.\n", job.Name))
+	if link := jobRunLink(run); link != "" {
+		sb.WriteString(link)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func saveJobRun(ctx context.Context, run *core.AssistantJobRun, create bool) error {
+	// do not let the timeout of a run prevent saving its state
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	ctx1 := orm.NewContextWithParent(saveCtx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.ImmediatelyRefresh
+	if create {
+		now := time.Now()
+		run.Created = &now
+		return orm.Create(ctx1, run)
+	}
+	return orm.Update(ctx1, run)
+}
+
+// saveAssistantJob saves the scheduling fields of a job, only them, the job
+// may be edited while it runs.
+func saveAssistantJob(ctx context.Context, job *core.AssistantJob, fields util.MapStr) error {
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	ctx1 := orm.NewContextWithParent(saveCtx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.ImmediatelyRefresh
+	return orm.UpdatePartialFields(ctx1, job, fields)
+}
@@ -0,0 +1,163 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"infini.sh/coco/core"
+)
+
+func TestNextJobRun(t *testing.T) {
+	utc := time.UTC
+	// a wednesday
+	now := time.Date(2025, 1, 15, 10, 30, 0, 0, utc)
+
+	next, err := NextJobRun(core.JobSchedule{Interval: "6h"}, now)
+	if err != nil || !next.Equal(now.Add(6*time.Hour)) {
+		t.Fatalf("unexpected interval run: %v, %v", next, err)
+	}
+	if _, err := NextJobRun(core.JobSchedule{Interval: "1m"}, now); err == nil {
+		t.Fatalf("expected a too short interval to be rejected")
+	}
+
+	next, err = NextJobRun(core.JobSchedule{At: "09:00", Timezone: "UTC"}, now)
+	if err != nil || !next.Equal(time.Date(2025, 1, 16, 9, 0, 0, 0, utc)) {
+		t.Fatalf("unexpected daily run: %v, %v", next, err)
+	}
+	next, err = NextJobRun(core.JobSchedule{At: "11:00", Timezone: "UTC"}, now)
+	if err != nil || !next.Equal(time.Date(2025, 1, 15, 11, 0, 0, 0, utc)) {
+		t.Fatalf("unexpected daily run: %v, %v", next, err)
+	}
+
+	next, err = NextJobRun(core.JobSchedule{At: "09:00", Weekdays: []string{"Monday"}, Timezone: "UTC"}, now)
+	if err != nil || !next.Equal(time.Date(2025, 1, 20, 9, 0, 0, 0, utc)) {
+		t.Fatalf("unexpected weekly run: %v, %v", next, err)
+	}
+	// the same day, a week later
+	next, err = NextJobRun(core.JobSchedule{At: "09:00", Weekdays: []string{"wednesday"}, Timezone: "UTC"}, now)
+	if err != nil || !next.Equal(time.Date(2025, 1, 22, 9, 0, 0, 0, utc)) {
+		t.Fatalf("unexpected weekly run: %v, %v", next, err)
+	}
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+	next, err = NextJobRun(core.JobSchedule{At: "09:00", Timezone: "Asia/Shanghai"}, now)
+	if err != nil || !next.Equal(time.Date(2025, 1, 16, 9, 0, 0, 0, shanghai)) {
+		t.Fatalf("unexpected run in timezone: %v, %v", next, err)
+	}
+
+	for _, s := range []core.JobSchedule{{At: "9am"}, {At: "09:00", Weekdays: []string{"someday"}}, {At: "09:00", Timezone: "Nowhere/City"}} {
+		if _, err := NextJobRun(s, now); err == nil {
+			t.Fatalf("expected schedule %+v to be rejected", s)
+		}
+	}
+}
+
+func TestBuildMailMessage(t *testing.T) {
+	body := strings.Repeat("周报 weekly digest ", 20)
+	to := []*mail.Address{{Address: "a@example.com"}, {Name: "Bob", Address: "b@example.com"}}
+	msg := string(buildMailMessage("coco@example.com", to, "周报", body, time.Now()))
+
+	for _, want := range []string{
+		"From: coco@example.com\r\n",
+		"To: <a@example.com>, \"Bob\" <b@example.com>\r\n",
+		"Subject: =?UTF-8?q?",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("expected %q in message:\n%v", want, msg)
+		}
+	}
+
+	parts := strings.SplitN(msg, "\r\n\r\n", 2)
+	lines := strings.Split(strings.TrimSpace(parts[1]), "\r\n")
+	for _, line := range lines {
+		if len(line) > 76 {
+			t.Fatalf("line too long: %d", len(line))
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil || string(decoded) != body {
+		t.Fatalf("unexpected body: %q, %v", decoded, err)
+	}
+}
+
+func TestSendMailRejectsHeaderInjection(t *testing.T) {
+	cfg := &core.SMTPConfig{Host: "smtp.example.com"}
+	if err := SendMail(cfg, []string{"a@example.com"}, "digest\r\nBcc: x@example.com", "body"); err == nil {
+		t.Fatalf("expected a multi-line subject to be rejected")
+	}
+	if err := SendMail(cfg, []string{"a@example.com>\r\nBcc: x@example.com"}, "digest", "body"); err == nil {
+		t.Fatalf("expected an invalid recipient to be rejected")
+	}
+}
+
+func TestDeliverToWebhook(t *testing.T) {
+	if err := deliverToWebhook(context.Background(), &core.WebhookDelivery{URL: "http://127.0.0.1:1/hook"}, nil); err == nil || !strings.Contains(err.Error(), "non-public") {
+		t.Fatalf("expected a loopback webhook to be refused, got %v", err)
+	}
+
+	// the test servers listen on loopback
+	defer func(client *http.Client) { webhookClient = client }(webhookClient)
+	webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+	var received map[string]any
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Token")
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	job := &core.AssistantJob{Name: "digest"}
+	run := &core.AssistantJobRun{Answer: "all good"}
+	hook := &core.WebhookDelivery{URL: server.URL, Headers: map[string]string{"X-Token": "secret"}}
+	payload := map[string]any{"job_name": job.Name, "answer": run.Answer}
+	if err := deliverToWebhook(context.Background(), hook, payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token != "secret" || received["answer"] != "all good" || received["job_name"] != "digest" {
+		t.Fatalf("unexpected delivery: %v %v", token, received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := deliverToWebhook(context.Background(), &core.WebhookDelivery{URL: failing.URL}, payload); err == nil {
+		t.Fatalf("expected an error status to fail the delivery")
+	}
+}
+
+func TestValidateJobEmailDelivery(t *testing.T) {
+	newJob := func(e *core.EmailDelivery) *core.AssistantJob {
+		return &core.AssistantJob{Name: "digest", AssistantID: "a1", Prompt: "summarize",
+			Schedule: core.JobSchedule{Interval: "24h"}, Delivery: core.JobDelivery{Email: e}}
+	}
+	if err := newJob(&core.EmailDelivery{To: []string{"Ops <ops@example.com>"}, Subject: "Digest"}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range []*core.EmailDelivery{
+		{To: []string{"not an address"}},
+		{To: []string{"ops@example.com\r\nBcc: x@example.com"}},
+		{To: []string{"ops@example.com"}, Subject: "Digest\nBcc: x@example.com"},
+	} {
+		if err := newJob(e).Validate(); err == nil {
+			t.Fatalf("expected %+v to be rejected", e)
+		}
+	}
+}
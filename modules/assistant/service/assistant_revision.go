@@ -0,0 +1,273 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// ignoredRevisionFields are the fields maintained by the system, not part of
+// the diff of a revision.
+var ignoredRevisionFields = map[string]bool{
+	"id":                true,
+	"created":           true,
+	"updated":           true,
+	"revision":          true,
+	"_system":           true,
+	"combined_fulltext": true,
+}
+
+// NextAssistantRevision returns the revision number of the next update of
+// assistant, saving the current configuration as the first revision if the
+// assistant predates the revisions.
+func NextAssistantRevision(ctx context.Context, userID string, assistant *core.Assistant) (int, error) {
+	if assistant.Revision > 0 {
+		return assistant.Revision + 1, nil
+	}
+	baseline := *assistant
+	baseline.Revision = 1
+	if err := SaveAssistantRevision(ctx, userID, nil, &baseline, "", 0); err != nil {
+		return 0, err
+	}
+	return 2, nil
+}
+
+// SaveAssistantRevision stores the configuration next, with the changes from
+// prev, as the revision next.Revision of the assistant. The caller owns the
+// revision number, claimed by updating the assistant to it. The shared prompt
+// templates referenced are stored as resolved, a pinned revision keeps its
+// prompts when the templates change.
+func SaveAssistantRevision(ctx context.Context, userID string, prev, next *core.Assistant, comment string, rollbackOf int) error {
+	rev := &core.AssistantRevision{
+		AssistantID: next.ID,
+		Revision:    next.Revision,
+		Author:      userID,
+		Comment:     comment,
+		RollbackOf:  rollbackOf,
+		Snapshot:    *next,
+		Prompts:     resolveRevisionPrompts(next),
+	}
+	rev.ID = core.AssistantRevisionID(next.ID, next.Revision)
+	if prev != nil {
+		changes, err := DiffAssistants(prev, next)
+		if err != nil {
+			return err
+		}
+		// the changes of the secrets are recorded, not their values
+		for i := range changes {
+			changes[i].Old = common.RedactFieldSecrets(changes[i].Field, changes[i].Old)
+			changes[i].New = common.RedactFieldSecrets(changes[i].Field, changes[i].New)
+		}
+		rev.Changes = changes
+	}
+
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.Refresh = orm.WaitForRefresh
+	return orm.Save(ctx1, rev)
+}
+
+// GetAssistantRevision returns a revision of an assistant.
+func GetAssistantRevision(ctx context.Context, assistantID string, revision int) (*core.AssistantRevision, bool, error) {
+	rev := &core.AssistantRevision{}
+	rev.ID = core.AssistantRevisionID(assistantID, revision)
+
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectReadAccess()
+	exists, err := orm.GetV2(ctx1, rev)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	return rev, true, nil
+}
+
+// DeleteAssistantRevisions removes the revisions of a deleted assistant.
+func DeleteAssistantRevisions(ctx context.Context, assistantID string) error {
+	builder := orm.NewQuery()
+	builder.Filter(orm.TermQuery("assistant_id", assistantID))
+
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	orm.WithModel(ctx1, &core.AssistantRevision{})
+	_, err := orm.DeleteByQuery(ctx1, builder)
+	return err
+}
+
+// InternalGetIntegrationAssistant retrieves an assistant as configured for an
+// integration: the pinned revision if the integration pins the assistant, the
+// latest configuration otherwise.
+func InternalGetIntegrationAssistant(ctx context.Context, integrationID string, assistantID string) (*core.Assistant, bool, error) {
+	integration, err := core.InternalGetIntegration(integrationID)
+	if err != nil {
+		log.Debugf("integration [%v] not found: %v", integrationID, err)
+		return InternalGetAssistant(ctx, assistantID)
+	}
+	revision, ok := integration.EnabledModule.AIChat.AssistantRevisions[assistantID]
+	if !ok || revision <= 0 {
+		return InternalGetAssistant(ctx, assistantID)
+	}
+	return InternalGetPinnedAssistant(ctx, assistantID, revision)
+}
+
+// InternalGetPinnedAssistant retrieves the configuration of an assistant at a
+// revision, revisions being immutable they are cached apart from the latest
+// configuration.
+func InternalGetPinnedAssistant(ctx context.Context, assistantID string, revision int) (*core.Assistant, bool, error) {
+	cacheKey := core.AssistantRevisionID(assistantID, revision)
+	item := common.GeneralObjectCache.Get(core.AssistantCachePrimary, cacheKey)
+	if item != nil && !item.Expired() {
+		if assistant, ok := item.Value().(*core.Assistant); ok {
+			return assistant, true, nil
+		}
+	}
+
+	rev, exists, err := GetAssistantRevision(ctx, assistantID, revision)
+	if err != nil {
+		return nil, exists, err
+	}
+	if !exists {
+		return nil, false, fmt.Errorf("revision %d of assistant [%v] not found", revision, assistantID)
+	}
+
+	assistant := &rev.Snapshot
+	assistant.ID = assistantID
+	if err = common.DecryptAssistantSecrets(assistant); err != nil {
+		return nil, true, err
+	}
+	if err = prepareAssistant(assistant); err != nil {
+		return nil, true, err
+	}
+	applyRevisionPrompts(assistant, rev.Prompts)
+
+	common.GeneralObjectCache.Set(core.AssistantCachePrimary, cacheKey, assistant, time.Duration(30)*time.Minute)
+	return assistant, true, nil
+}
+
+// Paths of the models whose prompt may reference a shared prompt template.
+const (
+	answeringPromptPath      = "answering_model"
+	intentAnalysisPromptPath = "config.intent_analysis_model"
+	pickingDocPromptPath     = "config.picking_doc_model"
+)
+
+// resolveRevisionPrompts returns the prompts of an assistant referencing a
+// shared prompt template, resolved with the current template.
+func resolveRevisionPrompts(assistant *core.Assistant) map[string]core.PromptConfig {
+	prompts := map[string]core.PromptConfig{}
+	resolve := func(path string, cfg *core.PromptConfig) {
+		if cfg == nil || cfg.TemplateID == "" {
+			return
+		}
+		resolved := *cfg
+		resolvePromptConfig(&resolved)
+		prompts[path] = resolved
+	}
+
+	resolve(answeringPromptPath, assistant.AnsweringModel.PromptConfig)
+	if assistant.Type == core.AssistantTypeDeepThink && assistant.Config != nil {
+		cfg := core.DeepThinkConfig{}
+		if err := util.FromJSONBytes(util.MustToJSONBytes(assistant.Config), &cfg); err == nil {
+			resolve(intentAnalysisPromptPath, cfg.IntentAnalysisModel.PromptConfig)
+			resolve(pickingDocPromptPath, cfg.PickingDocModel.PromptConfig)
+		}
+	}
+	if len(prompts) == 0 {
+		return nil
+	}
+	return prompts
+}
+
+// applyRevisionPrompts replaces the prompts of a prepared assistant with the
+// prompts resolved when its revision was saved.
+func applyRevisionPrompts(assistant *core.Assistant, prompts map[string]core.PromptConfig) {
+	set := func(path string, cfg **core.PromptConfig) {
+		if p, ok := prompts[path]; ok {
+			*cfg = &p
+		}
+	}
+
+	set(answeringPromptPath, &assistant.AnsweringModel.PromptConfig)
+	if assistant.DeepThinkConfig != nil {
+		set(intentAnalysisPromptPath, &assistant.DeepThinkConfig.IntentAnalysisModel.PromptConfig)
+		set(pickingDocPromptPath, &assistant.DeepThinkConfig.PickingDocModel.PromptConfig)
+	}
+}
+
+// DiffAssistants returns the fields changed between two configurations of an
+// assistant, by dotted JSON path, sorted. Objects are compared field by
+// field, arrays as a whole.
+func DiffAssistants(prev, next *core.Assistant) ([]core.AssistantFieldChange, error) {
+	before, err := flattenAssistant(prev)
+	if err != nil {
+		return nil, err
+	}
+	after, err := flattenAssistant(next)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	changes := []core.AssistantFieldChange{}
+	for _, k := range keys {
+		if !reflect.DeepEqual(before[k], after[k]) {
+			changes = append(changes, core.AssistantFieldChange{Field: k, Old: before[k], New: after[k]})
+		}
+	}
+	return changes, nil
+}
+
+func flattenAssistant(assistant *core.Assistant) (map[string]interface{}, error) {
+	buf, err := json.Marshal(assistant)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	for k := range ignoredRevisionFields {
+		delete(doc, k)
+	}
+
+	fields := map[string]interface{}{}
+	flattenFields("", doc, fields)
+	return fields, nil
+}
+
+func flattenFields(prefix string, doc map[string]interface{}, fields map[string]interface{}) {
+	for k, v := range doc {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			flattenFields(path, m, fields)
+			continue
+		}
+		fields[path] = v
+	}
+}
@@ -0,0 +1,90 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"infini.sh/coco/core"
+)
+
+func TestDiffAssistants(t *testing.T) {
+	prev := &core.Assistant{Name: "support", RolePrompt: "Be brief.", Revision: 3}
+	prev.AnsweringModel.Name = "gpt-4o"
+	prev.Datasource.IDs = []string{"d1"}
+
+	now := time.Now()
+	next := *prev
+	next.Updated = &now
+	next.Revision = 4
+	next.RolePrompt = "Be thorough."
+	next.AnsweringModel.Name = "gpt-4.1"
+	next.Datasource.IDs = []string{"d1", "d2"}
+
+	changes, err := DiffAssistants(prev, &next)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fields := []string{}
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+	}
+	want := []string{"answering_model.name", "datasource.ids", "role_prompt"}
+	if len(fields) != len(want) {
+		t.Fatalf("unexpected changes: got %v, want %v", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Fatalf("unexpected changes: got %v, want %v", fields, want)
+		}
+	}
+	if changes[2].Old != "Be brief." || changes[2].New != "Be thorough." {
+		t.Fatalf("unexpected change: %+v", changes[2])
+	}
+
+	if changes, _ := DiffAssistants(prev, prev); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestResolveRevisionPromptsWithoutTemplates(t *testing.T) {
+	assistant := &core.Assistant{Type: core.AssistantTypeDeepThink}
+	assistant.AnsweringModel.PromptConfig = &core.PromptConfig{PromptTemplate: "inline"}
+	assistant.Config = map[string]interface{}{
+		"intent_analysis_model": map[string]interface{}{"prompt": map[string]interface{}{"template": "intent"}},
+	}
+	if prompts := resolveRevisionPrompts(assistant); prompts != nil {
+		t.Fatalf("expected no prompts to resolve, got %+v", prompts)
+	}
+}
+
+func TestApplyRevisionPrompts(t *testing.T) {
+	assistant := &core.Assistant{DeepThinkConfig: &core.DeepThinkConfig{}}
+	assistant.AnsweringModel.PromptConfig = &core.PromptConfig{TemplateID: "t1", PromptTemplate: "current"}
+	assistant.DeepThinkConfig.IntentAnalysisModel.PromptConfig = &core.PromptConfig{PromptTemplate: "intent"}
+	assistant.DeepThinkConfig.PickingDocModel.PromptConfig = &core.PromptConfig{TemplateID: "t2", PromptTemplate: "current"}
+
+	applyRevisionPrompts(assistant, map[string]core.PromptConfig{
+		answeringPromptPath:  {TemplateID: "t1", PromptTemplate: "saved answer"},
+		pickingDocPromptPath: {TemplateID: "t2", PromptTemplate: "saved picking"},
+	})
+
+	if got := assistant.AnsweringModel.PromptConfig.PromptTemplate; got != "saved answer" {
+		t.Fatalf("expected the saved answering prompt, got %q", got)
+	}
+	if got := assistant.DeepThinkConfig.IntentAnalysisModel.PromptConfig.PromptTemplate; got != "intent" {
+		t.Fatalf("expected the inline intent prompt to be kept, got %q", got)
+	}
+	if got := assistant.DeepThinkConfig.PickingDocModel.PromptConfig.PromptTemplate; got != "saved picking" {
+		t.Fatalf("expected the saved picking prompt, got %q", got)
+	}
+
+	plain := &core.Assistant{}
+	applyRevisionPrompts(plain, nil)
+	if plain.AnsweringModel.PromptConfig != nil {
+		t.Fatalf("expected no prompt, got %+v", plain.AnsweringModel.PromptConfig)
+	}
+}
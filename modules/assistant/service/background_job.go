@@ -16,6 +16,7 @@ import (
 	common2 "infini.sh/coco/modules/assistant/common"
 	deep_research2 "infini.sh/coco/modules/assistant/deep_research_v2"
 	"infini.sh/coco/modules/assistant/deep_search"
+	"infini.sh/coco/modules/assistant/external_workflow"
 	"infini.sh/coco/modules/assistant/tools"
 	attachmentmod "infini.sh/coco/modules/attachment"
 
@@ -80,6 +81,7 @@ func CreateAssistantReplyMessage(sessionID, assistantID, requestMessageID string
 		SessionID:      sessionID,
 		MessageType:    core.MessageTypeAssistant,
 		ReplyMessageID: requestMessageID,
+		ParentID:       requestMessageID,
 		AssistantID:    assistantID,
 	}
 	now := time.Now()
@@ -111,6 +113,8 @@ func finalizeProcessing(ctx context.Context, msg *core.ChatMessage, sender core.
 
 	if err := orm.Create(ctx1, msg); err != nil {
 		_ = log.Errorf("Failed to save assistant message: %v", err)
+	} else if err := SetActiveMessage(saveCtx, msg.SessionID, msg.ID); err != nil {
+		_ = log.Warnf("Failed to update the active branch of session [%v]: %v", msg.SessionID, err)
 	}
 
 	_ = sender.SendChunkMessage(core.MessageTypeSystem,
@@ -163,6 +167,9 @@ func ProcessMessageAsync(ctx context.Context, userID string, reqMsg, replyMsg *c
 
 		reason := determineExitReason(ctx, err, taskID)
 		finalizeProcessing(ctx, replyMsg, sender, reason, err)
+		if reason == common.ReplyEndReasonCompleted {
+			updateSessionTitle(ctx, params, reqMsg, replyMsg)
+		}
 		// clear the inflight message task
 		InflightMessages.Delete(taskID)
 
@@ -187,8 +194,11 @@ func ProcessMessageAsync(ctx context.Context, userID string, reqMsg, replyMsg *c
 	params.InputValues["query"] = reqMsg.Message
 
 	// Processing pipeline
-	if params.AssistantCfg.ChatSettings.HistoryMessage.Number > 0 {
-		params.ChatHistory, params.InputValues["history"], _ = FetchSessionHistory(ctx, reqMsg, params.AssistantCfg.ChatSettings.HistoryMessage.Number)
+	if params.ChatHistory != nil {
+		// history provided by the caller, e.g. clients of the OpenAI-compatible
+		// API that send the whole conversation with every request
+	} else if params.AssistantCfg.ChatSettings.HistoryMessage.Number > 0 {
+		params.ChatHistory, params.InputValues["history"], _ = FetchSessionHistory(ctx, params, reqMsg)
 	} else {
 		params.InputValues["history"] = "</empty>"
 	}
@@ -221,8 +231,11 @@ func ProcessMessageAsync(ctx context.Context, userID string, reqMsg, replyMsg *c
 		}
 		err = deep_research2.RunDeepResearchV2(ctx, reqMsg.Message, params.AssistantCfg.DeepResearchConfig, reqMsg,
 			replyMsg, attachments,
-			sender)
+			sender, params.ResumeResearch)
 		log.Info("end running deep research")
+	case core.AssistantTypeExternalWorkflow:
+		err = external_workflow.RunExternalWorkflow(ctx, userID, params, reqMsg, replyMsg, sender)
+		log.Info("external workflow reply task done for query:", reqMsg.Message)
 	default:
 		//simple mode
 		var toolsMayHavePromisedResult = false
@@ -244,7 +257,8 @@ func ProcessMessageAsync(ctx context.Context, userID string, reqMsg, replyMsg *c
 		if params.SearchDB && !toolsMayHavePromisedResult && params.AssistantCfg.Datasource.Enabled && len(params.AssistantCfg.Datasource.GetIDs()) > 0 {
 			var fetchSize = 10
 			docs, _ := tools.InitialDocumentBriefSearch(ctx, userID, reqMsg, replyMsg, params, 0, fetchSize, sender)
-			params.InputValues["references"] = docs
+			params.InputValues["references"] = tools.FormatDocumentForReplyReferences(docs)
+			params.ReferenceDocs = docs
 		}
 
 		err = langchain.GenerateFinalResponse(ctx, reqMsg, replyMsg, params, params.InputValues, sender)
@@ -253,25 +267,50 @@ func ProcessMessageAsync(ctx context.Context, userID string, reqMsg, replyMsg *c
 	return err
 }
 
-func FetchSessionHistory(ctx context.Context, reqMsg *core.ChatMessage, size int) (*memory.ChatMessageHistory, string, error) {
-	var historyStr = strings.Builder{}
-
-	chatHistory := memory.NewChatMessageHistory(memory.WithPreviousMessages([]llms.ChatMessage{}))
+// FetchSessionHistory loads the recent messages of the session of reqMsg. When
+// the session summary is enabled, the messages already covered by the summary
+// are replaced by it and the older turns exceeding the compression threshold
+// are folded into it first.
+func FetchSessionHistory(ctx context.Context, params *common2.RAGContext, reqMsg *core.ChatMessage) (*memory.ChatMessageHistory, string, error) {
+	settings := params.AssistantCfg.ChatSettings.HistoryMessage
 
-	//get chat history
-	history, err := GetChatHistoryBySessionInternal(reqMsg.SessionID, size)
+	//get chat history, the branch of the conversation leading to reqMsg
+	tree, err := LoadMessageTree(ctx, reqMsg.SessionID, reqMsg.ID)
 	if err != nil {
 		return nil, "", err
 	}
+	history := tree.Branch(reqMsg.ID)
+	if settings.Number > 0 && len(history) > settings.Number {
+		history = history[len(history)-settings.Number:]
+	}
 
 	if len(history) <= 1 {
 		return nil, "", nil
 	}
 
+	if !settings.Summary {
+		chatHistory, historyStr := FormatChatHistory(ctx, history)
+		return chatHistory, historyStr, nil
+	}
+
+	history, summary := compressSessionHistory(ctx, params, reqMsg.SessionID, history)
+	chatHistory, historyStr := FormatChatHistory(ctx, history)
+	if summary != "" {
+		chatHistory, historyStr = withSessionSummary(ctx, chatHistory, historyStr, summary)
+	}
+	return chatHistory, historyStr, nil
+}
+
+// FormatChatHistory converts messages, sorted from the oldest to the newest,
+// into the chat memory and the text block used by the prompts.
+func FormatChatHistory(ctx context.Context, history []core.ChatMessage) (*memory.ChatMessageHistory, string) {
+	var historyStr = strings.Builder{}
+
+	chatHistory := memory.NewChatMessageHistory(memory.WithPreviousMessages([]llms.ChatMessage{}))
+
 	historyStr.WriteString("<conversation>\n")
 
-	for i := len(history) - 1; i >= 0; i-- {
-		v := history[i]
+	for _, v := range history {
 		msgText := util.SubStringWithSuffix(v.Message, 1000, "...")
 		switch v.MessageType {
 		case core.MessageTypeSystem:
@@ -289,7 +328,7 @@ func FetchSessionHistory(ctx context.Context, reqMsg *core.ChatMessage, size int
 		}
 
 		historyStr.WriteString(v.MessageType + ": " + msgText)
-		if v.DownVote > 0 {
+		if v.UpVote > 0 {
 			historyStr.WriteString(fmt.Sprintf("(%v people up voted this answer)", v.UpVote))
 		}
 		if v.DownVote > 0 {
@@ -299,7 +338,7 @@ func FetchSessionHistory(ctx context.Context, reqMsg *core.ChatMessage, size int
 	}
 	historyStr.WriteString("</conversation>")
 
-	return chatHistory, historyStr.String(), nil
+	return chatHistory, historyStr.String()
 }
 
 // attachmentWaitTimeout bounds how long ProcessMessageAsync will block waiting
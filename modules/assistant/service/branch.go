@@ -0,0 +1,260 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// maxSessionMessages bounds the messages of a session loaded to build its
+// conversation tree, and the messages walked up from an older branch.
+const maxSessionMessages = 1000
+
+// GetSessionMessagesInternal loads the newest messages of a session
+// regardless of their owner, from the oldest to the newest.
+func GetSessionMessagesInternal(sessionID string) ([]core.ChatMessage, error) {
+	docs, err := GetChatHistoryBySessionInternal(sessionID, maxSessionMessages)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(docs)
+	return docs, nil
+}
+
+// LoadMessageTree builds the conversation tree of a session from its newest
+// messages. The branches leading to leafIDs are completed by walking up the
+// parents of the leaves, so that the branch of an old message is right even
+// in a session longer than the messages loaded.
+func LoadMessageTree(ctx context.Context, sessionID string, leafIDs ...string) (*MessageTree, error) {
+	messages, err := GetSessionMessagesInternal(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	t := NewMessageTree(messages)
+	for _, id := range leafIDs {
+		if err := t.extend(ctx, sessionID, id); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// extend adds the messages from id up to the first one already in the tree.
+func (t *MessageTree) extend(ctx context.Context, sessionID, id string) error {
+	type link struct {
+		msg      core.ChatMessage
+		parentID string
+	}
+	var missing []link
+	for id != "" && len(missing) < maxSessionMessages {
+		if _, ok := t.messages[id]; ok {
+			break
+		}
+		msg := core.ChatMessage{}
+		msg.ID = id
+		ctx1 := orm.NewContextWithParent(ctx)
+		ctx1.DirectAccess()
+		exists, err := orm.GetV2(ctx1, &msg)
+		if err != nil {
+			return err
+		}
+		if !exists || msg.SessionID != sessionID {
+			break
+		}
+
+		parentID := msg.ParentID
+		if parentID == "" {
+			if msg.MessageType == core.MessageTypeAssistant {
+				parentID = msg.ReplyMessageID
+			} else if parentID, err = previousMessageID(sessionID, msg.Created); err != nil {
+				return err
+			}
+		}
+		missing = append(missing, link{msg: msg, parentID: parentID})
+		id = parentID
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		t.add(&missing[i].msg, missing[i].parentID)
+	}
+	return nil
+}
+
+// previousMessageID returns the message created right before a message saved
+// before ChatMessage.ParentID was introduced, its implied parent.
+func previousMessageID(sessionID string, created *time.Time) (string, error) {
+	if created == nil {
+		return "", nil
+	}
+	dsl := util.MapStr{
+		"size":    1,
+		"_source": []string{"id"},
+		"sort":    []util.MapStr{{"created": util.MapStr{"order": "desc"}}},
+		"query": util.MapStr{
+			"bool": util.MapStr{
+				"filter": []util.MapStr{
+					{"term": util.MapStr{"session_id": sessionID}},
+					{"range": util.MapStr{"created": util.MapStr{"lt": created}}},
+				},
+			},
+		},
+	}
+	docs := []core.ChatMessage{}
+	q := orm.Query{RawQuery: util.MustToJSONBytes(dsl)}
+	if err, _ := orm.SearchWithJSONMapper(&docs, &q); err != nil || len(docs) == 0 {
+		return "", err
+	}
+	return docs[0].ID, nil
+}
+
+// MessageTree is the conversation tree of a session. Messages saved before
+// ChatMessage.ParentID was introduced are chained in their creation order.
+type MessageTree struct {
+	messages map[string]*core.ChatMessage
+	parents  map[string]string
+	// children in their creation order, the roots are under ""
+	children map[string][]string
+	latest   string
+}
+
+// NewMessageTree builds the tree of messages sorted from the oldest to the
+// newest.
+func NewMessageTree(messages []core.ChatMessage) *MessageTree {
+	t := &MessageTree{
+		messages: make(map[string]*core.ChatMessage, len(messages)),
+		parents:  make(map[string]string, len(messages)),
+		children: map[string][]string{},
+	}
+	for i := range messages {
+		msg := &messages[i]
+		parentID := msg.ParentID
+		if parentID == "" {
+			if msg.MessageType == core.MessageTypeAssistant {
+				parentID = msg.ReplyMessageID
+			} else {
+				parentID = t.latest
+			}
+		}
+		t.add(msg, parentID)
+		t.latest = msg.ID
+	}
+	return t
+}
+
+func (t *MessageTree) add(msg *core.ChatMessage, parentID string) {
+	t.messages[msg.ID] = msg
+	t.parents[msg.ID] = parentID
+	t.children[parentID] = append(t.children[parentID], msg.ID)
+}
+
+// Get returns the message of the tree with the given ID.
+func (t *MessageTree) Get(id string) (*core.ChatMessage, bool) {
+	msg, ok := t.messages[id]
+	return msg, ok
+}
+
+// Parent returns the ID of the message id follows, "" for the first message.
+func (t *MessageTree) Parent(id string) string {
+	return t.parents[id]
+}
+
+// Branched tells whether a message was regenerated or edited in the session.
+func (t *MessageTree) Branched() bool {
+	for _, ids := range t.children {
+		if len(ids) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Branch returns the messages from the first one to leafID, the branch of
+// the latest message if leafID is not in the tree.
+func (t *MessageTree) Branch(leafID string) []core.ChatMessage {
+	if _, ok := t.messages[leafID]; !ok {
+		leafID = t.latest
+	}
+	branch := []core.ChatMessage{}
+	seen := map[string]bool{}
+	for id := leafID; id != "" && !seen[id]; id = t.parents[id] {
+		msg, ok := t.messages[id]
+		if !ok {
+			break
+		}
+		seen[id] = true
+		branch = append(branch, *msg)
+	}
+	slices.Reverse(branch)
+	return branch
+}
+
+// Leaf returns the last message of the branch continuing from id, following
+// the most recent reply or question at each step.
+func (t *MessageTree) Leaf(id string) string {
+	seen := map[string]bool{}
+	for !seen[id] {
+		seen[id] = true
+		children := t.children[id]
+		if len(children) == 0 {
+			break
+		}
+		id = children[len(children)-1]
+	}
+	return id
+}
+
+// Siblings returns the IDs of the alternatives of id in their creation order,
+// id included: the regenerated replies of a question or the edited versions
+// of a question.
+func (t *MessageTree) Siblings(id string) []string {
+	msg, ok := t.messages[id]
+	if !ok {
+		return nil
+	}
+	siblings := []string{}
+	for _, other := range t.children[t.parents[id]] {
+		if t.messages[other].MessageType == msg.MessageType {
+			siblings = append(siblings, other)
+		}
+	}
+	return siblings
+}
+
+// GetActiveMessageID returns the last message of the active branch of a
+// session, new questions are appended to it.
+func GetActiveMessageID(ctx context.Context, sessionID string) (string, error) {
+	session, exists := getSessionInternal(ctx, sessionID)
+	if exists && session.ActiveMessageID != "" {
+		return session.ActiveMessageID, nil
+	}
+	history, err := GetChatHistoryBySessionInternal(sessionID, 1)
+	if err != nil || len(history) == 0 {
+		return "", err
+	}
+	return history[0].ID, nil
+}
+
+// SetActiveMessage makes the branch ending with messageID the active branch
+// of a session.
+func SetActiveMessage(ctx context.Context, sessionID, messageID string) error {
+	session, exists := getSessionInternal(ctx, sessionID)
+	if !exists || session.ActiveMessageID == messageID {
+		return nil
+	}
+
+	// only the active branch, the title and summaries may be saved meanwhile
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.WaitForRefresh
+	return orm.UpdatePartialFields(ctx1, &session, util.MapStr{
+		"active_message_id": messageID,
+	})
+}
@@ -0,0 +1,111 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func treeMessage(id, messageType, parentID, replyTo string) core.ChatMessage {
+	msg := core.ChatMessage{MessageType: messageType, ParentID: parentID, ReplyMessageID: replyTo}
+	msg.ID = id
+	return msg
+}
+
+func branchIDs(branch []core.ChatMessage) []string {
+	ids := make([]string, 0, len(branch))
+	for _, msg := range branch {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func TestMessageTreeRegenerateAndEdit(t *testing.T) {
+	tree := NewMessageTree([]core.ChatMessage{
+		treeMessage("q1", core.MessageTypeUser, "", ""),
+		treeMessage("a1", core.MessageTypeAssistant, "q1", "q1"),
+		treeMessage("q2", core.MessageTypeUser, "a1", ""),
+		treeMessage("a2", core.MessageTypeAssistant, "q2", "q2"),
+		// regenerated reply to q2
+		treeMessage("a2b", core.MessageTypeAssistant, "q2", "q2"),
+		// edited q2
+		treeMessage("q2b", core.MessageTypeUser, "a1", ""),
+		treeMessage("a3", core.MessageTypeAssistant, "q2b", "q2b"),
+	})
+
+	if !tree.Branched() {
+		t.Fatal("expected a branched tree")
+	}
+	if got, want := branchIDs(tree.Branch("a2")), []string{"q1", "a1", "q2", "a2"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected branch: got %v, want %v", got, want)
+	}
+	// the latest message by default
+	if got, want := branchIDs(tree.Branch("")), []string{"q1", "a1", "q2b", "a3"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected default branch: got %v, want %v", got, want)
+	}
+	if got, want := tree.Siblings("a2"), []string{"a2", "a2b"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected reply siblings: got %v, want %v", got, want)
+	}
+	if got, want := tree.Siblings("q2b"), []string{"q2", "q2b"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected question siblings: got %v, want %v", got, want)
+	}
+	if got := tree.Leaf("q2"); got != "a2b" {
+		t.Fatalf("expected the latest reply as leaf, got %v", got)
+	}
+	if got := tree.Leaf("q1"); got != "a3" {
+		t.Fatalf("expected the latest branch leaf, got %v", got)
+	}
+}
+
+func TestMessageTreeLegacyMessages(t *testing.T) {
+	tree := NewMessageTree([]core.ChatMessage{
+		treeMessage("q1", core.MessageTypeUser, "", ""),
+		treeMessage("a1", core.MessageTypeAssistant, "", "q1"),
+		treeMessage("q2", core.MessageTypeUser, "", ""),
+		treeMessage("a2", core.MessageTypeAssistant, "", "q2"),
+		// a question sent after branching was introduced
+		treeMessage("q3", core.MessageTypeUser, "a2", ""),
+	})
+
+	if tree.Branched() {
+		t.Fatal("expected a linear tree")
+	}
+	if got := tree.Parent("q2"); got != "a1" {
+		t.Fatalf("expected legacy messages to be chained, got parent %v", got)
+	}
+	if got, want := branchIDs(tree.Branch("q3")), []string{"q1", "a1", "q2", "a2", "q3"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected branch: got %v, want %v", got, want)
+	}
+}
+
+func TestMessageTreeExtendedWithOlderBranch(t *testing.T) {
+	// only the newest messages are loaded, q2 was answered a long time ago
+	// and is regenerated now
+	tree := NewMessageTree([]core.ChatMessage{
+		treeMessage("q9", core.MessageTypeUser, "a8", ""),
+		treeMessage("a9", core.MessageTypeAssistant, "q9", "q9"),
+	})
+	if got := branchIDs(tree.Branch("q2")); !slices.Equal(got, []string{"q9", "a9"}) {
+		t.Fatalf("expected the latest branch for an unknown message, got %v", got)
+	}
+
+	// the parents walked up from q2, added from the oldest
+	q1 := treeMessage("q1", core.MessageTypeUser, "", "")
+	a1 := treeMessage("a1", core.MessageTypeAssistant, "q1", "q1")
+	q2 := treeMessage("q2", core.MessageTypeUser, "a1", "")
+	tree.add(&q1, "")
+	tree.add(&a1, "q1")
+	tree.add(&q2, "a1")
+
+	if got, want := branchIDs(tree.Branch("q2")), []string{"q1", "a1", "q2"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected branch: got %v, want %v", got, want)
+	}
+	if got, want := branchIDs(tree.Branch("")), []string{"q9", "a9"}; !slices.Equal(got, want) {
+		t.Fatalf("expected the latest message to stay the default leaf, got %v", got)
+	}
+}
@@ -0,0 +1,258 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/langchain"
+	"infini.sh/coco/modules/common"
+	llmmodule "infini.sh/coco/modules/llm"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+)
+
+const (
+	// evaluationQuestionTimeout bounds the answer and the judgement of a question
+	evaluationQuestionTimeout = 10 * time.Minute
+	// evaluationRunTimeout bounds a whole evaluation run
+	evaluationRunTimeout = 6 * time.Hour
+)
+
+// StartEvaluationRun runs the questions of set against assistant in the
+// background, the answers are scored by the judge model, the default
+// answering model if nil. The returned run is saved after every question.
+func StartEvaluationRun(ctx context.Context, userID string, assistant *core.Assistant, set *core.EvaluationSet, judge *core.ModelConfig) (*core.EvaluationRun, error) {
+	run := &core.EvaluationRun{
+		SetID:            set.ID,
+		SetName:          set.Name,
+		AssistantID:      assistant.ID,
+		AssistantUpdated: assistant.Updated,
+		Status:           core.EvaluationRunRunning,
+	}
+	if assistant.AnsweringModel.Name != "" {
+		run.AnsweringModel = assistant.AnsweringModel.ProviderID + "/" + assistant.AnsweringModel.Name
+	}
+
+	// a judge model is only needed to score the expected answers
+	needsJudge := slices.ContainsFunc(set.Questions, func(q core.EvaluationQuestion) bool { return q.ExpectedAnswer != "" })
+	if needsJudge {
+		run.JudgeModel = resolveJudgeModel(judge)
+		if run.JudgeModel == nil {
+			return nil, errors.New("no judge model is configured to score the expected answers")
+		}
+	}
+
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.Refresh = orm.WaitForRefresh
+	if err := orm.Create(ctx1, run); err != nil {
+		return nil, err
+	}
+
+	// detach from the request, keeping the user for the ORM hooks
+	runCtx, cancel := context.WithTimeout(security.CloneContext(ctx), evaluationRunTimeout)
+	go func() {
+		defer cancel()
+		runEvaluation(runCtx, userID, set, *run)
+	}()
+	return run, nil
+}
+
+// resolveJudgeModel resolves the configured judge model, falling back to the
+// default answering model.
+func resolveJudgeModel(cfg *core.ModelConfig) *core.ModelConfig {
+	model := core.ModelConfig{}
+	if cfg != nil {
+		model = *cfg
+	}
+	resolved := llmmodule.ResolveAssistantModel(core.AssistantModelUseAnswering, &core.ModelId{
+		ProviderID: model.ProviderID,
+		ID:         model.Name,
+	})
+	if resolved == nil {
+		return nil
+	}
+	model.ProviderID = resolved.ProviderID
+	model.Name = resolved.ID
+	return &model
+}
+
+func runEvaluation(ctx context.Context, userID string, set *core.EvaluationSet, run core.EvaluationRun) {
+	defer func() {
+		if r := recover(); r != nil {
+			run.Status = core.EvaluationRunFailed
+			run.Error = fmt.Sprint(r)
+			saveEvaluationRun(ctx, &run)
+		}
+	}()
+
+	for _, q := range set.Questions {
+		if ctx.Err() != nil {
+			break
+		}
+		run.Results = append(run.Results, evaluateQuestion(ctx, userID, run.AssistantID, run.JudgeModel, q))
+		run.Score = scoreEvaluation(run.Results)
+		saveEvaluationRun(ctx, &run)
+	}
+
+	now := time.Now()
+	run.Finished = &now
+	run.Status = core.EvaluationRunComplete
+	if err := ctx.Err(); err != nil {
+		run.Status = core.EvaluationRunFailed
+		run.Error = err.Error()
+	}
+	saveEvaluationRun(ctx, &run)
+	log.Infof("evaluation run [%v] of assistant [%v] on set [%v]: %v", run.ID, run.AssistantID, run.SetName, run.Status)
+}
+
+func saveEvaluationRun(ctx context.Context, run *core.EvaluationRun) {
+	// do not let the cancellation of a run prevent saving its state
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	ctx1 := orm.NewContextWithParent(saveCtx)
+	ctx1.Refresh = orm.ImmediatelyRefresh
+	if err := orm.Update(ctx1, run); err != nil {
+		_ = log.Errorf("failed to save evaluation run [%v]: %v", run.ID, err)
+	}
+}
+
+func evaluateQuestion(ctx context.Context, userID, assistantID string, judge *core.ModelConfig, q core.EvaluationQuestion) core.EvaluationResult {
+	ctx, cancel := context.WithTimeout(ctx, evaluationQuestionTimeout)
+	defer cancel()
+
+	result := core.EvaluationResult{QuestionID: q.ID, Question: q.Question}
+
+	start := time.Now()
+	answer, reply, err := AskAssistantSyncWithReply(ctx, userID, assistantID, q.Question, nil)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Answer = answer
+	result.Sources = replySourceIDs(reply)
+
+	if len(q.ExpectedDocuments) > 0 {
+		recall := retrievalRecall(q.ExpectedDocuments, result.Sources)
+		result.RetrievalRecall = &recall
+	}
+
+	if q.ExpectedAnswer != "" && judge != nil {
+		judgement, err := langchain.JudgeAnswer(ctx, judge, q.Question, q.ExpectedAnswer, answer)
+		if err != nil {
+			result.Error = fmt.Sprintf("failed to judge the answer: %v", err)
+			return result
+		}
+		result.AnswerSimilarity = &judgement.Score
+		result.Judgement = judgement.Reason
+	}
+	return result
+}
+
+// replySourceIDs returns the IDs of the documents a reply is based on, the
+// picked sources and the cited documents. The fetched sources are left out,
+// most of them are search hits the answer does not use.
+func replySourceIDs(reply *core.ChatMessage) []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, d := range reply.Details {
+		if d.Type != common.PickSource {
+			continue
+		}
+		// the payload is a typed value when the reply was not reloaded
+		buf, err := json.Marshal(d.Payload)
+		if err != nil {
+			continue
+		}
+		items := []struct {
+			ID string `json:"id"`
+		}{}
+		if json.Unmarshal(buf, &items) != nil {
+			continue
+		}
+		for _, item := range items {
+			add(item.ID)
+		}
+	}
+	for _, c := range reply.Citations {
+		add(c.DocumentID)
+	}
+	return ids
+}
+
+// retrievalRecall is the share of the expected documents in the retrieved
+// ones.
+func retrievalRecall(expected, retrieved []string) float64 {
+	if len(expected) == 0 {
+		return 0
+	}
+	found := 0
+	for _, id := range expected {
+		if slices.Contains(retrieved, id) {
+			found++
+		}
+	}
+	return float64(found) / float64(len(expected))
+}
+
+// scoreEvaluation aggregates the results of a run. The recall and similarity
+// are averaged over the questions expecting them, the latency over the
+// questions answered.
+func scoreEvaluation(results []core.EvaluationResult) core.EvaluationScore {
+	score := core.EvaluationScore{Questions: len(results)}
+
+	var recall, similarity float64
+	var recallCount, similarityCount int
+	latencies := []int64{}
+	for _, r := range results {
+		if r.Error != "" {
+			score.Errors++
+		}
+		if r.RetrievalRecall != nil {
+			recall += *r.RetrievalRecall
+			recallCount++
+		}
+		if r.AnswerSimilarity != nil {
+			similarity += *r.AnswerSimilarity
+			similarityCount++
+		}
+		if r.Answer != "" {
+			latencies = append(latencies, r.LatencyMs)
+		}
+	}
+	if recallCount > 0 {
+		score.RetrievalRecall = recall / float64(recallCount)
+	}
+	if similarityCount > 0 {
+		score.AnswerSimilarity = similarity / float64(similarityCount)
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		var total int64
+		for _, l := range latencies {
+			total += l
+		}
+		score.AvgLatencyMs = total / int64(len(latencies))
+		// nearest rank
+		rank := (95*len(latencies) + 99) / 100
+		score.P95LatencyMs = latencies[rank-1]
+	}
+	return score
+}
@@ -0,0 +1,51 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+)
+
+func TestReplySourceIDs(t *testing.T) {
+	reply := &core.ChatMessage{
+		Details: []core.ProcessingDetails{
+			{Type: common.FetchSource, Payload: []map[string]interface{}{{"id": "d1"}, {"id": "d2"}}},
+			{Type: common.PickSource, Payload: []interface{}{map[string]interface{}{"id": "d2", "explain": "relevant"}}},
+			{Type: common.Tools, Description: "no sources"},
+		},
+		Citations: []core.Citation{{Number: 1, DocumentID: "d3"}},
+	}
+	if got, want := replySourceIDs(reply), []string{"d2", "d3"}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected sources: got %v, want %v", got, want)
+	}
+}
+
+func TestScoreEvaluation(t *testing.T) {
+	recall := retrievalRecall([]string{"d1", "d2"}, []string{"d2", "d3"})
+	if recall != 0.5 {
+		t.Fatalf("unexpected recall: %v", recall)
+	}
+	full, none := 1.0, 0.0
+
+	score := scoreEvaluation([]core.EvaluationResult{
+		{Answer: "a", LatencyMs: 100, RetrievalRecall: &recall, AnswerSimilarity: &full},
+		{Answer: "b", LatencyMs: 300, AnswerSimilarity: &none},
+		{Answer: "c", LatencyMs: 200, RetrievalRecall: &full},
+		{Error: "timeout", LatencyMs: 9000},
+	})
+	if score.Questions != 4 || score.Errors != 1 {
+		t.Fatalf("unexpected counts: %+v", score)
+	}
+	if score.RetrievalRecall != 0.75 || score.AnswerSimilarity != 0.5 {
+		t.Fatalf("unexpected averages: %+v", score)
+	}
+	if score.AvgLatencyMs != 200 || score.P95LatencyMs != 300 {
+		t.Fatalf("unexpected latencies: %+v", score)
+	}
+}
@@ -0,0 +1,129 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// FeedbackID is the ID of the feedback of a user on a message, a new vote of
+// the user replaces the previous one.
+func FeedbackID(messageID, userID string) string {
+	return util.MD5digest(fmt.Sprintf("%v_%v", messageID, userID))
+}
+
+// SaveMessageFeedback stores the feedback of a user on msg and refreshes the
+// vote counters of the message.
+func SaveMessageFeedback(ctx context.Context, msg *core.ChatMessage, feedback *core.MessageFeedback) error {
+	feedback.ID = FeedbackID(msg.ID, feedback.UserID)
+	feedback.SessionID = msg.SessionID
+	feedback.MessageID = msg.ID
+	feedback.AssistantID = msg.AssistantID
+	if err := feedback.Validate(); err != nil {
+		return err
+	}
+
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.WaitForRefresh
+	if err := orm.Save(ctx1, feedback); err != nil {
+		return err
+	}
+	return refreshMessageVotes(ctx, msg)
+}
+
+// DeleteMessageFeedback removes the feedback of a user on msg, it returns
+// false if there was none.
+func DeleteMessageFeedback(ctx context.Context, msg *core.ChatMessage, userID string) (bool, error) {
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.WaitForRefresh
+
+	feedback := core.MessageFeedback{}
+	feedback.ID = FeedbackID(msg.ID, userID)
+	exists, err := orm.GetV2(ctx1, &feedback)
+	if err != nil || !exists {
+		return false, err
+	}
+	if err := orm.Delete(ctx1, &feedback); err != nil {
+		return false, err
+	}
+	return true, refreshMessageVotes(ctx, msg)
+}
+
+// maxVoteRefreshAttempts bounds the recounts of refreshMessageVotes.
+const maxVoteRefreshAttempts = 5
+
+// refreshMessageVotes recounts ChatMessage.UpVote and DownVote from the
+// feedback records of the message.
+func refreshMessageVotes(ctx context.Context, msg *core.ChatMessage) error {
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	ctx1.Refresh = orm.WaitForRefresh
+
+	return convergeVotes(msg,
+		func() (int, int, error) {
+			return countMessageVotes(msg.ID)
+		},
+		func(up, down int) error {
+			// only the counters, the message may be updated meanwhile
+			return orm.UpdatePartialFields(ctx1, msg, util.MapStr{
+				"up_vote":   up,
+				"down_vote": down,
+			})
+		})
+}
+
+// convergeVotes writes the counted votes until a count made after the write
+// matches it. A request counting before the feedback of a concurrent one is
+// visible may write a stale count after the fresh one, so every writer checks
+// its write: the last write is then always followed by a count seeing every
+// feedback saved before it.
+func convergeVotes(msg *core.ChatMessage, count func() (int, int, error), write func(up, down int) error) error {
+	for attempt := 0; attempt < maxVoteRefreshAttempts; attempt++ {
+		up, down, err := count()
+		if err != nil {
+			return err
+		}
+		if attempt > 0 && up == msg.UpVote && down == msg.DownVote {
+			return nil
+		}
+		if err := write(up, down); err != nil {
+			return err
+		}
+		msg.UpVote, msg.DownVote = up, down
+	}
+	return nil
+}
+
+func countMessageVotes(messageID string) (int, int, error) {
+	up, err := orm.Count(core.MessageFeedback{}, util.MustToJSONBytes(voteCountQuery(messageID, core.FeedbackVoteUp)))
+	if err != nil {
+		return 0, 0, err
+	}
+	down, err := orm.Count(core.MessageFeedback{}, util.MustToJSONBytes(voteCountQuery(messageID, core.FeedbackVoteDown)))
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(up), int(down), nil
+}
+
+func voteCountQuery(messageID, vote string) util.MapStr {
+	return util.MapStr{
+		"query": util.MapStr{
+			"bool": util.MapStr{
+				"filter": []util.MapStr{
+					{"term": util.MapStr{"message_id": messageID}},
+					{"term": util.MapStr{"vote": vote}},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,86 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func TestFeedbackID(t *testing.T) {
+	if FeedbackID("m1", "u1") != FeedbackID("m1", "u1") {
+		t.Fatal("expected a stable ID, a new vote replaces the previous one")
+	}
+	if FeedbackID("m1", "u1") == FeedbackID("m1", "u2") || FeedbackID("m1", "u1") == FeedbackID("m2", "u1") {
+		t.Fatal("expected an ID per message and user")
+	}
+}
+
+func TestConvergeVotesRewritesStaleCount(t *testing.T) {
+	// the first count misses the vote of a concurrent request
+	counts := [][2]int{{1, 0}, {2, 1}, {2, 1}}
+	stored := [2]int{}
+	writes := 0
+	msg := &core.ChatMessage{}
+
+	err := convergeVotes(msg,
+		func() (int, int, error) {
+			c := counts[0]
+			if len(counts) > 1 {
+				counts = counts[1:]
+			}
+			return c[0], c[1], nil
+		},
+		func(up, down int) error {
+			writes++
+			stored = [2]int{up, down}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != [2]int{2, 1} || msg.UpVote != 2 || msg.DownVote != 1 {
+		t.Fatalf("expected the fresh count to be stored, got %v on %v/%v", stored, msg.UpVote, msg.DownVote)
+	}
+	if writes != 2 {
+		t.Fatalf("expected 2 writes, got %d", writes)
+	}
+}
+
+func TestConvergeVotesStopsWhenStable(t *testing.T) {
+	writes := 0
+	msg := &core.ChatMessage{UpVote: 3}
+	err := convergeVotes(msg,
+		func() (int, int, error) { return 3, 0, nil },
+		func(up, down int) error { writes++; return nil })
+	if err != nil || writes != 1 {
+		t.Fatalf("expected a single write, got %d: %v", writes, err)
+	}
+
+	// a message whose votes keep changing is not retried forever
+	n := 0
+	err = convergeVotes(msg,
+		func() (int, int, error) { n++; return n, 0, nil },
+		func(up, down int) error { return nil })
+	if err != nil || n != maxVoteRefreshAttempts {
+		t.Fatalf("expected %d counts, got %d: %v", maxVoteRefreshAttempts, n, err)
+	}
+}
+
+func TestConvergeVotesReturnsErrors(t *testing.T) {
+	failed := errors.New("failed")
+	msg := &core.ChatMessage{}
+	if err := convergeVotes(msg, func() (int, int, error) { return 0, 0, failed }, nil); !errors.Is(err, failed) {
+		t.Fatalf("expected the count error, got %v", err)
+	}
+	err := convergeVotes(msg,
+		func() (int, int, error) { return 1, 0, nil },
+		func(up, down int) error { return failed })
+	if !errors.Is(err, failed) || msg.UpVote != 0 {
+		t.Fatalf("expected the write error without updating the message, got %v", err)
+	}
+}
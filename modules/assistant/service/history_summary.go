@@ -0,0 +1,131 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/cihub/seelog"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/memory"
+	"infini.sh/coco/core"
+	common2 "infini.sh/coco/modules/assistant/common"
+	"infini.sh/coco/modules/assistant/langchain"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// minRecentMessages is the number of newest messages never folded into the
+// summary: the current request and the previous turn.
+const minRecentMessages = 3
+
+// compressSessionHistory drops the messages of history, sorted from the
+// oldest to the newest, already covered by the session summary. If the rest
+// still exceeds the compression threshold, the older turns are folded into
+// the summary, which is persisted on the session. It returns the remaining
+// messages and the summary to use.
+func compressSessionHistory(ctx context.Context, params *common2.RAGContext, sessionID string, history []core.ChatMessage) ([]core.ChatMessage, string) {
+	session, exists := getSessionInternal(ctx, sessionID)
+	if !exists {
+		return history, ""
+	}
+
+	history = messagesAfterSummary(history, &session)
+	summary := session.HistorySummary
+
+	threshold := params.AssistantCfg.ChatSettings.HistoryMessage.CompressionThreshold
+	split := summarySplit(history, threshold)
+	if split <= 0 {
+		return history, summary
+	}
+
+	older := history[:split]
+	_, olderStr := FormatChatHistory(ctx, older)
+	newSummary, err := langchain.SummarizeConversation(ctx, params.GetHistorySummaryModel(), session.HistorySummary, olderStr, threshold/2)
+	if err != nil || newSummary == "" {
+		// the prompt assembly trims the history instead
+		_ = log.Warnf("failed to summarize the history of session [%v]: %v", sessionID, err)
+		return history, summary
+	}
+
+	// only the summary fields, the title may be saved meanwhile
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	err = orm.UpdatePartialFields(ctx1, &session, util.MapStr{
+		"history_summary":  newSummary,
+		"summarized_until": older[len(older)-1].Created,
+	})
+	if err != nil {
+		_ = log.Errorf("failed to save the summary of session [%v]: %v", sessionID, err)
+	}
+	log.Debugf("folded %v messages into the summary of session [%v]", len(older), sessionID)
+
+	return history[split:], newSummary
+}
+
+// messagesAfterSummary drops the messages created before the session summary
+// was last updated.
+func messagesAfterSummary(history []core.ChatMessage, session *core.Session) []core.ChatMessage {
+	if session.HistorySummary == "" || session.SummarizedUntil == nil {
+		return history
+	}
+	for i, msg := range history {
+		if msg.Created != nil && msg.Created.After(*session.SummarizedUntil) {
+			return history[i:]
+		}
+	}
+	return history[len(history)-1:]
+}
+
+// summarySplit returns how many of the oldest messages should be folded into
+// the summary, so that the newest ones fit into half of the threshold, or 0
+// if the history is small enough.
+func summarySplit(history []core.ChatMessage, threshold int) int {
+	if threshold <= 0 || len(history) <= minRecentMessages {
+		return 0
+	}
+
+	total := 0
+	for _, msg := range history {
+		total += summaryMessageSize(&msg)
+	}
+	if total <= threshold {
+		return 0
+	}
+
+	split := len(history) - minRecentMessages
+	size := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		size += summaryMessageSize(&history[i])
+		if size > threshold/2 {
+			split = min(split, i+1)
+			break
+		}
+	}
+	return split
+}
+
+// summaryMessageSize is the length of a message in the history, in
+// characters and with the same per-message limit as FormatChatHistory.
+func summaryMessageSize(msg *core.ChatMessage) int {
+	const maxMessageSize = 1000
+	return min(utf8.RuneCountInString(msg.Message), maxMessageSize)
+}
+
+// withSessionSummary puts the session summary in front of the history.
+func withSessionSummary(ctx context.Context, chatHistory *memory.ChatMessageHistory, historyStr string, summary string) (*memory.ChatMessageHistory, string) {
+	summaryMsg := llms.SystemChatMessage{Content: "Summary of the earlier conversation:\n" + summary}
+	messages, _ := chatHistory.Messages(ctx)
+	chatHistory = memory.NewChatMessageHistory(memory.WithPreviousMessages(append([]llms.ChatMessage{summaryMsg}, messages...)))
+
+	sb := strings.Builder{}
+	sb.WriteString("<summary>\n")
+	sb.WriteString(summary)
+	sb.WriteString("\n</summary>\n")
+	sb.WriteString(historyStr)
+	return chatHistory, sb.String()
+}
@@ -0,0 +1,79 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"infini.sh/coco/core"
+)
+
+func historyOfSizes(sizes ...int) []core.ChatMessage {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := make([]core.ChatMessage, len(sizes))
+	for i, size := range sizes {
+		created := start.Add(time.Duration(i) * time.Minute)
+		history[i].Created = &created
+		history[i].Message = strings.Repeat("x", size)
+	}
+	return history
+}
+
+func TestSummarySplit(t *testing.T) {
+	tests := []struct {
+		name      string
+		sizes     []int
+		threshold int
+		want      int
+	}{
+		{"disabled", []int{500, 500, 500, 500}, 0, 0},
+		{"below threshold", []int{100, 100, 100, 100}, 1000, 0},
+		{"too few messages", []int{900, 900, 900}, 1000, 0},
+		{"keeps newest within half threshold", []int{400, 400, 400, 200, 200, 200}, 1000, 3},
+		{"keeps the last turn at least", []int{900, 900, 900, 900}, 1000, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarySplit(historyOfSizes(tt.sizes...), tt.threshold); got != tt.want {
+				t.Fatalf("expected split %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSummarySplitCountsCharacters(t *testing.T) {
+	// 200 characters but 600 bytes each, below the threshold
+	history := historyOfSizes(0, 0, 0, 0)
+	for i := range history {
+		history[i].Message = strings.Repeat("中", 200)
+	}
+	if got := summarySplit(history, 1000); got != 0 {
+		t.Fatalf("expected split 0, got %d", got)
+	}
+}
+
+func TestMessagesAfterSummary(t *testing.T) {
+	history := historyOfSizes(1, 2, 3, 4)
+
+	session := &core.Session{}
+	if got := messagesAfterSummary(history, session); len(got) != 4 {
+		t.Fatalf("expected all messages without a summary, got %d", len(got))
+	}
+
+	// the summary written with the title covers no message
+	session.Summary = "the user asks about deployment"
+	if got := messagesAfterSummary(history, session); len(got) != 4 {
+		t.Fatalf("expected all messages with only a title summary, got %d", len(got))
+	}
+
+	session.HistorySummary = "earlier turns"
+	session.SummarizedUntil = history[1].Created
+	got := messagesAfterSummary(history, session)
+	if len(got) != 2 || len(got[0].Message) != 3 {
+		t.Fatalf("expected the two messages after the summary, got %d", len(got))
+	}
+}
@@ -0,0 +1,165 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/langchain"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// inputPreprocessPlaceholder is replaced with the message of the user in the
+// input preprocessing template of the chat settings.
+const inputPreprocessPlaceholder = "{{text}}"
+
+// GetPromptTemplate returns a shared prompt template.
+func GetPromptTemplate(ctx context.Context, id string) (*core.PromptTemplate, bool, error) {
+	obj := &core.PromptTemplate{}
+	obj.ID = id
+
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectReadAccess()
+	exists, err := orm.GetV2(ctx1, obj)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	return obj, true, nil
+}
+
+// ValidateAssistantPrompts checks the prompt templates of an assistant
+// against the variables the chat pipeline supplies, so the errors are found
+// when saving the assistant rather than when chatting.
+func ValidateAssistantPrompts(ctx context.Context, assistant *core.Assistant) error {
+	if err := validatePromptConfig(ctx, core.PromptUsageAnswering, assistant.AnsweringModel.PromptConfig); err != nil {
+		return fmt.Errorf("answering model: %w", err)
+	}
+
+	if assistant.Type == core.AssistantTypeDeepThink && assistant.Config != nil {
+		cfg := core.DeepThinkConfig{}
+		if err := util.FromJSONBytes(util.MustToJSONBytes(assistant.Config), &cfg); err != nil {
+			return err
+		}
+		if err := validatePromptConfig(ctx, core.PromptUsageIntentAnalysis, cfg.IntentAnalysisModel.PromptConfig); err != nil {
+			return fmt.Errorf("intent analysis model: %w", err)
+		}
+		if err := validatePromptConfig(ctx, core.PromptUsagePickingDoc, cfg.PickingDocModel.PromptConfig); err != nil {
+			return fmt.Errorf("picking doc model: %w", err)
+		}
+	}
+
+	if tpl := assistant.ChatSettings.InputPreprocessTemplate; tpl != "" && !strings.Contains(tpl, inputPreprocessPlaceholder) {
+		return fmt.Errorf("input preprocessing template must contain %v", inputPreprocessPlaceholder)
+	}
+	return nil
+}
+
+func validatePromptConfig(ctx context.Context, usage string, cfg *core.PromptConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.TemplateID != "" {
+		tpl, exists, err := GetPromptTemplate(ctx, cfg.TemplateID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("prompt template [%v] not found", cfg.TemplateID)
+		}
+		if tpl.Usage != usage {
+			return fmt.Errorf("prompt template [%v] is for %v prompts, not %v", tpl.Name, tpl.Usage, usage)
+		}
+		if err := langchain.ValidatePromptTemplate(usage, tpl.Template, tpl.Variables); err != nil {
+			return fmt.Errorf("prompt template [%v]: %w", tpl.Name, err)
+		}
+		return nil
+	}
+	if cfg.PromptTemplate == "" {
+		return nil
+	}
+	return langchain.ValidatePromptTemplate(usage, cfg.PromptTemplate, cfg.Variables)
+}
+
+// resolvePromptConfig replaces a reference to a shared prompt template with
+// the template. A template deleted since is logged, the inline template or
+// the default one is used instead.
+func resolvePromptConfig(cfg *core.PromptConfig) {
+	if cfg == nil || cfg.TemplateID == "" {
+		return
+	}
+	tpl, exists, err := GetPromptTemplate(context.Background(), cfg.TemplateID)
+	if err != nil || !exists {
+		_ = log.Warnf("prompt template [%v] not found: %v", cfg.TemplateID, err)
+		return
+	}
+	cfg.PromptTemplate = tpl.Template
+	cfg.Variables = tpl.Variables
+}
+
+// promptTemplateReferencesBatchSize is how many assistants are checked per
+// query for the references to a prompt template.
+const promptTemplateReferencesBatchSize = 500
+
+// AssistantsUsingPromptTemplate returns the IDs of the assistants referencing
+// a shared prompt template for prompts of another usage than usage, or for
+// any prompt if usage is empty. The prompts are not indexed, every assistant
+// is checked.
+func AssistantsUsingPromptTemplate(templateID string, usage string) ([]string, error) {
+	ids := []string{}
+	var searchAfter []interface{}
+	for {
+		dsl := util.MapStr{
+			"size":    promptTemplateReferencesBatchSize,
+			"sort":    []util.MapStr{{"id": util.MapStr{"order": "asc"}}},
+			"_source": []string{"id", "type", "answering_model", "config"},
+		}
+		if searchAfter != nil {
+			dsl["search_after"] = searchAfter
+		}
+		assistants := []core.Assistant{}
+		q := orm.Query{RawQuery: util.MustToJSONBytes(dsl)}
+		if err, _ := orm.SearchWithJSONMapper(&assistants, &q); err != nil {
+			return nil, err
+		}
+		for i := range assistants {
+			searchAfter = []interface{}{assistants[i].ID}
+			for _, used := range promptTemplateUsages(&assistants[i], templateID) {
+				if usage == "" || used != usage {
+					ids = append(ids, assistants[i].ID)
+					break
+				}
+			}
+		}
+		if len(assistants) < promptTemplateReferencesBatchSize {
+			return ids, nil
+		}
+	}
+}
+
+// promptTemplateUsages returns the usages of the prompts of an assistant
+// referencing a shared prompt template.
+func promptTemplateUsages(assistant *core.Assistant, templateID string) []string {
+	usages := []string{}
+	add := func(usage string, cfg *core.PromptConfig) {
+		if cfg != nil && cfg.TemplateID == templateID {
+			usages = append(usages, usage)
+		}
+	}
+
+	add(core.PromptUsageAnswering, assistant.AnsweringModel.PromptConfig)
+	if assistant.Type == core.AssistantTypeDeepThink && assistant.Config != nil {
+		cfg := core.DeepThinkConfig{}
+		if err := util.FromJSONBytes(util.MustToJSONBytes(assistant.Config), &cfg); err == nil {
+			add(core.PromptUsageIntentAnalysis, cfg.IntentAnalysisModel.PromptConfig)
+			add(core.PromptUsagePickingDoc, cfg.PickingDocModel.PromptConfig)
+		}
+	}
+	return usages
+}
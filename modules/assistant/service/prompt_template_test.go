@@ -0,0 +1,37 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func TestPromptTemplateUsages(t *testing.T) {
+	assistant := &core.Assistant{Type: core.AssistantTypeDeepThink}
+	assistant.AnsweringModel.PromptConfig = &core.PromptConfig{TemplateID: "t1"}
+	assistant.Config = map[string]interface{}{
+		"intent_analysis_model": map[string]interface{}{"prompt": map[string]interface{}{"template_id": "t2"}},
+		"picking_doc_model":     map[string]interface{}{"prompt": map[string]interface{}{"template_id": "t1"}},
+	}
+
+	if got, want := promptTemplateUsages(assistant, "t1"), []string{core.PromptUsageAnswering, core.PromptUsagePickingDoc}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, want := promptTemplateUsages(assistant, "t2"), []string{core.PromptUsageIntentAnalysis}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got := promptTemplateUsages(assistant, "t3"); len(got) != 0 {
+		t.Fatalf("expected no usage, got %v", got)
+	}
+
+	// the deep think config of the other assistants is not used
+	assistant.Type = core.AssistantTypeSimple
+	if got, want := promptTemplateUsages(assistant, "t1"), []string{core.PromptUsageAnswering}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,468 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// nodeID identifies this process among the nodes sharing the same store.
+var nodeID = util.GetUUID()
+
+// replyStreams holds the buffers of replies generated on this node, keyed by
+// reply message ID.
+var replyStreams = sync.Map{}
+
+// replyStreamFlushInterval is how often buffered chunks are persisted and the
+// cancel flag set by other nodes is checked.
+const replyStreamFlushInterval = time.Second
+
+// replyStreamRetention is how long a finished stream stays resumable.
+const replyStreamRetention = 10 * time.Minute
+
+// replyStreamHeartbeatInterval is how often the owning node renews the
+// heartbeat of a running stream that produced no chunks in the meantime.
+const replyStreamHeartbeatInterval = 10 * time.Second
+
+// replyStreamLease is how long a running stream is trusted without a
+// heartbeat, after which other nodes consider it finished.
+const replyStreamLease = 3 * replyStreamHeartbeatInterval
+
+var errReplyStreamNotFound = errors.New("reply stream not found")
+
+var errReplyStreamAbandoned = errors.New("the node generating the reply stopped")
+
+// ReplyStreamSender is a core.MessageSender that records every chunk of a
+// reply, so that clients can re-attach to it from any offset, and forwards
+// them to the client that started the reply as long as it stays connected.
+type ReplyStreamSender struct {
+	ctx              context.Context
+	reqMsg, replyMsg *core.ChatMessage
+	taskID           string
+
+	mu        sync.Mutex
+	inner     core.MessageSender
+	chunks    []*core.MessageChunk
+	persisted int
+	finished  bool
+	notify    chan struct{}
+	record    core.ReplyStream
+}
+
+// NewReplyStreamSender registers a resumable stream for replyMsg. ctx must be
+// the context of the reply task: once it is done, chunks other than reply_end
+// are rejected, just like HTTPStreamSender does.
+func NewReplyStreamSender(ctx context.Context, reqMsg, replyMsg *core.ChatMessage, inner core.MessageSender) *ReplyStreamSender {
+	s := &ReplyStreamSender{
+		ctx:      ctx,
+		reqMsg:   reqMsg,
+		replyMsg: replyMsg,
+		taskID:   GetReplyMessageTaskID(reqMsg.SessionID, reqMsg.ID),
+		inner:    inner,
+		notify:   make(chan struct{}),
+	}
+	s.record = core.ReplyStream{
+		SessionID:        reqMsg.SessionID,
+		RequestMessageID: reqMsg.ID,
+		NodeID:           nodeID,
+		Status:           core.ReplyStreamStatusRunning,
+		HeartbeatAt:      time.Now(),
+	}
+	s.record.ID = replyMsg.ID
+
+	if err := orm.Create(s.ormContext(), &s.record); err != nil {
+		_ = log.Warnf("failed to save reply stream [%v], it can only be resumed on this node: %v", replyMsg.ID, err)
+	}
+	replyStreams.Store(replyMsg.ID, s)
+
+	go s.run()
+	return s
+}
+
+func (s *ReplyStreamSender) SendChunkMessage(messageType, chunkType, messageChunk string, chunkSequence int) error {
+	msg := core.NewMessageChunk(s.reqMsg.SessionID, s.replyMsg.ID, messageType, s.reqMsg.ID, chunkType, messageChunk, chunkSequence)
+	return s.SendMessage(msg)
+}
+
+func (s *ReplyStreamSender) SendMessage(msg *core.MessageChunk) error {
+	if msg == nil || (msg.ChunkType == common.Response && strings.TrimSpace(msg.MessageChunk) == "") {
+		return nil
+	}
+
+	if msg.ChunkType != common.ReplyEnd && s.ctx.Err() != nil {
+		return fmt.Errorf("reply task stopped: %w", s.ctx.Err())
+	}
+
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return nil
+	}
+	msg.Offset = len(s.chunks) + 1
+	s.chunks = append(s.chunks, msg)
+	if msg.ChunkType == common.ReplyEnd {
+		s.finished = true
+	}
+	close(s.notify)
+	s.notify = make(chan struct{})
+	inner := s.inner
+	s.mu.Unlock()
+
+	// The initiating client going away must not stop the reply, it can be
+	// resumed later; stop forwarding to it instead.
+	if inner != nil {
+		if err := inner.SendMessage(msg); err != nil {
+			log.Debugf("stop forwarding reply [%v] to its initial client: %v", s.replyMsg.ID, err)
+			s.mu.Lock()
+			s.inner = nil
+			s.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// ChunksFrom returns the chunks after the given offset, whether the stream
+// is finished, and a channel closed when new chunks are appended.
+func (s *ReplyStreamSender) ChunksFrom(offset int) ([]*core.MessageChunk, bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset < 0 {
+		offset = 0
+	}
+	var out []*core.MessageChunk
+	if offset < len(s.chunks) {
+		out = append(out, s.chunks[offset:]...)
+	}
+	return out, s.finished, s.notify
+}
+
+func (s *ReplyStreamSender) ormContext() *orm.Context {
+	ctx := orm.NewContextWithParent(security.CloneContext(s.ctx))
+	ctx.DirectAccess()
+	return ctx
+}
+
+// run persists the buffered chunks and watches for cancel requests made on
+// other nodes until the reply is finished.
+func (s *ReplyStreamSender) run() {
+	ticker := time.NewTicker(replyStreamFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		finished := s.flush()
+		if finished {
+			break
+		}
+		if s.cancelRequested() {
+			log.Debugf("reply [%v] was cancelled from another node", s.replyMsg.ID)
+			StopMessageReplyTask(s.taskID)
+		}
+	}
+
+	time.AfterFunc(replyStreamRetention, s.cleanup)
+}
+
+func (s *ReplyStreamSender) flush() bool {
+	s.mu.Lock()
+	pending := s.chunks[s.persisted:]
+	from := s.persisted + 1
+	finished := s.finished
+	s.mu.Unlock()
+
+	ctx := s.ormContext()
+	if len(pending) > 0 {
+		batch := core.ReplyStreamChunks{
+			ReplyMessageID: s.replyMsg.ID,
+			FromOffset:     from,
+			ToOffset:       from + len(pending) - 1,
+			Chunks:         pending,
+		}
+		batch.ID = fmt.Sprintf("%s_%d", s.replyMsg.ID, from)
+		if err := orm.Create(ctx, &batch); err != nil {
+			_ = log.Warnf("failed to persist chunks of reply [%v]: %v", s.replyMsg.ID, err)
+			return finished
+		}
+		s.mu.Lock()
+		s.persisted += len(pending)
+		s.record.LastOffset = batch.ToOffset
+		s.mu.Unlock()
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	heartbeat := now.Sub(s.record.HeartbeatAt) >= replyStreamHeartbeatInterval
+	s.mu.Unlock()
+
+	if len(pending) > 0 || finished || heartbeat {
+		s.mu.Lock()
+		if finished {
+			s.record.Status = core.ReplyStreamStatusFinished
+		}
+		s.record.HeartbeatAt = now
+		record := s.record
+		s.mu.Unlock()
+
+		ctx.Refresh = orm.ImmediatelyRefresh
+		if err := orm.Update(ctx, &record); err != nil {
+			_ = log.Warnf("failed to update reply stream [%v]: %v", s.replyMsg.ID, err)
+		}
+	}
+	return finished
+}
+
+func (s *ReplyStreamSender) cancelRequested() bool {
+	record := core.ReplyStream{}
+	record.ID = s.replyMsg.ID
+	exists, err := orm.GetV2(s.ormContext(), &record)
+	return err == nil && exists && record.CancelRequested
+}
+
+func (s *ReplyStreamSender) cleanup() {
+	replyStreams.Delete(s.replyMsg.ID)
+
+	ctx := s.ormContext()
+	builder := orm.NewQuery()
+	builder.Filter(orm.TermQuery("reply_message_id", s.replyMsg.ID))
+	orm.WithModel(ctx, &core.ReplyStreamChunks{})
+	if _, err := orm.DeleteByQuery(ctx, builder); err != nil {
+		_ = log.Warnf("failed to delete chunks of reply [%v]: %v", s.replyMsg.ID, err)
+	}
+
+	if err := orm.Delete(s.ormContext(), &s.record); err != nil {
+		_ = log.Warnf("failed to delete reply stream [%v]: %v", s.replyMsg.ID, err)
+	}
+}
+
+// GetReplyStream loads the state of a reply stream, from this node if it
+// runs here or from the store otherwise.
+func GetReplyStream(ctx context.Context, replyMessageID string) (*core.ReplyStream, error) {
+	record, _, err := getReplyStream(ctx, replyMessageID)
+	return record, err
+}
+
+// getReplyStream is GetReplyStream, also telling whether the stream was
+// abandoned by its node.
+func getReplyStream(ctx context.Context, replyMessageID string) (*core.ReplyStream, bool, error) {
+	if v, ok := replyStreams.Load(replyMessageID); ok {
+		s := v.(*ReplyStreamSender)
+		s.mu.Lock()
+		record := s.record
+		s.mu.Unlock()
+		return &record, false, nil
+	}
+
+	record := core.ReplyStream{}
+	record.ID = replyMessageID
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	exists, err := orm.GetV2(ctx1, &record)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return nil, false, errReplyStreamNotFound
+	}
+	abandoned := expireReplyStream(&record, time.Now())
+	return &record, abandoned, nil
+}
+
+// expireReplyStream marks a running stream of another node as finished once
+// its lease expired, so that clients stop waiting for a reply nobody makes.
+// It returns whether the stream was abandoned.
+func expireReplyStream(record *core.ReplyStream, now time.Time) bool {
+	if record.Status != core.ReplyStreamStatusRunning || now.Sub(record.HeartbeatAt) <= replyStreamLease {
+		return false
+	}
+	record.Status = core.ReplyStreamStatusFinished
+	return true
+}
+
+// ResumeReplyStream writes the chunks of a reply after the given offset to
+// sender, then follows the stream until it is finished or ctx is done.
+func ResumeReplyStream(ctx context.Context, replyMessageID string, offset int, sender core.MessageSender) error {
+	if v, ok := replyStreams.Load(replyMessageID); ok {
+		return resumeLocalReplyStream(ctx, v.(*ReplyStreamSender), offset, sender)
+	}
+	return resumeRemoteReplyStream(ctx, replyMessageID, offset, sender)
+}
+
+func resumeLocalReplyStream(ctx context.Context, s *ReplyStreamSender, offset int, sender core.MessageSender) error {
+	for {
+		chunks, finished, notify := s.ChunksFrom(offset)
+		for _, chunk := range chunks {
+			if err := sender.SendMessage(chunk); err != nil {
+				return err
+			}
+			offset = chunk.Offset
+		}
+		if finished {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// resumeRemoteReplyStream replays a stream owned by another node by polling
+// the persisted chunk batches. A stream abandoned by its node is replayed up
+// to its last persisted chunk and ended with an error.
+func resumeRemoteReplyStream(ctx context.Context, replyMessageID string, offset int, sender core.MessageSender) error {
+	ticker := time.NewTicker(replyStreamFlushInterval)
+	defer ticker.Stop()
+
+	ended := false
+	for {
+		record, abandoned, err := getReplyStream(ctx, replyMessageID)
+		if err != nil {
+			return err
+		}
+
+		batches, err := loadReplyStreamChunks(replyMessageID, offset)
+		if err != nil {
+			return err
+		}
+		for _, batch := range batches {
+			for _, chunk := range batch.Chunks {
+				if chunk == nil || chunk.Offset <= offset {
+					continue
+				}
+				if err := sender.SendMessage(chunk); err != nil {
+					return err
+				}
+				offset = chunk.Offset
+				ended = chunk.ChunkType == common.ReplyEnd
+			}
+		}
+
+		if abandoned {
+			if ended {
+				return nil
+			}
+			log.Debugf("reply stream [%v] was abandoned by node [%v]", replyMessageID, record.NodeID)
+			payload := buildReplyEndPayload(common.ReplyEndReasonError, errReplyStreamAbandoned)
+			return sender.SendMessage(core.NewMessageChunk(record.SessionID, replyMessageID, core.MessageTypeSystem,
+				record.RequestMessageID, common.ReplyEnd, util.MustToJSON(payload), 0))
+		}
+		if record.Status == core.ReplyStreamStatusFinished && offset >= record.LastOffset {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// replyStreamChunksPageSize is how many chunk batches are loaded per query.
+const replyStreamChunksPageSize = 1000
+
+// loadReplyStreamChunks loads the persisted batches holding chunks after the
+// given offset, in their order.
+func loadReplyStreamChunks(replyMessageID string, offset int) ([]core.ReplyStreamChunks, error) {
+	var out []core.ReplyStreamChunks
+	for {
+		dsl := util.MapStr{
+			"size": replyStreamChunksPageSize,
+			"sort": []util.MapStr{{"from_offset": util.MapStr{"order": "asc"}}},
+			"query": util.MapStr{
+				"bool": util.MapStr{
+					"filter": []util.MapStr{
+						{"term": util.MapStr{"reply_message_id": replyMessageID}},
+						{"range": util.MapStr{"to_offset": util.MapStr{"gt": offset}}},
+					},
+				},
+			},
+		}
+		docs := []core.ReplyStreamChunks{}
+		q := orm.Query{RawQuery: util.MustToJSONBytes(dsl)}
+		if err, _ := orm.SearchWithJSONMapper(&docs, &q); err != nil {
+			return nil, err
+		}
+		out = append(out, docs...)
+		if len(docs) < replyStreamChunksPageSize {
+			return out, nil
+		}
+		offset = docs[len(docs)-1].ToOffset
+	}
+}
+
+// runningReplyStreams returns the replies of a session being generated on
+// any node, of a request message if given, leaving out the abandoned ones.
+func runningReplyStreams(ctx context.Context, sessionID, requestMessageID string) ([]core.ReplyStream, error) {
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+	orm.WithModel(ctx1, &core.ReplyStream{})
+
+	builder := orm.NewQuery()
+	builder.Size(10)
+	builder.Filter(orm.TermQuery("session_id", sessionID))
+	builder.Filter(orm.TermQuery("status", core.ReplyStreamStatusRunning))
+	if requestMessageID != "" {
+		builder.Filter(orm.TermQuery("request_message_id", requestMessageID))
+	}
+
+	docs := []core.ReplyStream{}
+	err, _ := elastic.SearchV2WithResultItemMapper(ctx1, &docs, builder, nil)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return slices.DeleteFunc(docs, func(doc core.ReplyStream) bool {
+		return expireReplyStream(&doc, now)
+	}), nil
+}
+
+// IsMessageBeingReplied tells whether a reply to a request message is being
+// generated, on this node or on another one.
+func IsMessageBeingReplied(ctx context.Context, sessionID, requestMessageID string) (bool, error) {
+	if _, ok := InflightMessages.Load(GetReplyMessageTaskID(sessionID, requestMessageID)); ok {
+		return true, nil
+	}
+	docs, err := runningReplyStreams(ctx, sessionID, requestMessageID)
+	return len(docs) > 0, err
+}
+
+// RequestCancelReplyStream asks the node owning the reply of the given
+// request message to stop it. It is used when the task is not running on
+// this node.
+func RequestCancelReplyStream(ctx context.Context, sessionID, requestMessageID string) error {
+	docs, err := runningReplyStreams(ctx, sessionID, requestMessageID)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return errReplyStreamNotFound
+	}
+
+	for i := range docs {
+		docs[i].CancelRequested = true
+		ctx2 := orm.NewContextWithParent(ctx)
+		ctx2.DirectAccess()
+		ctx2.Refresh = orm.ImmediatelyRefresh
+		if err := orm.Update(ctx2, &docs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+)
+
+type recordingSender struct {
+	chunks []*core.MessageChunk
+}
+
+func (s *recordingSender) SendMessage(msg *core.MessageChunk) error {
+	s.chunks = append(s.chunks, msg)
+	return nil
+}
+
+func (s *recordingSender) SendChunkMessage(messageType, chunkType, messageChunk string, chunkSequence int) error {
+	return s.SendMessage(core.NewMessageChunk("", "", messageType, "", chunkType, messageChunk, chunkSequence))
+}
+
+func TestExpireReplyStream(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		status        string
+		heartbeat     time.Time
+		wantAbandoned bool
+	}{
+		{"running with a fresh heartbeat", core.ReplyStreamStatusRunning, now.Add(-replyStreamHeartbeatInterval), false},
+		{"running at the end of the lease", core.ReplyStreamStatusRunning, now.Add(-replyStreamLease), false},
+		{"running past the lease", core.ReplyStreamStatusRunning, now.Add(-replyStreamLease - time.Second), true},
+		{"running without heartbeat", core.ReplyStreamStatusRunning, time.Time{}, true},
+		{"finished long ago", core.ReplyStreamStatusFinished, now.Add(-replyStreamRetention), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := core.ReplyStream{Status: tt.status, HeartbeatAt: tt.heartbeat}
+			if got := expireReplyStream(&record, now); got != tt.wantAbandoned {
+				t.Fatalf("expected abandoned %v, got %v", tt.wantAbandoned, got)
+			}
+			if record.Status != core.ReplyStreamStatusFinished && tt.wantAbandoned {
+				t.Fatalf("expected an abandoned stream to be finished, got %q", record.Status)
+			}
+			if record.Status != tt.status && !tt.wantAbandoned {
+				t.Fatalf("expected the status to be kept, got %q", record.Status)
+			}
+		})
+	}
+}
+
+func newTestReplyStreamSender(ctx context.Context) *ReplyStreamSender {
+	reqMsg := &core.ChatMessage{SessionID: "session"}
+	reqMsg.ID = "request"
+	replyMsg := &core.ChatMessage{SessionID: "session"}
+	replyMsg.ID = "reply"
+	return &ReplyStreamSender{
+		ctx:      ctx,
+		reqMsg:   reqMsg,
+		replyMsg: replyMsg,
+		notify:   make(chan struct{}),
+	}
+}
+
+func TestResumeLocalReplyStreamFromOffset(t *testing.T) {
+	s := newTestReplyStreamSender(context.Background())
+	for _, text := range []string{"a", "b", "c"} {
+		if err := s.SendChunkMessage(common.Response, common.Response, text, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	resumed := &recordingSender{}
+	go func() {
+		done <- resumeLocalReplyStream(context.Background(), s, 1, resumed)
+	}()
+
+	// the resumed client follows the stream until it ends
+	if err := s.SendChunkMessage(core.MessageTypeSystem, common.ReplyEnd, "{}", 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("resume did not stop at the end of the reply")
+	}
+
+	var offsets []int
+	for _, chunk := range resumed.chunks {
+		offsets = append(offsets, chunk.Offset)
+	}
+	if len(offsets) != 3 || offsets[0] != 2 || offsets[2] != 4 || resumed.chunks[2].ChunkType != common.ReplyEnd {
+		t.Fatalf("expected chunks 2 to 4 ending with reply_end, got offsets %v", offsets)
+	}
+
+	// chunks sent after the end are dropped
+	_ = s.SendChunkMessage(common.Response, common.Response, "late", 0)
+	if chunks, finished, _ := s.ChunksFrom(4); len(chunks) != 0 || !finished {
+		t.Fatalf("expected a finished stream without chunks after the end, got %v", len(chunks))
+	}
+}
+
+func TestReplyStreamRejectsChunksAfterTaskStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newTestReplyStreamSender(ctx)
+	cancel()
+
+	if err := s.SendChunkMessage(common.Response, common.Response, "a", 0); err == nil {
+		t.Fatal("expected chunks of a stopped task to be rejected")
+	}
+	if err := s.SendChunkMessage(core.MessageTypeSystem, common.ReplyEnd, "{}", 0); err != nil {
+		t.Fatalf("expected reply_end to be accepted, got %v", err)
+	}
+	if chunks, finished, _ := s.ChunksFrom(0); len(chunks) != 1 || !finished {
+		t.Fatalf("expected only the reply_end chunk, got %v", len(chunks))
+	}
+}
+
+func TestReplyStreamSkipsEmptyResponseChunks(t *testing.T) {
+	s := newTestReplyStreamSender(context.Background())
+	_ = s.SendChunkMessage(core.MessageTypeAssistant, common.Response, " \n", 0)
+	_ = s.SendChunkMessage(core.MessageTypeAssistant, common.Think, " ", 0)
+	_ = s.SendChunkMessage(core.MessageTypeAssistant, common.Response, "a", 0)
+
+	chunks, _, _ := s.ChunksFrom(0)
+	if len(chunks) != 2 || chunks[0].ChunkType != common.Think || chunks[1].MessageChunk != "a" {
+		t.Fatalf("expected the empty response chunk skipped, got %v chunks", len(chunks))
+	}
+}
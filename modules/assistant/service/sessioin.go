@@ -46,7 +46,7 @@ func InternalCreateAndSaveNewChatMessage(ctx context.Context, assistantID string
 	var firstMessage *core.ChatMessage
 	//save first message to history
 	if req != nil && !req.IsEmpty() {
-		firstMessage, err = SaveRequestMessage(ctx1, obj.ID, assistantID, req)
+		firstMessage, err = SaveRequestMessage(ctx1, obj.ID, assistantID, "", req)
 		if err != nil {
 			return core.Session{}, err, nil, nil
 		}
@@ -56,7 +56,9 @@ func InternalCreateAndSaveNewChatMessage(ctx context.Context, assistantID string
 	return obj, err, firstMessage, result
 }
 
-func SaveRequestMessage(ctx *orm.Context, sessionID, assistantID string, req *core.MessageRequest) (*core.ChatMessage, error) {
+// SaveRequestMessage saves a question of the user following parentID in the
+// conversation tree, "" for the first message of a session.
+func SaveRequestMessage(ctx *orm.Context, sessionID, assistantID, parentID string, req *core.MessageRequest) (*core.ChatMessage, error) {
 
 	if sessionID == "" || assistantID == "" || req.IsEmpty() {
 		panic("invalid chat message")
@@ -68,6 +70,7 @@ func SaveRequestMessage(ctx *orm.Context, sessionID, assistantID string, req *co
 		MessageType: core.MessageTypeUser,
 		Message:     req.Message,
 		Attachments: req.Attachments,
+		ParentID:    parentID,
 	}
 	msg.ID = util.GetUUID()
 
@@ -91,7 +94,9 @@ func GetChatHistoryBySessionInternal(sessionID string, size int) ([]core.ChatMes
 	return docs, nil
 }
 
-func StopMessageReplyTask(taskID string) {
+// StopMessageReplyTask cancels a reply task running on this node, it returns
+// false if the task was not found here.
+func StopMessageReplyTask(taskID string) bool {
 	v, ok := InflightMessages.Load(taskID)
 	if ok {
 		v1, ok := v.(common.MessageTask)
@@ -99,9 +104,10 @@ func StopMessageReplyTask(taskID string) {
 			seelog.Debug("stop task:", v1)
 			v1.CancelFunc()
 		}
-	} else {
-		_ = seelog.Warnf("task id [%s] was not found", taskID)
+		return true
 	}
+	_ = seelog.Warnf("task id [%s] was not found on this node", taskID)
+	return false
 }
 
 func StopAllMessageReplyTasks() int {
@@ -129,13 +135,27 @@ func GetReplyMessageTaskID(sessionID, messageID string) string {
 // AskAssistantSync sends a message to an assistant and waits for a full response.
 // This version is fully detached from APIHandler and HTTP context.
 func AskAssistantSync(ctx context.Context, userID string, id string, message string, vars map[string]any) (string, error) {
+	answer, _, err := AskAssistantSyncWithReply(ctx, userID, id, message, vars)
+	return answer, err
+}
+
+// AskAssistantSyncWithReply is AskAssistantSync also returning the reply
+// message, with the processing details, e.g. the picked sources, and the
+// citations of the answer.
+func AskAssistantSyncWithReply(ctx context.Context, userID string, id string, message string, vars map[string]any) (string, *core.ChatMessage, error) {
 	assistant, exists, err := InternalGetAssistant(ctx, id)
 	if !exists || err != nil {
-		return "", fmt.Errorf("assistant %s not found: %w", id, err)
+		return "", nil, fmt.Errorf("assistant %s not found: %w", id, err)
 	}
+	return AskLoadedAssistantSync(ctx, userID, assistant, message, vars)
+}
 
+// AskLoadedAssistantSync is AskAssistantSyncWithReply for an assistant already
+// loaded, e.g. at the revision pinned by an integration.
+func AskLoadedAssistantSync(ctx context.Context, userID string, assistant *core.Assistant, message string, vars map[string]any) (string, *core.ChatMessage, error) {
+	id := assistant.ID
 	if message == "" {
-		return "", errors.New("message is empty")
+		return "", nil, errors.New("message is empty")
 	}
 
 	// Construct and save a new chat message
@@ -144,7 +164,7 @@ func AskAssistantSync(ctx context.Context, userID string, id string, message str
 	}
 	session, err, reqMsg, _ := InternalCreateAndSaveNewChatMessage(ctx, id, &request, false)
 	if err != nil || reqMsg == nil {
-		return "", fmt.Errorf("failed to create chat message: %w", err)
+		return "", nil, fmt.Errorf("failed to create chat message: %w", err)
 	}
 
 	ragCtx := &common.RAGContext{}
@@ -157,10 +177,10 @@ func AskAssistantSync(ctx context.Context, userID string, id string, message str
 	// Memory-based receiver for synchronous mode
 	receiver := &common.MemoryMessageSender{}
 	if err := ProcessMessageAsync(ctx, userID, reqMsg, replyMsg, ragCtx, receiver); err != nil {
-		return "", fmt.Errorf("process message failed: %w", err)
+		return "", replyMsg, fmt.Errorf("process message failed: %w", err)
 	}
 
-	return receiver.FinalResponse(), nil
+	return receiver.FinalResponse(), replyMsg, nil
 }
 
 func CreateAndSaveNewChatMessage(request *http.Request, assistantID string, req *core.MessageRequest, visible bool) (core.Session, error, *core.ChatMessage, util.MapStr) {
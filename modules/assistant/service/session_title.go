@@ -0,0 +1,89 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package service
+
+import (
+	"context"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	common2 "infini.sh/coco/modules/assistant/common"
+	"infini.sh/coco/modules/assistant/langchain"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// sessionTitleTimeout bounds the generation of a session title.
+const sessionTitleTimeout = 30 * time.Second
+
+// updateSessionTitle names the session of reqMsg after its first exchange
+// when auto titles are enabled. The title of a session renamed by the user
+// is kept. It runs in the background once the reply was saved.
+func updateSessionTitle(ctx context.Context, params *common2.RAGContext, reqMsg, replyMsg *core.ChatMessage) {
+	if params.AssistantCfg == nil || !params.AssistantCfg.ChatSettings.AutoTitle.Enabled || replyMsg.Message == "" {
+		return
+	}
+
+	// detach from the request, keeping the user for the ORM hooks
+	ctx, cancel := context.WithTimeout(security.CloneContext(ctx), sessionTitleTimeout)
+	go func() {
+		defer cancel()
+
+		// the first exchange of the branch of the reply, which is a new
+		// branch when the first question is edited or its reply regenerated
+		tree, err := LoadMessageTree(ctx, reqMsg.SessionID, replyMsg.ID)
+		if err != nil || len(tree.Branch(replyMsg.ID)) > 2 {
+			return
+		}
+
+		session, exists := getSessionInternal(ctx, reqMsg.SessionID)
+		if !exists || session.ManuallyRenamedTitle || !session.Visible {
+			return
+		}
+
+		title, err := langchain.GenerateSessionTitle(ctx, params.GetSessionTitleModel(), reqMsg.Message, replyMsg.Message)
+		if err != nil {
+			_ = log.Warnf("failed to generate the title of session [%v]: %v", reqMsg.SessionID, err)
+			return
+		}
+
+		// the user may have renamed the session meanwhile
+		session, exists = getSessionInternal(ctx, reqMsg.SessionID)
+		if !exists || session.ManuallyRenamedTitle {
+			return
+		}
+		fields := util.MapStr{"title": title.Title}
+		if title.Summary != "" {
+			fields["summary"] = title.Summary
+		}
+
+		// only the title fields, the history summary may be saved meanwhile
+		ctx1 := orm.NewContextWithParent(ctx)
+		ctx1.DirectAccess()
+		ctx1.Refresh = orm.WaitForRefresh
+		if err := orm.UpdatePartialFields(ctx1, &session, fields); err != nil {
+			_ = log.Errorf("failed to save the title of session [%v]: %v", reqMsg.SessionID, err)
+			return
+		}
+		log.Debugf("session [%v] titled: %v", reqMsg.SessionID, title.Title)
+	}()
+}
+
+// getSessionInternal loads a session regardless of its owner.
+func getSessionInternal(ctx context.Context, sessionID string) (core.Session, bool) {
+	ctx1 := orm.NewContextWithParent(ctx)
+	ctx1.DirectAccess()
+
+	session := core.Session{}
+	session.ID = sessionID
+	exists, err := orm.GetV2(ctx1, &session)
+	if err != nil {
+		_ = log.Warnf("failed to load session [%v]: %v", sessionID, err)
+		return session, false
+	}
+	return session, exists
+}
@@ -96,6 +96,15 @@ func CallLLMTools(ctx context.Context, reqMsg *core.ChatMessage, replyMsg *core.
 				agentTools = append(agentTools, scr)
 			}
 		}
+
+		if cfg := params.AssistantCfg.ToolsConfig.BuiltinTools.WebSearch; cfg != nil && cfg.Engine != "" {
+			provider, err := NewWebSearchProvider(cfg)
+			if err != nil {
+				_ = log.Warnf("failed to init web search tool: %v", err)
+			} else {
+				agentTools = append(agentTools, &WebSearchTool{Provider: provider, MaxResults: cfg.MaxResults})
+			}
+		}
 	}
 
 	mcpClients := []*client.Client{}
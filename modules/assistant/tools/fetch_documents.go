@@ -33,6 +33,7 @@ func FetchDocumentInDepth(ctx context.Context, reqMsg, replyMsg *core.ChatMessag
 		replyMsg.Details = append(replyMsg.Details, detail)
 
 		inputValues["references"] = FormatDocumentForReplyReferences(pickedFullDoc)
+		params.ReferenceDocs = pickedFullDoc
 	}
 	return nil
 }
@@ -0,0 +1,316 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+)
+
+const (
+	bingSearchEndpoint   = "https://api.bing.microsoft.com/v7.0/search"
+	braveSearchEndpoint  = "https://api.search.brave.com/res/v1/web/search"
+	googleSearchEndpoint = "https://www.googleapis.com/customsearch/v1"
+
+	defaultWebSearchResults = 5
+)
+
+var webSearchClient = &http.Client{Timeout: 30 * time.Second}
+
+// WebSearchResult is a single result of a web search provider.
+type WebSearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Content string `json:"content"`
+}
+
+// WebSearchProvider searches the web through the API of a search engine.
+type WebSearchProvider interface {
+	Name() string
+	Search(ctx context.Context, query string, maxResults int) ([]WebSearchResult, error)
+}
+
+// NewWebSearchProvider returns the provider of the engine of the config.
+func NewWebSearchProvider(cfg *core.WebSearchConfig) (WebSearchProvider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Engine {
+	case core.WebSearchEngineSearXNG:
+		return &SearXNGProvider{Endpoint: cfg.Endpoint}, nil
+	case core.WebSearchEngineBing:
+		return &BingProvider{APIKey: cfg.APIKey, Endpoint: cfg.Endpoint}, nil
+	case core.WebSearchEngineBrave:
+		return &BraveProvider{APIKey: cfg.APIKey, Endpoint: cfg.Endpoint}, nil
+	case core.WebSearchEngineGoogle:
+		return &GoogleProvider{APIKey: cfg.APIKey, SearchEngineID: cfg.SearchEngineID, Endpoint: cfg.Endpoint}, nil
+	}
+	return nil, fmt.Errorf("unknown web search engine: %q", cfg.Engine)
+}
+
+// SearXNGProvider searches a self-hosted SearXNG instance, which must have
+// the json format enabled in its settings.
+type SearXNGProvider struct {
+	Endpoint string
+}
+
+func (p *SearXNGProvider) Name() string {
+	return core.WebSearchEngineSearXNG
+}
+
+func (p *SearXNGProvider) Search(ctx context.Context, query string, maxResults int) ([]WebSearchResult, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "json")
+
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	endpoint := strings.TrimRight(p.Endpoint, "/") + "/search"
+	if err := getWebSearchJSON(ctx, endpoint, params, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	results := []WebSearchResult{}
+	for _, r := range resp.Results {
+		results = append(results, WebSearchResult{Title: r.Title, URL: r.URL, Content: r.Content})
+	}
+	return limitWebSearchResults(results, maxResults), nil
+}
+
+// BingProvider searches with the Bing Web Search API.
+type BingProvider struct {
+	APIKey   string
+	Endpoint string
+}
+
+func (p *BingProvider) Name() string {
+	return core.WebSearchEngineBing
+}
+
+func (p *BingProvider) Search(ctx context.Context, query string, maxResults int) ([]WebSearchResult, error) {
+	maxResults = webSearchResultSize(maxResults, 50)
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("count", strconv.Itoa(maxResults))
+	params.Set("textFormat", "Raw")
+
+	var resp struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	headers := map[string]string{"Ocp-Apim-Subscription-Key": p.APIKey}
+	if err := getWebSearchJSON(ctx, endpointOrDefault(p.Endpoint, bingSearchEndpoint), params, headers, &resp); err != nil {
+		return nil, err
+	}
+
+	results := []WebSearchResult{}
+	for _, r := range resp.WebPages.Value {
+		results = append(results, WebSearchResult{Title: r.Name, URL: r.URL, Content: r.Snippet})
+	}
+	return limitWebSearchResults(results, maxResults), nil
+}
+
+// BraveProvider searches with the Brave Search API.
+type BraveProvider struct {
+	APIKey   string
+	Endpoint string
+}
+
+func (p *BraveProvider) Name() string {
+	return core.WebSearchEngineBrave
+}
+
+func (p *BraveProvider) Search(ctx context.Context, query string, maxResults int) ([]WebSearchResult, error) {
+	maxResults = webSearchResultSize(maxResults, 20)
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("count", strconv.Itoa(maxResults))
+
+	var resp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	headers := map[string]string{"X-Subscription-Token": p.APIKey}
+	if err := getWebSearchJSON(ctx, endpointOrDefault(p.Endpoint, braveSearchEndpoint), params, headers, &resp); err != nil {
+		return nil, err
+	}
+
+	results := []WebSearchResult{}
+	for _, r := range resp.Web.Results {
+		// descriptions highlight the matches with <strong>
+		results = append(results, WebSearchResult{Title: stripHTML(r.Title), URL: r.URL, Content: stripHTML(r.Description)})
+	}
+	return limitWebSearchResults(results, maxResults), nil
+}
+
+// GoogleProvider searches with the Custom Search JSON API of a Google
+// Programmable Search Engine.
+type GoogleProvider struct {
+	APIKey         string
+	SearchEngineID string
+	Endpoint       string
+}
+
+func (p *GoogleProvider) Name() string {
+	return core.WebSearchEngineGoogle
+}
+
+func (p *GoogleProvider) Search(ctx context.Context, query string, maxResults int) ([]WebSearchResult, error) {
+	maxResults = webSearchResultSize(maxResults, 10)
+	params := url.Values{}
+	params.Set("key", p.APIKey)
+	params.Set("cx", p.SearchEngineID)
+	params.Set("q", query)
+	params.Set("num", strconv.Itoa(maxResults))
+
+	var resp struct {
+		Items []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := getWebSearchJSON(ctx, endpointOrDefault(p.Endpoint, googleSearchEndpoint), params, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	results := []WebSearchResult{}
+	for _, r := range resp.Items {
+		results = append(results, WebSearchResult{Title: r.Title, URL: r.Link, Content: r.Snippet})
+	}
+	return limitWebSearchResults(results, maxResults), nil
+}
+
+// WebSearchTool is the builtin tool searching the web with a provider.
+type WebSearchTool struct {
+	Provider   WebSearchProvider
+	MaxResults int // 0 means use default (5)
+}
+
+// Name returns the tool name
+func (t *WebSearchTool) Name() string {
+	return "web_search"
+}
+
+// Description returns the tool description
+func (t *WebSearchTool) Description() string {
+	return "Search the web for up-to-date information. Input should be a search query string."
+}
+
+// Call executes the search, the results are formatted as the ones of the
+// TavilySearchTool.
+func (t *WebSearchTool) Call(ctx context.Context, input string) (string, error) {
+	log.Debugf("start call web search [%v]: %v", t.Provider.Name(), input)
+
+	results, err := t.Provider.Search(ctx, strings.TrimSpace(input), t.MaxResults)
+	if err != nil {
+		return "", fmt.Errorf("%v search failed: %w", t.Provider.Name(), err)
+	}
+	if len(results) == 0 {
+		return "No results found.", nil
+	}
+
+	formatted := make([]string, 0, len(results))
+	for i, r := range results {
+		formatted = append(formatted, fmt.Sprintf(
+			"[Result %d]\nTitle: %s\nURL: %s\nContent: %s\n",
+			i+1, r.Title, r.URL, r.Content,
+		))
+	}
+	return strings.Join(formatted, "\n---\n"), nil
+}
+
+func getWebSearchJSON(ctx context.Context, endpoint string, params url.Values, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := webSearchClient.Do(req)
+	if err != nil {
+		// the URL of the error has the query parameters, API keys included
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("search request to [%v] failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("search API returned status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+func endpointOrDefault(endpoint, defaultEndpoint string) string {
+	if endpoint != "" {
+		return endpoint
+	}
+	return defaultEndpoint
+}
+
+// webSearchResultSize returns the number of results to ask for, within the
+// limit of the API.
+func webSearchResultSize(maxResults, limit int) int {
+	if maxResults <= 0 {
+		maxResults = defaultWebSearchResults
+	}
+	if maxResults > limit {
+		maxResults = limit
+	}
+	return maxResults
+}
+
+func limitWebSearchResults(results []WebSearchResult, maxResults int) []WebSearchResult {
+	if maxResults <= 0 {
+		maxResults = defaultWebSearchResults
+	}
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	return results
+}
+
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+func stripHTML(s string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRegex.ReplaceAllString(s, "")))
+}
@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+)
+
+func newWebSearchFake(t *testing.T, check func(r *http.Request), body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		check(r)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebSearchProviders(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    func(endpoint string) *core.WebSearchConfig
+		check  func(t *testing.T, r *http.Request)
+		body   string
+		expect WebSearchResult
+	}{
+		{
+			name: "searxng",
+			cfg: func(endpoint string) *core.WebSearchConfig {
+				return &core.WebSearchConfig{Engine: core.WebSearchEngineSearXNG, Endpoint: endpoint + "/"}
+			},
+			check: func(t *testing.T, r *http.Request) {
+				if r.URL.Path != "/search" || r.URL.Query().Get("format") != "json" || r.URL.Query().Get("q") != "coco ai" {
+					t.Errorf("unexpected searxng request: %v", r.URL)
+				}
+			},
+			body:   `{"results":[{"title":"Coco","url":"https://coco.rs","content":"search everything"},{"title":"Other","url":"https://example.com","content":"other"}]}`,
+			expect: WebSearchResult{Title: "Coco", URL: "https://coco.rs", Content: "search everything"},
+		},
+		{
+			name: "bing",
+			cfg: func(endpoint string) *core.WebSearchConfig {
+				return &core.WebSearchConfig{Engine: core.WebSearchEngineBing, APIKey: "bing-key", Endpoint: endpoint}
+			},
+			check: func(t *testing.T, r *http.Request) {
+				if r.Header.Get("Ocp-Apim-Subscription-Key") != "bing-key" || r.URL.Query().Get("count") != "1" {
+					t.Errorf("unexpected bing request: %v %v", r.URL, r.Header)
+				}
+			},
+			body:   `{"webPages":{"value":[{"name":"Coco","url":"https://coco.rs","snippet":"search everything"}]}}`,
+			expect: WebSearchResult{Title: "Coco", URL: "https://coco.rs", Content: "search everything"},
+		},
+		{
+			name: "brave",
+			cfg: func(endpoint string) *core.WebSearchConfig {
+				return &core.WebSearchConfig{Engine: core.WebSearchEngineBrave, APIKey: "brave-key", Endpoint: endpoint}
+			},
+			check: func(t *testing.T, r *http.Request) {
+				if r.Header.Get("X-Subscription-Token") != "brave-key" || r.URL.Query().Get("q") != "coco ai" {
+					t.Errorf("unexpected brave request: %v %v", r.URL, r.Header)
+				}
+			},
+			body:   `{"web":{"results":[{"title":"Coco","url":"https://coco.rs","description":"<strong>search</strong> everything &amp; more"}]}}`,
+			expect: WebSearchResult{Title: "Coco", URL: "https://coco.rs", Content: "search everything & more"},
+		},
+		{
+			name: "google",
+			cfg: func(endpoint string) *core.WebSearchConfig {
+				return &core.WebSearchConfig{Engine: core.WebSearchEngineGoogle, APIKey: "google-key", SearchEngineID: "cx-id", Endpoint: endpoint}
+			},
+			check: func(t *testing.T, r *http.Request) {
+				q := r.URL.Query()
+				if q.Get("key") != "google-key" || q.Get("cx") != "cx-id" || q.Get("num") != "1" {
+					t.Errorf("unexpected google request: %v", r.URL)
+				}
+			},
+			body:   `{"items":[{"title":"Coco","link":"https://coco.rs","snippet":"search everything"}]}`,
+			expect: WebSearchResult{Title: "Coco", URL: "https://coco.rs", Content: "search everything"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newWebSearchFake(t, func(r *http.Request) { tt.check(t, r) }, tt.body)
+			provider, err := NewWebSearchProvider(tt.cfg(server.URL))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if provider.Name() != tt.name {
+				t.Fatalf("unexpected provider: %v", provider.Name())
+			}
+			results, err := provider.Search(context.Background(), "coco ai", 1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(results) != 1 || results[0] != tt.expect {
+				t.Fatalf("unexpected results: %+v", results)
+			}
+		})
+	}
+}
+
+func TestWebSearchProviderErrors(t *testing.T) {
+	for _, cfg := range []core.WebSearchConfig{
+		{Engine: "altavista"},
+		{Engine: core.WebSearchEngineSearXNG},
+		{Engine: core.WebSearchEngineSearXNG, Endpoint: "searxng:8080"},
+		{Engine: core.WebSearchEngineBing},
+		{Engine: core.WebSearchEngineGoogle, APIKey: "google-key"},
+	} {
+		if _, err := NewWebSearchProvider(&cfg); err == nil {
+			t.Fatalf("expected config %+v to be rejected", cfg)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	provider := &BraveProvider{APIKey: "brave-key", Endpoint: server.URL}
+	_, err := provider.Search(context.Background(), "coco", 0)
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("expected the status to be reported, got %v", err)
+	}
+}
+
+func TestWebSearchErrorsHideAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	endpoint := server.URL
+	server.Close()
+
+	provider := &GoogleProvider{APIKey: "google-secret-key", SearchEngineID: "cx", Endpoint: endpoint}
+	_, err := provider.Search(context.Background(), "coco", 0)
+	if err == nil {
+		t.Fatalf("expected the request to a closed server to fail")
+	}
+	if strings.Contains(err.Error(), "google-secret-key") {
+		t.Fatalf("expected the API key to be hidden, got %v", err)
+	}
+}
+
+func TestWebSearchToolFormatsResults(t *testing.T) {
+	server := newWebSearchFake(t, func(r *http.Request) {}, `{"results":[{"title":"Coco","url":"https://coco.rs","content":"search everything"}]}`)
+	tool := &WebSearchTool{Provider: &SearXNGProvider{Endpoint: server.URL}}
+
+	out, err := tool.Call(context.Background(), " coco ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"[Result 1]", "Title: Coco", "URL: https://coco.rs", "Content: search everything"} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected output to contain %q, got %q", expected, out)
+		}
+	}
+
+	empty := newWebSearchFake(t, func(r *http.Request) {}, `{"results":[]}`)
+	tool.Provider = &SearXNGProvider{Endpoint: empty.URL}
+	if out, err := tool.Call(context.Background(), "coco"); err != nil || out != "No results found." {
+		t.Fatalf("unexpected output: %q, %v", out, err)
+	}
+}
@@ -0,0 +1,66 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package audit
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// search searches the audit logs, the latest first. They are filtered like
+// the other searches, e.g. `filter=resource_type:datasource`.
+func (h *APIHandler) search(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	builder, err := orm.NewQueryBuilderFromRequest(req, "actor_login", "resource_id")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.EnableBodyBytes()
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.DirectAccess()
+	ctx.PermissionScope(security.PermissionScopePlatform)
+	orm.WithModel(ctx, &core.AuditLog{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.Write(w, res.Payload.([]byte))
+	if err != nil {
+		h.Error(w, err)
+	}
+}
+
+// DeleteExpiredAuditLogs deletes the audit logs older than the retention, by
+// a single delete by query.
+func DeleteExpiredAuditLogs() error {
+	days := common.AppConfig().Audit.GetRetentionDays()
+	before := time.Now().AddDate(0, 0, -days)
+	err := orm.DeleteBy(&core.AuditLog{}, util.MapStr{
+		"query": util.MapStr{
+			"range": util.MapStr{
+				"created": util.MapStr{"lt": before.Format(time.RFC3339)},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Debugf("deleted the audit logs older than %d days", days)
+	return nil
+}
@@ -0,0 +1,26 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package audit
+
+import (
+	"infini.sh/framework/core/api"
+	"infini.sh/framework/core/security"
+)
+
+type APIHandler struct {
+	api.Handler
+}
+
+const Category = "coco"
+const Resource = "audit_log"
+
+func init() {
+	searchPermission := security.GetSimplePermission(Category, Resource, string(security.Search))
+	security.GetOrInitPermissionKeys(searchPermission)
+
+	handler := APIHandler{}
+	api.HandleUIMethod(api.GET, "/audit_log/_search", handler.search, api.RequireLogin(), api.RequirePermission(searchPermission))
+	api.HandleUIMethod(api.POST, "/audit_log/_search", handler.search, api.RequireLogin(), api.RequirePermission(searchPermission))
+}
@@ -10,6 +10,7 @@ import (
 	_ "infini.sh/coco/modules/assistant"
 	assistantservice "infini.sh/coco/modules/assistant/service"
 	_ "infini.sh/coco/modules/attachment"
+	_ "infini.sh/coco/modules/audit"
 	"infini.sh/coco/modules/common"
 	_ "infini.sh/coco/modules/connector"
 	_ "infini.sh/coco/modules/datasource"
@@ -17,7 +18,8 @@ import (
 	"infini.sh/coco/modules/integration"
 	_ "infini.sh/coco/modules/integration"
 	_ "infini.sh/coco/modules/llm"
-	_ "infini.sh/coco/modules/system"
+	_ "infini.sh/coco/modules/mcp"
+	"infini.sh/coco/modules/system"
 	"infini.sh/framework/core/orm"
 )
 
@@ -36,10 +38,28 @@ func (this *Coco) Setup() {
 	orm.MustRegisterSchemaWithIndexName(core.Integration{}, "integration"+suffix)
 	orm.MustRegisterSchemaWithIndexName(core.ModelProvider{}, "model-provider"+suffix)
 	orm.MustRegisterSchemaWithIndexName(core.Assistant{}, "assistant"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.AssistantRevision{}, "assistant-revision"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.PromptTemplate{}, "prompt-template"+suffix)
 	orm.MustRegisterSchemaWithIndexName(core.MCPServer{}, "mcp-server"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.ReplyStream{}, "reply-stream"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.ReplyStreamChunks{}, "reply-stream-chunk"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.ResearchPlanApproval{}, "research-plan-approval"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.ResearchCheckpoint{}, "research-checkpoint"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.MessageFeedback{}, "message-feedback"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.EvaluationSet{}, "evaluation-set"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.EvaluationRun{}, "evaluation-run"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.AssistantJob{}, "assistant-job"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.AssistantJobRun{}, "assistant-job-run"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.LoginRecord{}, "login-record"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.UserTwoFactor{}, "user-two-factor"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.APIKey{}, "api-key"+suffix)
+	orm.MustRegisterSchemaWithIndexName(core.AuditLog{}, "audit-log"+suffix)
 }
 
 func (this *Coco) Start() error {
+	if err := common.EnsureSecretMasterKey(system.HasEncryptedSecrets); err != nil {
+		return err
+	}
 	integration.InitIntegrationOrigins()
 	return nil
 }
@@ -0,0 +1,189 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// auditIgnoredFields are the fields not recorded as changes, they change on
+// every update.
+var auditIgnoredFields = map[string]bool{
+	"updated": true,
+	"_system": true,
+}
+
+// ClientIP returns the IP of the client of a request, the headers set by a
+// reverse proxy are only trusted with `coco.login_protection.trust_proxy_headers`.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if cfg := AppConfig().LoginProtection; cfg != nil && cfg.TrustProxyHeaders {
+		return forwardedClientIP(host, r.Header, parseTrustedProxies(cfg.TrustedProxies))
+	}
+	return host
+}
+
+// parseTrustedProxies parses the IPs and CIDRs of the trusted proxies, the
+// invalid ones are ignored.
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil {
+				bits := 128
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, ipNet)
+		} else {
+			log.Warnf("invalid trusted proxy [%s] ignored", proxy)
+		}
+	}
+	return nets
+}
+
+// forwardedClientIP returns the client IP from the headers of the proxies. As
+// a client can send any X-Forwarded-For, the hops are read from the right, the
+// ones appended by the proxies, up to the first not trusted. Without trusted
+// proxies, the peer is the only proxy, and the last hop the client.
+func forwardedClientIP(remoteIP string, header http.Header, trusted []*net.IPNet) string {
+	isTrusted := func(ip net.IP) bool {
+		for _, ipNet := range trusted {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(trusted) > 0 {
+		if ip := net.ParseIP(remoteIP); ip == nil || !isTrusted(ip) {
+			return remoteIP
+		}
+	}
+
+	hops := []string{}
+	for _, value := range header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	clientIP := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		clientIP = ip.String()
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(header.Get("X-Real-IP"))); ip != nil {
+			clientIP = ip.String()
+		}
+	}
+	return clientIP
+}
+
+// RecordAudit saves the audit log of an action of the user of a request on a
+// resource. before and after are the resource before and after the action,
+// nil if it did not exist, their changed fields are recorded with the
+// secrets redacted. A failure is only logged, the action is done already.
+func RecordAudit(req *http.Request, action, resourceType, resourceID string, before, after interface{}) {
+	if cfg := AppConfig().Audit; cfg != nil && cfg.Disabled {
+		return
+	}
+
+	record := core.AuditLog{
+		IntegrationID: req.Header.Get(core.HeaderIntegrationID),
+		ClientIP:      ClientIP(req),
+		Action:        action,
+		ResourceType:  resourceType,
+		ResourceID:    resourceID,
+		Changes:       auditChanges(before, after),
+	}
+	record.ID = util.GetUUID()
+	if reqUser, err := security.GetUserFromContext(req.Context()); err == nil && reqUser != nil {
+		record.ActorID = reqUser.MustGetUserID()
+		record.ActorLogin = reqUser.Login
+		record.APIKeyID, _ = reqUser.GetString(core.UserSessionInfoKeyAPIKey)
+	}
+
+	ctx := orm.NewContext()
+	ctx.DirectAccess()
+	ctx.PermissionScope(security.PermissionScopePlatform)
+	if err := orm.Create(ctx, &record); err != nil {
+		log.Errorf("failed to save the audit log of %s %s [%s]: %v", action, resourceType, resourceID, err)
+	}
+}
+
+// AuditSnapshot returns a copy of a resource to audit, e.g. before it is
+// changed in place.
+func AuditSnapshot(obj interface{}) interface{} {
+	return RedactSecrets(obj)
+}
+
+// auditChanges returns the top level fields which differ between two
+// versions of a resource.
+func auditChanges(before, after interface{}) []core.AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	fields := make([]string, 0, len(beforeFields)+len(afterFields))
+	for field := range beforeFields {
+		fields = append(fields, field)
+	}
+	for field := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []core.AuditChange
+	for _, field := range fields {
+		if auditIgnoredFields[field] {
+			continue
+		}
+		b, a := beforeFields[field], afterFields[field]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		changes = append(changes, core.AuditChange{Field: field, Before: b, After: a})
+	}
+	return changes
+}
+
+// auditFields returns the fields of a resource with its secrets redacted.
+func auditFields(obj interface{}) map[string]interface{} {
+	if obj == nil || (reflect.ValueOf(obj).Kind() == reflect.Ptr && reflect.ValueOf(obj).IsNil()) {
+		return nil
+	}
+	switch fields := RedactSecrets(obj).(type) {
+	case map[string]interface{}:
+		return fields
+	case util.MapStr:
+		return fields
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/util"
+)
+
+func TestForwardedClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteIP   string
+		forwarded  []string
+		realIP     string
+		trusted    []string
+		expectedIP string
+	}{
+		{"no headers", "10.0.0.1", nil, "", nil, "10.0.0.1"},
+		{"last hop without trusted proxies", "10.0.0.1", []string{"1.2.3.4, 203.0.113.7"}, "", nil, "203.0.113.7"},
+		{"spoofed first hop", "10.0.0.1", []string{"1.2.3.4", "203.0.113.7"}, "", nil, "203.0.113.7"},
+		{"trusted hops skipped", "10.0.0.1", []string{"1.2.3.4, 203.0.113.7, 10.0.0.2"}, "", []string{"10.0.0.0/8"}, "203.0.113.7"},
+		{"trusted single IP", "10.0.0.1", []string{"203.0.113.7"}, "", []string{"10.0.0.1"}, "203.0.113.7"},
+		{"untrusted peer", "198.51.100.1", []string{"203.0.113.7"}, "", []string{"10.0.0.0/8"}, "198.51.100.1"},
+		{"all hops trusted", "10.0.0.1", []string{"10.0.0.3, 10.0.0.2"}, "", []string{"10.0.0.0/8"}, "10.0.0.3"},
+		{"invalid hop", "10.0.0.1", []string{"evil, 10.0.0.2"}, "", []string{"10.0.0.0/8"}, "10.0.0.2"},
+		{"real IP", "10.0.0.1", nil, "203.0.113.7", nil, "203.0.113.7"},
+		{"real IP of untrusted peer", "198.51.100.1", nil, "203.0.113.7", []string{"10.0.0.0/8"}, "198.51.100.1"},
+		{"IPv6", "::1", []string{"2001:db8::1"}, "", []string{"::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, value := range tt.forwarded {
+				header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := forwardedClientIP(tt.remoteIP, header, parseTrustedProxies(tt.trusted)); got != tt.expectedIP {
+				t.Fatalf("expected %v, got %v", tt.expectedIP, got)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets := parseTrustedProxies([]string{"10.0.0.1", " 192.168.0.0/16 ", "::1", "invalid", "10.0.0.0/33"})
+	if len(nets) != 3 {
+		t.Fatalf("expected 3 trusted proxies, got %v", nets)
+	}
+}
+
+type auditedResource struct {
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name"`
+	Enabled bool                   `json:"enabled"`
+	Updated string                 `json:"updated,omitempty"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+}
+
+func TestAuditChanges(t *testing.T) {
+	before := &auditedResource{ID: "r1", Name: "old", Enabled: true, Updated: "2025-01-01"}
+	after := &auditedResource{ID: "r1", Name: "new", Enabled: false, Updated: "2025-01-02"}
+
+	tests := []struct {
+		name     string
+		before   interface{}
+		after    interface{}
+		expected []core.AuditChange
+	}{
+		{"updated", before, after, []core.AuditChange{
+			{Field: "enabled", Before: true, After: false},
+			{Field: "name", Before: "old", After: "new"},
+		}},
+		{"created", nil, before, []core.AuditChange{
+			{Field: "enabled", After: true},
+			{Field: "id", After: "r1"},
+			{Field: "name", After: "old"},
+		}},
+		{"deleted", before, (*auditedResource)(nil), []core.AuditChange{
+			{Field: "enabled", Before: true},
+			{Field: "id", Before: "r1"},
+			{Field: "name", Before: "old"},
+		}},
+		{"unchanged", before, &auditedResource{ID: "r1", Name: "old", Enabled: true, Updated: "2025-01-03"}, nil},
+		{"map", util.MapStr{"name": "old", "_system": util.MapStr{"owner_id": "u1"}}, util.MapStr{"name": "new"}, []core.AuditChange{
+			{Field: "name", Before: "old", After: "new"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditChanges(tt.before, tt.after); !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAuditChangesRedactSecrets(t *testing.T) {
+	encrypted := EncryptedSecretPrefix + "wrapped.ciphertext"
+	before := &auditedResource{ID: "r1", Config: map[string]interface{}{
+		"endpoint": "https://a.example.com",
+		"api_key":  "sk-old",
+		"extra":    encrypted,
+	}}
+	after := &auditedResource{ID: "r1", Config: map[string]interface{}{
+		"endpoint": "https://b.example.com",
+		"api_key":  "sk-new",
+		"extra":    encrypted,
+	}}
+
+	changes := auditChanges(before, after)
+	if len(changes) != 1 || changes[0].Field != "config" {
+		t.Fatalf("expected the config changed, got %v", changes)
+	}
+	recorded := util.MustToJSON(changes)
+	for _, secret := range []string{"sk-old", "sk-new", encrypted} {
+		if strings.Contains(recorded, secret) {
+			t.Fatalf("expected %q redacted, got %v", secret, recorded)
+		}
+	}
+	config := changes[0].After.(map[string]interface{})
+	if config["api_key"] != RedactedSecret || config["extra"] != RedactedSecret || config["endpoint"] != "https://b.example.com" {
+		t.Fatalf("expected the secrets redacted and the endpoint kept, got %v", config)
+	}
+}
@@ -84,6 +84,17 @@ const (
 	// a ProcessingDetails entry whose Description carries the reasoning trace.
 	Think = "think"
 
+	// Citation is a streaming chunk type sent once after the answer, carrying a
+	// JSON array of Citation objects for the numbered markers, e.g. "[1]", found
+	// in the answer. It is persisted in ChatMessage.Citations rather than as a
+	// ProcessingDetails entry.
+	Citation = "citation"
+
+	// ContextBudget is a ProcessingDetails.Type recording which sections of the
+	// answering prompt were truncated to fit the context window of the model;
+	// it is not a streaming chunk type. Payload is a ContextBudgetReport.
+	ContextBudget = "context_budget"
+
 	// DeepResearch is a ProcessingDetails.Type used when persisted deep research
 	// chunks are grouped into one detail entry; it is not a streaming chunk type.
 	DeepResearch = "deep_research"
@@ -96,6 +107,7 @@ const (
 	ResearchPlannerEnd          = "research_planner_end"
 	ResearchPlanList            = "research_plan_list"
 	ResearchPlanUpdated         = "research_plan_updated"
+	ResearchPlanApproval        = "research_plan_approval"
 	ResearchResearcherStart     = "research_researcher_start"
 	ResearchResearcherStepStart = "research_researcher_step_start"
 	ResearchResearcherStepEnd   = "research_researcher_step_end"
@@ -0,0 +1,73 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"errors"
+
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// ErrConcurrentUpdate is returned when a document changed since it was read.
+var ErrConcurrentUpdate = errors.New("the object was modified concurrently, reload it and retry")
+
+// conditionalUpdateScript replaces the stored document, keeping the system
+// metadata the models do not carry, if the guarded field still has the value
+// read; otherwise the document is left untouched.
+const conditionalUpdateScript = `
+def current = ctx._source[params.field];
+if (current == params.expected || (current != null && params.expected != null && current.toString() == params.expected.toString())) {
+	def system = ctx._source['_system'];
+	ctx._source = params.doc;
+	if (system != null && !params.doc.containsKey('_system')) {
+		ctx._source['_system'] = system;
+	}
+} else {
+	ctx.op = 'noop';
+}`
+
+// UpdateIfUnchanged stores the document o with the given ID, if its field
+// still has the value expected, as read before the change. A field omitted
+// from the stored document is expected as nil. ErrConcurrentUpdate is
+// returned if the document changed meanwhile, the writes of the callers
+// racing on a document are so applied one at a time. With refresh, the index
+// is refreshed once the document is stored, so the next searches see it.
+func UpdateIfUnchanged(id string, o interface{}, field string, expected interface{}, refresh bool) error {
+	doc := util.MapStr{}
+	if err := util.FromJSONBytes(util.MustToJSONBytes(o), &doc); err != nil {
+		return err
+	}
+	body := util.MapStr{
+		"query": util.MapStr{
+			"ids": util.MapStr{"values": []string{id}},
+		},
+		"script": util.MapStr{
+			"lang":   "painless",
+			"source": conditionalUpdateScript,
+			"params": util.MapStr{
+				"field":    field,
+				"expected": expected,
+				"doc":      doc,
+			},
+		},
+	}
+
+	esClient := elastic.GetClient(global.MustLookupString(elastic.GlobalSystemElasticsearchID))
+	indexName := orm.GetIndexName(o)
+	resp, err := esClient.UpdateByQuery(indexName, util.MustToJSONBytes(body))
+	if err != nil {
+		return err
+	}
+	if resp.Updated == 0 {
+		return ErrConcurrentUpdate
+	}
+	if refresh {
+		return esClient.Refresh(indexName)
+	}
+	return nil
+}
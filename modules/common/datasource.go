@@ -131,6 +131,9 @@ func GetDatasourceConfig(ctx *orm.Context, id string) (*core.DataSource, error)
 
 	exists, err := orm.GetV2(ctx, &obj)
 	if err == nil && exists {
+		if err := DecryptDataSourceSecrets(&obj); err != nil {
+			return nil, err
+		}
 		GeneralObjectCache.Set(DatasourceItemsCacheKey, id, &obj, util.GetDurationOrDefault("30m", time.Duration(30)*time.Minute))
 		return &obj, nil
 	}
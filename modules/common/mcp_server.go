@@ -37,6 +37,9 @@ func GetMPCServer(id string) (*core.MCPServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := DecryptMCPServerSecrets(server); err != nil {
+		return nil, err
+	}
 	// Cache the provider object
 	GeneralObjectCache.Set(MCPServerItemCacheKey, id, server, time.Duration(30)*time.Minute)
 	return server, nil
@@ -31,6 +31,9 @@ func GetModelProvider(providerID string) (*core.ModelProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := DecryptModelProviderSecrets(provider); err != nil {
+		return nil, err
+	}
 	// Cache the provider object
 	GeneralObjectCache.Set(ModelProviderCachePrimary, providerID, provider, time.Duration(30)*time.Minute)
 	return provider, nil
@@ -0,0 +1,112 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type pausableTimeoutKey struct{}
+
+// pausableTimeout is a timeout which stops counting while paused, e.g. while
+// waiting for the user.
+type pausableTimeout struct {
+	context.Context
+	cancel context.CancelCauseFunc
+	parent *pausableTimeout
+
+	mu        sync.Mutex
+	remaining time.Duration
+	started   time.Time
+	timer     *time.Timer
+	paused    int
+}
+
+// WithPausableTimeout is context.WithTimeout, except that the time spent
+// within PauseTimeouts does not count.
+func WithPausableTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	t := &pausableTimeout{Context: ctx, cancel: cancel, remaining: timeout, started: time.Now()}
+	if p, ok := parent.Value(pausableTimeoutKey{}).(*pausableTimeout); ok {
+		t.parent = p
+	}
+	t.timer = time.AfterFunc(timeout, t.expire)
+	return t, func() {
+		t.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+func (t *pausableTimeout) expire() {
+	t.cancel(context.DeadlineExceeded)
+}
+
+func (t *pausableTimeout) Value(key any) any {
+	if key == (pausableTimeoutKey{}) {
+		return t
+	}
+	return t.Context.Value(key)
+}
+
+func (t *pausableTimeout) Err() error {
+	if err := t.Context.Err(); err != nil {
+		return context.Cause(t.Context)
+	}
+	return nil
+}
+
+func (t *pausableTimeout) Deadline() (time.Time, bool) {
+	t.mu.Lock()
+	deadline := time.Now().Add(t.remaining)
+	if t.paused == 0 {
+		deadline = t.started.Add(t.remaining)
+	}
+	t.mu.Unlock()
+	if parent, ok := t.Context.Deadline(); ok && parent.Before(deadline) {
+		return parent, true
+	}
+	return deadline, true
+}
+
+func (t *pausableTimeout) pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused++
+	if t.paused > 1 {
+		return
+	}
+	if t.timer.Stop() {
+		t.remaining -= time.Since(t.started)
+	}
+}
+
+func (t *pausableTimeout) resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused--
+	if t.paused > 0 || t.Context.Err() != nil {
+		return
+	}
+	t.started = time.Now()
+	t.timer = time.AfterFunc(t.remaining, t.expire)
+}
+
+// PauseTimeouts stops the pausable timeouts of a context, until the returned
+// function is called.
+func PauseTimeouts(ctx context.Context) (resume func()) {
+	t, _ := ctx.Value(pausableTimeoutKey{}).(*pausableTimeout)
+	paused := []*pausableTimeout{}
+	for ; t != nil; t = t.parent {
+		t.pause()
+		paused = append(paused, t)
+	}
+	return func() {
+		for _, t := range paused {
+			t.resume()
+		}
+	}
+}
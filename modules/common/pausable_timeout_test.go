@@ -0,0 +1,65 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPausableTimeoutExpires(t *testing.T) {
+	ctx, cancel := WithPausableTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the timeout to expire")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, ctx.Err())
+	}
+}
+
+func TestPauseTimeouts(t *testing.T) {
+	outer, cancelOuter := WithPausableTimeout(context.Background(), 60*time.Millisecond)
+	defer cancelOuter()
+	inner, cancelInner := WithPausableTimeout(outer, 40*time.Millisecond)
+	defer cancelInner()
+
+	resume := PauseTimeouts(inner)
+	select {
+	case <-inner.Done():
+		t.Fatalf("expected no timeout while paused, got %v", inner.Err())
+	case <-outer.Done():
+		t.Fatalf("expected no timeout of the parent while paused, got %v", outer.Err())
+	case <-time.After(100 * time.Millisecond):
+	}
+	if deadline, ok := inner.Deadline(); !ok || time.Until(deadline) <= 0 {
+		t.Fatalf("expected a deadline in the future while paused, got %v", deadline)
+	}
+
+	resume()
+	select {
+	case <-inner.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the timeout to expire once resumed")
+	}
+	if !errors.Is(inner.Err(), context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, inner.Err())
+	}
+}
+
+func TestPausableTimeoutCancel(t *testing.T) {
+	ctx, cancel := WithPausableTimeout(context.Background(), time.Minute)
+	cancel()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, ctx.Err())
+	}
+	// pausing a finished context is harmless
+	PauseTimeouts(ctx)()
+}
@@ -75,6 +75,67 @@ Ensure your response is thoughtful, accurate, and well-structured.
 For complex answers, format your response using clear and well-organized **Markdown** to improve readability.
 `
 
+// CitationInstruction is appended to the references given to the answering
+// model, the markers are mapped back to documents by their number.
+const CitationInstruction = `
+When a statement in your answer is based on one of the references above, cite it inline right after the statement with the number of the reference in square brackets, e.g. [1] or [1][3].
+Only use the numbers of the references listed above, never invent citations, and do not add a separate list of references at the end.
+`
+
+// SummarizeHistoryPromptTemplate folds older conversation turns into the
+// running summary of a chat session.
+const SummarizeHistoryPromptTemplate = `You are maintaining the running summary of a long conversation between a user and an AI assistant.
+
+Current summary:
+{{.summary}}
+
+Older turns of the conversation that are not covered by the summary yet:
+{{.history}}
+
+Update the summary so that it also covers these turns. Keep the facts, decisions, names, numbers and open questions the assistant needs to continue the conversation, drop greetings and repeated content.
+Write in the language of the conversation, use concise bullet points and keep it under {{.max_length}} characters.
+Output only the updated summary.
+`
+
+// SessionTitlePromptTemplate names a chat session after its first exchange.
+const SessionTitlePromptTemplate = `Here is the first exchange of a conversation between a user and an AI assistant.
+
+User:
+{{.query}}
+
+Assistant:
+{{.answer}}
+
+Write a concise title for this conversation, at most 8 words, without quotes or trailing punctuation, and a one sentence summary of what the user wants.
+Use the language of the user.
+Wrap the valid JSON result in <JSON></JSON> tags, like this:
+<JSON>
+{"title": "<Title>", "summary": "<Summary>"}
+</JSON>
+`
+
+// AnswerJudgePromptTemplate scores an answer of an assistant against the
+// expected answer of an evaluation question.
+const AnswerJudgePromptTemplate = `You are evaluating the answer of an AI assistant to a question against a reference answer.
+
+Question:
+{{.question}}
+
+Reference answer:
+{{.expected}}
+
+Assistant answer:
+{{.answer}}
+
+Score how well the assistant answer matches the facts of the reference answer, from 0 to 1:
+1 when it states the same facts, 0.5 when it is partially correct or incomplete, 0 when it is wrong, contradicts the reference or does not answer.
+Ignore differences of wording, language, formatting and additional details that do not contradict the reference.
+Wrap the valid JSON result in <JSON></JSON> tags, like this:
+<JSON>
+{"score": <Score>, "reason": "<One sentence explaining the score>"}
+</JSON>
+`
+
 const PickingDocPromptTemplate = `
 You are an AI assistant trained to select the most relevant documents for further processing and to answer user queries.
 We have already queried the backend database and retrieved a list of documents that may help answer the user's query. And also invoke some external tools provided by MCP servers. 
@@ -0,0 +1,70 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// maxPublicRedirects bounds the redirects followed by a public HTTP client.
+const maxPublicRedirects = 5
+
+// sharedAddressSpace is the carrier-grade NAT range, RFC 6598, not public.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPublicIP reports whether an IP is routable on the internet: loopback,
+// private, link-local (cloud metadata endpoints included), multicast and
+// unspecified addresses are not.
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// publicDialControl refuses the connections to non-public addresses, checked
+// once resolved, so a host name can not be pointed at the internal network.
+func publicDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return fmt.Errorf("connecting to the non-public address [%v] is not allowed", host)
+	}
+	return nil
+}
+
+// NewPublicHTTPClient returns an HTTP client for the URLs given by users, it
+// only connects to public addresses, redirects included.
+func NewPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: publicDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// through a proxy, the proxy would be checked instead of the target
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPublicRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme [%v]", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
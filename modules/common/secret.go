@@ -0,0 +1,678 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/keystore"
+)
+
+// The secrets of model providers, connectors, datasources, MCP servers and
+// assistants are encrypted at rest with envelope encryption: each value is encrypted with its
+// own data key, which is wrapped with the master key kept in the keystore.
+// They are decrypted only in the server process, the read APIs redact them.
+//
+// The master key is provisioned by the operator, the same on every node, and
+// backed up apart from the data. It is generated on the first start only,
+// while no stored secret is encrypted. To rotate it, the replaced keys are kept in
+// the previous keys to decrypt, until the stored secrets are encrypted again.
+const (
+	// EncryptedSecretPrefix marks an encrypted secret
+	EncryptedSecretPrefix = "coco:enc:v1:"
+	// RedactedSecret replaces a secret in the responses of the read APIs, an
+	// update with it keeps the stored secret
+	RedactedSecret = "******"
+
+	secretMasterKeyName         = "coco_secret_master_key"
+	secretPreviousMasterKeyName = "coco_secret_previous_master_keys"
+	secretKeySize               = 32
+)
+
+var (
+	// secretConfigKeys are the keys of the secret values of connector and
+	// datasource configs, at any depth
+	secretConfigKeys = map[string]bool{
+		"api_key":           true,
+		"app_secret":        true,
+		"client_secret":     true,
+		"secret_access_key": true,
+		"private_key":       true,
+		"password":          true,
+		"token":             true,
+		"access_token":      true,
+		"refresh_token":     true,
+		"auth_token":        true,
+		"user_access_token": true,
+		"connection_uri":    true,
+	}
+	// mcpSecretConfigKeys are the keys of the secret values of MCP server
+	// configs, all the environment variables of a stdio server included
+	mcpSecretConfigKeys = map[string]bool{
+		"env":          true,
+		"api_key":      true,
+		"token":        true,
+		"access_token": true,
+		"password":     true,
+	}
+
+	secretMasterKeys     *secretKeys
+	secretMasterKeysLock sync.Mutex
+)
+
+// secretKeys are the master key encrypting the secrets, and the previous ones
+// still decrypting the secrets not encrypted again since a rotation.
+type secretKeys struct {
+	current  []byte
+	previous [][]byte
+}
+
+// CheckSecretMasterKey checks the master key of the secrets is provisioned,
+// for the server to not start without it.
+func CheckSecretMasterKey() error {
+	_, err := getSecretMasterKeys()
+	return err
+}
+
+// EnsureSecretMasterKey generates the master key of the secrets, when it is
+// not provisioned and no stored secret is encrypted yet, e.g. on the first
+// start of a new install or of an upgrade from a version storing the secrets
+// in plain text. Once secrets are encrypted, a missing key is an error: a new
+// key would not decrypt them, e.g. on a node not provisioned with the key of
+// the others.
+func EnsureSecretMasterKey(hasEncryptedSecrets func() (bool, error)) error {
+	value, err := keystore.GetValue(secretMasterKeyName)
+	if err != nil {
+		return errors.Errorf("failed to read the secret master key from the keystore: %v", err)
+	}
+	if len(value) > 0 {
+		return CheckSecretMasterKey()
+	}
+	encrypted, err := hasEncryptedSecrets()
+	if err != nil {
+		return errors.Errorf("failed to check the stored secrets: %v", err)
+	}
+	if encrypted {
+		return errors.Errorf("the stored secrets are encrypted but the secret master key is not provisioned, add the master key they were encrypted with to the keystore with `coco keystore add %v`", secretMasterKeyName)
+	}
+
+	key := make([]byte, secretKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := keystore.SetValue(secretMasterKeyName, []byte(base64.StdEncoding.EncodeToString(key))); err != nil {
+		return errors.Errorf("failed to save the generated secret master key to the keystore: %v", err)
+	}
+	log.Warnf("generated the secret master key [%v] in the keystore, back it up apart from the data, and provision it to the other nodes before they start", secretMasterKeyName)
+	return CheckSecretMasterKey()
+}
+
+// getSecretMasterKeys returns the master keys of the secrets from the
+// keystore. They are only generated by EnsureSecretMasterKey: a key generated
+// by a node once secrets are encrypted would differ from the other nodes.
+func getSecretMasterKeys() (*secretKeys, error) {
+	secretMasterKeysLock.Lock()
+	defer secretMasterKeysLock.Unlock()
+	if secretMasterKeys != nil {
+		return secretMasterKeys, nil
+	}
+
+	value, err := keystore.GetValue(secretMasterKeyName)
+	if err != nil {
+		return nil, errors.Errorf("failed to read the secret master key from the keystore: %v", err)
+	}
+	if len(value) == 0 {
+		return nil, errors.Errorf("the secret master key is not provisioned, add a base64 encoded %d bytes key, e.g. by `openssl rand -base64 %d`, to the keystore with `coco keystore add %v`", secretKeySize, secretKeySize, secretMasterKeyName)
+	}
+	current, err := parseSecretMasterKey(string(value))
+	if err != nil {
+		return nil, errors.Errorf("invalid secret master key [%v] in the keystore: %v", secretMasterKeyName, err)
+	}
+	keys := &secretKeys{current: current}
+
+	value, err = keystore.GetValue(secretPreviousMasterKeyName)
+	if err != nil {
+		return nil, errors.Errorf("failed to read the previous secret master keys from the keystore: %v", err)
+	}
+	for _, item := range strings.Split(string(value), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		previous, err := parseSecretMasterKey(item)
+		if err != nil {
+			return nil, errors.Errorf("invalid previous secret master key in [%v] of the keystore: %v", secretPreviousMasterKeyName, err)
+		}
+		keys.previous = append(keys.previous, previous)
+	}
+	secretMasterKeys = keys
+	return keys, nil
+}
+
+func parseSecretMasterKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != secretKeySize {
+		return nil, errors.Errorf("a base64 encoded %d bytes key is expected", secretKeySize)
+	}
+	return key, nil
+}
+
+// IsEncryptedSecret reports whether a value is an encrypted secret.
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, EncryptedSecretPrefix)
+}
+
+// EncryptSecret encrypts a secret to be stored, an empty value or one already
+// encrypted with the current master key is returned as is. A value encrypted
+// with a previous master key is encrypted again with the current one.
+func EncryptSecret(plaintext string) (string, error) {
+	if plaintext == "" {
+		return plaintext, nil
+	}
+	keys, err := getSecretMasterKeys()
+	if err != nil {
+		return "", err
+	}
+	return encryptSecretWithKeys(keys, plaintext)
+}
+
+// DecryptSecret decrypts a stored secret, a value stored before secrets were
+// encrypted is returned as is.
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	keys, err := getSecretMasterKeys()
+	if err != nil {
+		return "", err
+	}
+	return decryptSecretWithKeys(keys, value)
+}
+
+func encryptSecretWithKeys(keys *secretKeys, value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return encryptSecretWithKey(keys.current, value)
+	}
+	if len(keys.previous) == 0 {
+		return value, nil
+	}
+	if _, err := decryptSecretWithKey(keys.current, value); err == nil {
+		return value, nil
+	}
+	plaintext, err := decryptSecretWithKeys(keys, value)
+	if err != nil {
+		return "", err
+	}
+	return encryptSecretWithKey(keys.current, plaintext)
+}
+
+// decryptSecretWithKeys decrypts a secret with the current master key, or the
+// previous ones.
+func decryptSecretWithKeys(keys *secretKeys, value string) (string, error) {
+	plaintext, err := decryptSecretWithKey(keys.current, value)
+	if err == nil {
+		return plaintext, nil
+	}
+	for _, previous := range keys.previous {
+		if plaintext, err1 := decryptSecretWithKey(previous, value); err1 == nil {
+			return plaintext, nil
+		}
+	}
+	return "", err
+}
+
+func encryptSecretWithKey(masterKey []byte, plaintext string) (string, error) {
+	dataKey := make([]byte, secretKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := sealAESGCM(masterKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealAESGCM(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return EncryptedSecretPrefix + base64.RawURLEncoding.EncodeToString(wrappedKey) + "." +
+		base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func decryptSecretWithKey(masterKey []byte, value string) (string, error) {
+	wrappedKey, ciphertext, ok := strings.Cut(strings.TrimPrefix(value, EncryptedSecretPrefix), ".")
+	if !ok {
+		return "", errors.New("invalid encrypted secret")
+	}
+	wrappedKeyBytes, err := base64.RawURLEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return "", errors.Errorf("invalid encrypted secret: %v", err)
+	}
+	ciphertextBytes, err := base64.RawURLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", errors.Errorf("invalid encrypted secret: %v", err)
+	}
+	dataKey, err := openAESGCM(masterKey, wrappedKeyBytes)
+	if err != nil {
+		return "", errors.Errorf("failed to unwrap the key of a secret, the master key may have changed: %v", err)
+	}
+	plaintext, err := openAESGCM(dataKey, ciphertextBytes)
+	if err != nil {
+		return "", errors.Errorf("failed to decrypt a secret: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// sealAESGCM encrypts data with AES-GCM, the nonce first.
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// redactSecret replaces a non-empty secret with RedactedSecret.
+func redactSecret(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	return RedactedSecret, nil
+}
+
+// RestoreRedactedSecret returns the stored secret for a value set to
+// RedactedSecret, e.g. by a config read then updated as is.
+func RestoreRedactedSecret(value, stored string) string {
+	if value == RedactedSecret {
+		return stored
+	}
+	return value
+}
+
+// transformConfigSecrets returns a copy of a config with the secret values,
+// by their keys, transformed. A config set in process as a struct is
+// converted to a map.
+func transformConfigSecrets(config interface{}, keys map[string]bool, secret bool, transform func(string) (string, error)) (interface{}, error) {
+	switch v := config.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if secret {
+			return transform(v)
+		}
+		return v, nil
+	case map[string]interface{}:
+		if v == nil {
+			return v, nil
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			transformed, err := transformConfigSecrets(item, keys, secret || keys[k], transform)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = transformed
+		}
+		return out, nil
+	case util.MapStr:
+		out, err := transformConfigSecrets(map[string]interface{}(v), keys, secret, transform)
+		if err != nil || out == nil {
+			return out, err
+		}
+		return util.MapStr(out.(map[string]interface{})), nil
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for k, item := range v {
+			transformed := item
+			if secret || keys[k] {
+				var err error
+				if transformed, err = transform(item); err != nil {
+					return nil, err
+				}
+			}
+			out[k] = transformed
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			transformed, err := transformConfigSecrets(item, keys, secret, transform)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = transformed
+		}
+		return out, nil
+	}
+
+	switch reflect.Indirect(reflect.ValueOf(config)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice:
+		var normalized interface{}
+		if err := util.FromJSONBytes(util.MustToJSONBytes(config), &normalized); err != nil {
+			return nil, err
+		}
+		return transformConfigSecrets(normalized, keys, secret, transform)
+	}
+	return config, nil
+}
+
+// restoreRedactedConfigSecrets returns a copy of a config with the values set
+// to RedactedSecret replaced by the ones of the stored config.
+func restoreRedactedConfigSecrets(config, stored interface{}) interface{} {
+	storedMap := func() map[string]interface{} {
+		switch s := stored.(type) {
+		case map[string]interface{}:
+			return s
+		case util.MapStr:
+			return s
+		}
+		return nil
+	}
+
+	switch v := config.(type) {
+	case string:
+		if s, ok := stored.(string); ok {
+			return RestoreRedactedSecret(v, s)
+		}
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		previous := storedMap()
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = restoreRedactedConfigSecrets(item, previous[k])
+		}
+		return out
+	case util.MapStr:
+		return util.MapStr(restoreRedactedConfigSecrets(map[string]interface{}(v), stored).(map[string]interface{}))
+	case []interface{}:
+		previous, _ := stored.([]interface{})
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			var storedItem interface{}
+			if i < len(previous) {
+				storedItem = previous[i]
+			}
+			out[i] = restoreRedactedConfigSecrets(item, storedItem)
+		}
+		return out
+	}
+	return config
+}
+
+// EncryptModelProviderSecrets encrypts the API key of a model provider to be
+// stored.
+func EncryptModelProviderSecrets(provider *core.ModelProvider) error {
+	apiKey, err := EncryptSecret(provider.APIKey)
+	if err != nil {
+		return err
+	}
+	provider.APIKey = apiKey
+	return nil
+}
+
+// DecryptModelProviderSecrets decrypts the API key of a stored model provider.
+func DecryptModelProviderSecrets(provider *core.ModelProvider) error {
+	apiKey, err := DecryptSecret(provider.APIKey)
+	if err != nil {
+		return errors.Errorf("failed to decrypt the API key of model provider [%v]: %v", provider.ID, err)
+	}
+	provider.APIKey = apiKey
+	return nil
+}
+
+// RedactModelProviderSecrets redacts the API key of a model provider returned
+// by an API.
+func RedactModelProviderSecrets(provider *core.ModelProvider) {
+	provider.APIKey, _ = redactSecret(provider.APIKey)
+}
+
+// EncryptDataSourceSecrets encrypts the secrets of the connector config of a
+// datasource to be stored.
+func EncryptDataSourceSecrets(datasource *core.DataSource) error {
+	config, err := transformConfigSecrets(datasource.Connector.Config, secretConfigKeys, false, EncryptSecret)
+	if err != nil {
+		return err
+	}
+	datasource.Connector.Config = config
+	return nil
+}
+
+// DecryptDataSourceSecrets decrypts the secrets of the connector config of a
+// stored datasource.
+func DecryptDataSourceSecrets(datasource *core.DataSource) error {
+	config, err := transformConfigSecrets(datasource.Connector.Config, secretConfigKeys, false, DecryptSecret)
+	if err != nil {
+		return errors.Errorf("failed to decrypt the config of datasource [%v]: %v", datasource.ID, err)
+	}
+	datasource.Connector.Config = config
+	return nil
+}
+
+// RedactDataSourceSecrets redacts the secrets of the connector config of a
+// datasource returned by an API.
+func RedactDataSourceSecrets(datasource *core.DataSource) {
+	datasource.Connector.Config = RedactConfigSecrets(datasource.Connector.Config)
+}
+
+// RestoreDataSourceSecrets keeps the stored secrets of a datasource updated
+// with redacted ones.
+func RestoreDataSourceSecrets(datasource, stored *core.DataSource) {
+	datasource.Connector.Config = restoreRedactedConfigSecrets(datasource.Connector.Config, stored.Connector.Config)
+}
+
+// EncryptConnectorSecrets encrypts the secrets of the config of a connector,
+// e.g. its OAuth client secret, to be stored.
+func EncryptConnectorSecrets(connector *core.Connector) error {
+	config, err := transformConfigSecrets(connector.Config, secretConfigKeys, false, EncryptSecret)
+	if err != nil {
+		return err
+	}
+	connector.Config, _ = config.(map[string]interface{})
+	return nil
+}
+
+// DecryptConnectorSecrets decrypts the secrets of the config of a stored
+// connector.
+func DecryptConnectorSecrets(connector *core.Connector) error {
+	config, err := transformConfigSecrets(connector.Config, secretConfigKeys, false, DecryptSecret)
+	if err != nil {
+		return errors.Errorf("failed to decrypt the config of connector [%v]: %v", connector.ID, err)
+	}
+	connector.Config, _ = config.(map[string]interface{})
+	return nil
+}
+
+// RedactConnectorSecrets redacts the secrets of the config of a connector
+// returned by an API.
+func RedactConnectorSecrets(connector *core.Connector) {
+	connector.Config, _ = RedactConfigSecrets(connector.Config).(map[string]interface{})
+}
+
+// RestoreConnectorSecrets keeps the stored secrets of a connector updated with
+// redacted ones.
+func RestoreConnectorSecrets(connector *core.Connector, storedConfig map[string]interface{}) {
+	connector.Config, _ = restoreRedactedConfigSecrets(connector.Config, storedConfig).(map[string]interface{})
+}
+
+// EncryptMCPServerSecrets encrypts the secrets of the config of an MCP server,
+// e.g. the environment variables of a stdio server, to be stored.
+func EncryptMCPServerSecrets(server *core.MCPServer) error {
+	config, err := transformConfigSecrets(server.Config, mcpSecretConfigKeys, false, EncryptSecret)
+	if err != nil {
+		return err
+	}
+	server.Config = config
+	return nil
+}
+
+// DecryptMCPServerSecrets decrypts the secrets of the config of a stored MCP
+// server.
+func DecryptMCPServerSecrets(server *core.MCPServer) error {
+	config, err := transformConfigSecrets(server.Config, mcpSecretConfigKeys, false, DecryptSecret)
+	if err != nil {
+		return errors.Errorf("failed to decrypt the config of MCP server [%v]: %v", server.ID, err)
+	}
+	server.Config = config
+	return nil
+}
+
+// RedactMCPServerSecrets redacts the secrets of the config of an MCP server
+// returned by an API.
+func RedactMCPServerSecrets(server *core.MCPServer) {
+	server.Config, _ = transformConfigSecrets(server.Config, mcpSecretConfigKeys, false, redactSecret)
+}
+
+// RestoreMCPServerSecrets keeps the stored secrets of an MCP server updated
+// with redacted ones.
+func RestoreMCPServerSecrets(server *core.MCPServer, storedConfig interface{}) {
+	server.Config = restoreRedactedConfigSecrets(server.Config, storedConfig)
+}
+
+// EncryptAssistantSecrets encrypts the secrets of an assistant to be stored:
+// the API key of its web search tool and the secrets of its config, e.g. the
+// API key of the external search of a deep research assistant.
+func EncryptAssistantSecrets(assistant *core.Assistant) error {
+	return transformAssistantSecrets(assistant, EncryptSecret)
+}
+
+// DecryptAssistantSecrets decrypts the secrets of a stored assistant.
+func DecryptAssistantSecrets(assistant *core.Assistant) error {
+	if err := transformAssistantSecrets(assistant, DecryptSecret); err != nil {
+		return errors.Errorf("failed to decrypt the secrets of assistant [%v]: %v", assistant.ID, err)
+	}
+	return nil
+}
+
+// RedactAssistantSecrets redacts the secrets of an assistant returned by an
+// API. The assistant may be cached, its web search config is replaced, not
+// changed.
+func RedactAssistantSecrets(assistant *core.Assistant) {
+	_ = transformAssistantSecrets(assistant, redactSecret)
+}
+
+// RestoreAssistantSecrets keeps the stored secrets of an assistant updated
+// with redacted ones.
+func RestoreAssistantSecrets(assistant, stored *core.Assistant) {
+	if ws := assistant.ToolsConfig.BuiltinTools.WebSearch; ws != nil {
+		if previous := stored.ToolsConfig.BuiltinTools.WebSearch; previous != nil {
+			ws.APIKey = RestoreRedactedSecret(ws.APIKey, previous.APIKey)
+		}
+	}
+	assistant.Config = restoreRedactedConfigSecrets(assistant.Config, stored.Config)
+}
+
+func transformAssistantSecrets(assistant *core.Assistant, transform func(string) (string, error)) error {
+	if ws := assistant.ToolsConfig.BuiltinTools.WebSearch; ws != nil {
+		transformed := *ws
+		apiKey, err := transform(ws.APIKey)
+		if err != nil {
+			return err
+		}
+		transformed.APIKey = apiKey
+		assistant.ToolsConfig.BuiltinTools.WebSearch = &transformed
+	}
+	config, err := transformConfigSecrets(assistant.Config, secretConfigKeys, false, transform)
+	if err != nil {
+		return err
+	}
+	assistant.Config = config
+	return nil
+}
+
+// RedactConfigSecrets returns a copy of a connector or datasource config with
+// its secrets redacted.
+func RedactConfigSecrets(config interface{}) interface{} {
+	redacted, err := transformConfigSecrets(config, secretConfigKeys, false, redactSecret)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// RedactSecrets returns a copy of an object, e.g. a model provider or a
+// connector, converted to a map with its secrets redacted, the encrypted
+// values of any key included.
+func RedactSecrets(obj interface{}) interface{} {
+	keys := make(map[string]bool, len(secretConfigKeys)+len(mcpSecretConfigKeys))
+	for k := range secretConfigKeys {
+		keys[k] = true
+	}
+	for k := range mcpSecretConfigKeys {
+		keys[k] = true
+	}
+	redacted, err := transformConfigSecrets(obj, keys, false, redactSecret)
+	if err != nil {
+		return nil
+	}
+	return redactEncryptedSecrets(redacted)
+}
+
+// RedactFieldSecrets returns a copy of the value of a field, by its dotted
+// path, with its secrets redacted, the field itself if its key is a secret
+// one, e.g. for the changes of an assistant revision.
+func RedactFieldSecrets(field string, value interface{}) interface{} {
+	key := field[strings.LastIndex(field, ".")+1:]
+	redacted, err := transformConfigSecrets(value, secretConfigKeys, secretConfigKeys[key], redactSecret)
+	if err != nil {
+		return nil
+	}
+	return redactEncryptedSecrets(redacted)
+}
+
+func redactEncryptedSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if IsEncryptedSecret(v) {
+			return RedactedSecret
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = redactEncryptedSecrets(item)
+		}
+	case util.MapStr:
+		for k, item := range v {
+			v[k] = redactEncryptedSecrets(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactEncryptedSecrets(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,287 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/util"
+)
+
+func testSecretKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, secretKeySize)
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	keys := &secretKeys{current: testSecretKey(1)}
+	encrypted, err := encryptSecretWithKeys(keys, "sk-secret")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !IsEncryptedSecret(encrypted) || strings.Contains(encrypted, "sk-secret") {
+		t.Fatalf("expected an encrypted secret, got %q", encrypted)
+	}
+	again, err := encryptSecretWithKeys(keys, "sk-secret")
+	if err != nil || again == encrypted {
+		t.Fatalf("expected each encryption to differ, got %q, %v", again, err)
+	}
+	kept, err := encryptSecretWithKeys(keys, encrypted)
+	if err != nil || kept != encrypted {
+		t.Fatalf("expected an encrypted secret kept as is, got %q, %v", kept, err)
+	}
+	plaintext, err := decryptSecretWithKeys(keys, encrypted)
+	if err != nil || plaintext != "sk-secret" {
+		t.Fatalf("expected sk-secret, got %q, %v", plaintext, err)
+	}
+}
+
+func TestDecryptSecretTampered(t *testing.T) {
+	keys := &secretKeys{current: testSecretKey(1)}
+	encrypted, err := encryptSecretWithKeys(keys, "sk-secret")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	wrappedKey, ciphertext, _ := strings.Cut(strings.TrimPrefix(encrypted, EncryptedSecretPrefix), ".")
+	flip := func(value string) string {
+		b, _ := base64.RawURLEncoding.DecodeString(value)
+		b[len(b)-1] ^= 1
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	tests := map[string]string{
+		"ciphertext":  EncryptedSecretPrefix + wrappedKey + "." + flip(ciphertext),
+		"wrapped key": EncryptedSecretPrefix + flip(wrappedKey) + "." + ciphertext,
+		"swapped":     EncryptedSecretPrefix + ciphertext + "." + wrappedKey,
+		"truncated":   EncryptedSecretPrefix + wrappedKey,
+		"not base64":  EncryptedSecretPrefix + "!!." + ciphertext,
+	}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decryptSecretWithKeys(keys, value); err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+
+	if _, err := decryptSecretWithKeys(&secretKeys{current: testSecretKey(2)}, encrypted); err == nil {
+		t.Fatalf("expected an error with another master key")
+	}
+}
+
+func TestEncryptSecretRotation(t *testing.T) {
+	old := &secretKeys{current: testSecretKey(1)}
+	encrypted, err := encryptSecretWithKeys(old, "sk-secret")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	rotated := &secretKeys{current: testSecretKey(2), previous: [][]byte{testSecretKey(1)}}
+	plaintext, err := decryptSecretWithKeys(rotated, encrypted)
+	if err != nil || plaintext != "sk-secret" {
+		t.Fatalf("expected sk-secret with the previous key, got %q, %v", plaintext, err)
+	}
+	reencrypted, err := encryptSecretWithKeys(rotated, encrypted)
+	if err != nil || reencrypted == encrypted {
+		t.Fatalf("expected the secret encrypted again, got %q, %v", reencrypted, err)
+	}
+	if plaintext, err := decryptSecretWithKeys(&secretKeys{current: testSecretKey(2)}, reencrypted); err != nil || plaintext != "sk-secret" {
+		t.Fatalf("expected sk-secret with the new key only, got %q, %v", plaintext, err)
+	}
+	kept, err := encryptSecretWithKeys(rotated, reencrypted)
+	if err != nil || kept != reencrypted {
+		t.Fatalf("expected a secret of the new key kept as is, got %q, %v", kept, err)
+	}
+}
+
+func TestParseSecretMasterKey(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(testSecretKey(1))
+	if key, err := parseSecretMasterKey(" " + valid + "\n"); err != nil || !bytes.Equal(key, testSecretKey(1)) {
+		t.Fatalf("expected the key, got %v, %v", key, err)
+	}
+	for _, value := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := parseSecretMasterKey(value); err == nil {
+			t.Fatalf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestTransformConfigSecrets(t *testing.T) {
+	upper := func(value string) (string, error) {
+		return strings.ToUpper(value), nil
+	}
+	config := map[string]interface{}{
+		"endpoint": "https://example.com",
+		"api_key":  "key",
+		"auth": map[string]interface{}{
+			"username": "user",
+			"password": "pass",
+		},
+		"headers": map[string]string{
+			"accept": "json",
+			"token":  "tok",
+		},
+		"accounts": []interface{}{
+			map[string]interface{}{"name": "a", "client_secret": "s1"},
+			util.MapStr{"name": "b", "access_token": "t2"},
+		},
+		"env":     map[string]interface{}{"HOME": "/root"},
+		"enabled": true,
+	}
+
+	got, err := transformConfigSecrets(config, secretConfigKeys, false, upper)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]interface{}{
+		"endpoint": "https://example.com",
+		"api_key":  "KEY",
+		"auth": map[string]interface{}{
+			"username": "user",
+			"password": "PASS",
+		},
+		"headers": map[string]string{
+			"accept": "json",
+			"token":  "TOK",
+		},
+		"accounts": []interface{}{
+			map[string]interface{}{"name": "a", "client_secret": "S1"},
+			util.MapStr{"name": "b", "access_token": "T2"},
+		},
+		"env":     map[string]interface{}{"HOME": "/root"},
+		"enabled": true,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if config["api_key"] != "key" {
+		t.Fatalf("expected the config left unchanged, got %v", config["api_key"])
+	}
+
+	// all the values under a secret key are secrets, e.g. the MCP env
+	got, err = transformConfigSecrets(util.MapStr{"env": map[string]interface{}{"HOME": "/root"}}, mcpSecretConfigKeys, false, upper)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if env := got.(util.MapStr)["env"].(map[string]interface{}); env["HOME"] != "/ROOT" {
+		t.Fatalf("expected /ROOT, got %v", env["HOME"])
+	}
+
+	// a struct is converted to a map
+	got, err = transformConfigSecrets(struct {
+		Token string `json:"token"`
+	}{Token: "tok"}, secretConfigKeys, false, upper)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(got, map[string]interface{}{"token": "TOK"}) {
+		t.Fatalf("expected the token transformed, got %v", got)
+	}
+}
+
+func TestRestoreRedactedConfigSecrets(t *testing.T) {
+	stored := map[string]interface{}{
+		"api_key": "stored-key",
+		"auth":    map[string]interface{}{"password": "stored-pass"},
+		"accounts": []interface{}{
+			map[string]interface{}{"client_secret": "stored-s1"},
+		},
+	}
+	config := util.MapStr{
+		"api_key": RedactedSecret,
+		"auth":    map[string]interface{}{"password": "new-pass"},
+		"accounts": []interface{}{
+			map[string]interface{}{"client_secret": RedactedSecret},
+			map[string]interface{}{"client_secret": RedactedSecret},
+		},
+		"endpoint": "https://example.com",
+	}
+
+	got := restoreRedactedConfigSecrets(config, stored)
+	expected := util.MapStr{
+		"api_key": "stored-key",
+		"auth":    map[string]interface{}{"password": "new-pass"},
+		"accounts": []interface{}{
+			map[string]interface{}{"client_secret": "stored-s1"},
+			// nothing stored to restore
+			map[string]interface{}{"client_secret": RedactedSecret},
+		},
+		"endpoint": "https://example.com",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestAssistantSecrets(t *testing.T) {
+	previous := secretMasterKeys
+	secretMasterKeys = &secretKeys{current: testSecretKey(1)}
+	t.Cleanup(func() { secretMasterKeys = previous })
+
+	assistant := &core.Assistant{Config: map[string]interface{}{
+		"external_search": map[string]interface{}{"engine": "tavily", "api_key": "tvly-key"},
+		"report_format":   "markdown",
+	}}
+	assistant.ToolsConfig.BuiltinTools.WebSearch = &core.WebSearchConfig{Engine: core.WebSearchEngineBrave, APIKey: "brave-key"}
+
+	if err := EncryptAssistantSecrets(assistant); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stored := util.MustToJSON(assistant)
+	if strings.Contains(stored, "tvly-key") || strings.Contains(stored, "brave-key") {
+		t.Fatalf("expected the API keys encrypted, got %v", stored)
+	}
+
+	// an assistant read then updated as is keeps its stored secrets
+	updated := &core.Assistant{}
+	util.MustFromJSONBytes([]byte(stored), updated)
+	RedactAssistantSecrets(updated)
+	if updated.ToolsConfig.BuiltinTools.WebSearch.APIKey != RedactedSecret || strings.Contains(util.MustToJSON(updated), EncryptedSecretPrefix) {
+		t.Fatalf("expected the API keys redacted, got %v", util.MustToJSON(updated))
+	}
+	RestoreAssistantSecrets(updated, assistant)
+	if util.MustToJSON(updated) != stored {
+		t.Fatalf("expected the stored secrets restored, got %v", util.MustToJSON(updated))
+	}
+
+	// the web search config of a cached assistant is replaced, not changed
+	webSearch := assistant.ToolsConfig.BuiltinTools.WebSearch
+	if err := DecryptAssistantSecrets(assistant); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if assistant.ToolsConfig.BuiltinTools.WebSearch.APIKey != "brave-key" || !IsEncryptedSecret(webSearch.APIKey) {
+		t.Fatalf("expected brave-key in a copy, got %q, %q", assistant.ToolsConfig.BuiltinTools.WebSearch.APIKey, webSearch.APIKey)
+	}
+	if apiKey := assistant.Config.(map[string]interface{})["external_search"].(map[string]interface{})["api_key"]; apiKey != "tvly-key" {
+		t.Fatalf("expected tvly-key, got %v", apiKey)
+	}
+}
+
+func TestRedactFieldSecrets(t *testing.T) {
+	encrypted := EncryptedSecretPrefix + "wrapped.ciphertext"
+	tests := []struct {
+		field    string
+		value    interface{}
+		expected interface{}
+	}{
+		{"tools.builtin.web_search.api_key", "plain-key", RedactedSecret},
+		{"config.external_search.api_key", encrypted, RedactedSecret},
+		{"config.external_search.api_key", nil, nil},
+		{"config.auth", map[string]interface{}{"type": "bearer", "token": "tok"}, map[string]interface{}{"type": "bearer", "token": RedactedSecret}},
+		{"config.extra", encrypted, RedactedSecret},
+		{"name", "assistant", "assistant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			if got := RedactFieldSecrets(tt.field, tt.value); !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
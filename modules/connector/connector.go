@@ -27,6 +27,11 @@ func (h *APIHandler) create(w http.ResponseWriter, req *http.Request, ps httprou
 		return
 	}
 
+	if err := common.EncryptConnectorSecrets(obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ctx := orm.NewContextWithParent(req.Context())
 	ctx.Refresh = orm.WaitForRefresh
 
@@ -35,6 +40,7 @@ func (h *APIHandler) create(w http.ResponseWriter, req *http.Request, ps httprou
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	common.RecordAudit(req, core.AuditActionCreate, core.AuditResourceConnector, obj.ID, nil, obj)
 
 	h.WriteJSON(w, util.MapStr{
 		"_id":    obj.ID,
@@ -63,6 +69,7 @@ func (h *APIHandler) get(w http.ResponseWriter, req *http.Request, ps httprouter
 		return
 	}
 
+	common.RedactConnectorSecrets(&obj)
 	h.WriteJSON(w, util.MapStr{
 		"found":   true,
 		"_id":     id,
@@ -82,6 +89,9 @@ func GetConnectorByID(id string) (*core.Connector, error) {
 
 	exists, err := orm.GetV2(ctx, &obj)
 	if exists && err == nil {
+		if err := common.DecryptConnectorSecrets(&obj); err != nil {
+			return nil, err
+		}
 		return &obj, nil
 	}
 	return nil, errors.Errorf("fail to get connector: %v", id)
@@ -97,6 +107,8 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 	var err error
 	var create *time.Time
 	var builtin, oauthConnectFromDB bool
+	var storedConfig map[string]interface{}
+	var before *core.Connector
 	if !replace {
 		obj.ID = id
 
@@ -113,6 +125,9 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 		create = obj.Created
 		builtin = obj.Builtin
 		oauthConnectFromDB = obj.OAuthConnectImplemented
+		storedConfig = obj.Config
+		stored := obj
+		before = &stored
 	} else {
 		t := time.Now()
 		create = &t
@@ -135,6 +150,11 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 	obj.ID = id
 	obj.Created = create
 	obj.Builtin = builtin
+	common.RestoreConnectorSecrets(&obj, storedConfig)
+	if err := common.EncryptConnectorSecrets(&obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "connector")
 
@@ -145,6 +165,7 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	common.RecordAudit(req, core.AuditActionUpdate, core.AuditResourceConnector, obj.ID, before, &obj)
 
 	h.WriteJSON(w, util.MapStr{
 		"_id":    obj.ID,
@@ -180,6 +201,7 @@ func (h *APIHandler) delete(w http.ResponseWriter, req *http.Request, ps httprou
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	common.RecordAudit(req, core.AuditActionDelete, core.AuditResourceConnector, obj.ID, &obj, nil)
 
 	h.WriteJSON(w, util.MapStr{
 		"_id":    obj.ID,
@@ -212,6 +234,10 @@ func (h *APIHandler) search(w http.ResponseWriter, req *http.Request, ps httprou
 	var connectors []core.Connector
 
 	itemMapFunc := func(source map[string]interface{}, targetRef interface{}) error {
+		if config, ok := source["config"]; ok {
+			source["config"] = common.RedactConfigSecrets(config)
+		}
+
 		if !appConfig.ServerInfo.EncodeIconToBase64 {
 			return nil
 		}
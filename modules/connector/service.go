@@ -7,6 +7,7 @@ package connector
 import (
 	"errors"
 	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
 	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/util"
 	ccache "infini.sh/framework/lib/cache"
@@ -32,6 +33,9 @@ func GetConnectorConfig(id string) (*core.Connector, error) {
 
 	exists, err := orm.Get(&obj)
 	if err == nil && exists {
+		if err := common.DecryptConnectorSecrets(&obj); err != nil {
+			return nil, err
+		}
 		configCache.Set(connectorCacheKey, id, &obj, util.GetDurationOrDefault("30m", time.Duration(30)*time.Minute))
 		return &obj, nil
 	}
@@ -37,7 +37,10 @@ func (h *APIHandler) createDatasource(w http.ResponseWriter, req *http.Request,
 
 		ctx.Refresh = orm.WaitForRefresh
 
-		err = orm.Create(ctx, obj)
+		err = common.EncryptDataSourceSecrets(obj)
+		if err == nil {
+			err = orm.Create(ctx, obj)
+		}
 		if err != nil {
 			h.WriteError(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -46,6 +49,7 @@ func (h *APIHandler) createDatasource(w http.ResponseWriter, req *http.Request,
 			common.ClearDatasourcesCache()
 			common.ClearDatasourceCache(obj.ID)
 		}
+		common.RecordAudit(req, core.AuditActionCreate, core.AuditResourceDatasource, obj.ID, nil, obj)
 
 		h.WriteJSON(w, util.MapStr{
 			"_id":    obj.ID,
@@ -57,8 +61,21 @@ func (h *APIHandler) createDatasource(w http.ResponseWriter, req *http.Request,
 	h.WriteError(w, "invalid datasource", http.StatusInternalServerError)
 }
 
+// apiKeyDenied writes the error if the API key of a request, if any, may not
+// access a datasource.
+func (h *APIHandler) apiKeyDenied(w http.ResponseWriter, req *http.Request, datasourceID string) bool {
+	if core.APIKeyAllowsDatasource(req.Context(), datasourceID) {
+		return false
+	}
+	h.WriteError(w, "api key not allowed to access the datasource", http.StatusForbidden)
+	return true
+}
+
 func (h *APIHandler) deleteDatasource(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	id := ps.MustGetParameter("id")
+	if h.apiKeyDenied(w, req, id) {
+		return
+	}
 
 	obj := core.DataSource{}
 	obj.ID = id
@@ -107,11 +124,15 @@ func (h *APIHandler) deleteDatasource(w http.ResponseWriter, req *http.Request,
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	common.RecordAudit(req, core.AuditActionDelete, core.AuditResourceDatasource, obj.ID, &obj, nil)
 	h.WriteDeletedOKJSON(w, obj.ID)
 }
 
 func (h *APIHandler) getDatasource(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	id := ps.MustGetParameter("id")
+	if h.apiKeyDenied(w, req, id) {
+		return
+	}
 
 	obj := core.DataSource{}
 	obj.ID = id
@@ -129,6 +150,7 @@ func (h *APIHandler) getDatasource(w http.ResponseWriter, req *http.Request, ps
 		return
 	}
 
+	common.RedactDataSourceSecrets(&obj)
 	h.WriteJSON(w, util.MapStr{
 		"found":   true,
 		"_id":     id,
@@ -141,6 +163,9 @@ func (h *APIHandler) updateDatasource(w http.ResponseWriter, req *http.Request,
 	if id == "" {
 		panic("invalid id")
 	}
+	if h.apiKeyDenied(w, req, id) {
+		return
+	}
 
 	replace := h.GetBoolOrDefault(req, "replace", false)
 	var err error
@@ -159,6 +184,19 @@ func (h *APIHandler) updateDatasource(w http.ResponseWriter, req *http.Request,
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "datasource")
 
+	// keep the stored secrets of a config read then updated as is
+	var before *core.DataSource
+	stored := core.DataSource{}
+	stored.ID = id
+	if exists, err := orm.GetV2(ctx, &stored); err == nil && exists {
+		common.RestoreDataSourceSecrets(&obj, &stored)
+		before = &stored
+	}
+	if err := common.EncryptDataSourceSecrets(&obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	if replace {
 		err = orm.Upsert(ctx, &obj)
 	} else {
@@ -173,6 +211,7 @@ func (h *APIHandler) updateDatasource(w http.ResponseWriter, req *http.Request,
 	//clear cache
 	common.ClearDatasourcesCache()
 	common.ClearDatasourceCache(obj.ID)
+	common.RecordAudit(req, core.AuditActionUpdate, core.AuditResourceDatasource, obj.ID, before, &obj)
 
 	h.WriteJSON(w, util.MapStr{
 		"_id":    obj.ID,
@@ -194,6 +233,11 @@ func GetDatasourceByID(id []string) ([]core.DataSource, error) {
 	if err != nil {
 		return nil, err
 	}
+	for i := range docs {
+		if err := common.DecryptDataSourceSecrets(&docs[i]); err != nil {
+			return nil, err
+		}
+	}
 	return docs, nil
 }
 
@@ -207,6 +251,10 @@ func (h *APIHandler) searchDatasource(w http.ResponseWriter, req *http.Request,
 
 	builder.EnableBodyBytes()
 
+	if ids, restricted := core.APIKeyDatasources(req.Context()); restricted {
+		builder.Filter(orm.TermsQuery("id", ids))
+	}
+
 	integrationID := req.Header.Get(core.HeaderIntegrationID)
 	if integrationID != "" {
 		ids, all, err := document.GetDatasourceByIntegration(integrationID)
@@ -231,7 +279,7 @@ func (h *APIHandler) searchDatasource(w http.ResponseWriter, req *http.Request,
 
 	docs := []core.DataSource{}
 
-	err, res := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, nil)
+	err, res := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, redactDataSourceSource)
 	if err != nil {
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -243,6 +291,20 @@ func (h *APIHandler) searchDatasource(w http.ResponseWriter, req *http.Request,
 	}
 }
 
+// redactDataSourceSource redacts the secrets of the connector config of a
+// datasource hit.
+func redactDataSourceSource(source map[string]interface{}, targetRef interface{}) error {
+	if connector, ok := source["connector"].(map[string]interface{}); ok {
+		if config, ok := connector["config"]; ok {
+			datasource := core.DataSource{}
+			datasource.Connector.Config = config
+			common.RedactDataSourceSecrets(&datasource)
+			connector["config"] = datasource.Connector.Config
+		}
+	}
+	return nil
+}
+
 func (h *APIHandler) createDocInDatasource(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var obj = &core.Document{}
 	err := h.DecodeJSON(req, obj)
@@ -252,6 +314,9 @@ func (h *APIHandler) createDocInDatasource(w http.ResponseWriter, req *http.Requ
 
 	//TODO cache for speed
 	datasourceID := ps.MustGetParameter("id")
+	if h.apiKeyDenied(w, req, datasourceID) {
+		return
+	}
 	datasourceObj := core.DataSource{}
 	datasourceObj.ID = datasourceID
 	ctx := orm.NewContextWithParent(req.Context())
@@ -291,6 +356,9 @@ func (h *APIHandler) createDocInDatasourceWithID(w http.ResponseWriter, req *htt
 
 	//TODO cache for speed
 	datasourceID := ps.MustGetParameter("id")
+	if h.apiKeyDenied(w, req, datasourceID) {
+		return
+	}
 	docID := ps.MustGetParameter("doc_id")
 	obj.ID = docID
 	datasourceObj := core.DataSource{}
@@ -0,0 +1,47 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package datasource
+
+import (
+	"reflect"
+	"testing"
+
+	"infini.sh/coco/modules/common"
+)
+
+func TestRedactDataSourceSource(t *testing.T) {
+	source := map[string]interface{}{
+		"name": "notion",
+		"connector": map[string]interface{}{
+			"id": "notion",
+			"config": map[string]interface{}{
+				"token":    "secret_token",
+				"interval": "1h",
+			},
+		},
+	}
+	if err := redactDataSourceSource(source, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]interface{}{
+		"id": "notion",
+		"config": map[string]interface{}{
+			"token":    common.RedactedSecret,
+			"interval": "1h",
+		},
+	}
+	if !reflect.DeepEqual(source["connector"], expected) {
+		t.Fatalf("expected %v, got %v", expected, source["connector"])
+	}
+
+	// a datasource without connector config is left as is
+	source = map[string]interface{}{"name": "custom", "connector": map[string]interface{}{"id": "custom"}}
+	if err := redactDataSourceSource(source, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if connector := source["connector"].(map[string]interface{}); len(connector) != 1 {
+		t.Fatalf("expected the connector unchanged, got %v", connector)
+	}
+}
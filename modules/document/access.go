@@ -0,0 +1,126 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package document
+
+import (
+	"context"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/security/share"
+)
+
+// DocumentAccess is the scope of the documents a user can access, from the
+// datasources the user owns and the sharing rules of the user and teams.
+type DocumentAccess struct {
+	UserID string
+	// Datasources are the datasources searched, the documents of the ones
+	// not in FullAccessDatasources are checked one by one
+	Datasources           []string
+	FullAccessDatasources []string
+	DisabledDatasources   []string
+	// AllowedDocuments and DeniedDocuments are the documents shared with the
+	// user, or denied, within Datasources
+	AllowedDocuments []string
+	DeniedDocuments  []string
+}
+
+// ResolveDocumentAccess returns the documents a user can access within the
+// datasources queried, all of them if none, and an integration. Nil is
+// returned if the user can access no datasource.
+func ResolveDocumentAccess(ctx context.Context, userID string, teamsID []string, queryDatasourceIDs []string, integrationID string) (*DocumentAccess, error) {
+	rules, err := sharingService.GetDirectResourceRulesByResourceTypeAndUserID(userID, teamsID, "datasource", nil, share.View)
+	if err != nil {
+		return nil, err
+	}
+	log.Trace("rules: ", util.ToJson(rules, true))
+
+	directAccessDatasources := []string{}
+	checkingScopeDatasources := []string{}
+	for _, rule := range rules {
+		checkingScopeDatasources = append(checkingScopeDatasources, rule.ResourceID)
+		directAccessDatasources = append(directAccessDatasources, rule.ResourceID)
+	}
+
+	rules, err = sharingService.GetAllCategoryVisibleWithChildrenSharedObjects(userID, teamsID, "datasource")
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		checkingScopeDatasources = append(checkingScopeDatasources, rule.ResourceCategoryID)
+	}
+
+	//(user own datasource + shared datasource) intersect query datasource
+	checkingScopeDatasources, mergedFullAccessDatasourceIDS, disabledIDs := BuildDatasourceFilter(userID, checkingScopeDatasources, directAccessDatasources, queryDatasourceIDs, integrationID, true)
+
+	// within the datasources of the API key of the request, if restricted
+	if ids, restricted := core.APIKeyDatasources(ctx); restricted {
+		checkingScopeDatasources = util.StringArrayIntersection(ids, checkingScopeDatasources)
+		mergedFullAccessDatasourceIDS = util.StringArrayIntersection(ids, mergedFullAccessDatasourceIDS)
+	}
+
+	if len(checkingScopeDatasources) == 0 && len(mergedFullAccessDatasourceIDS) == 0 {
+		return nil, nil
+	}
+
+	log.Trace("CheckingScopeDatasources:", checkingScopeDatasources, ",directAccessDatasources:", directAccessDatasources, ",queryDatasourceIDs:", queryDatasourceIDs, ",new mergedFullAccessDatasourceIDS:", mergedFullAccessDatasourceIDS, ",disabledIDs:", disabledIDs, ",integrationID:", integrationID)
+
+	//make sure checking scope include user's own datasource ids
+	checkingScopeDatasources = append(checkingScopeDatasources, mergedFullAccessDatasourceIDS...)
+
+	access := &DocumentAccess{
+		UserID:                userID,
+		Datasources:           checkingScopeDatasources,
+		FullAccessDatasources: mergedFullAccessDatasourceIDS,
+		DisabledDatasources:   disabledIDs,
+	}
+
+	rules, err = sharingService.GetDirectResourceRulesByResourceCategoryAndUserID(userID, teamsID, "document", "datasource", checkingScopeDatasources, share.None)
+	if err != nil {
+		return nil, err
+	}
+	log.Trace("doc rules:", util.ToJson(rules, true))
+	for _, rule := range rules {
+		if rule.Permission > share.None {
+			access.AllowedDocuments = append(access.AllowedDocuments, rule.ResourceID)
+		} else {
+			access.DeniedDocuments = append(access.DeniedDocuments, rule.ResourceID)
+		}
+	}
+	return access, nil
+}
+
+// CanAccessDatasource reports whether documents of the datasource may be
+// accessed.
+func (a *DocumentAccess) CanAccessDatasource(id string) bool {
+	return a != nil && util.ContainsAnyInArray(id, a.Datasources) && !util.ContainsAnyInArray(id, a.DisabledDatasources)
+}
+
+// Filters returns the query clauses restricting a search of the documents to
+// the accessible ones.
+func (a *DocumentAccess) Filters() []util.MapStr {
+	filters := []util.MapStr{}
+	if len(a.DisabledDatasources) > 0 {
+		filters = append(filters, util.MapStr{"bool": util.MapStr{"must_not": util.MapStr{"terms": util.MapStr{"source.id": a.DisabledDatasources}}}})
+	}
+	filters = append(filters, util.MapStr{"terms": util.MapStr{"source.id": a.Datasources}})
+
+	should := []util.MapStr{
+		{"term": util.MapStr{core.SystemOwnerQueryField: a.UserID}},
+	}
+	if len(a.FullAccessDatasources) > 0 {
+		should = append(should, util.MapStr{"terms": util.MapStr{"source.id": a.FullAccessDatasources}})
+	}
+	if len(a.AllowedDocuments) > 0 {
+		should = append(should, util.MapStr{"terms": util.MapStr{"id": a.AllowedDocuments}})
+	}
+	filters = append(filters, util.MapStr{"bool": util.MapStr{"should": should, "minimum_should_match": 1}})
+
+	if len(a.DeniedDocuments) > 0 {
+		filters = append(filters, util.MapStr{"bool": util.MapStr{"must_not": util.MapStr{"terms": util.MapStr{"id": a.DeniedDocuments}}}})
+	}
+	return filters
+}
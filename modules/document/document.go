@@ -60,6 +60,22 @@ func getBoolFromMap(m map[string]interface{}, key string) bool {
 	return false
 }
 
+// apiKeyAllowsDoc tells if the API key of a request, if any, may access the
+// stored document of an ID, a document not stored yet is allowed.
+func apiKeyAllowsDoc(req *http.Request, id string) bool {
+	if _, restricted := core.APIKeyDatasources(req.Context()); !restricted {
+		return true
+	}
+	obj := core.Document{}
+	obj.ID = id
+	ctx := orm.NewContextWithParent(req.Context())
+	exists, err := orm.GetV2(ctx, &obj)
+	if err != nil {
+		return false
+	}
+	return !exists || core.APIKeyAllowsDatasource(req.Context(), obj.Source.ID)
+}
+
 func (h *APIHandler) createDoc(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	var obj = &core.Document{}
 	err := h.DecodeJSON(req, obj)
@@ -67,6 +83,10 @@ func (h *APIHandler) createDoc(w http.ResponseWriter, req *http.Request, ps http
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !core.APIKeyAllowsDatasource(req.Context(), obj.Source.ID) {
+		h.WriteError(w, "api key not allowed to access the datasource", http.StatusForbidden)
+		return
+	}
 
 	ctx := orm.NewContextWithParent(req.Context())
 	ctx.Refresh = orm.WaitForRefresh
@@ -88,7 +108,7 @@ func (h *APIHandler) getDoc(w http.ResponseWriter, req *http.Request, ps httprou
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "document")
 	exists, err := orm.GetV2(ctx, &obj)
-	if !exists || err != nil {
+	if !exists || err != nil || !core.APIKeyAllowsDatasource(req.Context(), obj.Source.ID) {
 		h.WriteJSON(w, util.MapStr{
 			"_id":   id,
 			"found": false,
@@ -119,13 +139,14 @@ func (h *APIHandler) getDocRawContent(w http.ResponseWriter, req *http.Request,
 		return
 	}
 
-	if !exists {
+	if !exists || !core.APIKeyAllowsDatasource(req.Context(), obj.Source.ID) {
 		h.WriteJSON(w, util.MapStr{
 			"_id":   id,
 			"found": false,
 		}, http.StatusNotFound)
 		return
 	}
+	common.RecordAudit(req, core.AuditActionRead, core.AuditResourceDocument, id, nil, nil)
 
 	// Handle empty URL
 	if obj.URL == "" {
@@ -322,6 +343,10 @@ func (h *APIHandler) updateDoc(w http.ResponseWriter, req *http.Request, ps http
 
 	//protect
 	obj.ID = id
+	if !apiKeyAllowsDoc(req, id) || !core.APIKeyAllowsDatasource(req.Context(), obj.Source.ID) {
+		h.WriteError(w, "api key not allowed to access the datasource", http.StatusForbidden)
+		return
+	}
 	ctx.Refresh = orm.WaitForRefresh
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "document")
@@ -346,6 +371,11 @@ func (h *APIHandler) updateDoc(w http.ResponseWriter, req *http.Request, ps http
 func (h *APIHandler) deleteDoc(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	id := ps.MustGetParameter("doc_id")
 
+	if !apiKeyAllowsDoc(req, id) {
+		h.WriteError(w, "api key not allowed to access the datasource", http.StatusForbidden)
+		return
+	}
+
 	obj := core.Document{}
 	obj.ID = id
 	ctx := orm.NewContextWithParent(req.Context())
@@ -371,6 +401,9 @@ func (h *APIHandler) searchDocs(w http.ResponseWriter, req *http.Request, ps htt
 	// to slow us down.
 	builder.Exclude("payload.*", "document_chunk", "ai_insights.embedding")
 	builder.EnableBodyBytes()
+	if ids, restricted := core.APIKeyDatasources(req.Context()); restricted {
+		builder.Filter(orm.TermsQuery("source.id", ids))
+	}
 	if len(builder.Sorts()) == 0 {
 		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
 	}
@@ -485,6 +518,9 @@ func (h *APIHandler) batchDeleteDoc(w http.ResponseWriter, req *http.Request, ps
 	}
 
 	builder.Filter(orm.TermsQuery("id", ids))
+	if datasourceIDs, restricted := core.APIKeyDatasources(req.Context()); restricted {
+		builder.Filter(orm.TermsQuery("source.id", datasourceIDs))
+	}
 
 	ctx := orm.NewContextWithParent(req.Context())
 	orm.WithModel(ctx, &core.Document{})
@@ -239,6 +239,9 @@ func searchAssistant(req *http.Request, query string, size int) []core.Assistant
 	}
 	builder.Query(query)
 	builder.Must(orm.TermQuery("enabled", true))
+	if ids, restricted := core.APIKeyAssistants(req.Context()); restricted {
+		builder.Filter(orm.TermsQuery("id", ids))
+	}
 	builder.Size(size)
 	builder.Fuzziness(3)
 
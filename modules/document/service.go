@@ -97,27 +97,6 @@ func QueryDocuments(ctx1 context.Context, builder *orm.QueryBuilder, query strin
 
 	filters := BuildFilters(category, subcategory, richCategory)
 
-	rules, err := sharingService.GetDirectResourceRulesByResourceTypeAndUserID(userID, teamsID, "datasource", nil, share.View)
-	if err != nil {
-		panic(err)
-	}
-	log.Trace("rules: ", util.ToJson(rules, true))
-
-	directAccessDatasources := []string{}
-	checkingScopeDatasources := []string{}
-	for _, rule := range rules {
-		checkingScopeDatasources = append(checkingScopeDatasources, rule.ResourceID)
-		directAccessDatasources = append(directAccessDatasources, rule.ResourceID)
-	}
-
-	rules, err = sharingService.GetAllCategoryVisibleWithChildrenSharedObjects(userID, teamsID, "datasource")
-	if err != nil {
-		panic(err)
-	}
-
-	for _, rule := range rules {
-		checkingScopeDatasources = append(checkingScopeDatasources, rule.ResourceCategoryID)
-	}
 	//get all directly assigned rules assign to document level
 	queryDatasourceIDs := []string{}
 	if datasource == "" || util.ContainStr(datasource, "*") {
@@ -125,31 +104,27 @@ func QueryDocuments(ctx1 context.Context, builder *orm.QueryBuilder, query strin
 		queryDatasourceIDs = strings.Split(datasource, ",")
 	}
 
-	//(user own datasource + shared datasource) intersect query datasource
-	checkingScopeDatasources, mergedFullAccessDatasourceIDS, disabledIDs := BuildDatasourceFilter(userID, checkingScopeDatasources, directAccessDatasources, queryDatasourceIDs, integrationID, true)
+	access, err := ResolveDocumentAccess(ctx1, userID, teamsID, queryDatasourceIDs, integrationID)
+	if err != nil {
+		return nil, err
+	}
 
 	// User has no accessible datasources, return empty result directly to avoid unfiltered search.
-	if len(checkingScopeDatasources) == 0 && len(mergedFullAccessDatasourceIDS) == 0 {
+	if access == nil {
 		return &orm.SimpleResult{Raw: []byte(`{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`), Total: 0}, nil
 	}
 
-	if len(disabledIDs) > 0 {
-		filters = append(filters, orm.MustNotQuery(orm.TermsQuery("source.id", disabledIDs)))
+	if len(access.DisabledDatasources) > 0 {
+		filters = append(filters, orm.MustNotQuery(orm.TermsQuery("source.id", access.DisabledDatasources)))
 	}
 
-	log.Trace("CheckingScopeDatasources:", checkingScopeDatasources, ",directAccessDatasources:", directAccessDatasources, ",queryDatasourceIDs:", queryDatasourceIDs, ",new mergedFullAccessDatasourceIDS:", mergedFullAccessDatasourceIDS, ",disabledIDs:", disabledIDs, ",integrationID:", integrationID)
-
 	shouldClauses := []*orm.Clause{}
 
-	if len(mergedFullAccessDatasourceIDS) > 0 {
-		//make sure checking scope include user's own datasource ids
-		checkingScopeDatasources = append(checkingScopeDatasources, mergedFullAccessDatasourceIDS...)
-		shouldClauses = append(shouldClauses, orm.TermsQuery("source.id", mergedFullAccessDatasourceIDS)) //shared with user
+	if len(access.FullAccessDatasources) > 0 {
+		shouldClauses = append(shouldClauses, orm.TermsQuery("source.id", access.FullAccessDatasources)) //shared with user
 	}
 
-	if len(checkingScopeDatasources) > 0 {
-		filters = append(filters, orm.MustQuery(orm.TermsQuery("source.id", checkingScopeDatasources)))
-	}
+	filters = append(filters, orm.MustQuery(orm.TermsQuery("source.id", access.Datasources)))
 
 	//filter enabled doc
 	filters = append(filters, orm.BoolQuery(orm.Should, orm.TermQuery("disabled", false), orm.MustNotQuery(orm.ExistsQuery("disabled"))).Parameter("minimum_should_match", 1))
@@ -160,31 +135,16 @@ func QueryDocuments(ctx1 context.Context, builder *orm.QueryBuilder, query strin
 
 	builder.Filter(filters...)
 
-	rules, err = sharingService.GetDirectResourceRulesByResourceCategoryAndUserID(userID, teamsID, "document", "datasource", checkingScopeDatasources, share.None)
-	if err != nil {
-		panic(err)
-	}
-	log.Trace("doc rules:", util.ToJson(rules, true))
-	allowdDocs := []string{}
-	deniedDocs := []string{}
-	for _, rule := range rules {
-		if rule.Permission > share.None {
-			allowdDocs = append(allowdDocs, rule.ResourceID)
-		} else {
-			deniedDocs = append(deniedDocs, rule.ResourceID)
-		}
-	}
-
 	shouldClauses = append(shouldClauses, orm.TermQuery(core.SystemOwnerQueryField, userID)) //user is the owner
 
-	if len(allowdDocs) > 0 {
-		shouldClauses = append(shouldClauses, orm.TermsQuery("id", allowdDocs)) //direct shared with user
+	if len(access.AllowedDocuments) > 0 {
+		shouldClauses = append(shouldClauses, orm.TermsQuery("id", access.AllowedDocuments)) //direct shared with user
 	}
 
 	builder.Must(orm.BoolQuery(orm.Should, shouldClauses...).Parameter("minimum_should_match", 1))
 
-	if len(deniedDocs) > 0 {
-		builder.Must(orm.BoolQuery(orm.MustNot, orm.TermsQuery("id", deniedDocs)))
+	if len(access.DeniedDocuments) > 0 {
+		builder.Must(orm.BoolQuery(orm.MustNot, orm.TermsQuery("id", access.DeniedDocuments)))
 	}
 
 	ctx := orm.NewContextWithParent(ctx1)
@@ -6,6 +6,7 @@ package integration
 
 import (
 	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/elastic"
 	"infini.sh/framework/core/orm"
@@ -33,6 +34,7 @@ func (h *APIHandler) create(w http.ResponseWriter, req *http.Request, ps httprou
 	if obj.Enabled && obj.Cors.Enabled && len(obj.Cors.AllowedOrigins) > 0 {
 		integrationOrigins.Store(obj.ID, stringArrayToMap(obj.Cors.AllowedOrigins))
 	}
+	common.RecordAudit(req, core.AuditActionCreate, core.AuditResourceIntegration, obj.ID, nil, obj)
 
 	h.WriteCreatedOKJSON(w, obj.ID)
 
@@ -70,6 +72,12 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 	}
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "integration")
+	var before *core.Integration
+	stored := core.Integration{}
+	stored.ID = id
+	if exists, err := orm.GetV2(ctx, &stored); err == nil && exists {
+		before = &stored
+	}
 	ctx.Refresh = orm.WaitForRefresh
 	err = orm.UpdatePartialFields(ctx, &obj, delta)
 	if err != nil {
@@ -83,6 +91,7 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 	if obj.Enabled && obj.Cors.Enabled && len(obj.Cors.AllowedOrigins) > 0 {
 		integrationOrigins.Store(obj.ID, stringArrayToMap(obj.Cors.AllowedOrigins))
 	}
+	common.RecordAudit(req, core.AuditActionUpdate, core.AuditResourceIntegration, id, before, &obj)
 
 	h.WriteUpdatedOKJSON(w, obj.ID)
 }
@@ -94,6 +103,11 @@ func (h *APIHandler) delete(w http.ResponseWriter, req *http.Request, ps httprou
 	obj.ID = id
 	ctx := orm.NewContextWithParent(req.Context())
 
+	var before *core.Integration
+	if exists, err := orm.GetV2(ctx, &obj); err == nil && exists {
+		stored := obj
+		before = &stored
+	}
 	ctx.Refresh = orm.WaitForRefresh
 	err := orm.Delete(ctx, &obj)
 	if err != nil {
@@ -102,6 +116,7 @@ func (h *APIHandler) delete(w http.ResponseWriter, req *http.Request, ps httprou
 	}
 	// remove related origins check
 	integrationOrigins.Delete(obj.ID)
+	common.RecordAudit(req, core.AuditActionDelete, core.AuditResourceIntegration, id, before, nil)
 
 	h.WriteDeletedOKJSON(w, id)
 }
@@ -8,6 +8,7 @@ import (
 	"infini.sh/coco/core"
 	"infini.sh/coco/modules/common"
 	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
 	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/util"
 	"net/http"
@@ -22,6 +23,11 @@ func (h *APIHandler) createMCPServer(w http.ResponseWriter, req *http.Request, p
 		return
 	}
 
+	if err := common.EncryptMCPServerSecrets(obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ctx := orm.NewContextWithParent(req.Context())
 	ctx.Refresh = orm.WaitForRefresh
 
@@ -75,6 +81,7 @@ func (h *APIHandler) getMCPServer(w http.ResponseWriter, req *http.Request, ps h
 		return
 	}
 
+	common.RedactMCPServerSecrets(&obj)
 	h.WriteJSON(w, util.MapStr{
 		"found":   true,
 		"_id":     id,
@@ -99,6 +106,7 @@ func (h *APIHandler) updateMCPServer(w http.ResponseWriter, req *http.Request, p
 		return
 	}
 
+	storedConfig := obj.Config
 	newObj := core.MCPServer{}
 	err = h.DecodeJSON(req, &obj)
 	if err != nil {
@@ -110,6 +118,12 @@ func (h *APIHandler) updateMCPServer(w http.ResponseWriter, req *http.Request, p
 	newObj.ID = id
 	newObj.Created = obj.Created
 
+	common.RestoreMCPServerSecrets(&obj, storedConfig)
+	if err := common.EncryptMCPServerSecrets(&obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ctx.Refresh = orm.WaitForRefresh
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "mcp-server")
@@ -172,15 +186,26 @@ func (h *APIHandler) searchMCPServer(w http.ResponseWriter, req *http.Request, p
 	orm.WithModel(ctx, &core.MCPServer{})
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "mcp-server")
-	res, err := orm.SearchV2(ctx, builder)
+	docs := []core.MCPServer{}
+	err, res := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, redactMCPServerSource)
 	if err != nil {
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = h.Write(w, res.Payload.([]byte))
+	_, err = h.Write(w, res.Raw)
 	if err != nil {
 		h.Error(w, err)
 	}
 
 }
+
+// redactMCPServerSource redacts the secrets of the config of an MCP server hit.
+func redactMCPServerSource(source map[string]interface{}, targetRef interface{}) error {
+	if config, ok := source["config"]; ok {
+		server := core.MCPServer{Config: config}
+		common.RedactMCPServerSecrets(&server)
+		source["config"] = server.Config
+	}
+	return nil
+}
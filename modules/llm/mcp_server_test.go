@@ -0,0 +1,39 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package llm
+
+import (
+	"reflect"
+	"testing"
+
+	"infini.sh/coco/modules/common"
+)
+
+func TestRedactMCPServerSource(t *testing.T) {
+	source := map[string]interface{}{
+		"name": "github",
+		"type": "stdio",
+		"config": map[string]interface{}{
+			"command": "npx",
+			"env":     map[string]interface{}{"GITHUB_TOKEN": "ghp_secret"},
+		},
+	}
+	if err := redactMCPServerSource(source, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]interface{}{
+		"command": "npx",
+		"env":     map[string]interface{}{"GITHUB_TOKEN": common.RedactedSecret},
+	}
+	if !reflect.DeepEqual(source["config"], expected) {
+		t.Fatalf("expected %v, got %v", expected, source["config"])
+	}
+
+	// a server without config is left as is
+	source = map[string]interface{}{"name": "empty"}
+	if err := redactMCPServerSource(source, nil); err != nil || len(source) != 1 {
+		t.Fatalf("expected the source unchanged, got %v, %v", source, err)
+	}
+}
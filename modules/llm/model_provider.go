@@ -11,6 +11,7 @@ import (
 
 	"infini.sh/coco/modules/common"
 	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
 	"infini.sh/framework/core/orm"
 )
 
@@ -32,11 +33,16 @@ func (h *APIHandler) create(w http.ResponseWriter, req *http.Request, ps httprou
 	ctx.Refresh = orm.WaitForRefresh
 
 	obj.Builtin = false
+	if err := common.EncryptModelProviderSecrets(obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	err = orm.Create(ctx, obj)
 	if err != nil {
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	common.RecordAudit(req, core.AuditActionCreate, core.AuditResourceModelProvider, obj.ID, nil, obj)
 
 	h.WriteCreatedOKJSON(w, obj.ID)
 
@@ -56,6 +62,7 @@ func (h *APIHandler) get(w http.ResponseWriter, req *http.Request, ps httprouter
 		return
 	}
 
+	common.RedactModelProviderSecrets(&obj)
 	h.WriteGetOKJSON(w, id, obj)
 }
 
@@ -73,6 +80,8 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 		return
 	}
 
+	storedAPIKey := obj.APIKey
+	before := common.AuditSnapshot(&obj)
 	newObj := core.ModelProvider{}
 	err = h.DecodeJSON(req, &obj)
 	if err != nil {
@@ -93,6 +102,12 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 	newObj.Builtin = obj.Builtin
 	newObj.Created = obj.Created
 
+	obj.APIKey = common.RestoreRedactedSecret(obj.APIKey, storedAPIKey)
+	if err := common.EncryptModelProviderSecrets(&obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	ctx.Refresh = orm.WaitForRefresh
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "llm-provider")
@@ -103,6 +118,7 @@ func (h *APIHandler) update(w http.ResponseWriter, req *http.Request, ps httprou
 	}
 	//clear cache
 	common.GeneralObjectCache.Delete(common.ModelProviderCachePrimary, id)
+	common.RecordAudit(req, core.AuditActionUpdate, core.AuditResourceModelProvider, id, before, &obj)
 
 	h.WriteUpdatedOKJSON(w, obj.ID)
 }
@@ -133,6 +149,7 @@ func (h *APIHandler) delete(w http.ResponseWriter, req *http.Request, ps httprou
 	}
 	//clear cache
 	common.GeneralObjectCache.Delete(common.ModelProviderCachePrimary, id)
+	common.RecordAudit(req, core.AuditActionDelete, core.AuditResourceModelProvider, id, &obj, nil)
 
 	h.WriteDeletedOKJSON(w, obj.ID)
 }
@@ -151,14 +168,25 @@ func (h *APIHandler) search(w http.ResponseWriter, req *http.Request, ps httprou
 	orm.WithModel(ctx, &core.ModelProvider{})
 	ctx.Set(orm.SharingEnabled, true)
 	ctx.Set(orm.SharingResourceType, "llm-provider")
-	res, err := orm.SearchV2(ctx, builder)
+	docs := []core.ModelProvider{}
+	err, res := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, redactModelProviderSource)
 	if err != nil {
 		h.WriteError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = h.Write(w, res.Payload.([]byte))
+	_, err = h.Write(w, res.Raw)
 	if err != nil {
 		h.Error(w, err)
 	}
 }
+
+// redactModelProviderSource redacts the API key of a model provider hit.
+func redactModelProviderSource(source map[string]interface{}, targetRef interface{}) error {
+	if apiKey, ok := source["api_key"].(string); ok {
+		provider := core.ModelProvider{APIKey: apiKey}
+		common.RedactModelProviderSecrets(&provider)
+		source["api_key"] = provider.APIKey
+	}
+	return nil
+}
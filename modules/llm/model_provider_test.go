@@ -0,0 +1,34 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package llm
+
+import (
+	"testing"
+
+	"infini.sh/coco/modules/common"
+)
+
+func TestRedactModelProviderSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   map[string]interface{}
+		expected interface{}
+	}{
+		{"plain", map[string]interface{}{"name": "openai", "api_key": "sk-secret"}, common.RedactedSecret},
+		{"encrypted", map[string]interface{}{"name": "openai", "api_key": common.EncryptedSecretPrefix + "wrapped.ciphertext"}, common.RedactedSecret},
+		{"empty", map[string]interface{}{"name": "ollama", "api_key": ""}, ""},
+		{"missing", map[string]interface{}{"name": "ollama"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := redactModelProviderSource(tt.source, nil); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got := tt.source["api_key"]; got != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
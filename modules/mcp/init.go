@@ -0,0 +1,182 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/server"
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/security"
+)
+
+const Category = "coco"
+const Resource = "mcp"
+const AccessAction = "access"
+
+// BasePath is where the MCP endpoints are mounted:
+//
+//	/mcp           streamable HTTP transport
+//	/mcp/sse       SSE transport, event stream
+//	/mcp/message   SSE transport, client to server messages
+const BasePath = "/mcp"
+
+type APIHandler struct {
+	api.Handler
+	streamableServer *server.StreamableHTTPServer
+	sseServer        *server.SSEServer
+	sseSessions      *sessionOwners
+}
+
+// sessionOwners remembers the user who opened each SSE session, the
+// messages posted to a session are only accepted from that user.
+type sessionOwners struct {
+	lock   sync.RWMutex
+	owners map[string]string
+}
+
+func newSessionOwners() *sessionOwners {
+	return &sessionOwners{owners: map[string]string{}}
+}
+
+func (o *sessionOwners) add(sessionID, userID string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.owners[sessionID] = userID
+}
+
+func (o *sessionOwners) remove(sessionID string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	delete(o.owners, sessionID)
+}
+
+func (o *sessionOwners) owns(sessionID, userID string) bool {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	owner, ok := o.owners[sessionID]
+	return ok && userID != "" && owner == userID
+}
+
+// hooks registers the owner of the sessions, the context is the one of the
+// HTTP request opening the event stream.
+func (o *sessionOwners) hooks() *server.Hooks {
+	hooks := &server.Hooks{}
+	hooks.AddOnRegisterSession(func(ctx context.Context, session server.ClientSession) {
+		o.add(session.SessionID(), getUserID(ctx))
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		o.remove(session.SessionID())
+	})
+	return hooks
+}
+
+type integrationIDKey struct{}
+
+// withIntegrationID keeps the integration header of the HTTP request around,
+// so the tools apply the same datasource restrictions as the search API.
+func withIntegrationID(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, integrationIDKey{}, r.Header.Get(core.HeaderIntegrationID))
+}
+
+func getIntegrationID(ctx context.Context) string {
+	v, _ := ctx.Value(integrationIDKey{}).(string)
+	return v
+}
+
+// newMCPServer creates the MCP server with the tools of Coco.
+func newMCPServer(version string, sseSessions *sessionOwners) *server.MCPServer {
+	mcpServer := server.NewMCPServer("coco", version,
+		server.WithToolCapabilities(false),
+		server.WithRecovery(),
+		server.WithHooks(sseSessions.hooks()),
+	)
+	registerTools(mcpServer)
+	return mcpServer
+}
+
+func newAPIHandler(version string) *APIHandler {
+	handler := &APIHandler{sseSessions: newSessionOwners()}
+	mcpServer := newMCPServer(version, handler.sseSessions)
+	handler.streamableServer = server.NewStreamableHTTPServer(mcpServer,
+		server.WithEndpointPath(BasePath),
+		server.WithStateLess(true),
+		server.WithHTTPContextFunc(withIntegrationID),
+	)
+	handler.sseServer = server.NewSSEServer(mcpServer,
+		server.WithStaticBasePath(BasePath),
+		server.WithSSEEndpoint("/sse"),
+		server.WithMessageEndpoint("/message"),
+		server.WithUseFullURLForMessageEndpoint(false),
+		server.WithSSEContextFunc(withIntegrationID),
+	)
+	return handler
+}
+
+func init() {
+	accessPermission := security.GetSimplePermission(Category, Resource, AccessAction)
+	security.GetOrInitPermissionKeys(accessPermission)
+
+	handler := newAPIHandler(global.Env().GetVersion())
+
+	//access tokens are validated by the security filters before reaching the handlers
+	api.HandleUIMethod(api.POST, BasePath, handler.streamable, api.RequirePermission(accessPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.GET, BasePath, handler.streamable, api.RequirePermission(accessPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.DELETE, BasePath, handler.streamable, api.RequirePermission(accessPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, BasePath, handler.streamable, api.RequirePermission(accessPermission), api.Feature(core.FeatureCORS))
+
+	api.HandleUIMethod(api.GET, BasePath+"/sse", handler.sse, api.RequirePermission(accessPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.POST, BasePath+"/message", handler.sseMessage, api.RequirePermission(accessPermission), api.Feature(core.FeatureCORS))
+	api.HandleUIMethod(api.OPTIONS, BasePath+"/message", handler.sseMessage, api.RequirePermission(accessPermission), api.Feature(core.FeatureCORS))
+}
+
+// authenticated rejects the requests without a user session, the tools run
+// on behalf of the caller and must never run anonymously.
+func (h *APIHandler) authenticated(w http.ResponseWriter, req *http.Request) bool {
+	if getUserID(req.Context()) == "" {
+		h.WriteError(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func getUserID(ctx context.Context) string {
+	reqUser, err := security.GetUserFromContext(ctx)
+	if err != nil || reqUser == nil {
+		return ""
+	}
+	return reqUser.MustGetUserID()
+}
+
+func (h *APIHandler) streamable(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !h.authenticated(w, req) {
+		return
+	}
+	h.streamableServer.ServeHTTP(w, req)
+}
+
+func (h *APIHandler) sse(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !h.authenticated(w, req) {
+		return
+	}
+	h.sseServer.SSEHandler().ServeHTTP(w, req)
+}
+
+func (h *APIHandler) sseMessage(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !h.authenticated(w, req) {
+		return
+	}
+	//the session IDs travel in the URLs, a session is only usable by its owner
+	if req.Method == http.MethodPost && !h.sseSessions.owns(req.URL.Query().Get("sessionId"), getUserID(req.Context())) {
+		h.WriteError(w, "session not found", http.StatusNotFound)
+		return
+	}
+	h.sseServer.MessageHandler().ServeHTTP(w, req)
+}
@@ -0,0 +1,191 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package mcp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func postRPC(t *testing.T, handler http.Handler, id int, method string, params interface{}) rpcResponse {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	req := httptest.NewRequest(http.MethodPost, BasePath, strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%v: expected status 200, got %v: %v", method, rec.Code, rec.Body.String())
+	}
+	resp := rpcResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: invalid response %q: %v", method, rec.Body.String(), err)
+	}
+	if resp.ID != id {
+		t.Fatalf("%v: expected response id %v, got %v", method, id, resp.ID)
+	}
+	return resp
+}
+
+func TestStreamableRoundTrip(t *testing.T) {
+	handler := newAPIHandler("test").streamableServer
+
+	resp := postRPC(t, handler, 1, "initialize", map[string]interface{}{
+		"protocolVersion": "2025-03-26",
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]interface{}{"name": "test", "version": "1.0"},
+	})
+	if resp.Error != nil {
+		t.Fatalf("initialize failed: %v", resp.Error.Message)
+	}
+	initialized := struct {
+		ServerInfo struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+		Capabilities struct {
+			Tools *struct{} `json:"tools"`
+		} `json:"capabilities"`
+	}{}
+	if err := json.Unmarshal(resp.Result, &initialized); err != nil {
+		t.Fatal(err)
+	}
+	if initialized.ServerInfo.Name != "coco" || initialized.ServerInfo.Version != "test" {
+		t.Fatalf("unexpected server info %+v", initialized.ServerInfo)
+	}
+	if initialized.Capabilities.Tools == nil {
+		t.Fatal("expected the tools capability")
+	}
+
+	resp = postRPC(t, handler, 2, "tools/list", map[string]interface{}{})
+	if resp.Error != nil {
+		t.Fatalf("tools/list failed: %v", resp.Error.Message)
+	}
+	listed := struct {
+		Tools []struct {
+			Name        string `json:"name"`
+			InputSchema struct {
+				Required []string `json:"required"`
+			} `json:"inputSchema"`
+		} `json:"tools"`
+	}{}
+	if err := json.Unmarshal(resp.Result, &listed); err != nil {
+		t.Fatal(err)
+	}
+	required := map[string][]string{}
+	for _, tool := range listed.Tools {
+		required[tool.Name] = tool.InputSchema.Required
+	}
+	for name, want := range map[string][]string{
+		"search_documents": {"query"},
+		"get_document":     {"id"},
+		"list_datasources": nil,
+		"ask_assistant":    {"assistant_id", "message"},
+	} {
+		got, ok := required[name]
+		if !ok {
+			t.Fatalf("expected tool %q to be listed, got %v", name, required)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("expected tool %q to require %v, got %v", name, want, got)
+		}
+	}
+
+	// the argument checks run before any lookup, so the call needs no store
+	resp = postRPC(t, handler, 3, "tools/call", map[string]interface{}{
+		"name":      "search_documents",
+		"arguments": map[string]interface{}{},
+	})
+	if resp.Error != nil {
+		t.Fatalf("tools/call failed: %v", resp.Error.Message)
+	}
+	called := struct {
+		IsError bool `json:"isError"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}{}
+	if err := json.Unmarshal(resp.Result, &called); err != nil {
+		t.Fatal(err)
+	}
+	if !called.IsError || len(called.Content) != 1 || !strings.Contains(called.Content[0].Text, "query") {
+		t.Fatalf("expected a tool error about the query, got %+v", called)
+	}
+
+	resp = postRPC(t, handler, 4, "tools/call", map[string]interface{}{
+		"name":      "delete_everything",
+		"arguments": map[string]interface{}{},
+	})
+	if resp.Error == nil {
+		t.Fatalf("expected unknown tools to be rejected, got %s", resp.Result)
+	}
+}
+
+func TestHandlersRejectAnonymousRequests(t *testing.T) {
+	handler := newAPIHandler("test")
+
+	for name, serve := range map[string]func(http.ResponseWriter, *http.Request){
+		"streamable": func(w http.ResponseWriter, r *http.Request) { handler.streamable(w, r, nil) },
+		"sse":        func(w http.ResponseWriter, r *http.Request) { handler.sse(w, r, nil) },
+		"message":    func(w http.ResponseWriter, r *http.Request) { handler.sseMessage(w, r, nil) },
+	} {
+		body := `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{}}`
+		req := httptest.NewRequest(http.MethodPost, BasePath, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		serve(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%v: expected status 401, got %v", name, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "search_documents") {
+			t.Fatalf("%v: anonymous request reached the tools: %v", name, rec.Body.String())
+		}
+	}
+}
+
+func TestSessionOwners(t *testing.T) {
+	owners := newSessionOwners()
+	owners.add("s1", "u1")
+
+	if !owners.owns("s1", "u1") {
+		t.Fatal("expected the session owned by its user")
+	}
+	for _, tt := range []struct{ session, user string }{
+		{"s1", "u2"},
+		{"s1", ""},
+		{"s2", "u1"},
+		{"", "u1"},
+	} {
+		if owners.owns(tt.session, tt.user) {
+			t.Fatalf("expected session %q refused to user %q", tt.session, tt.user)
+		}
+	}
+
+	owners.remove("s1")
+	if owners.owns("s1", "u1") {
+		t.Fatal("expected the closed session refused")
+	}
+}
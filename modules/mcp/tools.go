@@ -0,0 +1,259 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	mcpgo "github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/assistant/service"
+	"infini.sh/coco/modules/common"
+	"infini.sh/coco/modules/document"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	defaultSearchSize = 10
+	maxSearchSize     = 50
+	maxContentLength  = 20000
+	askTimeout        = 5 * time.Minute
+)
+
+func registerTools(s *server.MCPServer) {
+	s.AddTool(mcpgo.NewTool("search_documents",
+		mcpgo.WithDescription("Search the documents indexed in Coco. Only documents from datasources the caller is allowed to access are returned."),
+		mcpgo.WithReadOnlyHintAnnotation(true),
+		mcpgo.WithString("query", mcpgo.Required(), mcpgo.Description("Search keywords or a natural language question")),
+		mcpgo.WithString("datasource", mcpgo.Description("Optional comma-separated datasource IDs to restrict the search to")),
+		mcpgo.WithString("category", mcpgo.Description("Optional document category filter")),
+		mcpgo.WithString("search_type", mcpgo.Description("One of keyword, semantic or hybrid, default: keyword"), mcpgo.Enum("keyword", "semantic", "hybrid")),
+		mcpgo.WithNumber("size", mcpgo.Description("Number of documents to return, default: 10, max: 50")),
+	), searchDocuments)
+
+	s.AddTool(mcpgo.NewTool("get_document",
+		mcpgo.WithDescription("Get a document by its ID, including its content."),
+		mcpgo.WithReadOnlyHintAnnotation(true),
+		mcpgo.WithString("id", mcpgo.Required(), mcpgo.Description("ID of the document")),
+	), getDocument)
+
+	s.AddTool(mcpgo.NewTool("list_datasources",
+		mcpgo.WithDescription("List the datasources the caller is allowed to search."),
+		mcpgo.WithReadOnlyHintAnnotation(true),
+	), listDatasources)
+
+	s.AddTool(mcpgo.NewTool("ask_assistant",
+		mcpgo.WithDescription("Ask a Coco AI assistant a question and return its answer."),
+		mcpgo.WithString("assistant_id", mcpgo.Required(), mcpgo.Description("ID of the assistant")),
+		mcpgo.WithString("message", mcpgo.Required(), mcpgo.Description("The question to ask")),
+	), askAssistant)
+}
+
+type documentResult struct {
+	ID         string   `json:"id"`
+	Title      string   `json:"title,omitempty"`
+	Summary    string   `json:"summary,omitempty"`
+	URL        string   `json:"url,omitempty"`
+	Type       string   `json:"type,omitempty"`
+	Category   string   `json:"category,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Datasource string   `json:"datasource,omitempty"`
+	Updated    string   `json:"updated,omitempty"`
+	Content    string   `json:"content,omitempty"`
+}
+
+func toDocumentResult(doc *core.Document, withContent bool) documentResult {
+	r := documentResult{
+		ID:         doc.ID,
+		Title:      doc.Title,
+		Summary:    doc.Summary,
+		URL:        doc.URL,
+		Type:       doc.Type,
+		Category:   doc.Category,
+		Tags:       doc.Tags,
+		Datasource: doc.Source.Name,
+	}
+	if doc.Updated != nil {
+		r.Updated = doc.Updated.Format(time.RFC3339)
+	}
+	if withContent {
+		r.Content = util.SubStringWithSuffix(doc.Content, maxContentLength, "...")
+	}
+	return r
+}
+
+func searchDocuments(ctx context.Context, request mcpgo.CallToolRequest) (*mcpgo.CallToolResult, error) {
+	query, err := request.RequireString("query")
+	if err != nil {
+		return mcpgo.NewToolResultError(err.Error()), nil
+	}
+	query = util.CleanUserQuery(query)
+	if query == "" {
+		return mcpgo.NewToolResultError("query is empty"), nil
+	}
+
+	size := request.GetInt("size", defaultSearchSize)
+	if size <= 0 || size > maxSearchSize {
+		size = defaultSearchSize
+	}
+
+	builder := orm.NewQuery()
+	builder.Size(size)
+
+	docs := []core.Document{}
+	_, err = document.QueryDocuments(ctx, builder, query,
+		request.GetString("datasource", ""), getIntegrationID(ctx),
+		request.GetString("category", ""), "", "",
+		request.GetString("search_type", "keyword"), 3, &docs)
+	if err != nil {
+		return mcpgo.NewToolResultErrorFromErr("failed to search documents", err), nil
+	}
+
+	results := make([]documentResult, 0, len(docs))
+	for i := range docs {
+		document.RefineDocument(ctx, &docs[i])
+		results = append(results, toDocumentResult(&docs[i], false))
+	}
+	return mcpgo.NewToolResultText(util.MustToJSON(util.MapStr{
+		"total":     len(results),
+		"documents": results,
+	})), nil
+}
+
+func getDocument(ctx context.Context, request mcpgo.CallToolRequest) (*mcpgo.CallToolResult, error) {
+	id, err := request.RequireString("id")
+	if err != nil {
+		return mcpgo.NewToolResultError(err.Error()), nil
+	}
+
+	obj := core.Document{}
+	obj.ID = id
+	ormCtx := orm.NewContextWithParent(ctx)
+	ormCtx.Set(orm.SharingEnabled, true)
+	ormCtx.Set(orm.SharingResourceType, "document")
+	exists, err := orm.GetV2(ormCtx, &obj)
+	if !exists || err != nil || obj.Disabled || !canAccessDatasource(ctx, obj.Source.ID) {
+		return mcpgo.NewToolResultError(fmt.Sprintf("document [%v] not found", id)), nil
+	}
+
+	document.RefineDocument(ctx, &obj)
+	return mcpgo.NewToolResultText(util.MustToJSON(toDocumentResult(&obj, true))), nil
+}
+
+// canAccessDatasource applies the datasource level checks of QueryDocuments
+// to a single document: the datasource must be enabled, visible to the user
+// and, when called through an integration, enabled for that integration.
+func canAccessDatasource(ctx context.Context, datasourceID string) bool {
+	if datasourceID == "" || common.IsDatasourceDeleted(datasourceID) {
+		return false
+	}
+	if disabled, err := common.GetDisabledDatasourceIDs(); err == nil && util.ContainsAnyInArray(datasourceID, disabled) {
+		return false
+	}
+	if integrationID := getIntegrationID(ctx); integrationID != "" {
+		ids, all, err := document.GetDatasourceByIntegration(integrationID)
+		if err != nil || (!all && !util.ContainsAnyInArray(datasourceID, ids)) {
+			return false
+		}
+	}
+	return util.ContainsAnyInArray(datasourceID, common.GetUserDatasource(orm.NewContextWithParent(ctx)))
+}
+
+func listDatasources(ctx context.Context, request mcpgo.CallToolRequest) (*mcpgo.CallToolResult, error) {
+	builder := orm.NewQuery()
+	builder.Size(1000)
+	builder.Filter(orm.TermQuery("enabled", true))
+	builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+
+	if integrationID := getIntegrationID(ctx); integrationID != "" {
+		ids, all, err := document.GetDatasourceByIntegration(integrationID)
+		if err != nil {
+			return mcpgo.NewToolResultErrorFromErr("failed to list datasources", err), nil
+		}
+		if !all {
+			builder.Filter(orm.TermsQuery("id", ids))
+		}
+	}
+
+	ormCtx := orm.NewContextWithParent(ctx)
+	orm.WithModel(ormCtx, &core.DataSource{})
+	ormCtx.Set(orm.SharingEnabled, true)
+	ormCtx.Set(orm.SharingResourceType, "datasource")
+	ormCtx.Set(orm.SharingCategoryCheckingChildrenEnabled, true)
+
+	docs := []core.DataSource{}
+	err, _ := elastic.SearchV2WithResultItemMapper(ormCtx, &docs, builder, nil)
+	if err != nil {
+		return mcpgo.NewToolResultErrorFromErr("failed to list datasources", err), nil
+	}
+
+	out := make([]util.MapStr, 0, len(docs))
+	for _, ds := range docs {
+		out = append(out, util.MapStr{
+			"id":          ds.ID,
+			"name":        ds.Name,
+			"description": ds.Description,
+			"type":        ds.Type,
+			"category":    ds.Category,
+			"tags":        ds.Tags,
+		})
+	}
+	return mcpgo.NewToolResultText(util.MustToJSON(out)), nil
+}
+
+func askAssistant(ctx context.Context, request mcpgo.CallToolRequest) (*mcpgo.CallToolResult, error) {
+	assistantID, err := request.RequireString("assistant_id")
+	if err != nil {
+		return mcpgo.NewToolResultError(err.Error()), nil
+	}
+	message, err := request.RequireString("message")
+	if err != nil {
+		return mcpgo.NewToolResultError(err.Error()), nil
+	}
+	message = strings.TrimSpace(message)
+
+	var assistant *core.Assistant
+	var exists bool
+	if integrationID := getIntegrationID(ctx); integrationID != "" {
+		integration, err := core.InternalGetIntegration(integrationID)
+		if err != nil || !util.ContainsAnyInArray(assistantID, integration.EnabledModule.AIChat.Assistants) {
+			return mcpgo.NewToolResultError(fmt.Sprintf("assistant [%v] not found", assistantID)), nil
+		}
+		// the integration the session was authenticated by may pin the
+		// assistant to a revision
+		if core.SessionIntegrationID(ctx) == integrationID {
+			assistant, exists, err = service.InternalGetIntegrationAssistant(ctx, integrationID, assistantID)
+		} else {
+			assistant, exists, err = service.InternalGetAssistant(ctx, assistantID)
+		}
+		if err != nil {
+			return mcpgo.NewToolResultError(fmt.Sprintf("assistant [%v] not found", assistantID)), nil
+		}
+	} else {
+		assistant, exists, err = service.InternalGetAssistant(ctx, assistantID)
+	}
+	if err != nil || !exists || !assistant.Enabled {
+		return mcpgo.NewToolResultError(fmt.Sprintf("assistant [%v] not found", assistantID)), nil
+	}
+
+	userID := security.MustGetUserFromContext(ctx).MustGetUserID()
+	ctx, cancel := context.WithTimeout(security.CloneContext(ctx), askTimeout)
+	defer cancel()
+
+	answer, _, err := service.AskLoadedAssistantSync(ctx, userID, assistant, message, nil)
+	if err != nil {
+		_ = log.Errorf("failed to ask assistant [%v] via mcp: %v", assistantID, err)
+		return mcpgo.NewToolResultErrorFromErr("failed to ask assistant", err), nil
+	}
+	return mcpgo.NewToolResultText(answer), nil
+}
@@ -44,6 +44,9 @@ func init() {
 		api.Feature(core.FeatureRemoveSensitiveField))
 	api.HandleUIMethod(api.PUT, "/settings", handler.updateServerSettings, api.RequirePermission(updatePermission))
 
+	//encrypt the secrets stored in plain text by a previous version
+	api.HandleUIMethod(api.POST, "/system/_encrypt_secrets", handler.encryptSecrets, api.RequireLogin(), api.RequirePermission(updatePermission))
+
 	//list all icons for connectors
 	api.HandleUIMethod(api.GET, "/icons/list", handler.getIcons, api.AllowPublicAccess())
 
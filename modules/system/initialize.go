@@ -599,6 +599,9 @@ func ensureSetupModelProvider(ctx *orm.Context, m *SetupModelConfig, llmType cor
 		if m.Model != nil {
 			upsertProviderModel(&provider, *m.Model, llmType)
 		}
+		if err := common.EncryptModelProviderSecrets(&provider); err != nil {
+			return "", "", err
+		}
 		if err := orm.Update(ctx, &provider); err != nil {
 			return "", "", err
 		}
@@ -618,6 +621,9 @@ func ensureSetupModelProvider(ctx *orm.Context, m *SetupModelConfig, llmType cor
 		Builtin:     false,
 	}
 	provider.Models = []core.Model{newProviderModel(modelName, m.Model, llmType)}
+	if err := common.EncryptModelProviderSecrets(provider); err != nil {
+		return "", "", err
+	}
 	if err := orm.Create(ctx, provider); err != nil {
 		return "", "", err
 	}
@@ -0,0 +1,161 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package system
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// migrationBatchSize is the number of objects of a kind read at once
+const migrationBatchSize = 500
+
+// encryptSecrets encrypts the secrets stored in plain text, e.g. by a version
+// before secrets were encrypted, or with a previous master key. With dry_run,
+// the objects to migrate are only counted.
+func (h *APIHandler) encryptSecrets(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	dryRun := h.GetBoolOrDefault(req, "dry_run", false)
+	migrated, err := EncryptStoredSecrets(dryRun)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.WriteJSON(w, util.MapStr{
+		"acknowledged": true,
+		"dry_run":      dryRun,
+		"migrated":     migrated,
+	}, 200)
+}
+
+// EncryptStoredSecrets encrypts the secrets stored in plain text, or with a
+// previous master key, of the model providers, connectors, datasources, MCP
+// servers and assistants, and returns the number of objects migrated by kind.
+func EncryptStoredSecrets(dryRun bool) (map[string]int, error) {
+	// the cached objects are decrypted, they do not change
+	migrated := map[string]int{}
+	var err error
+	if migrated["model_provider"], err = encryptStoredObjects(dryRun, common.EncryptModelProviderSecrets); err != nil {
+		return migrated, err
+	}
+	if migrated["connector"], err = encryptStoredObjects(dryRun, common.EncryptConnectorSecrets); err != nil {
+		return migrated, err
+	}
+	if migrated["datasource"], err = encryptStoredObjects(dryRun, common.EncryptDataSourceSecrets); err != nil {
+		return migrated, err
+	}
+	if migrated["mcp_server"], err = encryptStoredObjects(dryRun, common.EncryptMCPServerSecrets); err != nil {
+		return migrated, err
+	}
+	if migrated["assistant"], err = encryptStoredObjects(dryRun, common.EncryptAssistantSecrets); err != nil {
+		return migrated, err
+	}
+	log.Infof("secrets encrypted, dry run: %v, migrated objects: %v", dryRun, migrated)
+	return migrated, nil
+}
+
+// encryptStoredObjects encrypts the secrets of all the stored objects of a
+// kind, and returns the number of objects which had secrets to encrypt. An
+// object updated meanwhile is skipped, it was encrypted by its update.
+func encryptStoredObjects[T any](dryRun bool, encrypt func(*T) error) (int, error) {
+	count := 0
+	err := scanStoredObjects(func(obj *T, stored util.MapStr) (bool, error) {
+		id, _ := stored["id"].(string)
+		before := util.MustToJSON(obj)
+		if err := encrypt(obj); err != nil {
+			return false, err
+		}
+		if util.MustToJSON(obj) == before {
+			return true, nil
+		}
+		if dryRun {
+			count++
+			return true, nil
+		}
+		err := common.UpdateIfUnchanged(id, obj, "updated", stored["updated"], false)
+		if err == common.ErrConcurrentUpdate {
+			log.Debugf("object [%v] was updated meanwhile, skipped", id)
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+// HasEncryptedSecrets reports whether any stored model provider, connector,
+// datasource, MCP server or assistant has an encrypted secret.
+func HasEncryptedSecrets() (bool, error) {
+	for _, encrypted := range []func() (bool, error){
+		storedObjectsEncrypted[core.ModelProvider],
+		storedObjectsEncrypted[core.Connector],
+		storedObjectsEncrypted[core.DataSource],
+		storedObjectsEncrypted[core.MCPServer],
+		storedObjectsEncrypted[core.Assistant],
+	} {
+		if ok, err := encrypted(); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// storedObjectsEncrypted reports whether any stored object of a kind has an
+// encrypted secret.
+func storedObjectsEncrypted[T any]() (bool, error) {
+	found := false
+	err := scanStoredObjects(func(obj *T, stored util.MapStr) (bool, error) {
+		found = strings.Contains(util.MustToJSON(obj), common.EncryptedSecretPrefix)
+		return !found, nil
+	})
+	return found, err
+}
+
+// scanStoredObjects visits all the stored objects of a kind, by batches, until
+// visit returns false or an error.
+func scanStoredObjects[T any](visit func(obj *T, stored util.MapStr) (bool, error)) error {
+	var searchAfter []interface{}
+	for {
+		dsl := util.MapStr{
+			"size": migrationBatchSize,
+			"sort": []util.MapStr{{"id": util.MapStr{"order": "asc"}}},
+		}
+		if searchAfter != nil {
+			dsl["search_after"] = searchAfter
+		}
+		objects := []T{}
+		q := orm.Query{RawQuery: util.MustToJSONBytes(dsl)}
+		if err, _ := orm.SearchWithJSONMapper(&objects, &q); err != nil {
+			return err
+		}
+
+		for i := range objects {
+			obj := &objects[i]
+			stored := util.MapStr{}
+			if err := util.FromJSONBytes(util.MustToJSONBytes(obj), &stored); err != nil {
+				return err
+			}
+			id, _ := stored["id"].(string)
+			searchAfter = []interface{}{id}
+
+			next, err := visit(obj, stored)
+			if err != nil || !next {
+				return err
+			}
+		}
+		if len(objects) < migrationBatchSize {
+			return nil
+		}
+	}
+}
@@ -32,6 +32,7 @@ func (h *APIHandler) updateServerSettings(w http.ResponseWriter, req *http.Reque
 		return
 	}
 	oldAppConfig := common.AppConfig()
+	before := common.AuditSnapshot(oldAppConfig)
 	if appConfig.ServerInfo != nil {
 		//merge settings
 		serverCfg := core.ServerInfo{}
@@ -111,6 +112,7 @@ func (h *APIHandler) updateServerSettings(w http.ResponseWriter, req *http.Reque
 		oldAppConfig.DocumentProcessing = &docProcessing
 	}
 	common.SetAppConfig(&oldAppConfig)
+	common.RecordAudit(req, core.AuditActionUpdate, core.AuditResourceSettings, "", before, &oldAppConfig)
 	h.WriteAckOKJSON(w)
 }
 
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/util"
@@ -70,6 +71,9 @@ func getOAuthConfigFromConnector(connectorID string) (*OAuthConfig, error) {
 	connector := core.Connector{}
 	connector.ID = connectorID
 	exists, err := orm.Get(&connector)
+	if err == nil && exists {
+		err = common.DecryptConnectorSecrets(&connector)
+	}
 	if err == nil && exists && connector.Config != nil {
 		if clientID, ok := connector.Config["client_id"].(string); ok {
 			oauthConfig.ClientID = clientID
@@ -293,7 +297,10 @@ func oAuthRedirect(w http.ResponseWriter, req *http.Request, ps httprouter.Param
 
 	// Save datasource
 	ctx := orm.NewContextWithParent(req.Context())
-	err = orm.Save(ctx, &datasource)
+	err = common.EncryptDataSourceSecrets(&datasource)
+	if err == nil {
+		err = orm.Save(ctx, &datasource)
+	}
 	if err != nil {
 		_ = log.Errorf("[box connector] Failed to save datasource: %v", err)
 		http.Error(w, "Failed to save datasource.", http.StatusInternalServerError)
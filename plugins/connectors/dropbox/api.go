@@ -70,6 +70,9 @@ func getOAuthConfig(connectorID string) (*oauth2.Config, error) {
 	connector := core.Connector{}
 	connector.ID = connectorID
 	exists, err := orm.Get(&connector)
+	if err == nil && exists {
+		err = common.DecryptConnectorSecrets(&connector)
+	}
 	if err == nil && exists && connector.Config != nil {
 		if clientID, ok := connector.Config["client_id"].(string); ok {
 			oAuthConfig.ClientID = clientID
@@ -233,7 +236,10 @@ func oAuthRedirect(w http.ResponseWriter, req *http.Request, ps httprouter.Param
 
 	ctx := orm.NewContextWithParent(req.Context())
 	common.MarkDatasourceNotDeleted(datasource.ID)
-	err = orm.Save(ctx, &datasource)
+	err = common.EncryptDataSourceSecrets(&datasource)
+	if err == nil {
+		err = orm.Save(ctx, &datasource)
+	}
 	if err != nil {
 		_ = log.Errorf("[dropbox connector] Failed to save datasource: %v", err)
 		http.Error(w, "Failed to save datasource.", http.StatusInternalServerError)
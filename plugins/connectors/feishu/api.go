@@ -3,6 +3,7 @@ package feishu
 import (
 	"fmt"
 	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
 	"infini.sh/coco/modules/connector"
 	"infini.sh/coco/plugins/connectors"
 	"net/http"
@@ -163,7 +164,10 @@ func oAuthRedirect(w http.ResponseWriter, req *http.Request, ps httprouter.Param
 
 	// Save datasource
 	ctx := orm.NewContextWithParent(req.Context())
-	err = orm.Save(ctx, &datasource)
+	err = common.EncryptDataSourceSecrets(&datasource)
+	if err == nil {
+		err = orm.Save(ctx, &datasource)
+	}
 	if err != nil {
 		log.Errorf("[%s connector] Failed to save datasource: %v", pluginType, err)
 		http.Error(w, "Failed to save datasource.", http.StatusInternalServerError)
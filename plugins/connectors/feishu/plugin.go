@@ -225,7 +225,12 @@ func (this *Plugin) Fetch(ctx *pipeline.Context, connector *core.Connector, data
 					ormCtx := orm.NewContext().DirectAccess()
 					ormCtx.PermissionScope(security.PermissionScopePlatform)
 
-					if err := orm.Update(ormCtx, datasource); err != nil {
+					// the tokens are encrypted in the stored copy only, they are used below
+					stored := *datasource
+					if err := common.EncryptDataSourceSecrets(&stored); err != nil {
+						return errors.Errorf("[%s connector] failed to encrypt refreshed token: %v", this.PluginType, err)
+					}
+					if err := orm.Update(ormCtx, &stored); err != nil {
 						return errors.Errorf("[%s connector] failed to save refreshed token: %v", this.PluginType, err)
 					}
 				}
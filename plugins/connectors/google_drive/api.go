@@ -170,6 +170,9 @@ func oAuthRedirect(w http.ResponseWriter, req *http.Request, ps httprouter.Param
 
 	ctx := orm.NewContextWithParent(req.Context())
 	common.MarkDatasourceNotDeleted(datasource.ID)
+	if err := common.EncryptDataSourceSecrets(&datasource); err != nil {
+		panic(err)
+	}
 	err = orm.Save(ctx, &datasource)
 	if err != nil {
 		panic(err)
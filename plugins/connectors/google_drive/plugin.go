@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/security"
 
@@ -101,7 +102,12 @@ func (this *Processor) Fetch(pipeCtx *pipeline.Context, connector *core.Connecto
 				ctx.PermissionScope(security.PermissionScopePlatform)
 
 				// Optionally, save the new tokens in your store (e.g., database or config)
-				err = orm.Update(ctx, datasource)
+				// the tokens are encrypted in the stored copy only, they are used below
+				stored := *datasource
+				err = common.EncryptDataSourceSecrets(&stored)
+				if err == nil {
+					err = orm.Update(ctx, &stored)
+				}
 				if err != nil {
 					log.Errorf("Failed to save updated datasource configuration: %v", err)
 					return errors.New("Failed to save updated configuration")
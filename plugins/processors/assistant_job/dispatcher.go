@@ -0,0 +1,43 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package assistant_job
+
+import (
+	"fmt"
+
+	"infini.sh/coco/modules/assistant/service"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+)
+
+// Dispatcher runs the scheduled assistant jobs which are due.
+type Dispatcher struct{}
+
+const processorName = "assistant_job_dispatcher"
+
+func init() {
+	pipeline.RegisterProcessorPlugin(processorName, New)
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	runner := Dispatcher{}
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of %s processor: %s", processorName, err)
+	}
+	return &runner, nil
+}
+
+func (processor *Dispatcher) Name() string {
+	return processorName
+}
+
+func (processor *Dispatcher) Process(ctx *pipeline.Context) error {
+	if global.ShuttingDown() {
+		return errors.New("shutting down")
+	}
+	return service.RunDueAssistantJobs(ctx)
+}
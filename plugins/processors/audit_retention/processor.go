@@ -0,0 +1,43 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package audit_retention
+
+import (
+	"fmt"
+
+	"infini.sh/coco/modules/audit"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+)
+
+// Processor deletes the audit logs older than `coco.audit.retention_days`.
+type Processor struct{}
+
+const processorName = "audit_log_retention"
+
+func init() {
+	pipeline.RegisterProcessorPlugin(processorName, New)
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	runner := Processor{}
+	if err := c.Unpack(&runner); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of %s processor: %s", processorName, err)
+	}
+	return &runner, nil
+}
+
+func (processor *Processor) Name() string {
+	return processorName
+}
+
+func (processor *Processor) Process(ctx *pipeline.Context) error {
+	if global.ShuttingDown() {
+		return errors.New("shutting down")
+	}
+	return audit.DeleteExpiredAuditLogs()
+}
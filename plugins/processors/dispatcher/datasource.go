@@ -8,6 +8,7 @@ import (
 	"fmt"
 
 	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/pipeline"
@@ -36,6 +37,12 @@ func (processor *Dispatcher) syncDatasource(c *core.DataSource) error {
 	if err != nil {
 		panic(errors.Errorf("invalid %s connector:%v", connector.ID, err))
 	}
+	if err := common.DecryptConnectorSecrets(&connector); err != nil {
+		return err
+	}
+	if err := common.DecryptDataSourceSecrets(c); err != nil {
+		return err
+	}
 
 	if !connector.Processor.Enabled {
 		return errors.Errorf("connector %s not enable pipeline", connector.ID)
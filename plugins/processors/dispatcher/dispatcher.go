@@ -44,7 +44,10 @@ func New(c *config.Config) (pipeline.Processor, error) {
 	}
 
 	runner := Dispatcher{config: &cfg}
-	api.HandleUIMethod(api.POST, "/datasource/:id/_reset_sync", runner.resetAccessTime, api.RequireLogin())
+	// resetting the sync of a datasource is an update of it, granted to the
+	// API keys by the datasource write scope
+	updatePermission := security.GetSimplePermission("coco", "datasource", string(security.Update))
+	api.HandleUIMethod(api.POST, "/datasource/:id/_reset_sync", runner.resetAccessTime, api.RequirePermission(updatePermission), api.RequireLogin())
 	return &runner, nil
 }
 
@@ -5,9 +5,13 @@
 package dispatcher
 
 import (
+	"net/http"
+
+	"infini.sh/coco/core"
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/kv"
-	"net/http"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
 )
 
 const lastAccessTimeKey = "/datasource/lastAccessTime"
@@ -15,8 +19,28 @@ const lastAccessTimeKey = "/datasource/lastAccessTime"
 func (processor *Dispatcher) resetAccessTime(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	//from context
 	datasourceID := ps.MustGetParameter("id")
+	if !core.APIKeyAllowsDatasource(req.Context(), datasourceID) {
+		processor.WriteError(w, "api key not allowed to access the datasource", http.StatusForbidden)
+		return
+	}
+
+	// only the datasources the user can access
+	obj := core.DataSource{}
+	obj.ID = datasourceID
+	ctx := orm.NewContextWithParent(req.Context())
+	ctx.Set(orm.SharingEnabled, true)
+	ctx.Set(orm.SharingResourceType, "datasource")
+	ctx.Set(orm.SharingCategoryCheckingChildrenEnabled, true)
+	exists, err := orm.GetV2(ctx, &obj)
+	if !exists || err != nil {
+		processor.WriteJSON(w, util.MapStr{
+			"_id":   datasourceID,
+			"found": false,
+		}, http.StatusNotFound)
+		return
+	}
 
-	err := kv.DeleteKey(lastAccessTimeKey, []byte(datasourceID))
+	err = kv.DeleteKey(lastAccessTimeKey, []byte(datasourceID))
 	if err != nil {
 		panic(err)
 	}
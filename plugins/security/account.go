@@ -5,9 +5,13 @@
 package security
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/global"
@@ -19,6 +23,7 @@ import (
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/kv"
 	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
 )
 
 func (h APIHandler) Profile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -72,6 +77,10 @@ func (h APIHandler) UpdatePassword(w http.ResponseWriter, r *http.Request, ps ht
 	if err != nil {
 		panic(err)
 	}
+	if reqUser.Has(core.UserSessionInfoKeyAPIKey) {
+		api.WriteAuthRequiredError(w, "the password can not be changed with an api key")
+		return
+	}
 	var req struct {
 		OldPassword string `json:"old_password"`
 		NewPassword string `json:"new_password"`
@@ -114,6 +123,9 @@ func (h APIHandler) Login(w http.ResponseWriter, r *http.Request, ps httprouter.
 	var req struct {
 		Email    string `json:"email,omitempty"`
 		Password string `json:"password"`
+		// Challenge is the answer of the login challenge, required after
+		// failed logins when one is registered
+		Challenge string `json:"challenge,omitempty"`
 	}
 
 	var fromForm = false
@@ -139,6 +151,7 @@ func (h APIHandler) Login(w http.ResponseWriter, r *http.Request, ps httprouter.
 		}
 		fromForm = true
 		req.Password = r.PostFormValue("password")
+		req.Challenge = r.PostFormValue("challenge")
 
 	default:
 		h.WriteError(w, "unsupported content type", http.StatusUnsupportedMediaType)
@@ -151,14 +164,40 @@ func (h APIHandler) Login(w http.ResponseWriter, r *http.Request, ps httprouter.
 		return
 	}
 
+	login := req.Email
+	if login == "" {
+		login = core.DefaultSimpleAuthUserLogin
+	}
+	guard := newLoginGuard(r, login)
+	now := time.Now()
+	// the attempt is counted as failed until it succeeds
+	reason, retryAfter, challenge := guard.reserve(now)
+	if reason != "" {
+		guard.audit(r, nil, reason)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		h.WriteError(w, "too many failed logins, please retry later", http.StatusTooManyRequests)
+		return
+	}
+	if challenge {
+		if err := loginChallenge(r, login, req.Challenge); err != nil {
+			guard.audit(r, nil, core.LoginFailedChallenge)
+			h.WriteJSON(w, util.MapStr{
+				"error":              err.Error(),
+				"challenge_required": true,
+			}, http.StatusForbidden)
+			return
+		}
+	}
+
 	sessionInfo := security.UserSessionInfo{}
 
 	if req.Email == "" {
 		err, success := h.checkPassword(req.Password)
-		if err != nil {
+		if err != nil && !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 			panic(err)
 		}
 		if !success {
+			guard.audit(r, nil, core.LoginFailedBadCredentials)
 			h.WriteError(w, "failed to login", http.StatusForbidden)
 			return
 		}
@@ -173,6 +212,7 @@ func (h APIHandler) Login(w http.ResponseWriter, r *http.Request, ps httprouter.
 			panic(err)
 		}
 		if !success {
+			guard.audit(r, nil, core.LoginFailedBadCredentials)
 			h.WriteError(w, "failed to login", http.StatusForbidden)
 			return
 		}
@@ -183,11 +223,19 @@ func (h APIHandler) Login(w http.ResponseWriter, r *http.Request, ps httprouter.
 		sessionInfo.SetUserID(account.ID)
 	}
 
+	// the session is only created once the second factor is verified
+	if h.requireSecondFactor(w, &sessionInfo) {
+		guard.release(now)
+		return
+	}
+
 	err, token := security.AddUserToSession(w, r, &sessionInfo)
 	if err != nil {
 		h.ErrorInternalServer(w, "failed to authorize user")
 		return
 	}
+	guard.succeed(now)
+	guard.audit(r, &sessionInfo, "")
 
 	if fromForm {
 		h.Redirect(w, r, fmt.Sprintf("/login/success?request_id=%v&code=%v", requestID, token["access_token"]))
@@ -0,0 +1,561 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// apiKeyTouchInterval is how often the last use of an API key is saved.
+const apiKeyTouchInterval = time.Minute
+
+var manageAPIKeyPermission = security.GetSimplePermission("coco", "api_key", "manage")
+
+// newAPIKeySecret returns a random secret of 256 bits.
+func newAPIKeySecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// formatAPIKey returns the API key given to the client, it holds the ID of the
+// key to look it up.
+func formatAPIKey(id, secret string) string {
+	return core.APIKeyPrefix + id + "_" + secret
+}
+
+func parseAPIKey(raw string) (id, secret string, ok bool) {
+	raw, ok = strings.CutPrefix(strings.TrimSpace(raw), core.APIKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(raw, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateAPIKeyScopes checks the scopes of a key are known.
+func validateAPIKeyScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if _, ok := core.APIKeyScopes[scope]; !ok {
+			return fmt.Errorf("unknown scope [%s]", scope)
+		}
+	}
+	return nil
+}
+
+// apiKeyLifetime returns the days a key is valid for, the default lifetime
+// applies when days is 0.
+func apiKeyLifetime(cfg *core.APIKeyConfig, days int) (int, error) {
+	if days == 0 {
+		days = cfg.GetDefaultExpireDays()
+	}
+	if days < 0 || days > cfg.GetMaxExpireDays() {
+		return 0, fmt.Errorf("expire_in_days must be between 1 and %d", cfg.GetMaxExpireDays())
+	}
+	return days, nil
+}
+
+// apiKeyPermissions returns the permissions granted by the scopes of a key,
+// those the user it runs as does not have are left out.
+func apiKeyPermissions(session *security.UserSessionInfo, scopes []string) []security.PermissionKey {
+	return scopedPermissions(security.GetAllPermissionsForUser(session), scopes)
+}
+
+// scopedPermissions returns the permissions of a user granted by scopes.
+func scopedPermissions(userPermissions []security.PermissionKey, scopes []string) []security.PermissionKey {
+	granted := map[security.PermissionKey]bool{}
+	for _, scope := range scopes {
+		for _, permission := range core.APIKeyScopes[scope] {
+			granted[permission] = true
+		}
+	}
+	permissions := []security.PermissionKey{}
+	for _, permission := range userPermissions {
+		if granted[permission] {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// verifyAPIKey tells if the secret of a request is the one of a key, which is
+// neither revoked nor expired.
+func verifyAPIKey(key *core.APIKey, secret string, now time.Time) bool {
+	return key != nil && hmac.Equal([]byte(key.Hash), []byte(hashAPIKeySecret(secret))) && key.Active(now)
+}
+
+// revokeAPIKey marks a key revoked.
+func revokeAPIKey(key *core.APIKey, now time.Time) {
+	key.Revoked = true
+	key.RevokedAt = &now
+}
+
+// rotateAPIKey gives a key a new secret, returned, and its lifetime again.
+func rotateAPIKey(key *core.APIKey, now time.Time) (string, error) {
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return "", err
+	}
+	key.ExpireAt = now.AddDate(0, 0, key.ExpireInDays)
+	key.RotatedAt = &now
+	key.Hash = hashAPIKeySecret(secret)
+	key.Hint = secret[len(secret)-4:]
+	return secret, nil
+}
+
+func apiKeyContext() *orm.Context {
+	ctx := orm.NewContext()
+	ctx.DirectAccess()
+	ctx.PermissionScope(security.PermissionScopePlatform)
+	return ctx
+}
+
+// getAPIKey returns an API key by its ID, nil if not found.
+func getAPIKey(id string) (*core.APIKey, error) {
+	obj := core.APIKey{}
+	obj.ID = id
+	exists, err := orm.GetV2(apiKeyContext(), &obj)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// ValidateLoginByAPIKey authenticates the requests with an API key, they run
+// as the user of the key with the permissions of its scopes.
+func ValidateLoginByAPIKey(w http.ResponseWriter, r *http.Request) (claims *security.UserClaims, err error) {
+	raw := r.Header.Get(core.HeaderAPIKey)
+	if raw == "" {
+		return nil, errors.Error("api key not found")
+	}
+	id, secret, ok := parseAPIKey(raw)
+	if !ok {
+		return nil, errors.Error("invalid api key")
+	}
+	key, err := getAPIKey(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !verifyAPIKey(key, secret, now) {
+		return nil, errors.Error("invalid api key")
+	}
+
+	claims = security.NewUserClaims()
+	claims.SetUserID(key.RunAs)
+	claims.Provider = security.DefaultNativeAuthBackend
+	claims.Login = key.RunAs
+	claims.UserID = key.RunAs
+	claims.Set(core.UserSessionInfoKeyAPIKey, key.ID)
+	if len(key.Datasources) > 0 {
+		claims.Set(core.UserSessionInfoKeyAPIKeyDatasources, key.Datasources)
+	}
+	if len(key.Assistants) > 0 {
+		claims.Set(core.UserSessionInfoKeyAPIKeyAssistants, key.Assistants)
+	}
+	claims.UserAssignedPermission = security.NewUserAssignedPermission(apiKeyPermissions(claims.UserSessionInfo, key.Scopes), nil)
+
+	touchAPIKey(key, common.ClientIP(r), now)
+	return claims, nil
+}
+
+// touchAPIKey saves the last use of a key, at most once a minute.
+func touchAPIKey(key *core.APIKey, clientIP string, now time.Time) {
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < apiKeyTouchInterval {
+		return
+	}
+	err := orm.UpdatePartialFields(apiKeyContext(), key, util.MapStr{
+		"last_used_at": now,
+		"last_used_ip": clientIP,
+	})
+	if err != nil {
+		log.Errorf("failed to save the last use of api key [%s]: %v", key.ID, err)
+	}
+}
+
+// currentAPIKeyUser returns the user of a request managing the API keys, the
+// guest users of the integrations and the API keys can not.
+func currentAPIKeyUser(w http.ResponseWriter, r *http.Request) (*security.UserSessionInfo, bool) {
+	reqUser, err := security.GetUserFromContext(r.Context())
+	if err != nil || reqUser == nil {
+		api.WriteAuthRequiredError(w, "invalid user")
+		return nil, false
+	}
+	if reqUser.Has(core.UserSessionInfoKeyIntegration) {
+		api.WriteAuthRequiredError(w, "no api key for guest user")
+		return nil, false
+	}
+	if reqUser.Has(core.UserSessionInfoKeyAPIKey) {
+		api.WriteAuthRequiredError(w, "api keys can not be managed with an api key")
+		return nil, false
+	}
+	return reqUser, true
+}
+
+func isAdmin(reqUser *security.UserSessionInfo) bool {
+	return reqUser.Roles != nil && util.AnyInArrayEquals(reqUser.Roles, security.RoleAdmin)
+}
+
+// canManageServiceKeys tells if a user may manage the service keys.
+func canManageServiceKeys(reqUser *security.UserSessionInfo) bool {
+	if isAdmin(reqUser) {
+		return true
+	}
+	return reqUser.UserAssignedPermission.ValidateFor(security.GetOrInitPermissionKey(manageAPIKeyPermission))
+}
+
+// canManageAPIKey tells if a user may manage a key, their personal keys or the
+// service keys.
+func canManageAPIKey(reqUser *security.UserSessionInfo, key *core.APIKey) bool {
+	if key.Type == core.APIKeyTypeService {
+		return canManageServiceKeys(reqUser)
+	}
+	return key.RunAs == reqUser.MustGetUserID()
+}
+
+// managedAPIKey returns the key of a request the user may manage, it writes
+// the error otherwise.
+func (h APIHandler) managedAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (*core.APIKey, bool) {
+	reqUser, ok := currentAPIKeyUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	id := ps.MustGetParameter("id")
+	key, err := getAPIKey(id)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	// the keys of the others are not found, not forbidden
+	if key == nil || !canManageAPIKey(reqUser, key) {
+		h.WriteGetMissingJSON(w, id)
+		return nil, false
+	}
+	return key, true
+}
+
+// inaccessibleIDs returns the IDs among ids of the objects not found with a
+// context, e.g. those not shared with its user.
+func inaccessibleIDs[T any](ctx *orm.Context, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	builder := orm.NewQuery()
+	builder.Filter(orm.TermsQuery("id", ids))
+	builder.Size(len(ids))
+
+	found := map[string]bool{}
+	docs := []T{}
+	err, _ := elastic.SearchV2WithResultItemMapper(ctx, &docs, builder, func(source map[string]interface{}, targetRef interface{}) error {
+		if id, ok := source["id"].(string); ok {
+			found[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// validateAPIKeyRestrictions checks the datasources and assistants a key is
+// restricted to exist, and that the user creating the key may access them.
+func validateAPIKeyRestrictions(r *http.Request, datasources, assistants []string) (int, error) {
+	ctx := orm.NewContextWithParent(r.Context())
+	orm.WithModel(ctx, &core.DataSource{})
+	ctx.Set(orm.SharingEnabled, true)
+	ctx.Set(orm.SharingResourceType, "datasource")
+	ctx.Set(orm.SharingCategoryCheckingChildrenEnabled, true)
+	missing, err := inaccessibleIDs[core.DataSource](ctx, datasources)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(missing) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("datasources %v not found", missing)
+	}
+
+	ctx = orm.NewContextWithParent(r.Context())
+	orm.WithModel(ctx, &core.Assistant{})
+	ctx.Set(orm.SharingEnabled, true)
+	ctx.Set(orm.SharingResourceType, "assistant")
+	missing, err = inaccessibleIDs[core.Assistant](ctx, assistants)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(missing) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("assistants %v not found", missing)
+	}
+	return http.StatusOK, nil
+}
+
+// apiKeySnapshot returns a copy of a key to return or audit, without the hash
+// of its secret.
+func apiKeySnapshot(key *core.APIKey) *core.APIKey {
+	obj := *key
+	obj.Hash = ""
+	return &obj
+}
+
+// CreateAPIKey creates an API key, the key is only returned once.
+func (h APIHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reqUser, ok := currentAPIKeyUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name         string   `json:"name"`
+		Type         string   `json:"type"`
+		RunAs        string   `json:"run_as"`
+		Scopes       []string `json:"scopes"`
+		Datasources  []string `json:"datasources"`
+		Assistants   []string `json:"assistants"`
+		ExpireInDays int      `json:"expire_in_days"`
+	}
+	if err := h.DecodeJSON(r, &req); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateAPIKeyScopes(req.Scopes); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	days, err := apiKeyLifetime(common.AppConfig().APIKey, req.ExpireInDays)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if status, err := validateAPIKeyRestrictions(r, req.Datasources, req.Assistants); err != nil {
+		h.WriteError(w, err.Error(), status)
+		return
+	}
+
+	key := core.APIKey{
+		Name:         req.Name,
+		Type:         req.Type,
+		RunAs:        reqUser.MustGetUserID(),
+		Scopes:       req.Scopes,
+		Datasources:  req.Datasources,
+		Assistants:   req.Assistants,
+		ExpireInDays: days,
+		ExpireAt:     time.Now().AddDate(0, 0, days),
+	}
+	switch key.Type {
+	case "", core.APIKeyTypePersonal:
+		key.Type = core.APIKeyTypePersonal
+	case core.APIKeyTypeService:
+		if !canManageServiceKeys(reqUser) {
+			h.WriteError(w, "permission denied to create service keys", http.StatusForbidden)
+			return
+		}
+		// a key running as another user could get more than the creator has
+		if req.RunAs != "" && req.RunAs != key.RunAs {
+			if !isAdmin(reqUser) {
+				h.WriteError(w, "only the admins can create service keys running as another user", http.StatusForbidden)
+				return
+			}
+			_, user, err := security.GetUserByID(req.RunAs)
+			if err != nil {
+				h.WriteError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if user == nil {
+				h.WriteError(w, "run_as user not found", http.StatusBadRequest)
+				return
+			}
+			key.RunAs = req.RunAs
+		}
+	default:
+		h.WriteError(w, fmt.Sprintf("unknown type [%s]", key.Type), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key.ID = util.GetUUID()
+	key.Hash = hashAPIKeySecret(secret)
+	key.Hint = secret[len(secret)-4:]
+	if key.Name == "" {
+		key.Name = "api-key-" + key.Hint
+	}
+
+	ctx := apiKeyContext()
+	ctx.Refresh = orm.WaitForRefresh
+	if err := orm.Create(ctx, &key); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	common.RecordAudit(r, core.AuditActionCreate, core.AuditResourceAPIKey, key.ID, nil, apiKeySnapshot(&key))
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":       key.ID,
+		"result":    "created",
+		"api_key":   formatAPIKey(key.ID, secret),
+		"expire_at": key.ExpireAt,
+	}, http.StatusOK)
+}
+
+// SearchAPIKeys searches the personal keys of the current user, or the
+// service keys with `type=service`.
+func (h APIHandler) SearchAPIKeys(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reqUser, ok := currentAPIKeyUser(w, r)
+	if !ok {
+		return
+	}
+	builder, err := orm.NewQueryBuilderFromRequest(r, "name")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.EnableBodyBytes()
+	builder.Exclude("hash")
+	if h.GetParameterOrDefault(r, "type", core.APIKeyTypePersonal) == core.APIKeyTypeService {
+		if !canManageServiceKeys(reqUser) {
+			h.WriteError(w, "permission denied to manage service keys", http.StatusForbidden)
+			return
+		}
+		builder.Filter(orm.TermQuery("type", core.APIKeyTypeService))
+	} else {
+		builder.Filter(orm.TermQuery("type", core.APIKeyTypePersonal))
+		builder.Filter(orm.TermQuery("run_as", reqUser.MustGetUserID()))
+	}
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(r.Context())
+	ctx.DirectAccess()
+	ctx.PermissionScope(security.PermissionScopePlatform)
+	orm.WithModel(ctx, &core.APIKey{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.Write(w, res.Payload.([]byte))
+	if err != nil {
+		h.Error(w, err)
+	}
+}
+
+// GetAPIKey returns an API key, without its secret.
+func (h APIHandler) GetAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := h.managedAPIKey(w, r, ps)
+	if !ok {
+		return
+	}
+	h.WriteGetOKJSON(w, key.ID, apiKeySnapshot(key))
+}
+
+// RevokeAPIKey revokes an API key, it is refused at once.
+func (h APIHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := h.managedAPIKey(w, r, ps)
+	if !ok {
+		return
+	}
+	if key.Revoked {
+		h.WriteUpdatedOKJSON(w, key.ID)
+		return
+	}
+	before := apiKeySnapshot(key)
+	revokeAPIKey(key, time.Now())
+
+	// only the revocation is saved, not to overwrite a concurrent rotation or
+	// the last use of the key
+	ctx := apiKeyContext()
+	ctx.Refresh = orm.WaitForRefresh
+	err := orm.UpdatePartialFields(ctx, key, util.MapStr{
+		"revoked":    key.Revoked,
+		"revoked_at": key.RevokedAt,
+	})
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	common.RecordAudit(r, core.AuditActionUpdate, core.AuditResourceAPIKey, key.ID, before, apiKeySnapshot(key))
+	h.WriteUpdatedOKJSON(w, key.ID)
+}
+
+// RotateAPIKey replaces the secret of an API key, the previous one is refused
+// at once. The key gets its lifetime again, it is only returned once.
+func (h APIHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := h.managedAPIKey(w, r, ps)
+	if !ok {
+		return
+	}
+	if key.Revoked {
+		h.WriteError(w, "api key revoked", http.StatusBadRequest)
+		return
+	}
+	before := apiKeySnapshot(key)
+	secret, err := rotateAPIKey(key, time.Now())
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// only the new secret is saved, a concurrent revocation is kept and the
+	// secret refused
+	ctx := apiKeyContext()
+	ctx.Refresh = orm.WaitForRefresh
+	err = orm.UpdatePartialFields(ctx, key, util.MapStr{
+		"hash":       key.Hash,
+		"hint":       key.Hint,
+		"expire_at":  key.ExpireAt,
+		"rotated_at": key.RotatedAt,
+	})
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	common.RecordAudit(r, core.AuditActionUpdate, core.AuditResourceAPIKey, key.ID, before, apiKeySnapshot(key))
+
+	h.WriteJSON(w, util.MapStr{
+		"_id":       key.ID,
+		"result":    "updated",
+		"api_key":   formatAPIKey(key.ID, secret),
+		"expire_at": key.ExpireAt,
+	}, http.StatusOK)
+}
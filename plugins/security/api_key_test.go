@@ -0,0 +1,158 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package security
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"infini.sh/coco/core"
+	"infini.sh/framework/core/security"
+)
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		raw    string
+		id     string
+		secret string
+		ok     bool
+	}{
+		{formatAPIKey("key1", "s3cr3t"), "key1", "s3cr3t", true},
+		{" " + core.APIKeyPrefix + "key1_s3cr3t_more ", "key1", "s3cr3t_more", true},
+		{"key1_s3cr3t", "", "", false},
+		{core.APIKeyPrefix + "key1", "", "", false},
+		{core.APIKeyPrefix + "_s3cr3t", "", "", false},
+		{core.APIKeyPrefix + "key1_", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			id, secret, ok := parseAPIKey(tt.raw)
+			if id != tt.id || secret != tt.secret || ok != tt.ok {
+				t.Fatalf("expected %q, %q, %v, got %q, %q, %v", tt.id, tt.secret, tt.ok, id, secret, ok)
+			}
+		})
+	}
+}
+
+func TestAPIKeyLifetime(t *testing.T) {
+	cfg := &core.APIKeyConfig{DefaultExpireDays: 30, MaxExpireDays: 90}
+	tests := []struct {
+		cfg      *core.APIKeyConfig
+		days     int
+		expected int
+		ok       bool
+	}{
+		{cfg, 0, 30, true},
+		{cfg, 1, 1, true},
+		{cfg, 90, 90, true},
+		{cfg, 91, 0, false},
+		{cfg, -1, 0, false},
+		{nil, 0, 90, true},
+		{nil, 365, 365, true},
+		{nil, 366, 0, false},
+	}
+	for _, tt := range tests {
+		days, err := apiKeyLifetime(tt.cfg, tt.days)
+		if (err == nil) != tt.ok || days != tt.expected {
+			t.Fatalf("expected %d, %v for %d days, got %d, %v", tt.expected, tt.ok, tt.days, days, err)
+		}
+	}
+}
+
+func TestScopedPermissions(t *testing.T) {
+	searchDocument := security.GetSimplePermission("coco", "document", string(security.Search))
+	readDatasource := security.GetSimplePermission("coco", "datasource", string(security.Read))
+	deleteDatasource := security.GetSimplePermission("coco", "datasource", string(security.Delete))
+	manageKeys := manageAPIKeyPermission
+	userPermissions := []security.PermissionKey{searchDocument, readDatasource, deleteDatasource, manageKeys}
+
+	tests := []struct {
+		name     string
+		scopes   []string
+		expected []security.PermissionKey
+	}{
+		{"search", []string{core.APIKeyScopeSearch}, []security.PermissionKey{searchDocument}},
+		{"datasource read", []string{core.APIKeyScopeDatasourceRead}, []security.PermissionKey{searchDocument, readDatasource}},
+		{"datasource write", []string{core.APIKeyScopeDatasourceWrite}, []security.PermissionKey{searchDocument, readDatasource, deleteDatasource}},
+		{"unknown", []string{"admin"}, []security.PermissionKey{}},
+		{"none", nil, []security.PermissionKey{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scopedPermissions(userPermissions, tt.scopes); !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	// never more than the user has
+	if got := scopedPermissions([]security.PermissionKey{searchDocument}, []string{core.APIKeyScopeDatasourceWrite}); !reflect.DeepEqual(got, []security.PermissionKey{searchDocument}) {
+		t.Fatalf("expected the permissions of the user only, got %v", got)
+	}
+}
+
+func newTestAPIKey(t *testing.T, now time.Time) (*core.APIKey, string) {
+	key := &core.APIKey{ExpireInDays: 30, ExpireAt: now.AddDate(0, 0, 30)}
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	key.Hash = hashAPIKeySecret(secret)
+	return key, secret
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	now := time.Now()
+	key, secret := newTestAPIKey(t, now)
+
+	if !verifyAPIKey(key, secret, now) {
+		t.Fatalf("expected the key accepted")
+	}
+	if verifyAPIKey(key, secret+"0", now) {
+		t.Fatalf("expected a wrong secret refused")
+	}
+	if verifyAPIKey(nil, secret, now) {
+		t.Fatalf("expected a missing key refused")
+	}
+	if verifyAPIKey(key, secret, key.ExpireAt) {
+		t.Fatalf("expected an expired key refused")
+	}
+
+	revokeAPIKey(key, now)
+	if !key.Revoked || key.RevokedAt == nil {
+		t.Fatalf("expected the key revoked, got %v, %v", key.Revoked, key.RevokedAt)
+	}
+	if verifyAPIKey(key, secret, now) {
+		t.Fatalf("expected a revoked key refused")
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	created := time.Now().AddDate(0, 0, -20)
+	key, secret := newTestAPIKey(t, created)
+
+	now := time.Now()
+	rotated, err := rotateAPIKey(key, now)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if rotated == secret || key.Hint != rotated[len(rotated)-4:] {
+		t.Fatalf("expected a new secret with its hint, got %q, %q", rotated, key.Hint)
+	}
+	if verifyAPIKey(key, secret, now) {
+		t.Fatalf("expected the previous secret refused")
+	}
+	if !verifyAPIKey(key, rotated, now) {
+		t.Fatalf("expected the new secret accepted")
+	}
+	if !key.ExpireAt.Equal(now.AddDate(0, 0, 30)) || key.RotatedAt == nil {
+		t.Fatalf("expected the lifetime renewed, got %v, %v", key.ExpireAt, key.RotatedAt)
+	}
+	if !verifyAPIKey(key, rotated, created.AddDate(0, 0, 31)) {
+		t.Fatalf("expected the key valid past its first expiry")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"infini.sh/coco/core"
 	"infini.sh/framework/core/api"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/security"
 )
 
 type APIHandler struct {
@@ -17,6 +18,19 @@ type APIHandler struct {
 var apiHandler = APIHandler{}
 
 func init() {
+	readLoginRecordPermission := security.GetSimplePermission("coco", "login_record", string(security.Read))
+	security.GetOrInitPermissionKeys(readLoginRecordPermission, manageAPIKeyPermission)
+
+	// priority 30: runs after session_token (10) and bearer_token (20), before
+	// the integration guest auth (50)
+	security.RegisterHTTPAuthFilterProviderWithPriority("api_key", ValidateLoginByAPIKey, 30)
+
+	api.HandleUIMethod(api.POST, "/api_key/", apiHandler.CreateAPIKey, api.RequireLogin())
+	api.HandleUIMethod(api.GET, "/api_key/_search", apiHandler.SearchAPIKeys, api.RequireLogin())
+	api.HandleUIMethod(api.POST, "/api_key/_search", apiHandler.SearchAPIKeys, api.RequireLogin())
+	api.HandleUIMethod(api.GET, "/api_key/:id", apiHandler.GetAPIKey, api.RequireLogin())
+	api.HandleUIMethod(api.POST, "/api_key/:id/_revoke", apiHandler.RevokeAPIKey, api.RequireLogin())
+	api.HandleUIMethod(api.POST, "/api_key/:id/_rotate", apiHandler.RotateAPIKey, api.RequireLogin())
 
 	global.RegisterFuncBeforeSetup(func() {
 		//for not managed only
@@ -25,6 +39,14 @@ func init() {
 			api.HandleUIMethod(api.OPTIONS, "/account/profile", apiHandler.Profile, api.RequireLogin(), api.Feature(core.FeatureCORS))
 			api.HandleUIMethod(api.POST, "/account/login", apiHandler.Login)
 			api.HandleUIMethod(api.PUT, "/account/password", apiHandler.UpdatePassword, api.RequireLogin())
+			api.HandleUIMethod(api.POST, "/account/login/_enroll", apiHandler.EnrollLoginSecondFactor)
+			api.HandleUIMethod(api.POST, "/account/login/_verify", apiHandler.VerifyLoginSecondFactor)
+			api.HandleUIMethod(api.GET, "/account/two_factor", apiHandler.GetTwoFactor, api.RequireLogin())
+			api.HandleUIMethod(api.POST, "/account/two_factor/_enroll", apiHandler.EnrollTwoFactor, api.RequireLogin())
+			api.HandleUIMethod(api.POST, "/account/two_factor/_confirm", apiHandler.ConfirmTwoFactor, api.RequireLogin())
+			api.HandleUIMethod(api.POST, "/account/two_factor/_recovery_codes", apiHandler.RegenerateRecoveryCodes, api.RequireLogin())
+			api.HandleUIMethod(api.DELETE, "/account/two_factor", apiHandler.DisableTwoFactor, api.RequireLogin())
+			api.HandleUIMethod(api.GET, "/account/login_record/_search", apiHandler.SearchLoginRecords, api.RequireLogin(), api.RequirePermission(readLoginRecordPermission))
 		}
 	})
 
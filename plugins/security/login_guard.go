@@ -0,0 +1,299 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package security
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+// LoginChallenge verifies the extra proof of a login, e.g. the answer of a
+// CAPTCHA or a second factor, given in the `challenge` field of the login
+// request. It is only required once the account or the client IP failed to
+// login `challenge_after` times.
+type LoginChallenge func(r *http.Request, login, challenge string) error
+
+var loginChallenge LoginChallenge
+
+// RegisterLoginChallenge registers the challenge of the logins after failures,
+// without it the failed logins are only throttled and locked out.
+func RegisterLoginChallenge(challenge LoginChallenge) {
+	loginChallenge = challenge
+}
+
+// loginFailures is the failed logins of an account or of a client IP.
+type loginFailures struct {
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// The failed logins are kept in the kv store of the node serving the login,
+// like the logins waiting for their second factor: behind a load balancer,
+// the logins of a client are expected on the same node.
+var (
+	getLoginFailures = func(key []byte) ([]byte, error) {
+		return kv.GetValue(core.LoginAttemptsBucket, key)
+	}
+	saveLoginFailures = func(key, value []byte) error {
+		return kv.AddValue(core.LoginAttemptsBucket, key, value)
+	}
+	deleteLoginFailures = func(key []byte) error {
+		return kv.DeleteKey(core.LoginAttemptsBucket, key)
+	}
+)
+
+// loginAttemptsLock serializes the updates of the failed logins.
+var loginAttemptsLock sync.Mutex
+
+// loginGuard throttles and locks out the failed logins of an account from the
+// client IP of a login request, and of the client IP. An account is not locked
+// out for the other IPs, else anyone could keep it locked out, its failed
+// logins from all the IPs only require the registered login challenge.
+type loginGuard struct {
+	cfg      *core.LoginProtectionConfig
+	login    string
+	clientIP string
+	// reserved is set once the attempt is counted as failed
+	reserved bool
+}
+
+func newLoginGuard(r *http.Request, login string) *loginGuard {
+	cfg := common.AppConfig().LoginProtection
+	return &loginGuard{
+		cfg:      cfg,
+		login:    login,
+		clientIP: common.ClientIP(r),
+	}
+}
+
+func (g *loginGuard) enabled() bool {
+	return g.cfg == nil || !g.cfg.Disabled
+}
+
+func (g *loginGuard) accountKey() []byte {
+	return []byte("account:" + strings.ToLower(g.login))
+}
+
+func (g *loginGuard) accountIPKey() []byte {
+	return []byte("account:" + strings.ToLower(g.login) + "|ip:" + g.clientIP)
+}
+
+func (g *loginGuard) ipKey() []byte {
+	return []byte("ip:" + g.clientIP)
+}
+
+// reserve counts a login attempt as failed before its credentials are
+// checked, so that concurrent attempts can not exceed the limits, until it
+// succeeds. It returns why the attempt is refused and when it may be retried,
+// and whether the registered login challenge must be passed.
+func (g *loginGuard) reserve(now time.Time) (reason string, retryAfter time.Duration, challenge bool) {
+	if !g.enabled() {
+		return "", 0, false
+	}
+	loginAttemptsLock.Lock()
+	defer loginAttemptsLock.Unlock()
+
+	account := g.load(g.accountKey(), now)
+	accountIP := g.load(g.accountIPKey(), now)
+	ip := g.load(g.ipKey(), now)
+	var locked, throttled time.Duration
+	for _, f := range []loginFailures{accountIP, ip} {
+		if f.LockedUntil != nil {
+			if wait := f.LockedUntil.Sub(now); wait > locked {
+				locked = wait
+			}
+			continue
+		}
+		if wait := f.LastFailure.Add(g.backoff(f.Failures)).Sub(now); wait > throttled {
+			throttled = wait
+		}
+	}
+	if locked > 0 {
+		return core.LoginFailedLocked, locked, false
+	}
+	if throttled > 0 {
+		return core.LoginFailedThrottled, throttled, false
+	}
+
+	threshold := g.cfg.GetChallengeAfter()
+	challenge = loginChallenge != nil && (account.Failures >= threshold || ip.Failures >= threshold)
+	g.recordFailure(g.accountKey(), account, 0, now)
+	g.recordFailure(g.accountIPKey(), accountIP, g.cfg.GetMaxAccountFailures(), now)
+	g.recordFailure(g.ipKey(), ip, g.cfg.GetMaxIPFailures(), now)
+	g.reserved = true
+	return "", 0, challenge
+}
+
+// backoff is the delay after a number of failed logins, doubled on each one
+// after the first `backoff_after` failures.
+func (g *loginGuard) backoff(failures int) time.Duration {
+	n := failures - g.cfg.GetBackoffAfter()
+	if n < 0 {
+		return 0
+	}
+	max := g.cfg.GetMaxBackoff()
+	delay := g.cfg.GetBackoff()
+	for i := 0; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// recordFailure saves a failed login of a key, which is locked out once it
+// reaches its limit, never without one.
+func (g *loginGuard) recordFailure(key []byte, f loginFailures, maxFailures int, now time.Time) {
+	f.Failures++
+	f.LastFailure = now
+	if maxFailures > 0 && f.Failures >= maxFailures {
+		lockedUntil := now.Add(g.cfg.GetLockout())
+		f.LockedUntil = &lockedUntil
+		log.Warnf("too many failed logins of [%s], locked out until %v", string(key), lockedUntil)
+	}
+	if err := saveLoginFailures(key, util.MustToJSONBytes(f)); err != nil {
+		log.Errorf("failed to save the failed logins of [%s]: %v", string(key), err)
+	}
+}
+
+// release takes back the reserved attempt, e.g. once the password of a login
+// waiting for its second factor is verified.
+func (g *loginGuard) release(now time.Time) {
+	if !g.reserved {
+		return
+	}
+	loginAttemptsLock.Lock()
+	defer loginAttemptsLock.Unlock()
+
+	g.reserved = false
+	g.releaseFailure(g.accountKey(), 0, now)
+	g.releaseFailure(g.accountIPKey(), g.cfg.GetMaxAccountFailures(), now)
+	g.releaseFailure(g.ipKey(), g.cfg.GetMaxIPFailures(), now)
+}
+
+func (g *loginGuard) releaseFailure(key []byte, maxFailures int, now time.Time) {
+	f := g.load(key, now)
+	if f.Failures > 0 {
+		f.Failures--
+	}
+	// the lockout reached by the attempt itself
+	if f.LockedUntil != nil && f.Failures < maxFailures {
+		f.LockedUntil = nil
+	}
+	var err error
+	if f.Failures == 0 {
+		err = deleteLoginFailures(key)
+	} else {
+		err = saveLoginFailures(key, util.MustToJSONBytes(f))
+	}
+	if err != nil {
+		log.Errorf("failed to release the failed login of [%s]: %v", string(key), err)
+	}
+}
+
+// succeed takes back the reserved attempt and forgets the failed logins of
+// the account, those of the client IP are kept as it may try other accounts.
+func (g *loginGuard) succeed(now time.Time) {
+	if !g.enabled() {
+		return
+	}
+	g.release(now)
+	for _, key := range [][]byte{g.accountKey(), g.accountIPKey()} {
+		if err := deleteLoginFailures(key); err != nil {
+			log.Errorf("failed to reset the failed logins of [%s]: %v", string(key), err)
+		}
+	}
+}
+
+// load returns the failed logins of a key, those older than the failure
+// window, or of an expired lockout, are forgotten.
+func (g *loginGuard) load(key []byte, now time.Time) loginFailures {
+	f := loginFailures{}
+	buf, err := getLoginFailures(key)
+	if err != nil || len(buf) == 0 {
+		return f
+	}
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return loginFailures{}
+	}
+	if f.LockedUntil != nil {
+		if now.Before(*f.LockedUntil) {
+			return f
+		}
+		return loginFailures{}
+	}
+	if now.Sub(f.LastFailure) > g.cfg.GetFailureWindow() {
+		return loginFailures{}
+	}
+	return f
+}
+
+// audit saves the record of a login attempt.
+func (g *loginGuard) audit(r *http.Request, session *security.UserSessionInfo, reason string) {
+	record := core.LoginRecord{
+		Login:     g.login,
+		ClientIP:  g.clientIP,
+		UserAgent: r.UserAgent(),
+		Success:   session != nil,
+		Reason:    reason,
+	}
+	record.ID = util.GetUUID()
+	if session != nil {
+		record.UserID = session.MustGetUserID()
+		record.Provider = session.Provider
+	} else {
+		log.Warnf("failed login of [%s] from [%s]: %s", g.login, g.clientIP, reason)
+	}
+
+	ctx := orm.NewContext()
+	ctx.DirectAccess()
+	ctx.PermissionScope(security.PermissionScopePlatform)
+	if err := orm.Create(ctx, &record); err != nil {
+		log.Errorf("failed to save the login record of [%s]: %v", g.login, err)
+	}
+}
+
+// SearchLoginRecords searches the records of the login attempts, the latest
+// first.
+func (h APIHandler) SearchLoginRecords(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	builder, err := orm.NewQueryBuilderFromRequest(r, "login", "client_ip")
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	builder.EnableBodyBytes()
+	if len(builder.Sorts()) == 0 {
+		builder.SortBy(orm.Sort{Field: "created", SortType: orm.DESC})
+	}
+
+	ctx := orm.NewContextWithParent(r.Context())
+	ctx.DirectAccess()
+	ctx.PermissionScope(security.PermissionScopePlatform)
+	orm.WithModel(ctx, &core.LoginRecord{})
+	res, err := orm.SearchV2(ctx, builder)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.Write(w, res.Payload.([]byte))
+	if err != nil {
+		h.Error(w, err)
+	}
+}
@@ -0,0 +1,204 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package security
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"infini.sh/coco/core"
+)
+
+// useMemoryLoginFailures keeps the failed logins in memory during a test.
+func useMemoryLoginFailures(t *testing.T) {
+	var mu sync.Mutex
+	store := map[string][]byte{}
+	get, save, del := getLoginFailures, saveLoginFailures, deleteLoginFailures
+	getLoginFailures = func(key []byte) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return store[string(key)], nil
+	}
+	saveLoginFailures = func(key, value []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		store[string(key)] = value
+		return nil
+	}
+	deleteLoginFailures = func(key []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(store, string(key))
+		return nil
+	}
+	t.Cleanup(func() {
+		getLoginFailures, saveLoginFailures, deleteLoginFailures = get, save, del
+	})
+}
+
+func newTestLoginGuard(cfg *core.LoginProtectionConfig) *loginGuard {
+	return &loginGuard{cfg: cfg, login: "User@example.com", clientIP: "203.0.113.7"}
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	g := newTestLoginGuard(&core.LoginProtectionConfig{BackoffAfter: 2, Backoff: "1s", MaxBackoff: "5s"})
+	expected := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for failures, delay := range expected {
+		if got := g.backoff(failures); got != delay {
+			t.Fatalf("expected %v after %d failures, got %v", delay, failures, got)
+		}
+	}
+}
+
+func TestLoginGuardThrottle(t *testing.T) {
+	useMemoryLoginFailures(t)
+	g := newTestLoginGuard(&core.LoginProtectionConfig{BackoffAfter: 2, MaxAccountFailures: 10})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if reason, _, _ := newTestLoginGuard(g.cfg).reserve(now); reason != "" {
+			t.Fatalf("expected attempt %d allowed, got %v", i+1, reason)
+		}
+	}
+	reason, retryAfter, _ := g.reserve(now)
+	if reason != core.LoginFailedThrottled || retryAfter != time.Second {
+		t.Fatalf("expected throttled for 1s, got %v, %v", reason, retryAfter)
+	}
+	if reason, _, _ := g.reserve(now.Add(time.Second)); reason != "" {
+		t.Fatalf("expected allowed after the backoff, got %v", reason)
+	}
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	useMemoryLoginFailures(t)
+	cfg := &core.LoginProtectionConfig{MaxAccountFailures: 3, BackoffAfter: 10, Lockout: "15m"}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if reason, _, _ := newTestLoginGuard(cfg).reserve(now); reason != "" {
+			t.Fatalf("expected attempt %d allowed, got %v", i+1, reason)
+		}
+	}
+	reason, retryAfter, _ := newTestLoginGuard(cfg).reserve(now.Add(time.Minute))
+	if reason != core.LoginFailedLocked || retryAfter != 14*time.Minute {
+		t.Fatalf("expected locked for 14m, got %v, %v", reason, retryAfter)
+	}
+	// the account is locked from the IP, whatever the case of its login
+	if reason, _, _ := (&loginGuard{cfg: cfg, login: "user@example.com", clientIP: "203.0.113.7"}).reserve(now.Add(time.Minute)); reason != core.LoginFailedLocked {
+		t.Fatalf("expected the account locked from the IP, got %v", reason)
+	}
+	// not from another IP, which only has to pass the challenge
+	previous := loginChallenge
+	loginChallenge = func(r *http.Request, login, challenge string) error { return nil }
+	t.Cleanup(func() { loginChallenge = previous })
+	other := &loginGuard{cfg: cfg, login: "user@example.com", clientIP: "198.51.100.1"}
+	if reason, _, challenge := other.reserve(now.Add(time.Minute)); reason != "" || !challenge {
+		t.Fatalf("expected the account allowed from another IP with the challenge, got %v, %v", reason, challenge)
+	}
+	if reason, _, _ := newTestLoginGuard(cfg).reserve(now.Add(15 * time.Minute)); reason != "" {
+		t.Fatalf("expected allowed once the lockout expired, got %v", reason)
+	}
+}
+
+func TestLoginGuardFailureWindow(t *testing.T) {
+	useMemoryLoginFailures(t)
+	cfg := &core.LoginProtectionConfig{MaxAccountFailures: 3, BackoffAfter: 10, FailureWindow: "1h"}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		newTestLoginGuard(cfg).reserve(now)
+	}
+	g := newTestLoginGuard(cfg)
+	if f := g.load(g.accountKey(), now.Add(time.Hour)); f.Failures != 2 {
+		t.Fatalf("expected 2 failures within the window, got %d", f.Failures)
+	}
+	if f := g.load(g.accountKey(), now.Add(time.Hour+time.Second)); f.Failures != 0 {
+		t.Fatalf("expected the failures forgotten after the window, got %d", f.Failures)
+	}
+	// the older failures do not count toward the lockout
+	later := now.Add(2 * time.Hour)
+	for i := 0; i < 2; i++ {
+		if reason, _, _ := newTestLoginGuard(cfg).reserve(later); reason != "" {
+			t.Fatalf("expected attempt %d allowed, got %v", i+1, reason)
+		}
+	}
+}
+
+func TestLoginGuardConcurrentAttempts(t *testing.T) {
+	useMemoryLoginFailures(t)
+	cfg := &core.LoginProtectionConfig{MaxAccountFailures: 5, MaxIPFailures: 100, BackoffAfter: 100}
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reason, _, _ := newTestLoginGuard(cfg).reserve(now); reason == "" {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 5 {
+		t.Fatalf("expected 5 attempts allowed, got %d", allowed)
+	}
+}
+
+func TestLoginGuardSucceed(t *testing.T) {
+	useMemoryLoginFailures(t)
+	cfg := &core.LoginProtectionConfig{MaxAccountFailures: 3, MaxIPFailures: 3, BackoffAfter: 10}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		newTestLoginGuard(cfg).reserve(now)
+	}
+	// the attempt reaching the limits succeeds, it is not locked out
+	g := newTestLoginGuard(cfg)
+	if reason, _, _ := g.reserve(now); reason != "" {
+		t.Fatalf("expected allowed, got %v", reason)
+	}
+	g.succeed(now)
+	if f := g.load(g.accountKey(), now); f.Failures != 0 {
+		t.Fatalf("expected the failures of the account reset, got %d", f.Failures)
+	}
+	if f := g.load(g.accountIPKey(), now); f.Failures != 0 {
+		t.Fatalf("expected the failures of the account from the IP reset, got %d", f.Failures)
+	}
+	if f := g.load(g.ipKey(), now); f.Failures != 2 || f.LockedUntil != nil {
+		t.Fatalf("expected the 2 failures of the IP kept unlocked, got %d, %v", f.Failures, f.LockedUntil)
+	}
+
+	// a released attempt, e.g. waiting for its second factor, is not counted
+	g = newTestLoginGuard(cfg)
+	g.reserve(now)
+	g.release(now)
+	g.release(now)
+	if f := g.load(g.ipKey(), now); f.Failures != 2 {
+		t.Fatalf("expected the failures of the IP unchanged, got %d", f.Failures)
+	}
+}
+
+func TestLoginGuardChallenge(t *testing.T) {
+	useMemoryLoginFailures(t)
+	previous := loginChallenge
+	loginChallenge = func(r *http.Request, login, challenge string) error { return nil }
+	t.Cleanup(func() { loginChallenge = previous })
+	cfg := &core.LoginProtectionConfig{ChallengeAfter: 2, BackoffAfter: 10}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		_, _, challenge := newTestLoginGuard(cfg).reserve(now)
+		if challenge != (i >= 2) {
+			t.Fatalf("expected challenge %v on attempt %d, got %v", i >= 2, i+1, challenge)
+		}
+	}
+}
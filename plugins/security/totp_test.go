@@ -0,0 +1,136 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package security
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 secret of the test vectors of RFC 6238,
+// "12345678901234567890" in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// the last 6 digits of the 8 digits codes of the RFC
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if code != tt.code {
+			t.Fatalf("expected %v at %d, got %v", tt.code, tt.unix, code)
+		}
+	}
+	if code, err := totpCode(strings.ToLower(rfc6238Secret)+"====", 59/totpPeriod); err != nil || code != "287082" {
+		t.Fatalf("expected a lower case padded secret accepted, got %v, %v", code, err)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Fatalf("expected an error for an invalid secret")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, _ := totpCode(rfc6238Secret, current+offset)
+		step, ok := validateTOTP(rfc6238Secret, code, now, 0)
+		accepted := offset >= -totpSkew && offset <= totpSkew
+		if ok != accepted {
+			t.Fatalf("expected accepted %v at offset %d, got %v", accepted, offset, ok)
+		}
+		if ok && step != current+offset {
+			t.Fatalf("expected step %d, got %d", current+offset, step)
+		}
+	}
+
+	code, _ := totpCode(rfc6238Secret, current)
+	if _, ok := validateTOTP(rfc6238Secret, code[:3]+" "+code[3:], now, 0); !ok {
+		t.Fatalf("expected a code with a space accepted")
+	}
+	for _, code := range []string{"", "12345", "1234567"} {
+		if _, ok := validateTOTP(rfc6238Secret, code, now, 0); ok {
+			t.Fatalf("expected %q refused", code)
+		}
+	}
+}
+
+func TestValidateTOTPReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	code, _ := totpCode(rfc6238Secret, current)
+
+	step, ok := validateTOTP(rfc6238Secret, code, now, 0)
+	if !ok || step != current {
+		t.Fatalf("expected step %d accepted, got %d, %v", current, step, ok)
+	}
+	if _, ok := validateTOTP(rfc6238Secret, code, now, step); ok {
+		t.Fatalf("expected the used code refused")
+	}
+	// the code of a step before the last used one neither
+	previous, _ := totpCode(rfc6238Secret, current-1)
+	if _, ok := validateTOTP(rfc6238Secret, previous, now, step); ok {
+		t.Fatalf("expected the code of an older step refused")
+	}
+	next, _ := totpCode(rfc6238Secret, current+1)
+	if step, ok := validateTOTP(rfc6238Secret, next, now, current); !ok || step != current+1 {
+		t.Fatalf("expected the code of the next step accepted, got %d, %v", step, ok)
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d, %d", recoveryCodeCount, len(codes), len(hashes))
+	}
+
+	left, ok := useRecoveryCode(hashes, " "+strings.ToUpper(strings.ReplaceAll(codes[3], "-", ""))+" ")
+	if !ok || len(left) != recoveryCodeCount-1 {
+		t.Fatalf("expected the code used, got %v, %d left", ok, len(left))
+	}
+	if len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected the hashes left unchanged, got %d", len(hashes))
+	}
+	if _, ok := useRecoveryCode(left, codes[3]); ok {
+		t.Fatalf("expected a used code refused")
+	}
+	if _, ok := useRecoveryCode(left, "aaaaaaaa-bbbbbbbb"); ok {
+		t.Fatalf("expected an unknown code refused")
+	}
+	if left, ok := useRecoveryCode(left, codes[4]); !ok || len(left) != recoveryCodeCount-2 {
+		t.Fatalf("expected another code used, got %v, %d left", ok, len(left))
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("Coco AI", "user@example.com", rfc6238Secret)
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("expected a valid URI, got %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Coco AI:user@example.com" {
+		t.Fatalf("expected the label of the account, got %v", uri)
+	}
+	if strings.Contains(uri, "+") || u.Query().Get("secret") != rfc6238Secret || u.Query().Get("issuer") != "Coco AI" {
+		t.Fatalf("expected the secret and issuer, got %v", uri)
+	}
+}
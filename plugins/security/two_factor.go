@@ -0,0 +1,603 @@
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package security
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/coco/core"
+	"infini.sh/coco/modules/common"
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	// pendingLoginTTL is how long the second step of a login may take
+	pendingLoginTTL = 5 * time.Minute
+	// maxPendingLoginAttempts is the number of wrong codes before the second
+	// step of a login is canceled
+	maxPendingLoginAttempts = 5
+)
+
+// pendingLogin is a login waiting for its second factor, the user logged in
+// with a password but has no session yet. It is kept in the kv store of the
+// node serving the login.
+type pendingLogin struct {
+	UserID   string   `json:"user_id"`
+	Login    string   `json:"login"`
+	Provider string   `json:"provider"`
+	Roles    []string `json:"roles"`
+	// Enroll is set if the user must enroll a second factor first, as
+	// required for their roles
+	Enroll   bool      `json:"enroll,omitempty"`
+	Attempts int       `json:"attempts"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (p *pendingLogin) sessionInfo() *security.UserSessionInfo {
+	sessionInfo := security.UserSessionInfo{}
+	sessionInfo.Provider = p.Provider
+	sessionInfo.Login = p.Login
+	sessionInfo.Roles = p.Roles
+	sessionInfo.SetUserID(p.UserID)
+	return &sessionInfo
+}
+
+func savePendingLogin(token string, p *pendingLogin) error {
+	return kv.AddValue(core.LoginChallengeBucket, []byte(token), util.MustToJSONBytes(p))
+}
+
+// getPendingLogin returns the pending login of a challenge token, nil if it
+// does not exist or expired.
+func getPendingLogin(token string) *pendingLogin {
+	if token == "" {
+		return nil
+	}
+	buf, err := kv.GetValue(core.LoginChallengeBucket, []byte(token))
+	if err != nil || len(buf) == 0 {
+		return nil
+	}
+	p := pendingLogin{}
+	if err := json.Unmarshal(buf, &p); err != nil || time.Now().After(p.Expires) {
+		deletePendingLogin(token)
+		return nil
+	}
+	return &p
+}
+
+// pendingLoginsLock serializes the attempts of the pending logins.
+var pendingLoginsLock sync.Mutex
+
+// reservePendingLoginAttempt counts an attempt of the second step of a login
+// before its code is checked, and returns the pending login, nil if it does
+// not exist, expired or has no attempt left.
+func reservePendingLoginAttempt(token string) *pendingLogin {
+	pendingLoginsLock.Lock()
+	defer pendingLoginsLock.Unlock()
+	p := getPendingLogin(token)
+	if p == nil {
+		return nil
+	}
+	if p.Attempts >= maxPendingLoginAttempts {
+		deletePendingLogin(token)
+		return nil
+	}
+	p.Attempts++
+	if err := savePendingLogin(token, p); err != nil {
+		log.Errorf("failed to save the pending login of [%s]: %v", p.Login, err)
+		return nil
+	}
+	return p
+}
+
+func deletePendingLogin(token string) {
+	if err := kv.DeleteKey(core.LoginChallengeBucket, []byte(token)); err != nil {
+		log.Errorf("failed to delete the pending login: %v", err)
+	}
+}
+
+func newChallengeToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func twoFactorContext() *orm.Context {
+	ctx := orm.NewContext()
+	ctx.DirectAccess()
+	ctx.PermissionScope(security.PermissionScopePlatform)
+	ctx.Refresh = orm.WaitForRefresh
+	return ctx
+}
+
+// getTwoFactor returns the second factor of a user, nil if not enrolled.
+func getTwoFactor(userID string) (*core.UserTwoFactor, error) {
+	obj := core.UserTwoFactor{}
+	obj.ID = userID
+	exists, err := orm.GetV2(twoFactorContext(), &obj)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func saveTwoFactor(obj *core.UserTwoFactor) error {
+	return orm.Save(twoFactorContext(), obj)
+}
+
+// enrollTwoFactor generates a new secret for a user, it is only enabled once
+// confirmed with a code.
+func enrollTwoFactor(userID, login string) (util.MapStr, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := common.EncryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	obj := core.UserTwoFactor{Secret: encrypted}
+	obj.ID = userID
+	if err := saveTwoFactor(&obj); err != nil {
+		return nil, err
+	}
+	return util.MapStr{
+		"secret":      secret,
+		"otpauth_uri": totpURI(common.AppConfig().TwoFactor.GetIssuer(), login, secret),
+	}, nil
+}
+
+// verifyTOTP checks a code of the second factor of a user, and records its
+// time step so that it is not accepted again.
+func verifyTOTP(obj *core.UserTwoFactor, code string) (bool, error) {
+	secret, err := common.DecryptSecret(obj.Secret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := validateTOTP(secret, code, time.Now(), obj.LastUsedStep)
+	if ok {
+		obj.LastUsedStep = step
+	}
+	return ok, nil
+}
+
+// lastUsedStepField is the stored LastUsedStep, omitted while zero.
+func lastUsedStepField(obj *core.UserTwoFactor) interface{} {
+	if obj.LastUsedStep == 0 {
+		return nil
+	}
+	return obj.LastUsedStep
+}
+
+// recoveryCodesField is the stored RecoveryCodes, omitted while empty.
+func recoveryCodesField(obj *core.UserTwoFactor) interface{} {
+	if len(obj.RecoveryCodes) == 0 {
+		return nil
+	}
+	return obj.RecoveryCodes
+}
+
+// saveUsedSecondFactor saves a second factor once a code is used, if the
+// field recording the used codes was not changed meanwhile. It is false if
+// it was, the code was used by a concurrent request.
+func saveUsedSecondFactor(obj *core.UserTwoFactor, field string, expected interface{}) (bool, error) {
+	err := common.UpdateIfUnchanged(obj.ID, obj, field, expected, false)
+	if err == common.ErrConcurrentUpdate {
+		log.Warnf("second factor of [%s] used concurrently, the code is refused", obj.ID)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// verifySecondFactor checks a code, or a recovery code, of an enabled second
+// factor. The accepted code is saved as used, a code used by a concurrent
+// request is refused.
+func verifySecondFactor(obj *core.UserTwoFactor, code, recoveryCode string) (bool, error) {
+	ok := false
+	var field string
+	var expected interface{}
+	if recoveryCode != "" {
+		field, expected = "recovery_codes", recoveryCodesField(obj)
+		obj.RecoveryCodes, ok = useRecoveryCode(obj.RecoveryCodes, recoveryCode)
+	} else {
+		field, expected = "last_used_step", lastUsedStepField(obj)
+		var err error
+		if ok, err = verifyTOTP(obj, code); err != nil {
+			return false, err
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+	return saveUsedSecondFactor(obj, field, expected)
+}
+
+// confirmTwoFactor enables an enrolled second factor with its first code,
+// and returns the new recovery codes.
+func confirmTwoFactor(obj *core.UserTwoFactor, code string) ([]string, bool, error) {
+	expected := lastUsedStepField(obj)
+	ok, err := verifyTOTP(obj, code)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, false, err
+	}
+	obj.Enabled = true
+	obj.RecoveryCodes = hashes
+	if ok, err = saveUsedSecondFactor(obj, "last_used_step", expected); err != nil || !ok {
+		return nil, false, err
+	}
+	return codes, true, nil
+}
+
+// requireSecondFactor starts the second step of a login if the user enabled a
+// second factor, or must enroll one for their roles. It returns false if the
+// login is complete.
+func (h APIHandler) requireSecondFactor(w http.ResponseWriter, sessionInfo *security.UserSessionInfo) bool {
+	userID := sessionInfo.MustGetUserID()
+	obj, err := getTwoFactor(userID)
+	if err != nil {
+		panic(err)
+	}
+	enabled := obj != nil && obj.Enabled
+	if !enabled && !common.AppConfig().TwoFactor.Required(sessionInfo.Roles) {
+		return false
+	}
+
+	token, err := newChallengeToken()
+	if err != nil {
+		panic(err)
+	}
+	p := pendingLogin{
+		UserID:   userID,
+		Login:    sessionInfo.Login,
+		Provider: sessionInfo.Provider,
+		Roles:    sessionInfo.Roles,
+		Enroll:   !enabled,
+		Expires:  time.Now().Add(pendingLoginTTL),
+	}
+	if err := savePendingLogin(token, &p); err != nil {
+		panic(err)
+	}
+	h.WriteJSON(w, util.MapStr{
+		"two_factor_required": true,
+		"enrollment_required": p.Enroll,
+		"challenge_token":     token,
+		"expire_in":           int(pendingLoginTTL.Seconds()),
+	}, http.StatusOK)
+	return true
+}
+
+// EnrollLoginSecondFactor enrolls the second factor of a user who must have
+// one for their roles, during the second step of their login.
+func (h APIHandler) EnrollLoginSecondFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+	}
+	if err := h.DecodeJSON(r, &req); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := getPendingLogin(req.ChallengeToken)
+	if p == nil || !p.Enroll {
+		h.WriteError(w, "invalid or expired challenge token", http.StatusForbidden)
+		return
+	}
+	obj, err := getTwoFactor(p.UserID)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if obj != nil && obj.Enabled {
+		h.WriteError(w, "second factor already enabled", http.StatusBadRequest)
+		return
+	}
+
+	result, err := enrollTwoFactor(p.UserID, p.Login)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.WriteOKJSON(w, result)
+}
+
+// VerifyLoginSecondFactor completes a login with the code of its second
+// factor, or with a recovery code. The first code of a second factor enrolled
+// during the login confirms it, the recovery codes are then returned.
+func (h APIHandler) VerifyLoginSecondFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code,omitempty"`
+		RecoveryCode   string `json:"recovery_code,omitempty"`
+	}
+	if err := h.DecodeJSON(r, &req); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := reservePendingLoginAttempt(req.ChallengeToken)
+	if p == nil {
+		h.WriteError(w, "invalid or expired challenge token", http.StatusForbidden)
+		return
+	}
+
+	guard := newLoginGuard(r, p.Login)
+	now := time.Now()
+	if reason, retryAfter, _ := guard.reserve(now); reason != "" {
+		if reason == core.LoginFailedLocked {
+			deletePendingLogin(req.ChallengeToken)
+		}
+		guard.audit(r, nil, reason)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		h.WriteError(w, "too many failed logins, please retry later", http.StatusTooManyRequests)
+		return
+	}
+
+	// a server error is not a failed login
+	obj, err := getTwoFactor(p.UserID)
+	if err != nil {
+		guard.release(now)
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var recoveryCodes []string
+	ok := false
+	switch {
+	case obj == nil || obj.Secret == "":
+		err = errors.New("second factor not enrolled")
+	case p.Enroll && !obj.Enabled:
+		recoveryCodes, ok, err = confirmTwoFactor(obj, req.Code)
+	case obj.Enabled:
+		ok, err = verifySecondFactor(obj, req.Code, req.RecoveryCode)
+	}
+	if err != nil {
+		guard.release(now)
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if p.Attempts >= maxPendingLoginAttempts {
+			deletePendingLogin(req.ChallengeToken)
+		}
+		guard.audit(r, nil, core.LoginFailedSecondFactor)
+		h.WriteError(w, "invalid code", http.StatusForbidden)
+		return
+	}
+
+	deletePendingLogin(req.ChallengeToken)
+	sessionInfo := p.sessionInfo()
+	err, token := security.AddUserToSession(w, r, sessionInfo)
+	if err != nil {
+		guard.release(now)
+		h.ErrorInternalServer(w, "failed to authorize user")
+		return
+	}
+	guard.succeed(now)
+	guard.audit(r, sessionInfo, "")
+
+	if len(recoveryCodes) > 0 {
+		token["recovery_codes"] = recoveryCodes
+	}
+	h.WriteOKJSON(w, token)
+}
+
+// currentTwoFactorUser returns the user of a request, the guest users of the
+// integrations have no second factor.
+func currentTwoFactorUser(w http.ResponseWriter, r *http.Request) (*security.UserSessionInfo, bool) {
+	reqUser, err := security.GetUserFromContext(r.Context())
+	if err != nil || reqUser == nil {
+		api.WriteAuthRequiredError(w, "invalid user")
+		return nil, false
+	}
+	if reqUser.Has(core.UserSessionInfoKeyIntegration) {
+		api.WriteAuthRequiredError(w, "no second factor for guest user")
+		return nil, false
+	}
+	if reqUser.Has(core.UserSessionInfoKeyAPIKey) {
+		api.WriteAuthRequiredError(w, "the second factor can not be managed with an api key")
+		return nil, false
+	}
+	return reqUser, true
+}
+
+// GetTwoFactor returns the status of the second factor of the current user.
+func (h APIHandler) GetTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reqUser, ok := currentTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	obj, err := getTwoFactor(reqUser.MustGetUserID())
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	enabled := obj != nil && obj.Enabled
+	recoveryCodesLeft := 0
+	if enabled {
+		recoveryCodesLeft = len(obj.RecoveryCodes)
+	}
+	h.WriteJSON(w, util.MapStr{
+		"enabled":             enabled,
+		"required":            common.AppConfig().TwoFactor.Required(reqUser.Roles),
+		"recovery_codes_left": recoveryCodesLeft,
+	}, http.StatusOK)
+}
+
+// EnrollTwoFactor starts the enrollment of the second factor of the current
+// user, it returns the secret and its otpauth URI to add to an authenticator
+// app.
+func (h APIHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reqUser, ok := currentTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	obj, err := getTwoFactor(reqUser.MustGetUserID())
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if obj != nil && obj.Enabled {
+		h.WriteError(w, "second factor already enabled", http.StatusBadRequest)
+		return
+	}
+
+	result, err := enrollTwoFactor(reqUser.MustGetUserID(), reqUser.Login)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.WriteOKJSON(w, result)
+}
+
+// ConfirmTwoFactor enables the second factor of the current user with its
+// first code, and returns the recovery codes.
+func (h APIHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reqUser, ok := currentTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := h.DecodeJSON(r, &req); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	obj, err := getTwoFactor(reqUser.MustGetUserID())
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if obj == nil || obj.Enabled {
+		h.WriteError(w, "no pending enrollment of second factor", http.StatusBadRequest)
+		return
+	}
+
+	codes, ok, err := confirmTwoFactor(obj, req.Code)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.WriteError(w, "invalid code", http.StatusForbidden)
+		return
+	}
+	h.WriteOKJSON(w, util.MapStr{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user,
+// given a code of their second factor.
+func (h APIHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reqUser, ok := currentTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := h.DecodeJSON(r, &req); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	obj, err := getTwoFactor(reqUser.MustGetUserID())
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if obj == nil || !obj.Enabled {
+		h.WriteError(w, "second factor not enabled", http.StatusBadRequest)
+		return
+	}
+
+	expected := lastUsedStepField(obj)
+	ok, err = verifyTOTP(obj, req.Code)
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.WriteError(w, "invalid code", http.StatusForbidden)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	obj.RecoveryCodes = hashes
+	if ok, err = saveUsedSecondFactor(obj, "last_used_step", expected); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.WriteError(w, "invalid code", http.StatusForbidden)
+		return
+	}
+	h.WriteOKJSON(w, util.MapStr{"recovery_codes": codes})
+}
+
+// DisableTwoFactor removes the second factor of the current user, given a
+// code or a recovery code. It is refused if a second factor is required for
+// their roles.
+func (h APIHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reqUser, ok := currentTwoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	if common.AppConfig().TwoFactor.Required(reqUser.Roles) {
+		h.WriteError(w, "second factor required for your roles", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Code         string `json:"code,omitempty"`
+		RecoveryCode string `json:"recovery_code,omitempty"`
+	}
+	if err := h.DecodeJSON(r, &req); err != nil {
+		h.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	obj, err := getTwoFactor(reqUser.MustGetUserID())
+	if err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if obj == nil {
+		h.WriteError(w, "second factor not enrolled", http.StatusBadRequest)
+		return
+	}
+	if obj.Enabled {
+		ok, err := verifySecondFactor(obj, req.Code, req.RecoveryCode)
+		if err != nil {
+			h.WriteError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			h.WriteError(w, "invalid code", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := orm.Delete(twoFactorContext(), obj); err != nil {
+		h.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.WriteDeletedOKJSON(w, obj.ID)
+}
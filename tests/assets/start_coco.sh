@@ -1,3 +1,6 @@
+# Provision the master key of the secrets, a fixed key for the tests only
+echo "Y29jby1pbnRlZ3JhdGlvbi10ZXN0LW1hc3Rlci1rZXk=" | ./bin/coco keystore add coco_secret_master_key --stdin --force
+
 nohup ./bin/coco &
 echo $! > integration_test_coco.pid
 
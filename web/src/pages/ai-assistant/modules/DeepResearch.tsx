@@ -89,7 +89,9 @@ export const DeepResearchOutput = () => {
           options={[
             { label: 'Markdown', value: 'markdown' },
             { label: 'HTML', value: 'html' },
-            { label: 'PDF', value: 'pdf' }
+            { label: 'PDF', value: 'pdf' },
+            { label: 'Word (DOCX)', value: 'docx' },
+            { label: 'PowerPoint (PPTX)', value: 'pptx' }
           ]}
         />
       </Form.Item>